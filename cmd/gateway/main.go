@@ -5,6 +5,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -13,11 +14,10 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/aveiga/cloud-api-gateway/internal/auth"
+	"github.com/aveiga/cloud-api-gateway/internal/audit"
 	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/gateway"
 	"github.com/aveiga/cloud-api-gateway/internal/middleware"
-	"github.com/aveiga/cloud-api-gateway/internal/proxy"
-	"github.com/aveiga/cloud-api-gateway/internal/router"
 )
 
 // loadEnvFile reads a .env file and sets variables in the process environment.
@@ -52,18 +52,11 @@ func loadEnvFile(path string) {
 	}
 }
 
-func splitRulesByAuth(rules []config.RouteRule) (publicRules []config.RouteRule, protectedRules []config.RouteRule) {
-	for _, rule := range rules {
-		if rule.RequiresAuth() {
-			protectedRules = append(protectedRules, rule)
-			continue
-		}
-		publicRules = append(publicRules, rule)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(runAudit(os.Args[2:], os.Stdout, os.Stderr))
 	}
-	return publicRules, protectedRules
-}
 
-func main() {
 	loadEnvFile(".env")
 
 	// Parse command line flags
@@ -86,43 +79,27 @@ func main() {
 	}
 
 	// Initialize components
-	keycloakClient := auth.NewClient(&cfg.Authz, cfg.Cache.Enabled, cfg.Cache.TTL)
-	routeRouter := router.NewRouter(cfg.Routes)
-	authMW := middleware.NewAuthMiddleware(keycloakClient)
-	auditMW := middleware.NewAuditMiddleware()
-
-	// Create HTTP handler
-	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Match route
-		matchedRoute, matchingRules := routeRouter.MatchRoute(r)
-		if matchedRoute == nil {
-			http.Error(w, "Route not found", http.StatusNotFound)
-			return
-		}
-
-		// Create proxy for this route
-		routeProxy, err := proxy.NewProxy(matchedRoute)
-		if err != nil {
-			log.Printf("Failed to create proxy for route %s: %v", matchedRoute.Name, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-
-		// Compose middleware chain from matched rules.
-		// Any matching public rule bypasses auth; otherwise use auth + RBAC.
-		var chain http.Handler = routeProxy
-
-		publicRules, protectedRules := splitRulesByAuth(matchingRules)
-		if len(publicRules) == 0 {
-			rbacMW := middleware.NewRBACMiddleware(matchedRoute.Name, protectedRules)
-			chain = authMW.Handler(rbacMW.Handler(routeProxy))
+	gw, err := gateway.New(*configPath, cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize gateway: %v", err)
+	}
+	auditSink, err := audit.NewSink(cfg.Audit.Sink)
+	if err != nil {
+		log.Fatalf("Failed to initialize audit sink: %v", err)
+	}
+	auditQueue := audit.NewQueue(auditSink, cfg.Audit.Sink)
+	var auditOut io.Writer = auditQueue
+	var auditChain *audit.Chain
+	if cfg.Audit.Chain != nil {
+		if auditChain, err = audit.NewChain(auditQueue, cfg.Audit.Chain); err != nil {
+			log.Fatalf("Failed to initialize audit chain: %v", err)
 		}
-
-		chain.ServeHTTP(w, r)
-	})
+		auditOut = auditChain
+	}
+	auditMW := middleware.NewAuditMiddlewareWithConfig(cfg.Audit).WithOutput(auditOut)
 
 	// Wrap handler with audit logging middleware (applied first to log all requests)
-	handler = auditMW.Handler(handler)
+	var handler http.Handler = auditMW.Handler(gw)
 
 	// Create HTTP server
 	server := &http.Server{
@@ -141,10 +118,58 @@ func main() {
 		}
 	}()
 
+	// Start admin server for operator endpoints if configured
+	var adminServer *http.Server
+	if cfg.Server.AdminPort != 0 {
+		adminServer = &http.Server{
+			Addr:    fmt.Sprintf(":%d", cfg.Server.AdminPort),
+			Handler: gw.AdminHandler(),
+		}
+		go func() {
+			log.Printf("Starting admin server on port %d", cfg.Server.AdminPort)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Admin server failed to start: %v", err)
+			}
+		}()
+	}
+
+	// Reload configuration on SIGHUP and, if enabled, when the file changes
+	reload := make(chan struct{}, 1)
+	requestReload := func() {
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			requestReload()
+		}
+	}()
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	if cfg.Reload.Watch {
+		go gateway.WatchFile(watchCtx, *configPath, cfg.Reload.Interval, requestReload)
+	}
+
 	// Wait for interrupt signal for graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+
+	for running := true; running; {
+		select {
+		case <-reload:
+			if err := gw.Reload(); err != nil {
+				log.Printf("Configuration reload rejected, keeping last good configuration: %v", err)
+			}
+		case <-quit:
+			running = false
+		}
+	}
 
 	log.Println("Shutting down server...")
 
@@ -156,6 +181,23 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if adminServer != nil {
+		adminServer.Shutdown(ctx)
+	}
+	gw.Close()
+
+	// Close the audit chain, then deliver the entries still queued for the sink
+	if auditChain != nil {
+		if err := auditChain.Close(); err != nil {
+			log.Printf("Failed to close audit chain: %v", err)
+		}
+	}
+	if err := auditQueue.Shutdown(ctx); err != nil {
+		log.Printf("Failed to flush audit log: %v", err)
+	}
+	if dropped := auditQueue.Dropped(); dropped > 0 {
+		log.Printf("%d audit log entries were dropped", dropped)
+	}
 
 	log.Println("Server exited")
 }
@@ -1,33 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aveiga/cloud-api-gateway/internal/audit"
 	"github.com/aveiga/cloud-api-gateway/internal/config"
 )
 
-func boolPtr(v bool) *bool {
-	return &v
-}
-
-func TestSplitRulesByAuth(t *testing.T) {
-	rules := []config.RouteRule{
-		{Methods: []string{"GET"}, RequireAuth: boolPtr(false)},
-		{Methods: []string{"POST"}},
-		{Methods: []string{"DELETE"}, RequireAuth: boolPtr(true)},
-	}
-
-	publicRules, protectedRules := splitRulesByAuth(rules)
-	if len(publicRules) != 1 {
-		t.Fatalf("expected 1 public rule, got %d", len(publicRules))
-	}
-	if len(protectedRules) != 2 {
-		t.Fatalf("expected 2 protected rules, got %d", len(protectedRules))
-	}
-}
-
 func TestLoadEnvFileSetsVariables(t *testing.T) {
 	dir := t.TempDir()
 	envPath := filepath.Join(dir, ".env")
@@ -73,3 +62,57 @@ func TestLoadEnvFileStripsQuotes(t *testing.T) {
 		t.Errorf("expected stripped quotes, got %q", os.Getenv("QUOTED"))
 	}
 }
+
+func TestRunAuditVerify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "audit-key.pem")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	var log bytes.Buffer
+	chain, err := audit.NewChain(&log, &config.AuditChainConfig{
+		CheckpointEntries:  2,
+		CheckpointInterval: time.Hour,
+		SigningKey:         config.SigningKeyConfig{File: keyPath},
+	})
+	if err != nil {
+		t.Fatalf("NewChain: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		chain.Write([]byte(`{"path":"/api/users"}` + "\n"))
+	}
+	chain.Close()
+
+	logPath := filepath.Join(dir, "audit.log")
+	os.WriteFile(logPath, log.Bytes(), 0644)
+	var stdout, stderr bytes.Buffer
+	if code := runAudit([]string{"verify", "-key", keyPath, logPath}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s%s", code, stdout.String(), stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "3 signatures verified") {
+		t.Fatalf("unexpected output: %s", stdout.String())
+	}
+
+	tampered := bytes.Replace(log.Bytes(), []byte("/api/users"), []byte("/api/admin"), 1)
+	os.WriteFile(logPath, tampered, 0644)
+	stdout.Reset()
+	if code := runAudit([]string{"verify", logPath}, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d: %s", code, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "BREAK") {
+		t.Fatalf("expected the break to be printed, got: %s", stdout.String())
+	}
+
+	if code := runAudit([]string{"verify"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected usage errors to exit with 2, got %d", code)
+	}
+}
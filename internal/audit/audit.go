@@ -0,0 +1,207 @@
+// Package audit delivers audit log entries to a configurable sink. Entries are queued
+// and handed to the sink in batches by a background worker, so requests do not wait
+// on slow destinations.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+)
+
+// Sink receives batches of audit entries, each one JSON document without a trailing
+// newline. Sinks must not keep the batch after WriteEntries returns.
+type Sink interface {
+	WriteEntries(ctx context.Context, entries [][]byte) error
+	Close() error
+}
+
+// NewSink creates the sink selected by a validated audit.sink section
+func NewSink(cfg config.AuditSinkConfig) (Sink, error) {
+	switch cfg.Type {
+	case "", config.AuditSinkStdout:
+		return NewWriterSink(os.Stdout), nil
+	case config.AuditSinkFile:
+		return NewFileSink(cfg.File)
+	case config.AuditSinkSyslog:
+		return NewSyslogSink(cfg.Syslog)
+	case config.AuditSinkWebhook:
+		return NewWebhookSink(cfg.Webhook), nil
+	default:
+		return nil, fmt.Errorf("unsupported audit sink %q", cfg.Type)
+	}
+}
+
+// WriterSink writes one entry per line to an io.Writer such as stdout
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink creates a sink writing JSON lines to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// WriteEntries implements Sink
+func (s *WriterSink) WriteEntries(_ context.Context, entries [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(joinLines(entries))
+	return err
+}
+
+// Close implements Sink; the writer is left open
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// joinLines renders entries as newline-terminated JSON lines
+func joinLines(entries [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		buf.Write(entry)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// Queue buffers entries for a sink. When the queue is full, Write either waits for
+// room or drops the entry, depending on the overflow policy. Dropped entries, and
+// entries in batches the sink rejected, are counted in gateway_audit_entries_dropped_total.
+type Queue struct {
+	sink          Sink
+	block         bool
+	batchSize     int
+	flushInterval time.Duration
+	queue         chan []byte
+	dropped       atomic.Uint64
+
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// NewQueue starts delivering entries to sink according to a validated audit.sink section
+func NewQueue(sink Sink, cfg config.AuditSinkConfig) *Queue {
+	q := &Queue{
+		sink:          sink,
+		block:         cfg.Overflow != config.AuditOverflowDrop,
+		batchSize:     max(cfg.BatchSize, 1),
+		flushInterval: cfg.FlushInterval,
+		queue:         make(chan []byte, max(cfg.QueueSize, 1)),
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	if q.flushInterval <= 0 {
+		q.flushInterval = time.Second
+	}
+	go q.run()
+	return q
+}
+
+// Write queues one entry; a trailing newline is dropped. It implements io.Writer so
+// the audit middleware can write to a Queue like any other writer, and never fails.
+func (q *Queue) Write(p []byte) (int, error) {
+	entry := bytes.Clone(bytes.TrimRight(p, "\n"))
+	if len(entry) == 0 {
+		return len(p), nil
+	}
+	select {
+	case <-q.stop:
+		q.drop("shutdown", 1)
+		return len(p), nil
+	default:
+	}
+
+	if q.block {
+		select {
+		case q.queue <- entry:
+		case <-q.stop:
+			q.drop("shutdown", 1)
+		}
+		return len(p), nil
+	}
+	select {
+	case q.queue <- entry:
+	default:
+		q.drop("queue_full", 1)
+	}
+	return len(p), nil
+}
+
+// Dropped returns the number of entries lost so far
+func (q *Queue) Dropped() uint64 {
+	return q.dropped.Load()
+}
+
+func (q *Queue) drop(reason string, n int) {
+	if q.dropped.Add(uint64(n)) == uint64(n) {
+		log.Printf("Audit log entries are being dropped (%s)", reason)
+	}
+	metrics.AuditEntriesDropped.WithLabelValues(reason).Add(float64(n))
+}
+
+func (q *Queue) run() {
+	defer close(q.stopped)
+	ticker := time.NewTicker(q.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([][]byte, 0, q.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := q.sink.WriteEntries(context.Background(), batch); err != nil {
+			log.Printf("Failed to deliver %d audit log entries: %v", len(batch), err)
+			q.drop("sink_error", len(batch))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case entry := <-q.queue:
+			batch = append(batch, entry)
+			if len(batch) >= q.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-q.stop:
+			for {
+				select {
+				case entry := <-q.queue:
+					batch = append(batch, entry)
+					if len(batch) >= q.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Shutdown delivers every queued entry and closes the sink. Entries written after
+// Shutdown started may be dropped.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.stopOnce.Do(func() { close(q.stop) })
+	select {
+	case <-q.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return q.sink.Close()
+}
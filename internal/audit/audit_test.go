@@ -0,0 +1,205 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+)
+
+// recordingSink keeps every batch it receives; release, when set, holds deliveries back
+type recordingSink struct {
+	mu      sync.Mutex
+	batches [][]string
+	closed  bool
+	err     error
+	release chan struct{}
+}
+
+func (s *recordingSink) WriteEntries(_ context.Context, entries [][]byte) error {
+	if s.release != nil {
+		<-s.release
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch := make([]string, len(entries))
+	for i, entry := range entries {
+		batch[i] = string(entry)
+	}
+	s.batches = append(s.batches, batch)
+	return s.err
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordingSink) entries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []string
+	for _, batch := range s.batches {
+		all = append(all, batch...)
+	}
+	return all
+}
+
+func TestQueueBatchesAndFlushesOnShutdown(t *testing.T) {
+	sink := &recordingSink{}
+	q := NewQueue(sink, config.AuditSinkConfig{QueueSize: 10, BatchSize: 2, FlushInterval: time.Hour})
+
+	for i := 0; i < 5; i++ {
+		fmt.Fprintln(q, fmt.Sprintf(`{"n":%d}`, i))
+	}
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	got := sink.entries()
+	if len(got) != 5 || got[0] != `{"n":0}` || got[4] != `{"n":4}` {
+		t.Fatalf("expected all five entries in order without newlines, got %q", got)
+	}
+	for _, batch := range sink.batches {
+		if len(batch) > 2 {
+			t.Fatalf("expected batches of at most 2 entries, got %d", len(batch))
+		}
+	}
+	if !sink.closed {
+		t.Fatal("expected Shutdown to close the sink")
+	}
+}
+
+func TestQueueFlushesPartialBatchesOnInterval(t *testing.T) {
+	sink := &recordingSink{}
+	q := NewQueue(sink, config.AuditSinkConfig{QueueSize: 10, BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+	defer q.Shutdown(context.Background())
+
+	q.Write([]byte(`{"n":1}` + "\n"))
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sink.entries()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the partial batch to be delivered after flush_interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueueDropsWhenFullWithDropPolicy(t *testing.T) {
+	sink := &recordingSink{release: make(chan struct{})}
+	q := NewQueue(sink, config.AuditSinkConfig{QueueSize: 2, BatchSize: 1, FlushInterval: time.Hour, Overflow: config.AuditOverflowDrop})
+	before := metrics.AuditEntriesDropped.WithLabelValues("queue_full").Value()
+
+	// The worker holds one entry while the sink is stuck; the queue holds two more
+	q.Write([]byte(`{"n":0}`))
+	time.Sleep(20 * time.Millisecond)
+	for i := 1; i <= 5; i++ {
+		q.Write([]byte(fmt.Sprintf(`{"n":%d}`, i)))
+	}
+	if q.Dropped() != 3 {
+		t.Fatalf("expected 3 dropped entries, got %d", q.Dropped())
+	}
+	if got := metrics.AuditEntriesDropped.WithLabelValues("queue_full").Value() - before; got != 3 {
+		t.Fatalf("expected the drop counter to grow by 3, got %v", got)
+	}
+
+	close(sink.release)
+	q.Shutdown(context.Background())
+	if got := sink.entries(); len(got) != 3 {
+		t.Fatalf("expected the 3 queued entries to be delivered, got %q", got)
+	}
+}
+
+func TestQueueBlocksWhenFullWithBlockPolicy(t *testing.T) {
+	sink := &recordingSink{release: make(chan struct{})}
+	q := NewQueue(sink, config.AuditSinkConfig{QueueSize: 1, BatchSize: 1, FlushInterval: time.Hour, Overflow: config.AuditOverflowBlock})
+
+	q.Write([]byte(`{"n":0}`))
+	time.Sleep(20 * time.Millisecond)
+	q.Write([]byte(`{"n":1}`))
+
+	written := make(chan struct{})
+	go func() {
+		q.Write([]byte(`{"n":2}`))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("expected Write to wait for room in the queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(sink.release)
+	<-written
+	q.Shutdown(context.Background())
+	if got := sink.entries(); len(got) != 3 || q.Dropped() != 0 {
+		t.Fatalf("expected every entry delivered, got %q with %d dropped", got, q.Dropped())
+	}
+}
+
+func TestQueueCountsEntriesRejectedBySink(t *testing.T) {
+	sink := &recordingSink{err: errors.New("unavailable")}
+	q := NewQueue(sink, config.AuditSinkConfig{QueueSize: 10, BatchSize: 10, FlushInterval: time.Hour})
+	before := metrics.AuditEntriesDropped.WithLabelValues("sink_error").Value()
+
+	q.Write([]byte(`{"n":0}`))
+	q.Write([]byte(`{"n":1}`))
+	q.Shutdown(context.Background())
+
+	if q.Dropped() != 2 {
+		t.Fatalf("expected the failed batch to be counted as dropped, got %d", q.Dropped())
+	}
+	if got := metrics.AuditEntriesDropped.WithLabelValues("sink_error").Value() - before; got != 2 {
+		t.Fatalf("expected the sink_error counter to grow by 2, got %v", got)
+	}
+}
+
+func TestQueueDropsEntriesWrittenAfterShutdown(t *testing.T) {
+	sink := &recordingSink{}
+	q := NewQueue(sink, config.AuditSinkConfig{QueueSize: 1, BatchSize: 1, FlushInterval: time.Hour})
+	q.Shutdown(context.Background())
+
+	// Nothing would ever drain the queue, so writes must neither block nor be kept
+	q.Write([]byte(`{"n":0}`))
+	q.Write([]byte(`{"n":1}`))
+	if q.Dropped() != 2 || len(sink.entries()) != 0 {
+		t.Fatalf("expected both late writes to be dropped, got %d", q.Dropped())
+	}
+}
+
+func TestWriterSinkWritesJSONLines(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewWriterSink(&out)
+	if err := sink.WriteEntries(context.Background(), [][]byte{[]byte(`{"a":1}`), []byte(`{"b":2}`)}); err != nil {
+		t.Fatalf("WriteEntries: %v", err)
+	}
+	if out.String() != "{\"a\":1}\n{\"b\":2}\n" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+}
+
+func TestNewSinkSelectsType(t *testing.T) {
+	if sink, err := NewSink(config.AuditSinkConfig{Type: config.AuditSinkStdout}); err != nil {
+		t.Fatalf("NewSink(stdout): %v", err)
+	} else if _, ok := sink.(*WriterSink); !ok {
+		t.Fatalf("expected a WriterSink, got %T", sink)
+	}
+	sink, err := NewSink(config.AuditSinkConfig{Type: config.AuditSinkWebhook, Webhook: config.AuditWebhookSinkConfig{URL: "http://collector"}})
+	if err != nil {
+		t.Fatalf("NewSink(webhook): %v", err)
+	}
+	if _, ok := sink.(*WebhookSink); !ok {
+		t.Fatalf("expected a WebhookSink, got %T", sink)
+	}
+	if _, err := NewSink(config.AuditSinkConfig{Type: "kafka"}); err == nil {
+		t.Fatal("expected an unknown sink type to be rejected")
+	}
+}
@@ -0,0 +1,207 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/auth"
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// CheckpointType is the type of the signed checkpoint records of a chained audit log
+const CheckpointType = "audit_checkpoint"
+
+// Checkpoint reasons
+const (
+	checkpointStart    = "start"
+	checkpointEntries  = "entries"
+	checkpointInterval = "interval"
+	checkpointShutdown = "shutdown"
+)
+
+// GenesisHash is the prevHash of the first record of a chain
+var GenesisHash = strings.Repeat("0", 64)
+
+// Checkpoint is a signed record attesting the head of the chain: the sequence number
+// it occupies and the hash of the record before it
+type Checkpoint struct {
+	Seq       uint64 `json:"seq"`
+	PrevHash  string `json:"prevHash"`
+	Type      string `json:"type"`
+	Timestamp string `json:"timestamp"`
+	Reason    string `json:"reason"` // start, entries, interval or shutdown
+	Signature string `json:"signature"`
+}
+
+// checkpointPayload is what a checkpoint signature covers
+type checkpointPayload struct {
+	Seq       uint64 `json:"seq"`
+	PrevHash  string `json:"prevHash"`
+	Timestamp string `json:"timestamp"`
+	Reason    string `json:"reason"`
+}
+
+// Chain links audit entries into a hash chain on their way to out. Each entry gets a
+// seq and the prevHash of the record before it; the hash of a record is the SHA-256 of
+// its JSON line. A chain opens and closes with a signed checkpoint and adds one every
+// CheckpointEntries entries or CheckpointInterval, whichever comes first.
+type Chain struct {
+	out    io.Writer
+	signer *auth.PayloadSigner
+	every  int
+	now    func() time.Time
+
+	mu              sync.Mutex
+	seq             uint64
+	prevHash        string
+	sinceCheckpoint int
+
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// NewChain loads the checkpoint signing key and opens a new chain on out
+func NewChain(out io.Writer, cfg *config.AuditChainConfig) (*Chain, error) {
+	signer, err := auth.NewPayloadSigner(cfg.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("audit.chain.signing_key: %w", err)
+	}
+	c := &Chain{
+		out:      out,
+		signer:   signer,
+		every:    cfg.CheckpointEntries,
+		now:      time.Now,
+		prevHash: GenesisHash,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if err := c.checkpoint(checkpointStart); err != nil {
+		return nil, err
+	}
+	go c.run(cfg.CheckpointInterval)
+	return c, nil
+}
+
+// Write chains one JSON object entry; a trailing newline is dropped. It implements
+// io.Writer so the audit middleware can write to a Chain like any other writer.
+func (c *Chain) Write(p []byte) (int, error) {
+	entry := bytes.TrimRight(p, "\n")
+	if len(entry) < 2 || entry[0] != '{' {
+		return 0, fmt.Errorf("audit chain entries must be JSON objects")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	line := make([]byte, 0, len(entry)+100)
+	line = append(line, `{"seq":`...)
+	line = strconv.AppendUint(line, c.seq+1, 10)
+	line = append(line, `,"prevHash":"`...)
+	line = append(line, c.prevHash...)
+	line = append(line, '"')
+	if rest := bytes.TrimSpace(entry[1:]); len(rest) > 0 && rest[0] != '}' {
+		line = append(line, ',')
+	}
+	line = append(line, entry[1:]...)
+	if err := c.append(line); err != nil {
+		return 0, err
+	}
+
+	c.sinceCheckpoint++
+	if c.every > 0 && c.sinceCheckpoint >= c.every {
+		if err := c.appendCheckpoint(checkpointEntries); err != nil {
+			log.Printf("Failed to write audit checkpoint: %v", err)
+		}
+	}
+	return len(p), nil
+}
+
+// append writes a record and advances the chain; must be called with c.mu held
+func (c *Chain) append(line []byte) error {
+	if _, err := c.out.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(line)
+	c.seq++
+	c.prevHash = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// checkpoint writes a signed checkpoint
+func (c *Chain) checkpoint(reason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.appendCheckpoint(reason)
+}
+
+// appendCheckpoint writes a signed checkpoint; must be called with c.mu held
+func (c *Chain) appendCheckpoint(reason string) error {
+	payload := checkpointPayload{
+		Seq:       c.seq + 1,
+		PrevHash:  c.prevHash,
+		Timestamp: c.now().UTC().Format(time.RFC3339Nano),
+		Reason:    reason,
+	}
+	signed, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	signature, err := c.signer.Sign(signed)
+	if err != nil {
+		return fmt.Errorf("failed to sign audit checkpoint: %w", err)
+	}
+	line, err := json.Marshal(Checkpoint{
+		Seq:       payload.Seq,
+		PrevHash:  payload.PrevHash,
+		Type:      CheckpointType,
+		Timestamp: payload.Timestamp,
+		Reason:    reason,
+		Signature: signature,
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.append(line); err != nil {
+		return err
+	}
+	c.sinceCheckpoint = 0
+	return nil
+}
+
+// run adds a checkpoint every interval in which entries were written
+func (c *Chain) run(interval time.Duration) {
+	defer close(c.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			if c.sinceCheckpoint > 0 {
+				if err := c.appendCheckpoint(checkpointInterval); err != nil {
+					log.Printf("Failed to write audit checkpoint: %v", err)
+				}
+			}
+			c.mu.Unlock()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Close writes the closing checkpoint, so verification can tell a chain that ended
+// cleanly from one whose last entries were removed
+func (c *Chain) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	<-c.stopped
+	return c.checkpoint(checkpointShutdown)
+}
@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// testChainConfig returns a chain config signing with a fresh EC key
+func testChainConfig(t *testing.T, every int) (*config.AuditChainConfig, crypto.PublicKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "audit-key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	return &config.AuditChainConfig{
+		CheckpointEntries:  every,
+		CheckpointInterval: time.Hour,
+		SigningKey:         config.SigningKeyConfig{File: path},
+	}, &key.PublicKey
+}
+
+// newTestChain opens a chain on a buffer
+func newTestChain(t *testing.T, cfg *config.AuditChainConfig, out *bytes.Buffer) *Chain {
+	t.Helper()
+	c, err := NewChain(out, cfg)
+	if err != nil {
+		t.Fatalf("NewChain: %v", err)
+	}
+	return c
+}
+
+// writeEntries chains n audit entries
+func writeEntries(t *testing.T, c *Chain, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := fmt.Fprintf(c, "{\"path\":\"/api/%d\"}\n", i); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+}
+
+func lines(out *bytes.Buffer) []string {
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+func verify(t *testing.T, log string, key crypto.PublicKey) *VerifyReport {
+	t.Helper()
+	report, err := Verify(strings.NewReader(log), key)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	return report
+}
+
+func TestChainLinksEntriesAndVerifies(t *testing.T) {
+	cfg, key := testChainConfig(t, 3)
+	out := &bytes.Buffer{}
+	c := newTestChain(t, cfg, out)
+	writeEntries(t, c, 7)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	records := lines(out)
+	// start, 3 entries, checkpoint, 3 entries, checkpoint, 1 entry, shutdown
+	if len(records) != 11 {
+		t.Fatalf("expected 11 records, got %d:\n%s", len(records), out.String())
+	}
+	var first, entry struct {
+		Seq      uint64 `json:"seq"`
+		PrevHash string `json:"prevHash"`
+		Type     string `json:"type"`
+		Reason   string `json:"reason"`
+		Path     string `json:"path"`
+	}
+	json.Unmarshal([]byte(records[0]), &first)
+	json.Unmarshal([]byte(records[1]), &entry)
+	if first.Type != CheckpointType || first.Reason != "start" || first.Seq != 1 || first.PrevHash != GenesisHash {
+		t.Fatalf("expected the chain to open with a start checkpoint, got %s", records[0])
+	}
+	if entry.Seq != 2 || entry.Path != "/api/0" || entry.PrevHash == GenesisHash {
+		t.Fatalf("expected entries to keep their fields and be linked, got %s", records[1])
+	}
+
+	report := verify(t, out.String(), key)
+	if !report.OK() || len(report.Warnings) != 0 {
+		t.Fatalf("expected the chain to verify cleanly, got breaks %v warnings %v", report.Breaks, report.Warnings)
+	}
+	if report.Records != 11 || report.Chains != 1 || report.Checkpoints != 4 || report.Verified != 4 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	cfg, key := testChainConfig(t, 0)
+	out := &bytes.Buffer{}
+	c := newTestChain(t, cfg, out)
+	writeEntries(t, c, 4)
+	c.Close()
+	records := lines(out)
+
+	tests := []struct {
+		name   string
+		modify func([]string) []string
+		line   int
+	}{
+		{"edited", func(r []string) []string {
+			r[2] = strings.Replace(r[2], "/api/1", "/api/x", 1)
+			return r
+		}, 4},
+		{"removed", func(r []string) []string {
+			return append(r[:2:2], r[3:]...)
+		}, 3},
+		{"reordered", func(r []string) []string {
+			r[2], r[3] = r[3], r[2]
+			return r
+		}, 3},
+		{"forged checkpoint", func(r []string) []string {
+			r[len(r)-1] = strings.Replace(r[len(r)-1], `"reason":"shutdown"`, `"reason":"interval"`, 1)
+			return r
+		}, len(records)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := tt.modify(append([]string(nil), records...))
+			report := verify(t, strings.Join(modified, "\n")+"\n", key)
+			if report.OK() {
+				t.Fatal("expected a break")
+			}
+			if report.Breaks[0].Line != tt.line {
+				t.Fatalf("expected the first break on line %d, got %v", tt.line, report.Breaks)
+			}
+		})
+	}
+}
+
+func TestVerifyWarnsAboutUnsignedTail(t *testing.T) {
+	cfg, key := testChainConfig(t, 0)
+	out := &bytes.Buffer{}
+	c := newTestChain(t, cfg, out)
+	writeEntries(t, c, 2)
+	// No Close: the gateway stopped without a closing checkpoint
+
+	report := verify(t, out.String(), key)
+	if !report.OK() {
+		t.Fatalf("expected no breaks, got %v", report.Breaks)
+	}
+	if len(report.Warnings) != 1 || !strings.Contains(report.Warnings[0].Reason, "not covered by a signature") {
+		t.Fatalf("expected a warning about unsigned entries, got %v", report.Warnings)
+	}
+
+	records := lines(out)
+	truncated := strings.Join(records[:1], "\n") + "\n"
+	if report := verify(t, truncated, key); len(report.Warnings) != 1 {
+		t.Fatalf("expected a chain ending on a start checkpoint to be flagged, got %v", report.Warnings)
+	}
+}
+
+func TestVerifyAcceptsConsecutiveRuns(t *testing.T) {
+	cfg, key := testChainConfig(t, 0)
+	out := &bytes.Buffer{}
+	first := newTestChain(t, cfg, out)
+	writeEntries(t, first, 2)
+	first.Close()
+
+	// A restarted gateway opens a new chain with the same key
+	second := newTestChain(t, cfg, out)
+	writeEntries(t, second, 1)
+	second.Close()
+
+	report := verify(t, out.String(), key)
+	if !report.OK() || len(report.Warnings) != 0 || report.Chains != 2 {
+		t.Fatalf("expected two clean chains, got %+v", report)
+	}
+
+	// Verifying only the latest rotated file cannot check what came before it
+	tail := strings.Join(lines(out)[2:], "\n") + "\n"
+	if report := verify(t, tail, key); len(report.Warnings) == 0 {
+		t.Fatal("expected a warning when the input starts mid-chain")
+	}
+}
@@ -0,0 +1,161 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// backupTimeFormat names rotated files, e.g. audit-20240102T150405.000.log
+const backupTimeFormat = "20060102T150405.000"
+
+// FileSink appends JSON lines to a file. Once a write would grow the file past
+// MaxSizeMB it is renamed with a timestamp and a new file is started; rotated files
+// older than MaxAge or beyond MaxBackups are deleted.
+type FileSink struct {
+	cfg     config.AuditFileSinkConfig
+	maxSize int64
+	now     func() time.Time
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens (or creates) the audit file for appending
+func NewFileSink(cfg config.AuditFileSinkConfig) (*FileSink, error) {
+	s := &FileSink{
+		cfg:     cfg,
+		maxSize: int64(cfg.MaxSizeMB) * 1024 * 1024,
+		now:     time.Now,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the audit file; must be called with s.mu held or before the sink is shared
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.cfg.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(s.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// WriteEntries implements Sink
+func (s *FileSink) WriteEntries(_ context.Context, entries [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("audit log %s is closed", s.cfg.Path)
+	}
+
+	data := joinLines(entries)
+	if s.size > 0 && s.maxSize > 0 && s.size+int64(len(data)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	return err
+}
+
+// rotate renames the current file and starts a new one; must be called with s.mu held
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+	s.file = nil
+	if err := os.Rename(s.cfg.Path, s.backupName(s.now())); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	s.prune()
+	return nil
+}
+
+// backupName returns the name of a file rotated at t
+func (s *FileSink) backupName(t time.Time) string {
+	ext := filepath.Ext(s.cfg.Path)
+	base := strings.TrimSuffix(s.cfg.Path, ext)
+	return base + "-" + t.UTC().Format(backupTimeFormat) + ext
+}
+
+// prune deletes rotated files that are too old or too many; failures are only logged
+func (s *FileSink) prune() {
+	if s.cfg.MaxAge == 0 && s.cfg.MaxBackups == 0 {
+		return
+	}
+	ext := filepath.Ext(s.cfg.Path)
+	prefix := filepath.Base(strings.TrimSuffix(s.cfg.Path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(s.cfg.Path))
+	if err != nil {
+		log.Printf("Failed to list rotated audit logs: %v", err)
+		return
+	}
+
+	type backup struct {
+		path    string
+		rotated time.Time
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		rotated, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue // not one of ours
+		}
+		backups = append(backups, backup{filepath.Join(filepath.Dir(s.cfg.Path), name), rotated})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].rotated.After(backups[j].rotated) })
+
+	now := s.now()
+	for i, b := range backups {
+		expired := s.cfg.MaxAge > 0 && now.Sub(b.rotated) > s.cfg.MaxAge
+		excess := s.cfg.MaxBackups > 0 && i >= s.cfg.MaxBackups
+		if expired || excess {
+			if err := os.Remove(b.path); err != nil {
+				log.Printf("Failed to remove rotated audit log %s: %v", b.path, err)
+			}
+		}
+	}
+}
+
+// Close implements Sink
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
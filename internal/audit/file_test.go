@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// newTestFileSink returns a file sink in a temp dir with a controllable clock
+func newTestFileSink(t *testing.T, cfg config.AuditFileSinkConfig) (*FileSink, *time.Time) {
+	t.Helper()
+	cfg.Path = filepath.Join(t.TempDir(), "audit.log")
+	s, err := NewFileSink(cfg)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+// backups lists rotated files next to the sink's file, oldest first
+func backups(t *testing.T, s *FileSink) []string {
+	t.Helper()
+	matches, err := filepath.Glob(strings.TrimSuffix(s.cfg.Path, ".log") + "-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func TestFileSinkRotatesBySize(t *testing.T) {
+	s, now := newTestFileSink(t, config.AuditFileSinkConfig{MaxSizeMB: 1})
+	entry := []byte(`{"data":"` + strings.Repeat("x", 600*1024) + `"}`)
+
+	if err := s.WriteEntries(context.Background(), [][]byte{entry}); err != nil {
+		t.Fatalf("WriteEntries: %v", err)
+	}
+	*now = now.Add(time.Second)
+	if err := s.WriteEntries(context.Background(), [][]byte{entry}); err != nil {
+		t.Fatalf("WriteEntries: %v", err)
+	}
+
+	rotated := backups(t, s)
+	if len(rotated) != 1 || filepath.Base(rotated[0]) != "audit-20240102T150406.000.log" {
+		t.Fatalf("expected one timestamped backup, got %v", rotated)
+	}
+	for _, path := range []string{s.cfg.Path, rotated[0]} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != len(entry)+1 {
+			t.Fatalf("expected %s to hold exactly one entry, got %d bytes", path, len(data))
+		}
+	}
+}
+
+func TestFileSinkAppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	os.WriteFile(path, []byte("{\"old\":true}\n"), 0o600)
+	s, err := NewFileSink(config.AuditFileSinkConfig{Path: path, MaxSizeMB: 1})
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	s.WriteEntries(context.Background(), [][]byte{[]byte(`{"new":true}`)})
+	s.Close()
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "{\"old\":true}\n{\"new\":true}\n" {
+		t.Fatalf("unexpected file contents %q", data)
+	}
+	if err := s.WriteEntries(context.Background(), [][]byte{[]byte(`{}`)}); err == nil {
+		t.Fatal("expected writes after Close to fail")
+	}
+}
+
+func TestFileSinkPrunesBackups(t *testing.T) {
+	s, now := newTestFileSink(t, config.AuditFileSinkConfig{MaxSizeMB: 1, MaxBackups: 2, MaxAge: time.Hour})
+	entry := []byte(`{"data":"` + strings.Repeat("x", 600*1024) + `"}`)
+	write := func() {
+		t.Helper()
+		if err := s.WriteEntries(context.Background(), [][]byte{entry}); err != nil {
+			t.Fatalf("WriteEntries: %v", err)
+		}
+	}
+
+	write()
+	for i := 0; i < 4; i++ {
+		*now = now.Add(time.Minute)
+		write()
+	}
+	if got := backups(t, s); len(got) != 2 || !strings.HasSuffix(got[1], "T150805.000.log") {
+		t.Fatalf("expected the two newest backups to be kept, got %v", got)
+	}
+
+	// A file that is not a backup is left alone
+	unrelated := strings.TrimSuffix(s.cfg.Path, ".log") + "-notes.log"
+	os.WriteFile(unrelated, nil, 0o600)
+
+	*now = now.Add(2 * time.Hour)
+	write()
+	if got := backups(t, s); len(got) != 2 || !strings.HasSuffix(got[0], "T170805.000.log") || got[1] != unrelated {
+		t.Fatalf("expected backups older than max_age to be removed, got %v", got)
+	}
+}
@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// severityInfo is the RFC 5424 severity of audit messages
+const severityInfo = 6
+
+// SyslogSink sends each entry as an RFC 5424 message over UDP or TCP. TCP messages use
+// octet-counting framing (RFC 6587). A failed send is retried once on a new connection.
+type SyslogSink struct {
+	cfg      config.AuditSyslogSinkConfig
+	priority int
+	hostname string
+	now      func() time.Time
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogSink creates a syslog sink; the connection is made on first use
+func NewSyslogSink(cfg config.AuditSyslogSinkConfig) (*SyslogSink, error) {
+	facility, ok := config.SyslogFacilities[cfg.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", cfg.Facility)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &SyslogSink{
+		cfg:      cfg,
+		priority: facility*8 + severityInfo,
+		hostname: hostname,
+		now:      time.Now,
+	}, nil
+}
+
+// WriteEntries implements Sink
+func (s *SyslogSink) WriteEntries(_ context.Context, entries [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range entries {
+		message := s.format(entry)
+		if err := s.send(message); err != nil {
+			// The collector may have restarted; reconnect once before giving up
+			s.closeConn()
+			if err := s.send(message); err != nil {
+				s.closeConn()
+				return fmt.Errorf("syslog %s %s: %w", s.cfg.Network, s.cfg.Address, err)
+			}
+		}
+	}
+	return nil
+}
+
+// format renders an RFC 5424 message: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+func (s *SyslogSink) format(entry []byte) []byte {
+	header := fmt.Sprintf("<%d>1 %s %s %s %d - - ",
+		s.priority, s.now().UTC().Format(time.RFC3339Nano), s.hostname, s.cfg.Tag, os.Getpid())
+	message := append([]byte(header), entry...)
+	if s.cfg.Network == "tcp" {
+		message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+	return message
+}
+
+// send writes one message, dialing first if needed; must be called with s.mu held
+func (s *SyslogSink) send(message []byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.cfg.Network, s.cfg.Address, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := s.conn.Write(message)
+	return err
+}
+
+// closeConn drops the connection; must be called with s.mu held
+func (s *SyslogSink) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// Close implements Sink
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeConn()
+	return nil
+}
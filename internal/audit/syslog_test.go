@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+func newTestSyslogSink(t *testing.T, network, address string) *SyslogSink {
+	t.Helper()
+	s, err := NewSyslogSink(config.AuditSyslogSinkConfig{Network: network, Address: address, Tag: "gateway", Facility: "local0"})
+	if err != nil {
+		t.Fatalf("NewSyslogSink: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.hostname = "gw-1"
+	s.now = func() time.Time { return time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC) }
+	return s
+}
+
+func TestSyslogSinkSendsRFC5424OverUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := newTestSyslogSink(t, "udp", conn.LocalAddr().String())
+
+	if err := s.WriteEntries(context.Background(), [][]byte{[]byte(`{"n":1}`), []byte(`{"n":2}`)}); err != nil {
+		t.Fatalf("WriteEntries: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	for i := 1; i <= 2; i++ {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("ReadFrom: %v", err)
+		}
+		// local0 (16) * 8 + info (6) = 134
+		want := fmt.Sprintf(`<134>1 2024-01-02T15:04:05Z gw-1 gateway %d - - {"n":%d}`, os.Getpid(), i)
+		if string(buf[:n]) != want {
+			t.Fatalf("expected %q, got %q", want, buf[:n])
+		}
+	}
+}
+
+func TestSyslogSinkFramesAndReconnectsOverTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					length, err := r.ReadString(' ')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(length))
+					message := make([]byte, n)
+					if _, err := io.ReadFull(r, message); err != nil {
+						return
+					}
+					messages <- string(message)
+				}
+			}()
+		}
+	}()
+	s := newTestSyslogSink(t, "tcp", ln.Addr().String())
+
+	if err := s.WriteEntries(context.Background(), [][]byte{[]byte(`{"n":1}`)}); err != nil {
+		t.Fatalf("WriteEntries: %v", err)
+	}
+	if got := <-messages; !strings.HasSuffix(got, ` - - {"n":1}`) || !strings.HasPrefix(got, "<134>1 ") {
+		t.Fatalf("unexpected message %q", got)
+	}
+
+	// A dropped connection is replaced on the next write
+	s.mu.Lock()
+	s.conn.Close()
+	s.mu.Unlock()
+	if err := s.WriteEntries(context.Background(), [][]byte{[]byte(`{"n":2}`)}); err != nil {
+		t.Fatalf("WriteEntries after the connection closed: %v", err)
+	}
+	if got := <-messages; !strings.HasSuffix(got, `{"n":2}`) {
+		t.Fatalf("unexpected message %q", got)
+	}
+}
+
+func TestSyslogSinkReportsUnreachableCollector(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := ln.Addr().String()
+	ln.Close()
+
+	s := newTestSyslogSink(t, "tcp", address)
+	if err := s.WriteEntries(context.Background(), [][]byte{[]byte(`{}`)}); err == nil {
+		t.Fatal("expected an error when the collector is down")
+	}
+}
@@ -0,0 +1,168 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aveiga/cloud-api-gateway/internal/auth"
+)
+
+// ChainBreak is a place where a chained audit log does not verify
+type ChainBreak struct {
+	Line   int    // 1-based line number in the input
+	Seq    uint64 // seq of the record on that line, 0 if it has none
+	Reason string
+}
+
+func (b ChainBreak) String() string {
+	if b.Seq == 0 {
+		return fmt.Sprintf("line %d: %s", b.Line, b.Reason)
+	}
+	return fmt.Sprintf("line %d (seq %d): %s", b.Line, b.Seq, b.Reason)
+}
+
+// VerifyReport is the outcome of verifying a chained audit log
+type VerifyReport struct {
+	Records     int          // lines read, checkpoints included
+	Chains      int          // chains started in the input, one per gateway run
+	Checkpoints int          // checkpoint records
+	Verified    int          // checkpoints whose signature was checked
+	Breaks      []ChainBreak // evidence of edited, removed, reordered or forged records
+	Warnings    []ChainBreak // gaps verification cannot rule out, such as a missing closing checkpoint
+}
+
+// OK reports whether the chain verified without breaks
+func (r *VerifyReport) OK() bool {
+	return len(r.Breaks) == 0
+}
+
+// chainedRecord holds the fields of a record that verification looks at
+type chainedRecord struct {
+	Seq       *uint64 `json:"seq"`
+	PrevHash  string  `json:"prevHash"`
+	Type      string  `json:"type"`
+	Timestamp string  `json:"timestamp"`
+	Reason    string  `json:"reason"`
+	Signature string  `json:"signature"`
+}
+
+// Verify reads a chained audit log, e.g. a file sink's rotated files concatenated
+// oldest first, and reports every break in the chain. Checkpoint signatures are
+// checked when key is not nil.
+func Verify(r io.Reader, key crypto.PublicKey) (*VerifyReport, error) {
+	report := &VerifyReport{}
+	reader := bufio.NewReader(r)
+
+	var (
+		started    bool   // a previous record exists
+		prevSeq    uint64 // seq of the previous record
+		prevHash   string // hash of the previous record
+		lastReason string // reason of the last checkpoint of the current chain
+		unsigned   int    // entries since the last checkpoint
+	)
+	endChain := func(line int) {
+		if !started {
+			return
+		}
+		if unsigned > 0 {
+			report.Warnings = append(report.Warnings, ChainBreak{Line: line, Seq: prevSeq,
+				Reason: fmt.Sprintf("%d entries after the last checkpoint are not covered by a signature", unsigned)})
+		} else if lastReason != checkpointShutdown {
+			report.Warnings = append(report.Warnings, ChainBreak{Line: line, Seq: prevSeq,
+				Reason: "chain ends without a closing checkpoint; the gateway stopped abruptly or entries were removed"})
+		}
+	}
+
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			if err != nil {
+				endChain(lineNo - 1)
+				return report, nil
+			}
+			continue
+		}
+		report.Records++
+		sum := sha256.Sum256(line)
+		hash := hex.EncodeToString(sum[:])
+
+		var record chainedRecord
+		if jsonErr := json.Unmarshal(line, &record); jsonErr != nil || record.Seq == nil {
+			report.Breaks = append(report.Breaks, ChainBreak{Line: lineNo, Reason: "record is not a chained audit entry"})
+			started, prevHash = false, ""
+			continue
+		}
+		seq := *record.Seq
+
+		switch {
+		case seq == 1 && record.PrevHash == GenesisHash:
+			// A new gateway run starts a new chain
+			endChain(lineNo - 1)
+			report.Chains++
+			lastReason, unsigned = "", 0
+			if record.Type != CheckpointType {
+				report.Breaks = append(report.Breaks, ChainBreak{Line: lineNo, Seq: seq, Reason: "chain does not open with a checkpoint"})
+			}
+		case !started:
+			report.Warnings = append(report.Warnings, ChainBreak{Line: lineNo, Seq: seq,
+				Reason: "input starts in the middle of a chain; earlier records are not verified"})
+		default:
+			if seq != prevSeq+1 {
+				report.Breaks = append(report.Breaks, ChainBreak{Line: lineNo, Seq: seq,
+					Reason: fmt.Sprintf("expected seq %d; records were removed, reordered or inserted", prevSeq+1)})
+			} else if record.PrevHash != prevHash {
+				report.Breaks = append(report.Breaks, ChainBreak{Line: lineNo, Seq: seq,
+					Reason: "prevHash does not match the previous record; it was edited or replaced"})
+			}
+		}
+
+		if record.Type == CheckpointType {
+			report.Checkpoints++
+			lastReason, unsigned = record.Reason, 0
+			if key != nil {
+				if reason := verifyCheckpoint(record, key); reason != "" {
+					report.Breaks = append(report.Breaks, ChainBreak{Line: lineNo, Seq: seq, Reason: reason})
+				} else {
+					report.Verified++
+				}
+			}
+		} else {
+			unsigned++
+		}
+
+		started, prevSeq, prevHash = true, seq, hash
+		if err != nil {
+			endChain(lineNo)
+			return report, nil
+		}
+	}
+}
+
+// verifyCheckpoint checks a checkpoint's signature and that it covers the record's
+// own seq and prevHash; it returns why the checkpoint is invalid, or ""
+func verifyCheckpoint(record chainedRecord, key crypto.PublicKey) string {
+	payload, err := auth.VerifyPayload(record.Signature, key)
+	if err != nil {
+		return fmt.Sprintf("checkpoint signature is invalid: %v", err)
+	}
+	var signed checkpointPayload
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		return "checkpoint signature covers no checkpoint"
+	}
+	if signed.Seq != *record.Seq || signed.PrevHash != record.PrevHash ||
+		signed.Timestamp != record.Timestamp || signed.Reason != record.Reason {
+		return "checkpoint does not match what was signed"
+	}
+	return ""
+}
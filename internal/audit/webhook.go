@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// WebhookSink POSTs each batch to an HTTP endpoint as a JSON array
+type WebhookSink struct {
+	cfg    config.AuditWebhookSinkConfig
+	client *http.Client
+}
+
+// NewWebhookSink creates a webhook sink
+func NewWebhookSink(cfg config.AuditWebhookSinkConfig) *WebhookSink {
+	return &WebhookSink{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// WriteEntries implements Sink; any status other than 2xx fails the batch
+func (s *WebhookSink) WriteEntries(ctx context.Context, entries [][]byte) error {
+	var body bytes.Buffer
+	body.WriteByte('[')
+	for i, entry := range entries {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+		body.Write(entry)
+	}
+	body.WriteByte(']')
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Close implements Sink
+func (s *WebhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
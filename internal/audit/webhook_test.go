@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+func TestWebhookSinkPostsBatchAsJSONArray(t *testing.T) {
+	var got []map[string]int
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("expected a JSON array, got %q", body)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	s := NewWebhookSink(config.AuditWebhookSinkConfig{
+		URL: server.URL, Headers: map[string]string{"Authorization": "Bearer secret"}, Timeout: time.Second,
+	})
+	if err := s.WriteEntries(context.Background(), [][]byte{[]byte(`{"n":1}`), []byte(`{"n":2}`)}); err != nil {
+		t.Fatalf("WriteEntries: %v", err)
+	}
+	if len(got) != 2 || got[0]["n"] != 1 || got[1]["n"] != 2 {
+		t.Fatalf("unexpected batch %v", got)
+	}
+	if header.Get("Content-Type") != "application/json" || header.Get("Authorization") != "Bearer secret" {
+		t.Fatalf("unexpected headers %v", header)
+	}
+}
+
+func TestWebhookSinkFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	s := NewWebhookSink(config.AuditWebhookSinkConfig{URL: server.URL, Timeout: time.Second})
+	if err := s.WriteEntries(context.Background(), [][]byte{[]byte(`{}`)}); err == nil {
+		t.Fatal("expected a 503 to fail the batch")
+	}
+}
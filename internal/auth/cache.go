@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+)
+
+// cacheKey is the SHA-256 digest of a bearer token. Raw tokens are never kept as keys.
+type cacheKey [sha256.Size]byte
+
+func hashToken(token string) cacheKey {
+	return sha256.Sum256([]byte(token))
+}
+
+// cacheEntry is one cached introspection result
+type cacheEntry struct {
+	key        cacheKey
+	result     *IntrospectionResponse
+	expiresAt  time.Time
+	staleUntil time.Time // end of the stale grace window; never before expiresAt
+	size       int64
+}
+
+// entryOverhead approximates the fixed memory cost of an entry: the map slot,
+// the list element, the entry itself and the response struct
+const entryOverhead = 256
+
+// CacheStats are cumulative token cache counters
+type CacheStats struct {
+	Entries      int    `json:"entries"`
+	Bytes        int64  `json:"bytes"`
+	Hits         uint64 `json:"hits"`
+	NegativeHits uint64 `json:"negativeHits"` // hits on cached active:false results, included in Hits
+	StaleHits    uint64 `json:"staleHits"`    // expired results served while Keycloak was failing
+	Misses       uint64 `json:"misses"`
+	Evictions    uint64 `json:"evictions"` // entries dropped to stay within max_entries / max_bytes
+	Expired      uint64 `json:"expired"`   // entries removed after their expiry and stale grace
+}
+
+// TokenCache is a bounded LRU cache of introspection results keyed by token hash.
+// An entry is fresh until its expiry and may then be kept for a stale grace window,
+// during which only GetStale returns it. Entries past their grace window are removed
+// on lookup and by a background sweeper.
+type TokenCache struct {
+	maxEntries int
+	maxBytes   int64
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List // front is most recently used
+	bytes   int64
+	closed  bool // entries no longer count towards the entries gauge
+
+	hits         atomic.Uint64
+	negativeHits atomic.Uint64
+	staleHits    atomic.Uint64
+	misses       atomic.Uint64
+	evictions    atomic.Uint64
+	expired      atomic.Uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTokenCache creates a cache holding at most maxEntries entries and roughly maxBytes
+// bytes (0 disables either limit). A positive sweepInterval starts a background sweeper
+// that runs until Close.
+func NewTokenCache(maxEntries int, maxBytes int64, sweepInterval time.Duration) *TokenCache {
+	c := &TokenCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		now:        time.Now,
+		entries:    make(map[cacheKey]*list.Element),
+		lru:        list.New(),
+		stop:       make(chan struct{}),
+	}
+	if sweepInterval > 0 {
+		go c.sweepLoop(sweepInterval)
+	}
+	return c
+}
+
+// Get returns the cached result for token if present and not expired
+func (c *TokenCache) Get(token string) (*IntrospectionResponse, bool) {
+	key := hashToken(token)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		metrics.TokenCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	now := c.now()
+	if !now.Before(entry.expiresAt) {
+		if !now.Before(entry.staleUntil) {
+			c.remove(elem)
+			c.expired.Add(1)
+			metrics.TokenCacheRemovals.WithLabelValues("expired").Inc()
+		}
+		c.misses.Add(1)
+		metrics.TokenCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits.Add(1)
+	if !entry.result.Active {
+		c.negativeHits.Add(1)
+		metrics.TokenCacheRequests.WithLabelValues("negative_hit").Inc()
+	} else {
+		metrics.TokenCacheRequests.WithLabelValues("hit").Inc()
+	}
+	return entry.result, true
+}
+
+// GetStale returns the cached result for token if it is still within its stale grace
+// window. It is meant for serving a recently valid result while Keycloak is failing.
+func (c *TokenCache) GetStale(token string) (*IntrospectionResponse, bool) {
+	key := hashToken(token)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok || !c.now().Before(elem.Value.(*cacheEntry).staleUntil) {
+		return nil, false
+	}
+	c.staleHits.Add(1)
+	metrics.TokenCacheRequests.WithLabelValues("stale_hit").Inc()
+	return elem.Value.(*cacheEntry).result, true
+}
+
+// Set caches result for token until expiresAt and keeps it for stale lookups until
+// staleUntil (a zero or earlier staleUntil disables the grace window). Least recently
+// used entries are evicted as needed to stay within the configured limits.
+func (c *TokenCache) Set(token string, result *IntrospectionResponse, expiresAt, staleUntil time.Time) {
+	if staleUntil.Before(expiresAt) {
+		staleUntil = expiresAt
+	}
+	entry := &cacheEntry{
+		key:        hashToken(token),
+		result:     result,
+		expiresAt:  expiresAt,
+		staleUntil: staleUntil,
+		size:       entrySize(result),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// An older result for the token must not outlive a newer one, even one too large to keep
+	if elem, ok := c.entries[entry.key]; ok {
+		c.remove(elem)
+	}
+	if c.maxBytes > 0 && entry.size > c.maxBytes {
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.bytes += entry.size
+	if !c.closed {
+		metrics.TokenCacheEntries.WithLabelValues().Inc()
+	}
+
+	for c.overLimit() {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+		metrics.TokenCacheRemovals.WithLabelValues("evicted").Inc()
+	}
+}
+
+// Delete drops any result cached for token, including one kept for stale lookups
+func (c *TokenCache) Delete(token string) {
+	key := hashToken(token)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// Sweep removes all entries past their stale grace window and returns how many were removed
+func (c *TokenCache) Sweep() int {
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for elem := c.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if !now.Before(elem.Value.(*cacheEntry).staleUntil) {
+			c.remove(elem)
+			removed++
+		}
+		elem = prev
+	}
+	c.expired.Add(uint64(removed))
+	metrics.TokenCacheRemovals.WithLabelValues("expired").Add(float64(removed))
+	return removed
+}
+
+// Stats returns the current size and cumulative counters
+func (c *TokenCache) Stats() CacheStats {
+	c.mu.Lock()
+	entries, bytes := len(c.entries), c.bytes
+	c.mu.Unlock()
+
+	return CacheStats{
+		Entries:      entries,
+		Bytes:        bytes,
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		StaleHits:    c.staleHits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+		Expired:      c.expired.Load(),
+	}
+}
+
+// Close stops the background sweeper and takes the cache's entries out of the entries
+// gauge, as a cache replaced on reload is about to be dropped. The cache remains usable
+// by in-flight requests but no longer counts towards the gauge.
+func (c *TokenCache) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.closed = true
+		metrics.TokenCacheEntries.WithLabelValues().Add(-float64(len(c.entries)))
+	})
+}
+
+func (c *TokenCache) sweepLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.Sweep()
+		}
+	}
+}
+
+func (c *TokenCache) overLimit() bool {
+	if c.lru.Len() == 0 {
+		return false
+	}
+	return (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)
+}
+
+// remove must be called with c.mu held
+func (c *TokenCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+	if !c.closed {
+		metrics.TokenCacheEntries.WithLabelValues().Dec()
+	}
+}
+
+// entrySize estimates the memory held by a cached result
+func entrySize(result *IntrospectionResponse) int64 {
+	size := int64(entryOverhead + len(result.Username) + len(result.ClientID) + len(result.Subject))
+	for _, role := range result.RealmAccess.Roles {
+		size += int64(16 + len(role))
+	}
+	for client, access := range result.ResourceAccess {
+		size += int64(48 + len(client))
+		for _, role := range access.Roles {
+			size += int64(16 + len(role))
+		}
+	}
+	return size + claimSize(result.Claims)
+}
+
+// claimSize estimates the memory held by a decoded JSON value
+func claimSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		size := int64(48)
+		for key, item := range v {
+			size += int64(16+len(key)) + claimSize(item)
+		}
+		return size
+	case []interface{}:
+		size := int64(24)
+		for _, item := range v {
+			size += claimSize(item)
+		}
+		return size
+	case string:
+		return int64(16 + len(v))
+	default:
+		return 16
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+)
+
+// newTestCache returns a cache with a controllable clock and no sweeper
+func newTestCache(maxEntries int, maxBytes int64) (*TokenCache, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	c := NewTokenCache(maxEntries, maxBytes, 0)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestTokenCacheDoesNotKeepRawTokens(t *testing.T) {
+	c, now := newTestCache(10, 0)
+	c.Set("secret-token", &IntrospectionResponse{Active: true}, now.Add(time.Minute), time.Time{})
+
+	for key := range c.entries {
+		if key != hashToken("secret-token") {
+			t.Fatalf("expected entries to be keyed by token hash")
+		}
+	}
+	if _, ok := c.Get("secret-token"); !ok {
+		t.Fatal("expected cache hit")
+	}
+}
+
+func TestTokenCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, now := newTestCache(2, 0)
+	expiry := now.Add(time.Minute)
+	c.Set("a", &IntrospectionResponse{Username: "a"}, expiry, time.Time{})
+	c.Set("b", &IntrospectionResponse{Username: "b"}, expiry, time.Time{})
+	c.Get("a") // a is now more recent than b
+	c.Set("c", &IntrospectionResponse{Username: "c"}, expiry, time.Time{})
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected least recently used entry to be evicted")
+	}
+	for _, token := range []string{"a", "c"} {
+		if _, ok := c.Get(token); !ok {
+			t.Fatalf("expected %s to remain cached", token)
+		}
+	}
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestTokenCacheRespectsByteBudget(t *testing.T) {
+	result := &IntrospectionResponse{Username: "alice", RealmAccess: RealmAccess{Roles: []string{"admin"}}}
+	size := entrySize(result)
+	c, now := newTestCache(0, 3*size)
+
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprintf("token-%d", i), result, now.Add(time.Minute), time.Time{})
+	}
+	stats := c.Stats()
+	if stats.Entries != 3 || stats.Bytes != 3*size || stats.Evictions != 7 {
+		t.Fatalf("expected cache to stay within byte budget, got %+v", stats)
+	}
+
+	// An entry larger than the whole budget is not cached at all
+	c.Set("huge", &IntrospectionResponse{Username: string(make([]byte, 4*size))}, now.Add(time.Minute), time.Time{})
+	if _, ok := c.Get("huge"); ok {
+		t.Fatal("expected oversized entry to be skipped")
+	}
+
+	// ...and does not leave an older result for the same token behind
+	c.Set("token-9", &IntrospectionResponse{Username: string(make([]byte, 4*size))}, now.Add(time.Minute), time.Time{})
+	if _, ok := c.GetStale("token-9"); ok {
+		t.Fatal("expected oversized entry to replace the older result")
+	}
+}
+
+func TestTokenCacheDelete(t *testing.T) {
+	c, now := newTestCache(10, 0)
+	c.Set("token", &IntrospectionResponse{}, now.Add(time.Minute), now.Add(time.Hour))
+	c.Delete("token")
+	c.Delete("unknown")
+
+	if _, ok := c.GetStale("token"); ok {
+		t.Fatal("expected deleted entry to be gone from stale lookups")
+	}
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestTokenCacheExpiresEntries(t *testing.T) {
+	c, now := newTestCache(10, 0)
+	c.Set("short", &IntrospectionResponse{}, now.Add(time.Second), time.Time{})
+	c.Set("long", &IntrospectionResponse{}, now.Add(time.Hour), time.Time{})
+
+	*now = now.Add(time.Minute)
+	if removed := c.Sweep(); removed != 1 {
+		t.Fatalf("expected sweep to remove 1 expired entry, got %d", removed)
+	}
+	if _, ok := c.Get("long"); !ok {
+		t.Fatal("expected unexpired entry to survive the sweep")
+	}
+
+	*now = now.Add(2 * time.Hour)
+	if _, ok := c.Get("long"); ok {
+		t.Fatal("expected expired entry to miss")
+	}
+	stats := c.Stats()
+	if stats.Entries != 0 || stats.Expired != 2 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestTokenCacheSweeperRunsInBackground(t *testing.T) {
+	c := NewTokenCache(10, 0, time.Millisecond)
+	defer c.Close()
+	c.Set("token", &IntrospectionResponse{}, time.Now().Add(-time.Second), time.Time{})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Stats().Entries != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected background sweeper to purge expired entry")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTokenCacheCloseRemovesEntriesFromGauge(t *testing.T) {
+	gauge := metrics.TokenCacheEntries.WithLabelValues()
+	before := gauge.Value()
+
+	c, now := newTestCache(10, 0)
+	for _, token := range []string{"a", "b", "c"} {
+		c.Set(token, &IntrospectionResponse{}, now.Add(time.Minute), time.Time{})
+	}
+	if got := gauge.Value() - before; got != 3 {
+		t.Fatalf("expected gauge to count 3 entries, got %v", got)
+	}
+
+	c.Close()
+	if got := gauge.Value(); got != before {
+		t.Fatalf("expected closed cache's entries to leave the gauge, got %v want %v", got, before)
+	}
+
+	// Requests still in flight after a reload may keep using the old cache
+	c.Set("d", &IntrospectionResponse{}, now.Add(time.Minute), time.Time{})
+	*now = now.Add(time.Hour)
+	c.Sweep()
+	if got := gauge.Value(); got != before {
+		t.Fatalf("expected closed cache to stay out of the gauge, got %v want %v", got, before)
+	}
+}
+
+func TestTokenCacheKeepsEntriesForStaleGrace(t *testing.T) {
+	c, now := newTestCache(10, 0)
+	c.Set("token", &IntrospectionResponse{Username: "alice"}, now.Add(time.Minute), now.Add(time.Hour))
+
+	*now = now.Add(30 * time.Minute)
+	if _, ok := c.Get("token"); ok {
+		t.Fatal("expected entry past its expiry to miss on Get")
+	}
+	if c.Sweep() != 0 {
+		t.Fatal("expected sweep to keep entries within stale grace")
+	}
+	if result, ok := c.GetStale("token"); !ok || result.Username != "alice" {
+		t.Fatalf("expected stale result, got %+v", result)
+	}
+
+	*now = now.Add(time.Hour)
+	if _, ok := c.GetStale("token"); ok {
+		t.Fatal("expected no stale result after the grace window")
+	}
+	if stats := c.Stats(); stats.StaleHits != 1 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
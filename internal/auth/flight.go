@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"sync"
+)
+
+// flightCall is one in-flight introspection shared by every caller asking for the same token
+type flightCall struct {
+	done    chan struct{}
+	result  *IntrospectionResponse
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup collapses concurrent lookups of the same key into a single call.
+// Unlike a plain singleflight, each caller can give up when its own context is
+// cancelled, and the shared call is cancelled once no caller is waiting for it.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// do runs fn once per key for all concurrent callers and returns its result to each of them
+func (g *flightGroup) do(ctx context.Context, key string, fn func(context.Context) (*IntrospectionResponse, error)) (*IntrospectionResponse, error) {
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if !ok {
+		// The shared call keeps the first caller's values (e.g. trace context) but
+		// not its cancellation, which would otherwise fail every other waiter.
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go g.run(callCtx, key, c, fn)
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.result, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 && g.calls[key] == c {
+			// Nobody is waiting any more; abandon the call so a later caller starts fresh
+			delete(g.calls, key)
+			c.cancel()
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (g *flightGroup) run(ctx context.Context, key string, c *flightCall, fn func(context.Context) (*IntrospectionResponse, error)) {
+	c.result, c.err = fn(ctx)
+	c.cancel()
+
+	g.mu.Lock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+
+	close(c.done)
+}
+
+// waiting returns the number of callers waiting on key
+func (g *flightGroup) waiting(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c.waiters
+	}
+	return 0
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// blockingIntrospectionServer counts introspection calls and holds them until released
+func blockingIntrospectionServer(t *testing.T, release <-chan struct{}, cancelled chan<- struct{}) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm() // drain the body so the server notices client disconnects
+		calls.Add(1)
+		select {
+		case <-release:
+			w.Write([]byte(`{"active":true,"username":"alice","exp":9999999999}`))
+		case <-r.Context().Done():
+			if cancelled != nil {
+				close(cancelled)
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func newFlightTestClient(url string) *Client {
+	return NewClient(&config.AuthzConfig{
+		IntrospectionURL: url,
+		ClientID:         "gateway",
+		ClientSecret:     "secret",
+		Timeout:          5 * time.Second,
+	}, false, 0)
+}
+
+// waiting returns how many callers are waiting on the introspection of token
+func waiting(c *Client, token string) int {
+	key := hashToken(token)
+	return c.flight.waiting(string(key[:]))
+}
+
+func waitForWaiters(t *testing.T, c *Client, token string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for waiting(c, token) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d waiters, got %d", n, waiting(c, token))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestIntrospectTokenCollapsesConcurrentCalls(t *testing.T) {
+	const callers = 50
+	release := make(chan struct{})
+	server, calls := blockingIntrospectionServer(t, release, nil)
+	client := newFlightTestClient(server.URL)
+
+	var wg sync.WaitGroup
+	results := make([]*IntrospectionResponse, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = client.IntrospectToken(context.Background(), "burst-token")
+		}(i)
+	}
+
+	waitForWaiters(t, client, "burst-token", callers)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected exactly 1 introspection call for %d parallel callers, got %d", callers, got)
+	}
+	for i := 0; i < callers; i++ {
+		if errs[i] != nil || results[i] == nil || results[i].Username != "alice" {
+			t.Fatalf("caller %d: unexpected result %+v, err %v", i, results[i], errs[i])
+		}
+	}
+}
+
+func TestIntrospectTokenDoesNotCollapseDifferentTokens(t *testing.T) {
+	release := make(chan struct{})
+	server, calls := blockingIntrospectionServer(t, release, nil)
+	client := newFlightTestClient(server.URL)
+
+	var wg sync.WaitGroup
+	for _, token := range []string{"token-a", "token-b"} {
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+			client.IntrospectToken(context.Background(), token)
+		}(token)
+	}
+	waitForWaiters(t, client, "token-a", 1)
+	waitForWaiters(t, client, "token-b", 1)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected one call per distinct token, got %d", got)
+	}
+}
+
+func TestIntrospectTokenCallerCancellationDoesNotAffectOthers(t *testing.T) {
+	release := make(chan struct{})
+	server, calls := blockingIntrospectionServer(t, release, nil)
+	client := newFlightTestClient(server.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelledErr := make(chan error, 1)
+	go func() {
+		_, err := client.IntrospectToken(ctx, "shared-token")
+		cancelledErr <- err
+	}()
+	patientResult := make(chan *IntrospectionResponse, 1)
+	go func() {
+		result, _ := client.IntrospectToken(context.Background(), "shared-token")
+		patientResult <- result
+	}()
+	waitForWaiters(t, client, "shared-token", 2)
+
+	cancel()
+	if err := <-cancelledErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancelled caller to return context.Canceled, got %v", err)
+	}
+
+	close(release)
+	if result := <-patientResult; result == nil || result.Username != "alice" {
+		t.Fatalf("expected remaining caller to receive the shared result, got %+v", result)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected 1 introspection call, got %d", got)
+	}
+}
+
+func TestIntrospectTokenAbandonsCallWhenAllCallersCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	upstreamCancelled := make(chan struct{})
+	server, calls := blockingIntrospectionServer(t, release, upstreamCancelled)
+	client := newFlightTestClient(server.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		client.IntrospectToken(ctx, "abandoned-token")
+		close(done)
+	}()
+	waitForWaiters(t, client, "abandoned-token", 1)
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the shared introspection request to be cancelled")
+	}
+	if waiting(client, "abandoned-token") != 0 {
+		t.Fatal("expected abandoned call to be removed")
+	}
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// jsonWebKey is one entry of a JWKS document (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSValidator validates signed JWTs locally against the issuer's JSON Web Key Set.
+// Keys are cached and refetched periodically or when a token references an unknown kid.
+type JWKSValidator struct {
+	config     *config.JWKSConfig
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+
+	refreshMu sync.Mutex // serializes JWKS fetches
+}
+
+// NewJWKSValidator creates a validator for the configured JWKS endpoint
+func NewJWKSValidator(cfg *config.JWKSConfig, timeout time.Duration) *JWKSValidator {
+	return &JWKSValidator{
+		config: cfg,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				MaxIdleConns:        10,
+				MaxIdleConnsPerHost: 2,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			Timeout: timeout,
+		},
+		now:  time.Now,
+		keys: make(map[string]crypto.PublicKey),
+	}
+}
+
+// ValidateToken verifies the token's signature and exp, nbf, iss and aud claims
+func (v *JWKSValidator) ValidateToken(ctx context.Context, token string) (*IntrospectionResponse, error) {
+	parsed, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := v.key(ctx, parsed.header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := parsed.verifySignature(key); err != nil {
+		return nil, err
+	}
+	if err := parsed.claims.validateClaims(v.now(), v.config.Issuer, v.config.Audience, v.config.Leeway); err != nil {
+		return nil, err
+	}
+
+	return parsed.claims.introspectionResponse(), nil
+}
+
+// key returns the public key for kid, refreshing the key set when it is stale
+// or does not contain kid (the issuer rotated its keys)
+func (v *JWKSValidator) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	stale := v.now().Sub(v.fetchedAt) > v.config.RefreshInterval
+	v.mu.RUnlock()
+
+	if ok && !stale {
+		return key, nil
+	}
+
+	if err := v.refresh(ctx, !ok); err != nil && !ok {
+		return nil, err
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no JWKS key with kid %q", kid)
+}
+
+// refresh refetches the key set. Fetches are throttled by min_refresh_interval so
+// tokens with made-up kids cannot hammer the issuer; force only bypasses the
+// staleness check, not the throttle.
+func (v *JWKSValidator) refresh(ctx context.Context, force bool) error {
+	v.refreshMu.Lock()
+	defer v.refreshMu.Unlock()
+
+	v.mu.RLock()
+	fresh := v.now().Sub(v.fetchedAt) <= v.config.RefreshInterval
+	throttled := v.now().Sub(v.lastAttempt) < v.config.MinRefreshInterval
+	v.mu.RUnlock()
+	if (fresh && !force) || throttled {
+		return nil
+	}
+
+	v.mu.Lock()
+	v.lastAttempt = v.now()
+	v.mu.Unlock()
+
+	keys, err := v.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = v.now()
+	v.mu.Unlock()
+	return nil
+}
+
+// fetch downloads and parses the JWKS document
+func (v *JWKSValidator) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", v.config.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("JWKS request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("JWKS request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(document.Keys))
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip keys we cannot use rather than rejecting the whole set
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// publicKey converts an RSA or EC JWK into a Go public key
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK number: %w", err)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+const testIssuer = "https://keycloak/realms/test"
+
+// jwksServer serves a mutable key set and counts fetches
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []map[string]string
+	fetches atomic.Int64
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	t.Helper()
+	s := &jwksServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		s.fetches.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setRSAKeys(kids map[string]*rsa.PrivateKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = nil
+	for kid, key := range kids {
+		s.keys = append(s.keys, map[string]string{
+			"kty": "RSA", "kid": kid, "use": "sig",
+			"n": b64(key.N.Bytes()),
+			"e": b64(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+}
+
+func (s *jwksServer) addECKey(kid string, key *ecdsa.PrivateKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, map[string]string{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": b64(key.X.FillBytes(make([]byte, 32))),
+		"y": b64(key.Y.FillBytes(make([]byte, 32))),
+	})
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signTestJWT(t *testing.T, key crypto.Signer, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	case "PS256":
+		sig, err = rsa.SignPSS(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	default:
+		t.Fatalf("unsupported test alg %s", alg)
+	}
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signingInput + "." + b64(sig)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                testIssuer,
+		"aud":                []string{"account", "gateway"},
+		"sub":                "user-1",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"nbf":                time.Now().Add(-time.Minute).Unix(),
+		"preferred_username": "alice",
+		"azp":                "web-app",
+		"realm_access":       map[string]interface{}{"roles": []string{"admin"}},
+		"resource_access":    map[string]interface{}{"app": map[string]interface{}{"roles": []string{"editor"}}},
+	}
+}
+
+func newTestJWKSValidator(url string) *JWKSValidator {
+	return NewJWKSValidator(&config.JWKSConfig{
+		URL:                url,
+		Issuer:             testIssuer,
+		Audience:           []string{"gateway"},
+		RefreshInterval:    time.Hour,
+		MinRefreshInterval: time.Millisecond,
+	}, 5*time.Second)
+}
+
+func mustRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	return key
+}
+
+func TestJWKSValidatorAcceptsValidRS256Token(t *testing.T) {
+	key := mustRSAKey(t)
+	server := newJWKSServer(t)
+	server.setRSAKeys(map[string]*rsa.PrivateKey{"k1": key})
+	v := newTestJWKSValidator(server.URL)
+
+	result, err := v.ValidateToken(context.Background(), signTestJWT(t, key, "RS256", "k1", validClaims()))
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if !result.Active || result.Username != "alice" || result.ClientID != "web-app" {
+		t.Fatalf("unexpected claims: %+v", result)
+	}
+	roles := result.GetAllRoles()
+	if len(roles) != 2 {
+		t.Fatalf("expected realm and resource roles, got %v", roles)
+	}
+	if sub, _ := result.Claim("sub"); sub != "user-1" {
+		t.Fatalf("expected all JWT claims to be available, got sub=%v", sub)
+	}
+
+	// Second validation uses the cached key set
+	if _, err := v.ValidateToken(context.Background(), signTestJWT(t, key, "PS256", "k1", validClaims())); err != nil {
+		t.Fatalf("ValidateToken PS256: %v", err)
+	}
+	if server.fetches.Load() != 1 {
+		t.Fatalf("expected JWKS to be fetched once, got %d", server.fetches.Load())
+	}
+}
+
+func TestJWKSValidatorAcceptsValidES256Token(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate EC key: %v", err)
+	}
+	server := newJWKSServer(t)
+	server.addECKey("ec1", key)
+	v := newTestJWKSValidator(server.URL)
+
+	if _, err := v.ValidateToken(context.Background(), signTestJWT(t, key, "ES256", "ec1", validClaims())); err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+}
+
+func TestJWKSValidatorRejectsInvalidTokens(t *testing.T) {
+	key := mustRSAKey(t)
+	otherKey := mustRSAKey(t)
+	server := newJWKSServer(t)
+	server.setRSAKeys(map[string]*rsa.PrivateKey{"k1": key})
+	v := newTestJWKSValidator(server.URL)
+
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		expect string
+	}{
+		{"expired", signTestJWT(t, key, "RS256", "k1", with("exp", time.Now().Add(-time.Hour).Unix())), "expired"},
+		{"missing exp", signTestJWT(t, key, "RS256", "k1", with("exp", nil)), "no exp"},
+		{"not yet valid", signTestJWT(t, key, "RS256", "k1", with("nbf", time.Now().Add(time.Hour).Unix())), "not valid yet"},
+		{"wrong issuer", signTestJWT(t, key, "RS256", "k1", with("iss", "https://evil")), "issuer"},
+		{"wrong audience", signTestJWT(t, key, "RS256", "k1", with("aud", "other")), "audience"},
+		{"bad signature", signTestJWT(t, otherKey, "RS256", "k1", validClaims()), "signature"},
+		{"unknown kid", signTestJWT(t, key, "RS256", "nope", validClaims()), "no JWKS key"},
+		{"opaque token", "opaque-token", "not a JWT"},
+		{"alg none", b64([]byte(`{"alg":"none","kid":"k1"}`)) + "." + b64([]byte(`{}`)) + ".", "unsupported"},
+		{"hmac", b64([]byte(`{"alg":"HS256","kid":"k1"}`)) + "." + b64([]byte(`{}`)) + "." + b64([]byte("sig")), "unsupported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateToken(context.Background(), tt.token)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestJWKSValidatorRefetchesOnUnknownKid(t *testing.T) {
+	oldKey := mustRSAKey(t)
+	newKey := mustRSAKey(t)
+	server := newJWKSServer(t)
+	server.setRSAKeys(map[string]*rsa.PrivateKey{"old": oldKey})
+	v := newTestJWKSValidator(server.URL)
+
+	if _, err := v.ValidateToken(context.Background(), signTestJWT(t, oldKey, "RS256", "old", validClaims())); err != nil {
+		t.Fatalf("ValidateToken old key: %v", err)
+	}
+
+	// Issuer rotates its signing key
+	server.setRSAKeys(map[string]*rsa.PrivateKey{"new": newKey})
+	time.Sleep(2 * time.Millisecond) // let min_refresh_interval pass
+
+	if _, err := v.ValidateToken(context.Background(), signTestJWT(t, newKey, "RS256", "new", validClaims())); err != nil {
+		t.Fatalf("ValidateToken new key: %v", err)
+	}
+	if server.fetches.Load() != 2 {
+		t.Fatalf("expected a refetch for the unknown kid, got %d fetches", server.fetches.Load())
+	}
+}
+
+func TestJWKSValidatorThrottlesRefetches(t *testing.T) {
+	key := mustRSAKey(t)
+	server := newJWKSServer(t)
+	server.setRSAKeys(map[string]*rsa.PrivateKey{"k1": key})
+	v := NewJWKSValidator(&config.JWKSConfig{
+		URL:                server.URL,
+		Issuer:             testIssuer,
+		RefreshInterval:    time.Hour,
+		MinRefreshInterval: time.Hour,
+	}, 5*time.Second)
+
+	for i := 0; i < 5; i++ {
+		v.ValidateToken(context.Background(), signTestJWT(t, key, "RS256", "unknown", validClaims()))
+	}
+	if server.fetches.Load() != 1 {
+		t.Fatalf("expected unknown kids not to trigger repeated fetches, got %d", server.fetches.Load())
+	}
+}
+
+func TestJWKSValidatorReportsFetchErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	v := newTestJWKSValidator(server.URL)
+
+	_, err := v.ValidateToken(context.Background(), signTestJWT(t, mustRSAKey(t), "RS256", "k1", validClaims()))
+	if err == nil || !strings.Contains(err.Error(), "status 500") {
+		t.Fatalf("expected JWKS fetch error, got: %v", err)
+	}
+}
+
+func TestFallbackValidatorUsesIntrospectionForOpaqueTokens(t *testing.T) {
+	introspection := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"active":true,"username":"bob"}`))
+	}))
+	defer introspection.Close()
+	jwks := newJWKSServer(t)
+
+	v := &FallbackValidator{
+		Primary: newTestJWKSValidator(jwks.URL),
+		Fallback: NewClient(&config.AuthzConfig{
+			IntrospectionURL: introspection.URL,
+			ClientID:         "gateway",
+			ClientSecret:     "secret",
+		}, false, 0),
+	}
+
+	result, err := v.ValidateToken(context.Background(), "opaque-token")
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if result.Username != "bob" {
+		t.Fatalf("expected introspection result, got %+v", result)
+	}
+}
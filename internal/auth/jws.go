@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// PayloadSigner signs arbitrary JSON payloads as compact JWS, e.g. audit log checkpoints
+type PayloadSigner struct {
+	key signingKey
+}
+
+// NewPayloadSigner loads a PEM encoded RSA or EC private key
+func NewPayloadSigner(cfg config.SigningKeyConfig) (*PayloadSigner, error) {
+	key, err := loadSigningKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &PayloadSigner{key: key}, nil
+}
+
+// KeyID returns the kid placed in every signature header
+func (s *PayloadSigner) KeyID() string {
+	return s.key.kid
+}
+
+// Sign returns a compact JWS carrying payload
+func (s *PayloadSigner) Sign(payload []byte) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: s.key.alg, Kid: s.key.kid})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := s.key.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// VerifyPayload checks a compact JWS made by PayloadSigner against key and returns its payload
+func VerifyPayload(token string, key crypto.PublicKey) ([]byte, error) {
+	parsed, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := parsed.verifySignature(key); err != nil {
+		return nil, err
+	}
+	return base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[1])
+}
+
+// LoadPublicKey reads a public key from a PEM file holding a public key, a certificate
+// or a private key
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM block", path)
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	key, err := loadSigningKey(config.SigningKeyConfig{File: path})
+	if err != nil {
+		return nil, err
+	}
+	return key.key.Public(), nil
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+func TestPayloadSignerRoundTrip(t *testing.T) {
+	key := mustECKey(t)
+	signer, err := NewPayloadSigner(config.SigningKeyConfig{File: writeKeyFile(t, key)})
+	if err != nil {
+		t.Fatalf("NewPayloadSigner: %v", err)
+	}
+	token, err := signer.Sign([]byte(`{"seq":1}`))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	payload, err := VerifyPayload(token, &key.PublicKey)
+	if err != nil {
+		t.Fatalf("VerifyPayload: %v", err)
+	}
+	if string(payload) != `{"seq":1}` {
+		t.Fatalf("expected the signed payload back, got %s", payload)
+	}
+
+	parts := strings.Split(token, ".")
+	parts[1] = b64([]byte(`{"seq":2}`))
+	if _, err := VerifyPayload(strings.Join(parts, "."), &key.PublicKey); err == nil {
+		t.Fatal("expected a modified payload to fail verification")
+	}
+	if _, err := VerifyPayload(token, &mustECKey(t).PublicKey); err == nil {
+		t.Fatal("expected verification with another key to fail")
+	}
+}
+
+func TestLoadPublicKeyAcceptsPublicAndPrivateKeys(t *testing.T) {
+	key := mustECKey(t)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	publicPath := filepath.Join(t.TempDir(), "public.pem")
+	if err := os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	for _, path := range []string{publicPath, writeKeyFile(t, key)} {
+		loaded, err := LoadPublicKey(path)
+		if err != nil {
+			t.Fatalf("LoadPublicKey(%s): %v", filepath.Base(path), err)
+		}
+		if !key.PublicKey.Equal(loaded) {
+			t.Fatalf("LoadPublicKey(%s) returned another key", filepath.Base(path))
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// jwtHeader is the JOSE header of a compact-serialized JWS
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// jwtClaims are the registered and Keycloak-specific claims the gateway reads from a JWT
+type jwtClaims struct {
+	Issuer            string                 `json:"iss"`
+	Subject           string                 `json:"sub"`
+	Audience          audience               `json:"aud"`
+	ExpiresAt         int64                  `json:"exp"`
+	NotBefore         int64                  `json:"nbf"`
+	PreferredUsername string                 `json:"preferred_username"`
+	Username          string                 `json:"username"`
+	AuthorizedParty   string                 `json:"azp"`
+	ClientID          string                 `json:"client_id"`
+	RealmAccess       RealmAccess            `json:"realm_access"`
+	ResourceAccess    map[string]RealmAccess `json:"resource_access"`
+
+	all map[string]interface{} // every claim, including the ones above
+}
+
+// audience accepts both the string and array forms of the aud claim
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("aud must be a string or array of strings")
+	}
+	*a = many
+	return nil
+}
+
+// parsedJWT is a decoded but not yet verified token
+type parsedJWT struct {
+	header       jwtHeader
+	claims       jwtClaims
+	signingInput string
+	signature    []byte
+}
+
+var errNotJWT = errors.New("token is not a JWT")
+
+// parseJWT decodes a compact JWS without verifying it
+func parseJWT(token string) (*parsedJWT, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errNotJWT
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT header encoding: %w", err)
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT claims encoding: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT signature encoding: %w", err)
+	}
+
+	parsed := &parsedJWT{
+		signingInput: parts[0] + "." + parts[1],
+		signature:    signature,
+	}
+	if err := json.Unmarshal(headerJSON, &parsed.header); err != nil {
+		return nil, fmt.Errorf("invalid JWT header: %w", err)
+	}
+	if err := json.Unmarshal(claimsJSON, &parsed.claims); err != nil {
+		return nil, fmt.Errorf("invalid JWT claims: %w", err)
+	}
+	if err := json.Unmarshal(claimsJSON, &parsed.claims.all); err != nil {
+		return nil, fmt.Errorf("invalid JWT claims: %w", err)
+	}
+	return parsed, nil
+}
+
+// hashForAlg returns the digest used by a JWS algorithm
+func hashForAlg(alg string) (crypto.Hash, error) {
+	switch alg[len(alg)-3:] {
+	case "256":
+		return crypto.SHA256, nil
+	case "384":
+		return crypto.SHA384, nil
+	case "512":
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported JWT algorithm %q", alg)
+}
+
+// verifySignature checks the token signature with the given public key.
+// Supported algorithms are RS*, PS* and ES* with SHA-256/384/512; "none" and HMAC are rejected.
+func (t *parsedJWT) verifySignature(key crypto.PublicKey) error {
+	alg := t.header.Alg
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+	hash, err := hashForAlg(alg)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write([]byte(t.signingInput))
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match JWT algorithm %s", alg)
+		}
+		if alg[:2] == "RS" {
+			err = rsa.VerifyPKCS1v15(rsaKey, hash, digest, t.signature)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, hash, digest, t.signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return errors.New("invalid JWT signature")
+		}
+		return nil
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match JWT algorithm %s", alg)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(t.signature) != 2*size {
+			return errors.New("invalid JWT signature")
+		}
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid JWT signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported JWT algorithm %q", alg)
+}
+
+// validateClaims checks exp, nbf, iss and aud against the expected values
+func (c *jwtClaims) validateClaims(now time.Time, issuer string, audiences []string, leeway time.Duration) error {
+	if c.ExpiresAt == 0 {
+		return errors.New("token has no exp claim")
+	}
+	if now.After(time.Unix(c.ExpiresAt, 0).Add(leeway)) {
+		return errors.New("token is expired")
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if issuer != "" && c.Issuer != issuer {
+		return fmt.Errorf("unexpected token issuer %q", c.Issuer)
+	}
+	if len(audiences) > 0 && !c.hasAudience(audiences) {
+		return errors.New("token audience not accepted")
+	}
+	return nil
+}
+
+func (c *jwtClaims) hasAudience(accepted []string) bool {
+	for _, aud := range c.Audience {
+		for _, want := range accepted {
+			if aud == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// introspectionResponse builds introspection-style claims so RBAC and audit logging
+// treat locally validated tokens exactly like introspected ones
+func (c *jwtClaims) introspectionResponse() *IntrospectionResponse {
+	username := c.PreferredUsername
+	if username == "" {
+		username = c.Username
+	}
+	if username == "" {
+		username = c.Subject
+	}
+	clientID := c.ClientID
+	if clientID == "" {
+		clientID = c.AuthorizedParty
+	}
+	return &IntrospectionResponse{
+		Active:         true,
+		RealmAccess:    c.RealmAccess,
+		ResourceAccess: c.ResourceAccess,
+		Username:       username,
+		ClientID:       clientID,
+		Subject:        c.Subject,
+		Exp:            c.ExpiresAt,
+		Claims:         c.all,
+	}
+}
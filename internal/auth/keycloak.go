@@ -6,22 +6,58 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+	"github.com/aveiga/cloud-api-gateway/internal/tracing"
 )
 
 // IntrospectionResponse represents the response from Keycloak token introspection
 type IntrospectionResponse struct {
-	Active       bool              `json:"active"`
-	RealmAccess  RealmAccess      `json:"realm_access"`
+	Active         bool                   `json:"active"`
+	RealmAccess    RealmAccess            `json:"realm_access"`
 	ResourceAccess map[string]RealmAccess `json:"resource_access"`
-	Username     string           `json:"username"`
-	ClientID     string           `json:"client_id"`
-	Exp          int64            `json:"exp"`
+	Username       string                 `json:"username"`
+	ClientID       string                 `json:"client_id"`
+	Subject        string                 `json:"sub"`
+	Exp            int64                  `json:"exp"`
+
+	// Claims holds every claim of the token, including those without a field above
+	Claims map[string]interface{} `json:"-"`
+}
+
+// UnmarshalJSON decodes the known fields and keeps all claims in Claims
+func (ir *IntrospectionResponse) UnmarshalJSON(data []byte) error {
+	type fields IntrospectionResponse
+	if err := json.Unmarshal(data, (*fields)(ir)); err != nil {
+		return err
+	}
+	ir.Claims = nil
+	return json.Unmarshal(data, &ir.Claims)
+}
+
+// Claim returns a claim by name. A name that is not a top-level claim is treated as a
+// dot-separated path into nested objects, e.g. "address.country".
+func (ir *IntrospectionResponse) Claim(name string) (interface{}, bool) {
+	if value, ok := ir.Claims[name]; ok {
+		return value, true
+	}
+	var value interface{} = ir.Claims
+	for _, part := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
 }
 
 // RealmAccess contains role information
@@ -29,23 +65,30 @@ type RealmAccess struct {
 	Roles []string `json:"roles"`
 }
 
-// CachedToken stores token introspection result with expiration
-type CachedToken struct {
-	Result     *IntrospectionResponse
-	ExpiresAt  time.Time
-}
-
 // Client handles Keycloak token introspection with caching
 type Client struct {
-	config      *config.AuthzConfig
-	httpClient  *http.Client
-	cache       *sync.Map // map[string]*CachedToken
+	config       *config.AuthzConfig
+	httpClient   *http.Client
+	cache        *TokenCache
 	cacheEnabled bool
-	cacheTTL    time.Duration
+	cacheTTL     time.Duration
+	negativeTTL  time.Duration
+	staleGrace   time.Duration
+	flight       *flightGroup
 }
 
-// NewClient creates a new Keycloak introspection client
+// NewClient creates a new Keycloak introspection client with a default-sized cache
 func NewClient(cfg *config.AuthzConfig, cacheEnabled bool, cacheTTL time.Duration) *Client {
+	return NewClientWithCache(cfg, config.CacheConfig{
+		Enabled:    cacheEnabled,
+		TTL:        cacheTTL,
+		MaxEntries: config.DefaultCacheMaxEntries,
+	})
+}
+
+// NewClientWithCache creates a new Keycloak introspection client using the given cache settings.
+// A positive sweep interval starts a background sweeper that runs until Close.
+func NewClientWithCache(cfg *config.AuthzConfig, cacheCfg config.CacheConfig) *Client {
 	// Create HTTP client with connection pooling
 	transport := &http.Transport{
 		MaxIdleConns:        100,
@@ -58,29 +101,91 @@ func NewClient(cfg *config.AuthzConfig, cacheEnabled bool, cacheTTL time.Duratio
 		Timeout:   cfg.Timeout,
 	}
 
-	return &Client{
+	client := &Client{
 		config:       cfg,
 		httpClient:   httpClient,
-		cache:        &sync.Map{},
-		cacheEnabled: cacheEnabled,
-		cacheTTL:     cacheTTL,
+		cacheEnabled: cacheCfg.Enabled,
+		cacheTTL:     cacheCfg.TTL,
+		negativeTTL:  cacheCfg.NegativeTTL,
+		staleGrace:   cacheCfg.StaleGrace,
+		flight:       newFlightGroup(),
+	}
+	if cacheCfg.Enabled {
+		client.cache = NewTokenCache(cacheCfg.MaxEntries, cacheCfg.MaxBytes, cacheCfg.SweepInterval)
+	}
+	return client
+}
+
+// CacheStats reports token cache counters; all zero when caching is disabled
+func (c *Client) CacheStats() CacheStats {
+	if c.cache == nil {
+		return CacheStats{}
+	}
+	return c.cache.Stats()
+}
+
+// Close stops the cache sweeper
+func (c *Client) Close() {
+	if c.cache != nil {
+		c.cache.Close()
 	}
 }
 
 // IntrospectToken validates a token via Keycloak introspection endpoint
-func (c *Client) IntrospectToken(ctx context.Context, token string) (*IntrospectionResponse, error) {
+func (c *Client) IntrospectToken(ctx context.Context, token string) (result *IntrospectionResponse, err error) {
+	ctx, span := tracing.Start(ctx, "auth.introspect", tracing.SpanKindInternal)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
 	// Check cache first if enabled
 	if c.cacheEnabled {
-		if cached, ok := c.cache.Load(token); ok {
-			cachedToken := cached.(*CachedToken)
-			if time.Now().Before(cachedToken.ExpiresAt) {
-				return cachedToken.Result, nil
-			}
-			// Expired, remove from cache
-			c.cache.Delete(token)
+		if result, ok := c.cache.Get(token); ok {
+			span.SetAttribute("auth.cache", "hit")
+			return result, nil
 		}
 	}
+	span.SetAttribute("auth.cache", "miss")
 
+	// Concurrent lookups of the same token share one introspection call
+	key := hashToken(token)
+	result, err = c.flight.do(ctx, string(key[:]), func(ctx context.Context) (*IntrospectionResponse, error) {
+		return c.introspect(ctx, token)
+	})
+
+	// While Keycloak is failing, a recently valid result may still be served
+	if err != nil && c.cacheEnabled && c.staleGrace > 0 && ctx.Err() == nil {
+		if stale, ok := c.cache.GetStale(token); ok {
+			span.SetAttribute("auth.cache", "stale")
+			log.Printf("Token introspection failed, serving cached result within stale grace: %v", err)
+			return stale, nil
+		}
+	}
+	return result, err
+}
+
+// introspect calls the Keycloak introspection endpoint, records its latency and caches the result
+func (c *Client) introspect(ctx context.Context, token string) (*IntrospectionResponse, error) {
+	start := time.Now()
+	result, err := c.callIntrospection(ctx, token)
+	outcome := "error"
+	if err == nil && result.Active {
+		outcome = "active"
+	} else if err == nil {
+		outcome = "inactive"
+	}
+	metrics.IntrospectionDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	c.store(token, result)
+	return result, nil
+}
+
+// callIntrospection performs the introspection request
+func (c *Client) callIntrospection(ctx context.Context, token string) (*IntrospectionResponse, error) {
 	// Prepare introspection request
 	data := url.Values{}
 	data.Set("token", token)
@@ -93,6 +198,7 @@ func (c *Client) IntrospectToken(ctx context.Context, token string) (*Introspect
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tracing.Inject(ctx, req.Header)
 
 	// Execute request
 	resp, err := c.httpClient.Do(req)
@@ -112,48 +218,64 @@ func (c *Client) IntrospectToken(ctx context.Context, token string) (*Introspect
 		return nil, fmt.Errorf("failed to parse introspection response: %w", err)
 	}
 
+	return &result, nil
+}
+
+// store caches an introspection result if caching is enabled
+func (c *Client) store(token string, result *IntrospectionResponse) {
 	// Cache the result if enabled and token is active
 	if c.cacheEnabled && result.Active {
-		// Use token expiration if available, otherwise use configured TTL
-		expiresAt := time.Now().Add(c.cacheTTL)
+		// Use token expiration if available, otherwise use configured TTL.
+		// The stale grace window never extends past the token's own expiry.
+		now := time.Now()
+		expiresAt := now.Add(c.cacheTTL)
+		staleUntil := expiresAt.Add(c.staleGrace)
 		if result.Exp > 0 {
 			tokenExp := time.Unix(result.Exp, 0)
 			if tokenExp.Before(expiresAt) {
 				expiresAt = tokenExp
 			}
+			if tokenExp.Before(staleUntil) {
+				staleUntil = tokenExp
+			}
 		}
 
-		c.cache.Store(token, &CachedToken{
-			Result:    &result,
-			ExpiresAt: expiresAt,
-		})
+		c.cache.Set(token, result, expiresAt, staleUntil)
 	}
 
-	return &result, nil
+	// Briefly remember inactive tokens so clients retrying a revoked token do not hit Keycloak
+	// every time. Otherwise drop the token's earlier active result, which stale lookups could
+	// still serve while Keycloak fails.
+	if c.cacheEnabled && !result.Active {
+		if c.negativeTTL > 0 {
+			c.cache.Set(token, result, time.Now().Add(c.negativeTTL), time.Time{})
+		} else {
+			c.cache.Delete(token)
+		}
+	}
 }
 
 // GetAllRoles extracts all roles from the introspection response
 func (ir *IntrospectionResponse) GetAllRoles() []string {
 	roleSet := make(map[string]bool)
-	
+
 	// Add realm roles
 	for _, role := range ir.RealmAccess.Roles {
 		roleSet[role] = true
 	}
-	
+
 	// Add resource access roles
 	for _, access := range ir.ResourceAccess {
 		for _, role := range access.Roles {
 			roleSet[role] = true
 		}
 	}
-	
+
 	// Convert to slice
 	roles := make([]string, 0, len(roleSet))
 	for role := range roleSet {
 		roles = append(roles, role)
 	}
-	
+
 	return roles
 }
-
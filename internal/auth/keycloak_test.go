@@ -2,12 +2,16 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/tracing"
 )
 
 func TestGetAllRolesFromRealmAccess(t *testing.T) {
@@ -72,6 +76,36 @@ func TestIntrospectTokenReturnsActiveToken(t *testing.T) {
 	}
 }
 
+func TestIntrospectionResponseKeepsAllClaims(t *testing.T) {
+	var ir IntrospectionResponse
+	err := json.Unmarshal([]byte(`{"active":true,"username":"test","email":"test@example.com",`+
+		`"address":{"country":"PT"},"https://example.com/tenant":"acme"}`), &ir)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !ir.Active || ir.Username != "test" {
+		t.Fatalf("expected known fields to be decoded, got %+v", ir)
+	}
+
+	tests := []struct {
+		name   string
+		want   interface{}
+		exists bool
+	}{
+		{"email", "test@example.com", true},
+		{"address.country", "PT", true},
+		{"https://example.com/tenant", "acme", true},
+		{"address.city", nil, false},
+		{"email.domain", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := ir.Claim(tt.name)
+		if ok != tt.exists || got != tt.want {
+			t.Errorf("Claim(%q) = %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.exists)
+		}
+	}
+}
+
 func TestIntrospectTokenReturnsErrorOnNonOKStatus(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusUnauthorized)
@@ -168,3 +202,148 @@ func TestIntrospectTokenReturnsErrorOnInvalidJSON(t *testing.T) {
 		t.Fatal("expected error for invalid JSON")
 	}
 }
+
+func TestIntrospectTokenCachesInactiveResultsForNegativeTTL(t *testing.T) {
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"active":false}`))
+	}))
+	defer server.Close()
+
+	client := NewClientWithCache(&config.AuthzConfig{
+		IntrospectionURL: server.URL,
+		ClientID:         "gateway",
+		ClientSecret:     "secret",
+		Timeout:          5 * time.Second,
+	}, config.CacheConfig{Enabled: true, TTL: time.Minute, MaxEntries: 10, NegativeTTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		result, err := client.IntrospectToken(context.Background(), "revoked-token")
+		if err != nil || result.Active {
+			t.Fatalf("expected inactive result, got %+v, err %v", result, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected revoked token to be introspected once, got %d calls", calls.Load())
+	}
+	if stats := client.CacheStats(); stats.NegativeHits != 2 {
+		t.Fatalf("expected 2 negative hits, got %+v", stats)
+	}
+}
+
+func TestIntrospectTokenDoesNotCacheInactiveResultsByDefault(t *testing.T) {
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"active":false}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.AuthzConfig{IntrospectionURL: server.URL, Timeout: 5 * time.Second}, true, time.Minute)
+	client.IntrospectToken(context.Background(), "revoked-token")
+	client.IntrospectToken(context.Background(), "revoked-token")
+	if calls.Load() != 2 {
+		t.Fatalf("expected inactive results not to be cached, got %d calls", calls.Load())
+	}
+}
+
+func TestIntrospectTokenServesStaleResultWhileKeycloakFails(t *testing.T) {
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"active":true,"username":"alice","exp":9999999999}`))
+	}))
+	defer server.Close()
+
+	newClient := func(grace time.Duration) *Client {
+		return NewClientWithCache(&config.AuthzConfig{
+			IntrospectionURL: server.URL,
+			Timeout:          5 * time.Second,
+		}, config.CacheConfig{Enabled: true, TTL: 10 * time.Millisecond, MaxEntries: 10, StaleGrace: grace})
+	}
+	withGrace := newClient(time.Hour)
+	withoutGrace := newClient(0)
+	for _, client := range []*Client{withGrace, withoutGrace} {
+		if _, err := client.IntrospectToken(context.Background(), "token"); err != nil {
+			t.Fatalf("warm cache: %v", err)
+		}
+	}
+
+	failing.Store(true)
+	time.Sleep(20 * time.Millisecond) // let the fresh entries expire
+
+	result, err := withGrace.IntrospectToken(context.Background(), "token")
+	if err != nil || result.Username != "alice" {
+		t.Fatalf("expected stale result during outage, got %+v, err %v", result, err)
+	}
+	if stats := withGrace.CacheStats(); stats.StaleHits != 1 {
+		t.Fatalf("expected 1 stale hit, got %+v", stats)
+	}
+	if _, err := withoutGrace.IntrospectToken(context.Background(), "token"); err == nil {
+		t.Fatal("expected introspection error without a stale grace window")
+	}
+}
+
+func TestIntrospectTokenDoesNotServeStaleResultAfterRevocation(t *testing.T) {
+	var state atomic.Value
+	state.Store("active")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		switch state.Load() {
+		case "active":
+			w.Write([]byte(`{"active":true,"username":"alice","exp":9999999999}`))
+		case "revoked":
+			w.Write([]byte(`{"active":false}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClientWithCache(&config.AuthzConfig{
+		IntrospectionURL: server.URL,
+		Timeout:          5 * time.Second,
+	}, config.CacheConfig{Enabled: true, TTL: 10 * time.Millisecond, MaxEntries: 10, StaleGrace: time.Hour})
+	if _, err := client.IntrospectToken(context.Background(), "token"); err != nil {
+		t.Fatalf("warm cache: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond) // let the active entry go stale
+	state.Store("revoked")
+	if result, err := client.IntrospectToken(context.Background(), "token"); err != nil || result.Active {
+		t.Fatalf("expected inactive result, got %+v, err %v", result, err)
+	}
+
+	state.Store("failing")
+	if result, err := client.IntrospectToken(context.Background(), "token"); err == nil {
+		t.Fatalf("expected revoked token to be rejected during outage, got %+v", result)
+	}
+}
+
+func TestIntrospectTokenPropagatesTraceContext(t *testing.T) {
+	var traceparent atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent.Store(r.Header.Get("traceparent"))
+		w.Write([]byte(`{"active":true}`))
+	}))
+	defer server.Close()
+
+	tracer := tracing.NewTracerWithExporter(1, time.Hour, tracing.NewStdoutExporter(io.Discard, "test"))
+	defer tracer.Shutdown(context.Background())
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, span := tracer.StartServer(req, "GET")
+	defer span.End()
+
+	client := NewClient(&config.AuthzConfig{IntrospectionURL: server.URL, Timeout: 5 * time.Second}, false, 0)
+	if _, err := client.IntrospectToken(ctx, "token"); err != nil {
+		t.Fatalf("IntrospectToken: %v", err)
+	}
+	sc, err := tracing.ParseTraceparent(traceparent.Load().(string))
+	if err != nil || sc.TraceID != span.SpanContext().TraceID || sc.SpanID == span.SpanContext().SpanID {
+		t.Fatalf("expected Keycloak call to carry a child of the request trace, got %v (%v)", traceparent.Load(), err)
+	}
+}
@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// TokenSigner mints short-lived JWTs that tell upstreams who the gateway authenticated.
+// Keys are read once when the signer is created; a reload builds a new signer, which
+// is how keys are rotated.
+type TokenSigner struct {
+	config *config.UpstreamTokenConfig
+	now    func() time.Time
+
+	signing signingKey // the first configured key
+	jwks    []byte     // JWKS document publishing every configured key
+}
+
+// signingKey is a loaded private key with its JWS parameters
+type signingKey struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+// NewTokenSigner loads the configured signing keys
+func NewTokenSigner(cfg *config.UpstreamTokenConfig) (*TokenSigner, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, fmt.Errorf("no upstream token signing keys configured")
+	}
+
+	keys := make([]jsonWebKey, 0, len(cfg.Keys))
+	seen := make(map[string]bool, len(cfg.Keys))
+	var signing signingKey
+	for i, keyCfg := range cfg.Keys {
+		key, err := loadSigningKey(keyCfg)
+		if err != nil {
+			return nil, fmt.Errorf("upstream_token.keys[%d]: %w", i, err)
+		}
+		if seen[key.kid] {
+			return nil, fmt.Errorf("upstream_token.keys[%d]: kid %q is used more than once", i, key.kid)
+		}
+		seen[key.kid] = true
+		if i == 0 {
+			signing = key
+		}
+		jwk := publicJWK(key.key.Public())
+		jwk.Kid, jwk.Alg, jwk.Use = key.kid, key.alg, "sig"
+		keys = append(keys, jwk)
+	}
+
+	jwks, err := json.Marshal(struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{keys})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JWKS: %w", err)
+	}
+
+	return &TokenSigner{
+		config:  cfg,
+		now:     time.Now,
+		signing: signing,
+		jwks:    jwks,
+	}, nil
+}
+
+// JWKS returns the JSON Web Key Set upstreams use to verify minted tokens
+func (s *TokenSigner) JWKS() []byte {
+	return s.jwks
+}
+
+// upstreamClaims are the claims of a minted token
+type upstreamClaims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud,omitempty"`
+	IssuedAt int64    `json:"iat"`
+	Expires  int64    `json:"exp"`
+	ID       string   `json:"jti"`
+	Roles    []string `json:"roles"`
+	Route    string   `json:"route"`
+}
+
+// Sign mints a token for the validated caller on route. The token expires after the
+// configured TTL, or with the caller's own token if that is sooner.
+func (s *TokenSigner) Sign(caller *IntrospectionResponse, route string) (string, error) {
+	now := s.now()
+	expires := now.Add(s.config.TTL).Unix()
+	if caller.Exp > 0 && caller.Exp < expires {
+		expires = caller.Exp
+	}
+	subject := caller.Subject
+	if subject == "" {
+		subject = caller.Username
+	}
+	roles := caller.GetAllRoles()
+	sort.Strings(roles)
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+
+	header, err := json.Marshal(jwtHeader{Alg: s.signing.alg, Kid: s.signing.kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(upstreamClaims{
+		Issuer:   s.config.Issuer,
+		Subject:  subject,
+		Audience: s.config.Audience,
+		IssuedAt: now.Unix(),
+		Expires:  expires,
+		ID:       hex.EncodeToString(id),
+		Roles:    roles,
+		Route:    route,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	signature, err := s.signing.sign([]byte(signingInput))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign upstream token: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// sign produces a JWS signature over input
+func (k signingKey) sign(input []byte) ([]byte, error) {
+	hash, err := hashForAlg(k.alg)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			return nil, err
+		}
+		// JWS uses the fixed-size r || s encoding rather than ASN.1
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+		return signature, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", k.key)
+}
+
+// loadSigningKey reads a PEM encoded private key and picks its JWS algorithm
+func loadSigningKey(cfg config.SigningKeyConfig) (signingKey, error) {
+	data, err := os.ReadFile(cfg.File)
+	if err != nil {
+		return signingKey{}, fmt.Errorf("failed to read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return signingKey{}, fmt.Errorf("%s does not contain a PEM block", cfg.File)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return signingKey{}, fmt.Errorf("%s: unsupported PEM block %q", cfg.File, block.Type)
+	}
+	if err != nil {
+		return signingKey{}, fmt.Errorf("%s: %w", cfg.File, err)
+	}
+
+	key := signingKey{kid: cfg.ID}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return signingKey{}, fmt.Errorf("%s: RSA keys must be at least 2048 bits", cfg.File)
+		}
+		key.key, key.alg = k, "RS256"
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			key.alg = "ES256"
+		case elliptic.P384():
+			key.alg = "ES384"
+		case elliptic.P521():
+			key.alg = "ES512"
+		default:
+			return signingKey{}, fmt.Errorf("%s: unsupported EC curve", cfg.File)
+		}
+		key.key = k
+	default:
+		return signingKey{}, fmt.Errorf("%s: only RSA and EC keys are supported", cfg.File)
+	}
+	if key.kid == "" {
+		key.kid = thumbprint(publicJWK(key.key.Public()))
+	}
+	return key, nil
+}
+
+// publicJWK converts an RSA or EC public key into a JWK without kid, alg or use
+func publicJWK(public crypto.PublicKey) jsonWebKey {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return jsonWebKey{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return jsonWebKey{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}
+	}
+	return jsonWebKey{}
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint, used as the default kid
+func thumbprint(jwk jsonWebKey) string {
+	var members string
+	if jwk.Kty == "RSA" {
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	} else {
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Crv, jwk.X, jwk.Y)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+func writeKeyFile(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return path
+}
+
+func mustECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate EC key: %v", err)
+	}
+	return key
+}
+
+// verifyMinted checks a minted token against the signer's own JWKS and returns its claims
+func verifyMinted(t *testing.T, signer *TokenSigner, token string) (*parsedJWT, map[string]interface{}) {
+	t.Helper()
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(signer.JWKS(), &document); err != nil {
+		t.Fatalf("parse JWKS: %v", err)
+	}
+	parsed, err := parseJWT(token)
+	if err != nil {
+		t.Fatalf("parseJWT: %v", err)
+	}
+	for _, jwk := range document.Keys {
+		if jwk.Kid != parsed.header.Kid {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			t.Fatalf("publicKey: %v", err)
+		}
+		if err := parsed.verifySignature(key); err != nil {
+			t.Fatalf("verifySignature: %v", err)
+		}
+		return parsed, parsed.claims.all
+	}
+	t.Fatalf("kid %q is not published in the JWKS", parsed.header.Kid)
+	return nil, nil
+}
+
+func TestTokenSignerMintsVerifiableTokens(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		key  interface{}
+		alg  string
+	}{
+		{"EC", mustECKey(t), "ES256"},
+		{"RSA", mustRSAKey(t), "RS256"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := NewTokenSigner(&config.UpstreamTokenConfig{
+				Issuer:   "gateway",
+				Audience: []string{"internal"},
+				TTL:      time.Minute,
+				Keys:     []config.SigningKeyConfig{{ID: "k1", File: writeKeyFile(t, tt.key)}},
+			})
+			if err != nil {
+				t.Fatalf("NewTokenSigner: %v", err)
+			}
+			now := time.Unix(1700000000, 0)
+			signer.now = func() time.Time { return now }
+
+			token, err := signer.Sign(&IntrospectionResponse{
+				Active:         true,
+				Subject:        "user-1",
+				Username:       "alice",
+				RealmAccess:    RealmAccess{Roles: []string{"viewer", "admin"}},
+				ResourceAccess: map[string]RealmAccess{"app": {Roles: []string{"editor"}}},
+			}, "users")
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			parsed, claims := verifyMinted(t, signer, token)
+			if parsed.header.Alg != tt.alg || parsed.header.Kid != "k1" {
+				t.Fatalf("unexpected header: %+v", parsed.header)
+			}
+			if err := parsed.claims.validateClaims(now, "gateway", []string{"internal"}, 0); err != nil {
+				t.Fatalf("validateClaims: %v", err)
+			}
+			roles, _ := json.Marshal(claims["roles"])
+			if claims["sub"] != "user-1" || claims["route"] != "users" || string(roles) != `["admin","editor","viewer"]` {
+				t.Fatalf("unexpected claims: %v", claims)
+			}
+			if parsed.claims.ExpiresAt != now.Add(time.Minute).Unix() || claims["jti"] == "" {
+				t.Fatalf("unexpected exp or jti: %v", claims)
+			}
+		})
+	}
+}
+
+func TestTokenSignerNeverOutlivesCallerToken(t *testing.T) {
+	signer, err := NewTokenSigner(&config.UpstreamTokenConfig{
+		TTL:  time.Hour,
+		Keys: []config.SigningKeyConfig{{File: writeKeyFile(t, mustECKey(t))}},
+	})
+	if err != nil {
+		t.Fatalf("NewTokenSigner: %v", err)
+	}
+	callerExp := time.Now().Add(time.Minute).Unix()
+
+	token, err := signer.Sign(&IntrospectionResponse{Active: true, Username: "alice", Exp: callerExp}, "users")
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	parsed, claims := verifyMinted(t, signer, token)
+	if parsed.claims.ExpiresAt != callerExp {
+		t.Fatalf("expected exp %d, got %d", callerExp, parsed.claims.ExpiresAt)
+	}
+	if claims["sub"] != "alice" {
+		t.Fatalf("expected username as subject fallback, got %v", claims["sub"])
+	}
+}
+
+func TestTokenSignerPublishesEveryKeyAndSignsWithFirst(t *testing.T) {
+	current, previous := mustECKey(t), mustRSAKey(t)
+	signer, err := NewTokenSigner(&config.UpstreamTokenConfig{
+		TTL: time.Minute,
+		Keys: []config.SigningKeyConfig{
+			{File: writeKeyFile(t, current)},
+			{ID: "previous", File: writeKeyFile(t, previous)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewTokenSigner: %v", err)
+	}
+
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	json.Unmarshal(signer.JWKS(), &document)
+	if len(document.Keys) != 2 || document.Keys[1].Kid != "previous" || document.Keys[1].Kty != "RSA" {
+		t.Fatalf("unexpected JWKS: %s", signer.JWKS())
+	}
+	if strings.Contains(string(signer.JWKS()), `"d"`) {
+		t.Fatal("JWKS must not contain private key material")
+	}
+	// Without an id the kid is the RFC 7638 thumbprint
+	if kid := document.Keys[0].Kid; kid != thumbprint(publicJWK(&current.PublicKey)) || len(kid) != 43 {
+		t.Fatalf("unexpected default kid %q", kid)
+	}
+
+	token, err := signer.Sign(&IntrospectionResponse{Active: true, Subject: "user-1"}, "users")
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if parsed, _ := verifyMinted(t, signer, token); parsed.header.Kid != document.Keys[0].Kid {
+		t.Fatalf("expected the first key to sign, got kid %q", parsed.header.Kid)
+	}
+}
+
+func TestNewTokenSignerRejectsUnusableKeys(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	ecKey := writeKeyFile(t, mustECKey(t))
+	notPEM := filepath.Join(t.TempDir(), "key.pem")
+	os.WriteFile(notPEM, []byte("not a key"), 0600)
+
+	tests := []struct {
+		name   string
+		keys   []config.SigningKeyConfig
+		expect string
+	}{
+		{"missing file", []config.SigningKeyConfig{{File: filepath.Join(t.TempDir(), "missing.pem")}}, "failed to read key file"},
+		{"not PEM", []config.SigningKeyConfig{{File: notPEM}}, "does not contain a PEM block"},
+		{"weak RSA key", []config.SigningKeyConfig{{File: writeKeyFile(t, weak)}}, "at least 2048 bits"},
+		{"same key twice", []config.SigningKeyConfig{{File: ecKey}, {File: ecKey}}, "used more than once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTokenSigner(&config.UpstreamTokenConfig{TTL: time.Minute, Keys: tt.keys})
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
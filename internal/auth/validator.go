@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"context"
+)
+
+// TokenValidator resolves a bearer token into introspection-style claims
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (*IntrospectionResponse, error)
+}
+
+// ValidateToken validates a token via Keycloak introspection
+func (c *Client) ValidateToken(ctx context.Context, token string) (*IntrospectionResponse, error) {
+	return c.IntrospectToken(ctx, token)
+}
+
+// FallbackValidator tries Primary first and falls back to Fallback when Primary
+// cannot validate the token, for example opaque tokens or an unreachable JWKS endpoint
+type FallbackValidator struct {
+	Primary  TokenValidator
+	Fallback TokenValidator
+}
+
+// ValidateToken validates the token with Primary, then Fallback on error
+func (v *FallbackValidator) ValidateToken(ctx context.Context, token string) (*IntrospectionResponse, error) {
+	result, err := v.Primary.ValidateToken(ctx, token)
+	if err == nil {
+		return result, nil
+	}
+	return v.Fallback.ValidateToken(ctx, token)
+}
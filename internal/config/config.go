@@ -2,8 +2,13 @@ package config
 
 import (
 	"fmt"
+	"net/textproto"
+	"net/url"
 	"os"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,15 +17,22 @@ import (
 
 // Config represents the root configuration structure
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Authz AuthzConfig `yaml:"authz"`
-	Cache    CacheConfig    `yaml:"cache"`
-	Routes   []RouteConfig  `yaml:"routes"`
+	Server        ServerConfig        `yaml:"server"`
+	Authz         AuthzConfig         `yaml:"authz"`
+	Cache         CacheConfig         `yaml:"cache"`
+	Reload        ReloadConfig        `yaml:"reload"`
+	Tracing       TracingConfig       `yaml:"tracing"`
+	UpstreamToken UpstreamTokenConfig `yaml:"upstream_token"`
+	Audit         AuditConfig         `yaml:"audit"`
+	Headers       *HeadersConfig      `yaml:"headers"` // applied to every route before its own headers
+	CORS          *CORSConfig         `yaml:"cors"`    // for every route without its own cors block
+	Routes        []RouteConfig       `yaml:"routes"`
 }
 
 // ServerConfig holds HTTP server configuration
 type ServerConfig struct {
 	Port         int           `yaml:"port"`
+	AdminPort    int           `yaml:"admin_port"` // 0 disables the admin server
 	ReadTimeout  time.Duration `yaml:"read_timeout"`
 	WriteTimeout time.Duration `yaml:"write_timeout"`
 	IdleTimeout  time.Duration `yaml:"idle_timeout"`
@@ -32,34 +44,673 @@ type AuthzConfig struct {
 	ClientID         string        `yaml:"client_id"`
 	ClientSecret     string        `yaml:"client_secret"`
 	Timeout          time.Duration `yaml:"timeout"`
+	Mode             string        `yaml:"mode"` // default auth mode for routes; defaults to introspection
+	JWKS             JWKSConfig    `yaml:"jwks"`
+}
+
+// Auth modes supported by AuthzConfig.Mode and RouteConfig.AuthMode
+const (
+	AuthModeIntrospection    = "introspection"
+	AuthModeJWKS             = "jwks"
+	AuthModeJWKSWithFallback = "jwks_with_fallback"
+)
+
+// JWKSConfig holds settings for validating signed JWTs locally against the issuer's JWKS
+type JWKSConfig struct {
+	URL                string        `yaml:"url"`
+	Issuer             string        `yaml:"issuer"`
+	Audience           []string      `yaml:"audience"`             // token must carry at least one; empty skips the check
+	RefreshInterval    time.Duration `yaml:"refresh_interval"`     // defaults to 10m
+	MinRefreshInterval time.Duration `yaml:"min_refresh_interval"` // throttles refetches on unknown kid; defaults to 30s
+	Leeway             time.Duration `yaml:"leeway"`               // clock skew allowed on exp/nbf
 }
 
 // CacheConfig holds token cache settings
 type CacheConfig struct {
-	Enabled bool          `yaml:"enabled"`
-	TTL     time.Duration `yaml:"ttl"`
+	Enabled       bool          `yaml:"enabled"`
+	TTL           time.Duration `yaml:"ttl"`
+	MaxEntries    int           `yaml:"max_entries"`    // defaults to 10000 unless max_bytes is set
+	MaxBytes      int64         `yaml:"max_bytes"`      // approximate memory budget; 0 means no byte limit
+	SweepInterval time.Duration `yaml:"sweep_interval"` // how often expired entries are purged; defaults to 1m
+	NegativeTTL   time.Duration `yaml:"negative_ttl"`   // how long active:false results are cached; 0 disables
+	StaleGrace    time.Duration `yaml:"stale_grace"`    // how long past ttl a result may be served while Keycloak fails; 0 disables
+}
+
+// Token cache defaults
+const (
+	DefaultCacheMaxEntries    = 10000
+	DefaultCacheSweepInterval = time.Minute
+)
+
+// ReloadConfig controls hot-reload of the configuration file.
+// SIGHUP always triggers a reload; Watch additionally polls the file for changes.
+type ReloadConfig struct {
+	Watch    bool          `yaml:"watch"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// TracingConfig controls distributed tracing and span export
+type TracingConfig struct {
+	Enabled      bool              `yaml:"enabled"`
+	Exporter     string            `yaml:"exporter"`      // otlp_http or stdout; defaults to otlp_http
+	Endpoint     string            `yaml:"endpoint"`      // OTLP/HTTP collector base URL, e.g. http://otel-collector:4318
+	Headers      map[string]string `yaml:"headers"`       // extra headers sent to the collector
+	ServiceName  string            `yaml:"service_name"`  // defaults to cloud-api-gateway
+	SampleRatio  *float64          `yaml:"sample_ratio"`  // share of new traces recorded; nil defaults to 1
+	BatchTimeout time.Duration     `yaml:"batch_timeout"` // max delay before spans are exported; defaults to 5s
+	Timeout      time.Duration     `yaml:"timeout"`       // export request timeout; defaults to 10s
+}
+
+// AuditConfig controls what the audit log captures. Bodies always stream through;
+// only a prefix is kept for logging.
+type AuditConfig struct {
+	AuditPolicy      `yaml:",inline"`
+	MaxBodyBytes     int               `yaml:"max_body_bytes"`     // body prefix captured per request and response; defaults to DefaultAuditMaxBodyBytes
+	SkipContentTypes []string          `yaml:"skip_content_types"` // media types never captured; "image/*" matches a whole type
+	Sink             AuditSinkConfig   `yaml:"sink"`
+	Chain            *AuditChainConfig `yaml:"chain"` // nil leaves entries unchained
+}
+
+// AuditChainConfig makes the audit log tamper evident. Every entry carries a sequence
+// number and the hash of the entry before it, and signed checkpoints attest the head
+// of the chain, so edited, removed or reordered entries show up on verification.
+type AuditChainConfig struct {
+	CheckpointEntries  int              `yaml:"checkpoint_entries"`  // entries between checkpoints; defaults to 1000
+	CheckpointInterval time.Duration    `yaml:"checkpoint_interval"` // max time between checkpoints while entries arrive; defaults to 1m
+	SigningKey         SigningKeyConfig `yaml:"signing_key"`         // signs checkpoints; read at startup
+}
+
+// AuditPolicy selects which requests are logged and what is redacted. The top-level
+// audit section sets it for the deployment; a route's audit block extends it: its
+// lists are added to the deployment's and its capture toggles override them.
+type AuditPolicy struct {
+	SkipPaths           []string            `yaml:"skip_paths"`            // path regexes that are not logged; defaults to DefaultAuditSkipPaths
+	SkipMethods         []string            `yaml:"skip_methods"`          // e.g. OPTIONS to leave out CORS preflights
+	RedactHeaders       []string            `yaml:"redact_headers"`        // dropped from the log in addition to credentials headers
+	RedactFields        []string            `yaml:"redact_fields"`         // JSON paths such as "card.number", "items.*.iban" or "**.ssn"
+	RedactValues        []AuditValuePattern `yaml:"redact_values"`         // values masked wherever they appear
+	CaptureQuery        *bool               `yaml:"capture_query"`         // defaults to true
+	CaptureRequestBody  *bool               `yaml:"capture_request_body"`  // defaults to true
+	CaptureResponseBody *bool               `yaml:"capture_response_body"` // defaults to false
+	CompiledSkipPaths   []*regexp.Regexp    `yaml:"-"`
+}
+
+// Built-in audit value patterns, selected by name
+const (
+	AuditRedactCardNumber = "card_number" // payment card numbers passing the Luhn check
+	AuditRedactEmail      = "email"
+)
+
+// AuditRedactPresets are the regexes of the built-in audit value patterns
+var AuditRedactPresets = map[string]string{
+	AuditRedactCardNumber: `\b(?:\d[ -]?){12,18}\d\b`,
+	AuditRedactEmail:      `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+}
+
+// AuditValuePattern masks matching substrings of logged values. Name alone selects a
+// built-in pattern; with Pattern set it is only a label.
+type AuditValuePattern struct {
+	Name     string         `yaml:"name"`
+	Pattern  string         `yaml:"pattern"`
+	Compiled *regexp.Regexp `yaml:"-"`
+}
+
+// DefaultAuditSkipPaths are health checks and static files that are not worth logging;
+// used when audit.skip_paths is not set
+var DefaultAuditSkipPaths = []string{
+	"^/health",
+	"^/ping",
+	"^/favicon\\.ico",
+	"^/audit-logs", // Prevent infinite logging loops
+}
+
+// boolPtr returns a pointer to b
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// DefaultAuditConfig returns the audit settings used when the audit section is empty
+func DefaultAuditConfig() AuditConfig {
+	var a AuditConfig
+	if err := a.validate(); err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Audit sink types
+const (
+	AuditSinkStdout  = "stdout"
+	AuditSinkFile    = "file"
+	AuditSinkSyslog  = "syslog"
+	AuditSinkWebhook = "webhook"
+)
+
+// Audit queue overflow policies
+const (
+	AuditOverflowBlock = "block" // the request waits for room in the queue
+	AuditOverflowDrop  = "drop"  // the entry is dropped and counted
+)
+
+// AuditSinkConfig selects where audit entries are delivered. Entries are queued and
+// handed to the sink in batches by a background worker.
+type AuditSinkConfig struct {
+	Type          string                 `yaml:"type"`           // stdout, file, syslog or webhook; defaults to stdout
+	QueueSize     int                    `yaml:"queue_size"`     // entries buffered for the sink; defaults to 10000
+	Overflow      string                 `yaml:"overflow"`       // block or drop when the queue is full; defaults to block
+	BatchSize     int                    `yaml:"batch_size"`     // entries handed to the sink at once; defaults to 100
+	FlushInterval time.Duration          `yaml:"flush_interval"` // max delay before a partial batch is delivered; defaults to 1s
+	File          AuditFileSinkConfig    `yaml:"file"`
+	Syslog        AuditSyslogSinkConfig  `yaml:"syslog"`
+	Webhook       AuditWebhookSinkConfig `yaml:"webhook"`
+}
+
+// AuditFileSinkConfig writes JSON lines to a local file that is rotated by size
+type AuditFileSinkConfig struct {
+	Path       string        `yaml:"path"`
+	MaxSizeMB  int           `yaml:"max_size_mb"` // rotate once the file would exceed this; defaults to 100
+	MaxAge     time.Duration `yaml:"max_age"`     // delete rotated files older than this; 0 keeps them
+	MaxBackups int           `yaml:"max_backups"` // keep at most this many rotated files; 0 keeps all
+}
+
+// AuditSyslogSinkConfig sends each entry as an RFC 5424 syslog message
+type AuditSyslogSinkConfig struct {
+	Network  string `yaml:"network"`  // udp or tcp; defaults to udp
+	Address  string `yaml:"address"`  // host:port
+	Tag      string `yaml:"tag"`      // APP-NAME; defaults to cloud-api-gateway
+	Facility string `yaml:"facility"` // e.g. local0 (default), user, daemon, auth
+}
+
+// AuditWebhookSinkConfig POSTs each batch as a JSON array
+type AuditWebhookSinkConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout time.Duration     `yaml:"timeout"` // defaults to 5s
+}
+
+// SyslogFacilities maps facility names to their RFC 5424 codes
+var SyslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// DefaultAuditMaxBodyBytes is the body prefix captured when audit.max_body_bytes is not set
+const DefaultAuditMaxBodyBytes = 8192
+
+// DefaultAuditSkipContentTypes are binary and streaming media types whose bodies are
+// not worth capturing; used when audit.skip_content_types is not set
+var DefaultAuditSkipContentTypes = []string{
+	"text/event-stream",
+	"application/octet-stream",
+	"multipart/form-data",
+	"image/*",
+	"audio/*",
+	"video/*",
+}
+
+// UpstreamTokenConfig controls the short-lived JWTs the gateway mints for upstreams in
+// place of the caller's Authorization header. The first key signs; every key is
+// published in the gateway's JWKS so keys can be rotated without rejecting tokens.
+type UpstreamTokenConfig struct {
+	Enabled  bool               `yaml:"enabled"`   // mint for every route unless the route sets upstream_token: false
+	Issuer   string             `yaml:"issuer"`    // defaults to cloud-api-gateway
+	Audience []string           `yaml:"audience"`  // aud claim; omitted when empty
+	TTL      time.Duration      `yaml:"ttl"`       // defaults to 1m; never outlives the caller's token
+	JWKSPath string             `yaml:"jwks_path"` // served on the main port; defaults to /.well-known/jwks.json
+	Keys     []SigningKeyConfig `yaml:"keys"`
 }
 
+// SigningKeyConfig is a PEM encoded RSA or EC private key
+type SigningKeyConfig struct {
+	ID   string `yaml:"id"`   // kid; defaults to the RFC 7638 thumbprint of the key
+	File string `yaml:"file"` // re-read on every reload
+}
+
+// Span exporters supported by TracingConfig.Exporter
+const (
+	TracingExporterOTLPHTTP = "otlp_http"
+	TracingExporterStdout   = "stdout"
+)
+
 // RouteRule defines method, authentication, and role requirements.
 type RouteRule struct {
-	Methods         []string `yaml:"methods"`
-	RequireAuth     *bool    `yaml:"require_auth"` // nil defaults to true
-	RequiredRoles   []string `yaml:"required_roles"`
-	RequireAllRoles bool     `yaml:"require_all_roles"`
+	Methods         []string         `yaml:"methods"`
+	RequireAuth     *bool            `yaml:"require_auth"` // nil defaults to true
+	RequiredRoles   []string         `yaml:"required_roles"`
+	RequireAllRoles bool             `yaml:"require_all_roles"`
+	RateLimit       *RateLimitConfig `yaml:"rate_limit"` // applies to requests matching this rule
+	Headers         *HeadersConfig   `yaml:"headers"`    // applied to requests matching this rule, after the route's
+}
+
+// RateLimitConfig limits how many requests each client may make
+type RateLimitConfig struct {
+	Algorithm string        `yaml:"algorithm"` // token_bucket or sliding_window; defaults to token_bucket
+	Requests  int           `yaml:"requests"`  // requests allowed per window
+	Window    time.Duration `yaml:"window"`
+	Burst     int           `yaml:"burst"`  // token bucket capacity; defaults to requests
+	Key       string        `yaml:"key"`    // ip, username, client_id or header; defaults to ip
+	Header    string        `yaml:"header"` // header name when key is header
+}
+
+// Rate limit algorithms and keys supported by RateLimitConfig
+const (
+	RateLimitTokenBucket   = "token_bucket"
+	RateLimitSlidingWindow = "sliding_window"
+
+	RateLimitKeyIP       = "ip"
+	RateLimitKeyUsername = "username"
+	RateLimitKeyClientID = "client_id"
+	RateLimitKeyHeader   = "header"
+)
+
+// UpstreamTarget is one backend replica a route can send traffic to
+type UpstreamTarget struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"` // defaults to 1
+}
+
+// Load-balancing policies supported by LoadBalancingConfig.Policy
+const (
+	PolicyRoundRobin         = "round_robin"
+	PolicyWeightedRoundRobin = "weighted_round_robin"
+	PolicyLeastOutstanding   = "least_outstanding"
+	PolicyRandomTwoChoices   = "random_two_choices"
+	PolicyConsistentHash     = "consistent_hash"
+)
+
+// LoadBalancingConfig selects how a route picks one of its upstream targets
+type LoadBalancingConfig struct {
+	Policy     string `yaml:"policy"`      // defaults to round_robin
+	HashHeader string `yaml:"hash_header"` // consistent_hash: request header to hash on
+	HashCookie string `yaml:"hash_cookie"` // consistent_hash: cookie to hash on
+}
+
+// HealthCheckConfig configures active and passive health checking of a route's targets
+type HealthCheckConfig struct {
+	Active  ActiveHealthCheckConfig  `yaml:"active"`
+	Passive PassiveHealthCheckConfig `yaml:"passive"`
+}
+
+// ActiveHealthCheckConfig periodically probes every target; enabled when Path is set
+type ActiveHealthCheckConfig struct {
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval"`            // defaults to 10s
+	Timeout            time.Duration `yaml:"timeout"`             // defaults to 2s
+	ExpectedStatus     int           `yaml:"expected_status"`     // defaults to 200
+	HealthyThreshold   int           `yaml:"healthy_threshold"`   // defaults to 2
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold"` // defaults to 3
+}
+
+// Enabled reports whether active health checks are configured
+func (a ActiveHealthCheckConfig) Enabled() bool {
+	return a.Path != ""
+}
+
+// PassiveHealthCheckConfig ejects a target after consecutive 5xx responses or connection
+// errors; enabled when ConsecutiveFailures is set
+type PassiveHealthCheckConfig struct {
+	ConsecutiveFailures int           `yaml:"consecutive_failures"`
+	EjectionDuration    time.Duration `yaml:"ejection_duration"` // defaults to 30s
+}
+
+// Enabled reports whether passive outlier detection is configured
+func (p PassiveHealthCheckConfig) Enabled() bool {
+	return p.ConsecutiveFailures > 0
+}
+
+// TimeoutsConfig bounds how long the gateway waits on a route's upstream; zero means no limit
+type TimeoutsConfig struct {
+	Connect        time.Duration `yaml:"connect"`         // establishing the TCP connection; defaults to 30s
+	ResponseHeader time.Duration `yaml:"response_header"` // from sending the request until response headers arrive
+	Request        time.Duration `yaml:"request"`         // the whole upstream exchange, retries and response body included
+}
+
+// RetryConfig retries idempotent requests that failed to connect or got a listed status
+type RetryConfig struct {
+	Attempts    int               `yaml:"attempts"`     // retries after the first try
+	OnStatus    []int             `yaml:"on_status"`    // upstream statuses that are retried, e.g. [502, 503, 504]
+	BackoffBase time.Duration     `yaml:"backoff_base"` // first backoff ceiling, doubled per retry; defaults to 25ms
+	BackoffMax  time.Duration     `yaml:"backoff_max"`  // defaults to 250ms
+	Budget      RetryBudgetConfig `yaml:"budget"`
+}
+
+// RetryBudgetConfig caps retries at a share of recent requests so retries cannot
+// multiply load on an upstream that is already failing
+type RetryBudgetConfig struct {
+	Ratio               float64 `yaml:"ratio"`                  // retries allowed per request over the last 10s; defaults to 0.2
+	MinRetriesPerSecond int     `yaml:"min_retries_per_second"` // always allowed regardless of ratio; defaults to 10
+}
+
+// CircuitBreakerConfig stops sending traffic to a failing target until a probe succeeds.
+// A target's circuit opens after ConsecutiveFailures failures in a row or once at least
+// MinRequests requests in Window failed at ErrorRate or more; at least one trigger is required.
+type CircuitBreakerConfig struct {
+	ConsecutiveFailures int           `yaml:"consecutive_failures"`
+	ErrorRate           float64       `yaml:"error_rate"`         // share of failed requests, between 0 and 1
+	MinRequests         int           `yaml:"min_requests"`       // requests in the window before error_rate applies; defaults to 20
+	Window              time.Duration `yaml:"window"`             // error_rate window; defaults to 10s
+	OpenDuration        time.Duration `yaml:"open_duration"`      // how long the circuit stays open; defaults to 30s
+	HalfOpenRequests    int           `yaml:"half_open_requests"` // probes that must succeed to close again; defaults to 1
 }
 
 // RouteConfig represents a single route configuration
 type RouteConfig struct {
-	Name              string `yaml:"name"`
-	PathPattern       string `yaml:"path_pattern"`
+	Name              string        `yaml:"name"`
+	Path              string        `yaml:"path"`         // template such as /users/{id} or /files/{path...}
+	PathPattern       string        `yaml:"path_pattern"` // regex, tried in order when no path template matches
+	CompiledPath      []PathSegment `yaml:"-"`
 	CompiledPattern   *regexp.Regexp
-	Methods           []string    `yaml:"methods"`
-	Upstream          string      `yaml:"upstream"`
-	StripPrefix       string      `yaml:"strip_prefix"`
-	RequiredRoles     []string    `yaml:"required_roles"`
-	RequireAllRoles   bool        `yaml:"require_all_roles"`
-	LegacyRequireAuth *bool       `yaml:"require_auth"` // disallowed at route level; use rules[].require_auth
-	Rules             []RouteRule `yaml:"rules"`
+	Methods           []string               `yaml:"methods"`
+	Upstream          string                 `yaml:"upstream"`
+	Upstreams         []UpstreamTarget       `yaml:"upstreams"`
+	LoadBalancing     LoadBalancingConfig    `yaml:"load_balancing"`
+	HealthCheck       HealthCheckConfig      `yaml:"health_check"`
+	Timeouts          TimeoutsConfig         `yaml:"timeouts"`
+	Retry             *RetryConfig           `yaml:"retry"`           // nil disables retries
+	CircuitBreaker    *CircuitBreakerConfig  `yaml:"circuit_breaker"` // nil disables the breaker
+	StripPrefix       string                 `yaml:"strip_prefix"`
+	Rewrite           *RewriteConfig         `yaml:"rewrite"` // reshapes the upstream request; nil keeps it as is
+	RequiredRoles     []string               `yaml:"required_roles"`
+	RequireAllRoles   bool                   `yaml:"require_all_roles"`
+	LegacyRequireAuth *bool                  `yaml:"require_auth"`     // disallowed at route level; use rules[].require_auth
+	AuthMode          string                 `yaml:"auth_mode"`        // defaults to authz.mode
+	RateLimit         *RateLimitConfig       `yaml:"rate_limit"`       // applies to every request of the route
+	IdentityHeaders   *IdentityHeadersConfig `yaml:"identity_headers"` // caller identity forwarded upstream
+	UpstreamToken     *bool                  `yaml:"upstream_token"`   // defaults to upstream_token.enabled
+	WebSocket         *WebSocketConfig       `yaml:"websocket"`        // token sources for upgrade handshakes
+	Audit             *AuditPolicy           `yaml:"audit"`            // extends the top-level audit policy
+	Match             *RouteMatchConfig      `yaml:"match"`            // request attributes required besides the path
+	Headers           *HeadersConfig         `yaml:"headers"`          // applied after the top-level headers
+	CORS              *CORSConfig            `yaml:"cors"`             // defaults to the top-level cors block
+	GatewayHeaders    *HeadersConfig         `yaml:"-"`                // the top-level headers block
+	Rules             []RouteRule            `yaml:"rules"`
+}
+
+// RouteMatchConfig narrows a route to requests with the given attributes. Every
+// configured condition must hold; routes sharing a path are tried in order, so list
+// the most specific first.
+type RouteMatchConfig struct {
+	Hosts        []string       `yaml:"hosts"`         // any of; "*.example.com" matches every subdomain of example.com
+	Headers      []ValueMatcher `yaml:"headers"`       // all of
+	Query        []ValueMatcher `yaml:"query"`         // all of
+	ContentTypes []string       `yaml:"content_types"` // any of; "application/*" matches a whole type
+}
+
+// ValueMatcher requires a header or query parameter. With neither Value nor Pattern
+// its presence is enough; a parameter with several values matches if any value does.
+type ValueMatcher struct {
+	Name     string         `yaml:"name"`
+	Value    string         `yaml:"value"`   // exact value
+	Pattern  string         `yaml:"pattern"` // regex, unanchored unless it anchors itself
+	Compiled *regexp.Regexp `yaml:"-"`
+}
+
+// Matches reports whether one of values satisfies the matcher; values are those the
+// request has for Name
+func (m *ValueMatcher) Matches(values []string) bool {
+	if len(values) == 0 {
+		return false
+	}
+	switch {
+	case m.Compiled != nil:
+		return slices.ContainsFunc(values, m.Compiled.MatchString)
+	case m.Value != "":
+		return slices.Contains(values, m.Value)
+	}
+	return true
+}
+
+// RewriteConfig reshapes the request sent upstream. Templates insert the route's
+// captures: $1 or ${1} the first capture group of path_pattern, or the first parameter
+// of path, and {name} a named group or parameter; $$ is a literal $.
+type RewriteConfig struct {
+	Path  string            `yaml:"path"`  // upstream path, before the target's base path; may end in ?name=value
+	Query map[string]string `yaml:"query"` // added to the query string
+	Host  string            `yaml:"host"`  // Host header sent upstream
+
+	CompiledPath  *Template    `yaml:"-"` // nil when the path is kept
+	CompiledQuery []QueryParam `yaml:"-"` // from path and query, sorted by name
+}
+
+// CORSConfig lets browsers call a route from other origins. The gateway answers
+// preflight requests itself, before authentication, and adds CORS headers to the
+// route's responses in place of any the upstream sends.
+type CORSConfig struct {
+	AllowedOrigins        []string      `yaml:"allowed_origins"`         // exact origins, "*" or wildcards such as "https://*.example.com"
+	AllowedOriginPatterns []string      `yaml:"allowed_origin_patterns"` // regexes matched against the whole origin
+	AllowedMethods        []string      `yaml:"allowed_methods"`         // defaults to the methods the route's rules allow
+	AllowedHeaders        []string      `yaml:"allowed_headers"`         // request headers; empty or "*" allows those the browser asks for
+	ExposedHeaders        []string      `yaml:"exposed_headers"`         // response headers scripts may read
+	AllowCredentials      bool          `yaml:"allow_credentials"`       // cookies and Authorization on cross-origin requests
+	MaxAge                time.Duration `yaml:"max_age"`                 // how long browsers may cache a preflight; 0 leaves it to the browser
+
+	CompiledOriginPatterns []*regexp.Regexp `yaml:"-"` // wildcard origins and allowed_origin_patterns
+}
+
+// AllowsAnyOrigin reports whether every origin is allowed
+func (c *CORSConfig) AllowsAnyOrigin() bool {
+	return slices.Contains(c.AllowedOrigins, "*")
+}
+
+// AllowsOrigin reports whether requests from origin may read the route's responses
+func (c *CORSConfig) AllowsOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if c.AllowsAnyOrigin() || slices.Contains(c.AllowedOrigins, strings.ToLower(origin)) {
+		return true
+	}
+	for _, pattern := range c.CompiledOriginPatterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// HeadersConfig transforms the headers of the request sent upstream and of the
+// upstream's response
+type HeadersConfig struct {
+	Request  *HeaderTransform `yaml:"request"`
+	Response *HeaderTransform `yaml:"response"`
+}
+
+// HeaderTransform edits headers in the order remove, rename, set, add. Values are
+// templates: besides the route's captures they may use {route.name}, {client.ip} and
+// {claims.name}, where dots in the claim name address nested claims. A value that
+// expands to an empty string is not sent.
+type HeaderTransform struct {
+	Remove []string          `yaml:"remove"`
+	Rename map[string]string `yaml:"rename"` // old name -> new name; the new name's values are replaced
+	Set    map[string]string `yaml:"set"`    // replaces any values the header has
+	Add    map[string]string `yaml:"add"`    // appended to any values the header has
+
+	CompiledRename []HeaderRename `yaml:"-"` // sorted by old name
+	CompiledSet    []HeaderValue  `yaml:"-"` // sorted by name
+	CompiledAdd    []HeaderValue  `yaml:"-"` // sorted by name
+}
+
+// HeaderRename moves the values of one header to another
+type HeaderRename struct {
+	From string
+	To   string
+}
+
+// HeaderValue is a header set or added by a HeaderTransform
+type HeaderValue struct {
+	Name  string
+	Value *Template
+}
+
+// QueryParam is a query parameter added by a rewrite
+type QueryParam struct {
+	Name  string
+	Value *Template
+}
+
+// Template is a string with placeholders for a route's captures
+type Template struct {
+	Parts []TemplatePart
+}
+
+// TemplatePart is literal text or one placeholder of a Template
+type TemplatePart struct {
+	Literal string
+	Group   int    // $N: 1-based capture group or path parameter
+	Param   string // {name}: named capture group or path parameter
+	Var     string // {route.name}, {client.ip} or {claims.<name>}: a request attribute
+}
+
+// Request attributes a template can reference besides the route's captures
+const (
+	TemplateVarRouteName   = "route.name"
+	TemplateVarClientIP    = "client.ip"
+	TemplateVarClaimPrefix = "claims."
+)
+
+// ParseTemplate compiles a template with $N, ${N}, {name} and request attribute
+// placeholders
+func ParseTemplate(text string) (*Template, error) {
+	t := &Template{}
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			t.Parts = append(t.Parts, TemplatePart{Literal: literal.String()})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '$' && i+1 < len(text) && text[i+1] == '$':
+			literal.WriteByte('$')
+			i++
+		case c == '$':
+			digits := text[i+1:]
+			braced := strings.HasPrefix(digits, "{")
+			if braced {
+				end := strings.IndexByte(digits, '}')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated ${ in %q", text)
+				}
+				digits = digits[1:end]
+			} else if n := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }); n >= 0 {
+				digits = digits[:n]
+			}
+			group, err := strconv.Atoi(digits)
+			if err != nil || group < 1 {
+				return nil, fmt.Errorf("invalid capture group reference at %q in %q; use $1, ${1} or $$", text[i:], text)
+			}
+			flush()
+			t.Parts = append(t.Parts, TemplatePart{Group: group})
+			i += len(digits)
+			if braced {
+				i += 2
+			}
+		case c == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated { in %q", text)
+			}
+			name := text[i+1 : i+end]
+			flush()
+			switch {
+			case name == TemplateVarRouteName, name == TemplateVarClientIP,
+				strings.HasPrefix(name, TemplateVarClaimPrefix) && len(name) > len(TemplateVarClaimPrefix):
+				t.Parts = append(t.Parts, TemplatePart{Var: name})
+			case pathParamName.MatchString(name):
+				t.Parts = append(t.Parts, TemplatePart{Param: name})
+			default:
+				return nil, fmt.Errorf("invalid parameter name %q in %q", name, text)
+			}
+			i += end
+		case c == '}':
+			return nil, fmt.Errorf("unmatched } in %q", text)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+	return t, nil
+}
+
+// PathSegment is one slash-separated segment of a compiled path template
+type PathSegment struct {
+	Literal  string // text of a literal segment
+	Param    string // parameter name; empty for a literal segment
+	CatchAll bool   // the parameter takes the rest of the path, slashes included
+}
+
+// pathParamName matches the names allowed in {name} and {name...}
+var pathParamName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// CompilePathTemplate splits a path template into segments. A segment is either
+// literal text or a whole-segment parameter: {name} matches one non-empty segment
+// and {name...}, allowed only last, matches the rest of the path.
+func CompilePathTemplate(template string) ([]PathSegment, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("%q must start with /", template)
+	}
+	parts := strings.Split(template[1:], "/")
+	segments := make([]PathSegment, len(parts))
+	seen := make(map[string]bool)
+	for i, part := range parts {
+		if !strings.ContainsAny(part, "{}") {
+			segments[i] = PathSegment{Literal: part}
+			continue
+		}
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			return nil, fmt.Errorf("%q: parameters must take a whole segment, got %q", template, part)
+		}
+		name := part[1 : len(part)-1]
+		catchAll := strings.HasSuffix(name, "...")
+		name = strings.TrimSuffix(name, "...")
+		if !pathParamName.MatchString(name) {
+			return nil, fmt.Errorf("%q: invalid parameter name %q", template, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%q: parameter %q is used more than once", template, name)
+		}
+		if catchAll && i != len(parts)-1 {
+			return nil, fmt.Errorf("%q: {%s...} must be the last segment", template, name)
+		}
+		seen[name] = true
+		segments[i] = PathSegment{Param: name, CatchAll: catchAll}
+	}
+	return segments, nil
+}
+
+// WebSocketConfig lets upgrade handshakes carry the access token where browsers can set
+// it, since they cannot add an Authorization header to a WebSocket handshake. An
+// Authorization header still takes precedence.
+type WebSocketConfig struct {
+	TokenQueryParam        string `yaml:"token_query_param"`        // e.g. "access_token"
+	TokenSubprotocolPrefix string `yaml:"token_subprotocol_prefix"` // e.g. "bearer." for a "bearer.<token>" subprotocol
+}
+
+// IdentityHeadersConfig names the upstream headers that carry the authenticated caller's
+// identity. Client-supplied copies of every configured header are always removed.
+type IdentityHeadersConfig struct {
+	Username string            `yaml:"username"`
+	ClientID string            `yaml:"client_id"`
+	Roles    string            `yaml:"roles"`  // all realm and client roles, comma separated
+	Claims   map[string]string `yaml:"claims"` // claim name (dots address nested claims) -> header
+}
+
+// HeaderNames returns every configured header name in canonical form
+func (ih *IdentityHeadersConfig) HeaderNames() []string {
+	var names []string
+	for _, name := range []string{ih.Username, ih.ClientID, ih.Roles} {
+		if name != "" {
+			names = append(names, textproto.CanonicalMIMEHeaderKey(name))
+		}
+	}
+	for _, name := range ih.Claims {
+		names = append(names, textproto.CanonicalMIMEHeaderKey(name))
+	}
+	sort.Strings(names)
+	return names
 }
 
 // Load reads and parses the YAML configuration file
@@ -115,39 +766,118 @@ func (c *Config) validateAndCompile() error {
 	if c.Server.Port <= 0 || c.Server.Port > 65535 {
 		return fmt.Errorf("invalid server port: %d", c.Server.Port)
 	}
+	if c.Server.AdminPort < 0 || c.Server.AdminPort > 65535 || (c.Server.AdminPort != 0 && c.Server.AdminPort == c.Server.Port) {
+		return fmt.Errorf("invalid server admin_port: %d", c.Server.AdminPort)
+	}
 
 	// Validate authz config
-	if c.Authz.IntrospectionURL == "" {
-		return fmt.Errorf("authz.introspection_url is required")
+	if err := c.validateAuthz(); err != nil {
+		return err
+	}
+
+	// Validate cache config
+	if err := c.Cache.validate(); err != nil {
+		return err
+	}
+
+	// Validate reload config
+	if c.Reload.Interval < 0 {
+		return fmt.Errorf("reload.interval must not be negative")
+	}
+
+	// Validate tracing config
+	if err := c.Tracing.validate(); err != nil {
+		return err
+	}
+
+	// Validate upstream token config
+	if err := c.validateUpstreamToken(); err != nil {
+		return err
+	}
+
+	// Validate audit config
+	if err := c.Audit.validate(); err != nil {
+		return err
 	}
-	if c.Authz.ClientID == "" {
-		return fmt.Errorf("authz.client_id is required")
+
+	// Validate top-level header transforms
+	if err := c.Headers.validate("headers", nil); err != nil {
+		return err
 	}
-	if c.Authz.ClientSecret == "" {
-		return fmt.Errorf("authz.client_secret is required")
+
+	// Validate top-level CORS settings
+	if err := c.CORS.validate("cors"); err != nil {
+		return err
 	}
 
 	// Validate and compile route patterns
 	for i := range c.Routes {
 		route := &c.Routes[i]
-		if route.PathPattern == "" {
-			return fmt.Errorf("route[%d].path_pattern is required", i)
+		if route.Path == "" && route.PathPattern == "" {
+			return fmt.Errorf("route[%d]: path or path_pattern is required", i)
+		}
+		if route.Path != "" && route.PathPattern != "" {
+			return fmt.Errorf("route[%d]: path and path_pattern are mutually exclusive", i)
+		}
+		if err := route.validateUpstreams(i); err != nil {
+			return err
+		}
+		if err := route.validateHealthCheck(i); err != nil {
+			return err
 		}
-		if route.Upstream == "" {
-			return fmt.Errorf("route[%d].upstream is required", i)
+		if err := route.validateTimeoutsAndRetry(i); err != nil {
+			return err
+		}
+		if err := route.CircuitBreaker.validate(i); err != nil {
+			return err
+		}
+		if err := route.RateLimit.validate(fmt.Sprintf("route[%d].rate_limit", i)); err != nil {
+			return err
+		}
+		if err := route.IdentityHeaders.validate(i); err != nil {
+			return err
+		}
+		if err := route.WebSocket.validate(i); err != nil {
+			return err
+		}
+		if err := route.Audit.validate(fmt.Sprintf("route[%d].audit", i)); err != nil {
+			return err
+		}
+		if err := route.Match.validate(i); err != nil {
+			return err
 		}
 
-		// Compile regex pattern with case-insensitive matching
-		// Add (?i) flag at the beginning if not already present
-		pattern := route.PathPattern
-		if !strings.HasPrefix(pattern, "(?i)") && !strings.HasPrefix(pattern, "(?i:") {
-			pattern = "(?i)" + pattern
+		if route.Path != "" {
+			segments, err := CompilePathTemplate(route.Path)
+			if err != nil {
+				return fmt.Errorf("route[%d].path: %w", i, err)
+			}
+			route.CompiledPath = segments
+		} else {
+			// Compile regex pattern with case-insensitive matching
+			// Add (?i) flag at the beginning if not already present
+			pattern := route.PathPattern
+			if !strings.HasPrefix(pattern, "(?i)") && !strings.HasPrefix(pattern, "(?i:") {
+				pattern = "(?i)" + pattern
+			}
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("route[%d].path_pattern invalid regex: %w", i, err)
+			}
+			route.CompiledPattern = compiled
 		}
-		compiled, err := regexp.Compile(pattern)
-		if err != nil {
-			return fmt.Errorf("route[%d].path_pattern invalid regex: %w", i, err)
+		if err := route.Rewrite.validate(route, i); err != nil {
+			return err
+		}
+		if err := route.Headers.validate(fmt.Sprintf("route[%d].headers", i), route); err != nil {
+			return err
+		}
+		route.GatewayHeaders = c.Headers
+		if route.CORS == nil {
+			route.CORS = c.CORS
+		} else if err := route.CORS.validate(fmt.Sprintf("route[%d].cors", i)); err != nil {
+			return err
 		}
-		route.CompiledPattern = compiled
 
 		if len(route.Methods) > 0 || len(route.RequiredRoles) > 0 || route.RequireAllRoles {
 			return fmt.Errorf("route[%d]: route-level methods/required_roles/require_all_roles are not supported; use rules[]", i)
@@ -169,12 +899,978 @@ func (c *Config) validateAndCompile() error {
 			if !rule.RequiresAuth() && len(rule.RequiredRoles) > 0 {
 				return fmt.Errorf("route[%d].rules[%d]: rules with require_auth=false cannot define required_roles", i, j)
 			}
+			if err := rule.RateLimit.validate(fmt.Sprintf("route[%d].rules[%d].rate_limit", i, j)); err != nil {
+				return err
+			}
+			if err := rule.Headers.validate(fmt.Sprintf("route[%d].rules[%d].headers", i, j), route); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateAuthz resolves every route's auth mode and checks that the settings
+// each mode needs are present
+func (c *Config) validateAuthz() error {
+	if c.Authz.Mode == "" {
+		c.Authz.Mode = AuthModeIntrospection
+	}
+	if !validAuthMode(c.Authz.Mode) {
+		return fmt.Errorf("authz.mode %q is not supported", c.Authz.Mode)
+	}
+
+	// Without routes, the default mode alone decides which settings are needed
+	needsIntrospection := len(c.Routes) == 0 && c.Authz.Mode != AuthModeJWKS
+	needsJWKS := len(c.Routes) == 0 && c.Authz.Mode != AuthModeIntrospection
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		if route.AuthMode == "" {
+			route.AuthMode = c.Authz.Mode
+		}
+		if !validAuthMode(route.AuthMode) {
+			return fmt.Errorf("route[%d].auth_mode %q is not supported", i, route.AuthMode)
+		}
+		needsIntrospection = needsIntrospection || route.AuthMode != AuthModeJWKS
+		needsJWKS = needsJWKS || route.AuthMode != AuthModeIntrospection
+	}
+
+	if needsIntrospection {
+		if c.Authz.IntrospectionURL == "" {
+			return fmt.Errorf("authz.introspection_url is required")
+		}
+		if c.Authz.ClientID == "" {
+			return fmt.Errorf("authz.client_id is required")
+		}
+		if c.Authz.ClientSecret == "" {
+			return fmt.Errorf("authz.client_secret is required")
+		}
+	}
+
+	jwks := &c.Authz.JWKS
+	if needsJWKS {
+		if jwks.URL == "" {
+			return fmt.Errorf("authz.jwks.url is required when a route uses jwks auth")
+		}
+		if jwks.Issuer == "" {
+			return fmt.Errorf("authz.jwks.issuer is required when a route uses jwks auth")
+		}
+	}
+	if jwks.RefreshInterval < 0 || jwks.MinRefreshInterval < 0 || jwks.Leeway < 0 {
+		return fmt.Errorf("authz.jwks: refresh_interval, min_refresh_interval and leeway must not be negative")
+	}
+	if jwks.RefreshInterval == 0 {
+		jwks.RefreshInterval = 10 * time.Minute
+	}
+	if jwks.MinRefreshInterval == 0 {
+		jwks.MinRefreshInterval = 30 * time.Second
+	}
+	return nil
+}
+
+// validate checks cache limits and fills in defaults
+func (c *CacheConfig) validate() error {
+	if c.TTL < 0 || c.MaxEntries < 0 || c.MaxBytes < 0 || c.SweepInterval < 0 {
+		return fmt.Errorf("cache.ttl, cache.max_entries, cache.max_bytes and cache.sweep_interval must not be negative")
+	}
+	if c.NegativeTTL < 0 || c.StaleGrace < 0 {
+		return fmt.Errorf("cache.negative_ttl and cache.stale_grace must not be negative")
+	}
+	if c.MaxEntries == 0 && c.MaxBytes == 0 {
+		c.MaxEntries = DefaultCacheMaxEntries
+	}
+	if c.SweepInterval == 0 {
+		c.SweepInterval = DefaultCacheSweepInterval
+	}
+	return nil
+}
+
+// validate checks a rate limit and fills in defaults; a nil limit is valid
+func (rl *RateLimitConfig) validate(path string) error {
+	if rl == nil {
+		return nil
+	}
+	if rl.Requests <= 0 || rl.Window <= 0 {
+		return fmt.Errorf("%s: requests and window must be positive", path)
+	}
+	if rl.Algorithm == "" {
+		rl.Algorithm = RateLimitTokenBucket
+	}
+	switch rl.Algorithm {
+	case RateLimitTokenBucket:
+		if rl.Burst < 0 {
+			return fmt.Errorf("%s: burst must not be negative", path)
+		}
+		if rl.Burst == 0 {
+			rl.Burst = rl.Requests
+		}
+	case RateLimitSlidingWindow:
+		if rl.Burst != 0 {
+			return fmt.Errorf("%s: burst is only supported by the %s algorithm", path, RateLimitTokenBucket)
 		}
+	default:
+		return fmt.Errorf("%s: unsupported algorithm %q", path, rl.Algorithm)
 	}
+	if rl.Key == "" {
+		rl.Key = RateLimitKeyIP
+	}
+	switch rl.Key {
+	case RateLimitKeyIP, RateLimitKeyUsername, RateLimitKeyClientID:
+	case RateLimitKeyHeader:
+		if rl.Header == "" {
+			return fmt.Errorf("%s: header is required when key is header", path)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported key %q", path, rl.Key)
+	}
+	return nil
+}
+
+// validate checks that identity header names are valid and distinct; nil is valid
+func (ih *IdentityHeadersConfig) validate(routeIndex int) error {
+	if ih == nil {
+		return nil
+	}
+	for claim, name := range ih.Claims {
+		if claim == "" {
+			return fmt.Errorf("route[%d].identity_headers.claims: claim name must not be empty", routeIndex)
+		}
+		if name == "" {
+			return fmt.Errorf("route[%d].identity_headers.claims: header for claim %q must not be empty", routeIndex, claim)
+		}
+	}
+	names := ih.HeaderNames()
+	if len(names) == 0 {
+		return fmt.Errorf("route[%d].identity_headers: at least one header must be configured", routeIndex)
+	}
+	for k, name := range names {
+		if !validHeaderName(name) {
+			return fmt.Errorf("route[%d].identity_headers: invalid header name %q", routeIndex, name)
+		}
+		if k > 0 && names[k-1] == name {
+			return fmt.Errorf("route[%d].identity_headers: header %q is configured more than once", routeIndex, name)
+		}
+	}
+	return nil
+}
 
+// validate checks the match conditions and normalizes hosts, header names and media
+// types; nil is valid
+func (m *RouteMatchConfig) validate(routeIndex int) error {
+	if m == nil {
+		return nil
+	}
+	prefix := fmt.Sprintf("route[%d].match", routeIndex)
+	if len(m.Hosts) == 0 && len(m.Headers) == 0 && len(m.Query) == 0 && len(m.ContentTypes) == 0 {
+		return fmt.Errorf("%s: at least one condition must be configured", prefix)
+	}
+	for i, host := range m.Hosts {
+		host = strings.ToLower(host)
+		if name := strings.TrimPrefix(host, "*."); name == "" || strings.ContainsAny(name, "*:/ ") {
+			return fmt.Errorf("%s.hosts: %q is not a host name or a *.domain wildcard", prefix, m.Hosts[i])
+		}
+		m.Hosts[i] = host
+	}
+	for i := range m.Headers {
+		if !validHeaderName(m.Headers[i].Name) {
+			return fmt.Errorf("%s.headers[%d]: invalid header name %q", prefix, i, m.Headers[i].Name)
+		}
+		m.Headers[i].Name = textproto.CanonicalMIMEHeaderKey(m.Headers[i].Name)
+		if err := m.Headers[i].validate(fmt.Sprintf("%s.headers[%d]", prefix, i)); err != nil {
+			return err
+		}
+	}
+	for i := range m.Query {
+		if m.Query[i].Name == "" {
+			return fmt.Errorf("%s.query[%d]: name is required", prefix, i)
+		}
+		if err := m.Query[i].validate(fmt.Sprintf("%s.query[%d]", prefix, i)); err != nil {
+			return err
+		}
+	}
+	for i, contentType := range m.ContentTypes {
+		if !strings.Contains(contentType, "/") {
+			return fmt.Errorf("%s.content_types: %q is not a media type", prefix, contentType)
+		}
+		m.ContentTypes[i] = strings.ToLower(contentType)
+	}
 	return nil
 }
 
+// validate compiles the matcher's pattern; path names it in errors
+func (m *ValueMatcher) validate(path string) error {
+	if m.Value != "" && m.Pattern != "" {
+		return fmt.Errorf("%s: value and pattern are mutually exclusive", path)
+	}
+	if m.Pattern != "" {
+		compiled, err := regexp.Compile(m.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s.pattern invalid regex: %w", path, err)
+		}
+		m.Compiled = compiled
+	}
+	return nil
+}
+
+// validate compiles the rewrite templates and checks that they only reference the
+// route's captures; the route's path must be compiled already. nil is valid.
+func (rw *RewriteConfig) validate(route *RouteConfig, routeIndex int) error {
+	if rw == nil {
+		return nil
+	}
+	prefix := fmt.Sprintf("route[%d].rewrite", routeIndex)
+	if rw.Path == "" && len(rw.Query) == 0 && rw.Host == "" {
+		return fmt.Errorf("%s: at least one of path, query and host must be configured", prefix)
+	}
+	if rw.Path != "" && route.StripPrefix != "" {
+		return fmt.Errorf("%s.path and strip_prefix are mutually exclusive", prefix)
+	}
+
+	compile := func(field, text string) (*Template, error) {
+		return compileTemplate(prefix+"."+field, text, route, false)
+	}
+
+	rw.CompiledPath, rw.CompiledQuery = nil, nil
+	query := make(map[string]string, len(rw.Query))
+	if rw.Path != "" {
+		path, rawQuery, _ := strings.Cut(rw.Path, "?")
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("%s.path %q must start with /", prefix, rw.Path)
+		}
+		if _, err := url.PathUnescape(strings.NewReplacer("{", "", "}", "").Replace(path)); err != nil {
+			return fmt.Errorf("%s.path %q is not a valid URL path: %w", prefix, rw.Path, err)
+		}
+		compiled, err := compile("path", path)
+		if err != nil {
+			return err
+		}
+		rw.CompiledPath = compiled
+		for _, pair := range strings.Split(rawQuery, "&") {
+			name, value, _ := strings.Cut(pair, "=")
+			if name == "" {
+				continue
+			}
+			var err error
+			if name, err = url.QueryUnescape(name); err == nil {
+				value, err = url.QueryUnescape(value)
+			}
+			if err != nil {
+				return fmt.Errorf("%s.path %q has an invalid query string: %w", prefix, rw.Path, err)
+			}
+			query[name] = value
+		}
+	}
+	for name, value := range rw.Query {
+		if name == "" {
+			return fmt.Errorf("%s.query: parameter name must not be empty", prefix)
+		}
+		query[name] = value
+	}
+	for name, value := range query {
+		compiled, err := compile("query."+name, value)
+		if err != nil {
+			return err
+		}
+		rw.CompiledQuery = append(rw.CompiledQuery, QueryParam{Name: name, Value: compiled})
+	}
+	slices.SortFunc(rw.CompiledQuery, func(a, b QueryParam) int { return strings.Compare(a.Name, b.Name) })
+
+	if rw.Host != "" && strings.ContainsAny(rw.Host, "/ {}$") {
+		return fmt.Errorf("%s.host %q is not a host name", prefix, rw.Host)
+	}
+	return nil
+}
+
+// compileTemplate parses a template and checks that it only references the captures
+// of route, which is nil outside a route. vars allows request attribute placeholders;
+// path names the template in errors.
+func compileTemplate(path, text string, route *RouteConfig, vars bool) (*Template, error) {
+	t, err := ParseTemplate(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var (
+		groups int
+		names  []string
+	)
+	if route != nil {
+		groups, names = route.captures()
+	}
+	for _, part := range t.Parts {
+		if route == nil && (part.Group > 0 || part.Param != "") {
+			return nil, fmt.Errorf("%s: path captures are only available in route templates", path)
+		}
+		if part.Group > groups {
+			return nil, fmt.Errorf("%s: $%d is out of range; the route captures %d values", path, part.Group, groups)
+		}
+		if part.Param != "" && !slices.Contains(names, part.Param) {
+			return nil, fmt.Errorf("%s: the route captures no {%s}", path, part.Param)
+		}
+		if part.Var != "" && !vars {
+			return nil, fmt.Errorf("%s: {%s} is only available in header templates", path, part.Var)
+		}
+	}
+	return t, nil
+}
+
+// wildcardOriginLabels replaces the * of a wildcard origin: one or more host labels
+const wildcardOriginLabels = `[a-z0-9-]+(\.[a-z0-9-]+)*`
+
+// validate normalizes origins, methods and header names and compiles origin patterns;
+// prefix names the block in errors. nil is valid.
+func (c *CORSConfig) validate(prefix string) error {
+	if c == nil {
+		return nil
+	}
+	if len(c.AllowedOrigins) == 0 && len(c.AllowedOriginPatterns) == 0 {
+		return fmt.Errorf("%s: allowed_origins or allowed_origin_patterns must be configured", prefix)
+	}
+	c.CompiledOriginPatterns = nil
+	for i, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return fmt.Errorf("%s: allow_credentials cannot be combined with the * origin; list the origins instead", prefix)
+			}
+			continue
+		}
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		scheme, host, _ := strings.Cut(origin, "://")
+		name := strings.TrimPrefix(host, "*.")
+		u, err := url.Parse(scheme + "://" + name)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil || strings.Contains(name, "*") {
+			return fmt.Errorf("%s.allowed_origins: %q is not an origin such as https://app.example.com or https://*.example.com", prefix, c.AllowedOrigins[i])
+		}
+		if name != host {
+			pattern := "^" + regexp.QuoteMeta(scheme+"://") + wildcardOriginLabels + regexp.QuoteMeta("."+name) + "$"
+			c.CompiledOriginPatterns = append(c.CompiledOriginPatterns, regexp.MustCompile(pattern))
+		}
+		c.AllowedOrigins[i] = origin
+	}
+	for i, pattern := range c.AllowedOriginPatterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%s.allowed_origin_patterns[%d] invalid regex: %w", prefix, i, err)
+		}
+		c.CompiledOriginPatterns = append(c.CompiledOriginPatterns, compiled)
+	}
+	for i, method := range c.AllowedMethods {
+		if !validHeaderName(method) {
+			return fmt.Errorf("%s.allowed_methods: %q is not a method", prefix, method)
+		}
+		c.AllowedMethods[i] = strings.ToUpper(method)
+	}
+	for field, names := range map[string][]string{"allowed_headers": c.AllowedHeaders, "exposed_headers": c.ExposedHeaders} {
+		for i, name := range names {
+			if name == "*" {
+				continue
+			}
+			if !validHeaderName(name) {
+				return fmt.Errorf("%s.%s: invalid header name %q", prefix, field, name)
+			}
+			names[i] = textproto.CanonicalMIMEHeaderKey(name)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("%s.max_age must not be negative", prefix)
+	}
+	return nil
+}
+
+// validate checks and compiles the request and response transforms; route is nil
+// for the top-level block and prefix names the block in errors. nil is valid.
+func (h *HeadersConfig) validate(prefix string, route *RouteConfig) error {
+	if h == nil {
+		return nil
+	}
+	if h.Request == nil && h.Response == nil {
+		return fmt.Errorf("%s: request or response must be configured", prefix)
+	}
+	if err := h.Request.validate(prefix+".request", route); err != nil {
+		return err
+	}
+	if h.Request != nil {
+		for _, name := range h.Request.names() {
+			if name == "Host" {
+				return fmt.Errorf("%s.request: the Host header cannot be transformed; use rewrite.host", prefix)
+			}
+		}
+	}
+	return h.Response.validate(prefix+".response", route)
+}
+
+// validate canonicalizes header names and compiles values; nil is valid
+func (t *HeaderTransform) validate(prefix string, route *RouteConfig) error {
+	if t == nil {
+		return nil
+	}
+	if len(t.Remove) == 0 && len(t.Rename) == 0 && len(t.Set) == 0 && len(t.Add) == 0 {
+		return fmt.Errorf("%s: at least one of remove, rename, set and add must be configured", prefix)
+	}
+	canonical := func(field, name string) (string, error) {
+		if !validHeaderName(name) {
+			return "", fmt.Errorf("%s.%s: invalid header name %q", prefix, field, name)
+		}
+		return textproto.CanonicalMIMEHeaderKey(name), nil
+	}
+
+	for i, name := range t.Remove {
+		canonicalName, err := canonical("remove", name)
+		if err != nil {
+			return err
+		}
+		t.Remove[i] = canonicalName
+	}
+	t.CompiledRename = nil
+	for from, to := range t.Rename {
+		canonicalFrom, err := canonical("rename", from)
+		if err != nil {
+			return err
+		}
+		canonicalTo, err := canonical("rename", to)
+		if err != nil {
+			return err
+		}
+		if canonicalFrom == canonicalTo {
+			return fmt.Errorf("%s.rename: %q is renamed to itself", prefix, from)
+		}
+		t.CompiledRename = append(t.CompiledRename, HeaderRename{From: canonicalFrom, To: canonicalTo})
+	}
+	slices.SortFunc(t.CompiledRename, func(a, b HeaderRename) int { return strings.Compare(a.From, b.From) })
+
+	compileValues := func(field string, values map[string]string) ([]HeaderValue, error) {
+		var compiled []HeaderValue
+		for name, value := range values {
+			canonicalName, err := canonical(field, name)
+			if err != nil {
+				return nil, err
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf("%s.%s.%s: value must not contain line breaks", prefix, field, name)
+			}
+			tmpl, err := compileTemplate(fmt.Sprintf("%s.%s.%s", prefix, field, name), value, route, true)
+			if err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, HeaderValue{Name: canonicalName, Value: tmpl})
+		}
+		slices.SortFunc(compiled, func(a, b HeaderValue) int { return strings.Compare(a.Name, b.Name) })
+		return compiled, nil
+	}
+	var err error
+	if t.CompiledSet, err = compileValues("set", t.Set); err != nil {
+		return err
+	}
+	t.CompiledAdd, err = compileValues("add", t.Add)
+	return err
+}
+
+// names returns every header name the transform touches, in canonical form
+func (t *HeaderTransform) names() []string {
+	names := slices.Clone(t.Remove)
+	for _, rename := range t.CompiledRename {
+		names = append(names, rename.From, rename.To)
+	}
+	for _, value := range append(slices.Clone(t.CompiledSet), t.CompiledAdd...) {
+		names = append(names, value.Name)
+	}
+	return names
+}
+
+// captures returns how many values the route's path captures and the names of the
+// named ones
+func (r *RouteConfig) captures() (int, []string) {
+	if r.CompiledPattern != nil {
+		var names []string
+		for _, name := range r.CompiledPattern.SubexpNames() {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		return r.CompiledPattern.NumSubexp(), names
+	}
+	var names []string
+	for _, segment := range r.CompiledPath {
+		if segment.Param != "" {
+			names = append(names, segment.Param)
+		}
+	}
+	return len(names), names
+}
+
+// validate checks that a WebSocket token source is configured; nil is valid
+func (ws *WebSocketConfig) validate(routeIndex int) error {
+	if ws == nil {
+		return nil
+	}
+	if ws.TokenQueryParam == "" && ws.TokenSubprotocolPrefix == "" {
+		return fmt.Errorf("route[%d].websocket needs token_query_param or token_subprotocol_prefix", routeIndex)
+	}
+	if ws.TokenSubprotocolPrefix != "" && !validHeaderName(ws.TokenSubprotocolPrefix) {
+		return fmt.Errorf("route[%d].websocket.token_subprotocol_prefix %q is not a valid subprotocol token", routeIndex, ws.TokenSubprotocolPrefix)
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is an RFC 7230 token
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks audit settings and fills in defaults
+func (a *AuditConfig) validate() error {
+	if a.MaxBodyBytes < 0 {
+		return fmt.Errorf("audit.max_body_bytes must not be negative")
+	}
+	if a.MaxBodyBytes == 0 {
+		a.MaxBodyBytes = DefaultAuditMaxBodyBytes
+	}
+	if a.SkipContentTypes == nil {
+		a.SkipContentTypes = append([]string(nil), DefaultAuditSkipContentTypes...)
+	}
+	for _, contentType := range a.SkipContentTypes {
+		if !strings.Contains(contentType, "/") {
+			return fmt.Errorf("audit.skip_content_types: %q is not a media type", contentType)
+		}
+	}
+
+	if a.SkipPaths == nil {
+		a.SkipPaths = append([]string(nil), DefaultAuditSkipPaths...)
+	}
+	if a.CaptureQuery == nil {
+		a.CaptureQuery = boolPtr(true)
+	}
+	if a.CaptureRequestBody == nil {
+		a.CaptureRequestBody = boolPtr(true)
+	}
+	if a.CaptureResponseBody == nil {
+		a.CaptureResponseBody = boolPtr(false)
+	}
+	if err := a.AuditPolicy.validate("audit"); err != nil {
+		return err
+	}
+	if err := a.Chain.validate(); err != nil {
+		return err
+	}
+	return a.Sink.validate()
+}
+
+// validate checks the audit chain and fills in defaults
+func (c *AuditChainConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.CheckpointEntries < 0 || c.CheckpointInterval < 0 {
+		return fmt.Errorf("audit.chain: checkpoint_entries and checkpoint_interval must not be negative")
+	}
+	if c.CheckpointEntries == 0 {
+		c.CheckpointEntries = 1000
+	}
+	if c.CheckpointInterval == 0 {
+		c.CheckpointInterval = time.Minute
+	}
+	if c.SigningKey.File == "" {
+		return fmt.Errorf("audit.chain.signing_key.file is required")
+	}
+	return nil
+}
+
+// validate compiles the policy's patterns; prefix names the section in errors
+func (p *AuditPolicy) validate(prefix string) error {
+	if p == nil {
+		return nil
+	}
+	p.CompiledSkipPaths = nil
+	for _, pattern := range p.SkipPaths {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%s.skip_paths: invalid regex %q: %w", prefix, pattern, err)
+		}
+		p.CompiledSkipPaths = append(p.CompiledSkipPaths, compiled)
+	}
+	for i, method := range p.SkipMethods {
+		p.SkipMethods[i] = strings.ToUpper(method)
+	}
+	for _, name := range p.RedactHeaders {
+		if name == "" || strings.ContainsAny(name, " :") {
+			return fmt.Errorf("%s.redact_headers: %q is not a header name", prefix, name)
+		}
+	}
+	for _, path := range p.RedactFields {
+		trimmed := strings.TrimPrefix(path, "$.")
+		if trimmed == "" || slices.Contains(strings.Split(trimmed, "."), "") {
+			return fmt.Errorf("%s.redact_fields: %q is not a JSON path", prefix, path)
+		}
+	}
+	for i := range p.RedactValues {
+		value := &p.RedactValues[i]
+		pattern := value.Pattern
+		if pattern == "" {
+			preset, ok := AuditRedactPresets[value.Name]
+			if !ok {
+				return fmt.Errorf("%s.redact_values[%d]: pattern is required unless name is card_number or email", prefix, i)
+			}
+			pattern = preset
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%s.redact_values[%d]: invalid regex: %w", prefix, i, err)
+		}
+		value.Compiled = compiled
+	}
+	return nil
+}
+
+// validate checks the audit sink and fills in defaults
+func (s *AuditSinkConfig) validate() error {
+	if s.QueueSize < 0 || s.BatchSize < 0 || s.FlushInterval < 0 {
+		return fmt.Errorf("audit.sink: queue_size, batch_size and flush_interval must not be negative")
+	}
+	if s.QueueSize == 0 {
+		s.QueueSize = 10000
+	}
+	if s.BatchSize == 0 {
+		s.BatchSize = 100
+	}
+	if s.FlushInterval == 0 {
+		s.FlushInterval = time.Second
+	}
+	switch s.Overflow {
+	case "":
+		s.Overflow = AuditOverflowBlock
+	case AuditOverflowBlock, AuditOverflowDrop:
+	default:
+		return fmt.Errorf("audit.sink.overflow %q is not supported", s.Overflow)
+	}
+
+	switch s.Type {
+	case "":
+		s.Type = AuditSinkStdout
+	case AuditSinkStdout:
+	case AuditSinkFile:
+		f := &s.File
+		if f.Path == "" {
+			return fmt.Errorf("audit.sink.file.path is required for the file sink")
+		}
+		if f.MaxSizeMB < 0 || f.MaxAge < 0 || f.MaxBackups < 0 {
+			return fmt.Errorf("audit.sink.file: max_size_mb, max_age and max_backups must not be negative")
+		}
+		if f.MaxSizeMB == 0 {
+			f.MaxSizeMB = 100
+		}
+	case AuditSinkSyslog:
+		sl := &s.Syslog
+		if sl.Network == "" {
+			sl.Network = "udp"
+		}
+		if sl.Network != "udp" && sl.Network != "tcp" {
+			return fmt.Errorf("audit.sink.syslog.network must be udp or tcp")
+		}
+		if sl.Address == "" {
+			return fmt.Errorf("audit.sink.syslog.address is required for the syslog sink")
+		}
+		if sl.Tag == "" {
+			sl.Tag = "cloud-api-gateway"
+		}
+		if sl.Facility == "" {
+			sl.Facility = "local0"
+		}
+		if _, ok := SyslogFacilities[sl.Facility]; !ok {
+			return fmt.Errorf("audit.sink.syslog.facility %q is not a syslog facility", sl.Facility)
+		}
+	case AuditSinkWebhook:
+		wh := &s.Webhook
+		if wh.URL == "" {
+			return fmt.Errorf("audit.sink.webhook.url is required for the webhook sink")
+		}
+		if wh.Timeout < 0 {
+			return fmt.Errorf("audit.sink.webhook.timeout must not be negative")
+		}
+		if wh.Timeout == 0 {
+			wh.Timeout = 5 * time.Second
+		}
+	default:
+		return fmt.Errorf("audit.sink.type %q is not supported", s.Type)
+	}
+	return nil
+}
+
+// validateUpstreamToken resolves which routes mint upstream tokens and checks that
+// signing keys are configured when any route does
+func (c *Config) validateUpstreamToken() error {
+	ut := &c.UpstreamToken
+	minting := false
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		if route.UpstreamToken == nil {
+			enabled := ut.Enabled
+			route.UpstreamToken = &enabled
+		}
+		minting = minting || *route.UpstreamToken
+	}
+	if !minting && len(ut.Keys) == 0 {
+		return nil
+	}
+
+	if len(ut.Keys) == 0 {
+		return fmt.Errorf("upstream_token.keys is required when a route mints upstream tokens")
+	}
+	ids := make(map[string]bool, len(ut.Keys))
+	for i, key := range ut.Keys {
+		if key.File == "" {
+			return fmt.Errorf("upstream_token.keys[%d].file is required", i)
+		}
+		if key.ID != "" && ids[key.ID] {
+			return fmt.Errorf("upstream_token.keys[%d].id %q is used more than once", i, key.ID)
+		}
+		ids[key.ID] = true
+	}
+	if ut.TTL < 0 {
+		return fmt.Errorf("upstream_token.ttl must not be negative")
+	}
+	if ut.TTL == 0 {
+		ut.TTL = time.Minute
+	}
+	if ut.Issuer == "" {
+		ut.Issuer = "cloud-api-gateway"
+	}
+	if ut.JWKSPath == "" {
+		ut.JWKSPath = "/.well-known/jwks.json"
+	}
+	if !strings.HasPrefix(ut.JWKSPath, "/") {
+		return fmt.Errorf("upstream_token.jwks_path must start with /")
+	}
+	return nil
+}
+
+// MintsUpstreamToken reports whether requests on the route carry a gateway-signed JWT upstream
+func (r *RouteConfig) MintsUpstreamToken() bool {
+	return r.UpstreamToken != nil && *r.UpstreamToken
+}
+
+// validate checks tracing settings and fills in defaults
+func (t *TracingConfig) validate() error {
+	if !t.Enabled {
+		return nil
+	}
+	if t.Exporter == "" {
+		t.Exporter = TracingExporterOTLPHTTP
+	}
+	switch t.Exporter {
+	case TracingExporterOTLPHTTP:
+		if t.Endpoint == "" {
+			return fmt.Errorf("tracing.endpoint is required for the %s exporter", t.Exporter)
+		}
+	case TracingExporterStdout:
+	default:
+		return fmt.Errorf("tracing.exporter %q is not supported", t.Exporter)
+	}
+	if t.ServiceName == "" {
+		t.ServiceName = "cloud-api-gateway"
+	}
+	if t.SampleRatio != nil && (*t.SampleRatio < 0 || *t.SampleRatio > 1) {
+		return fmt.Errorf("tracing.sample_ratio must be between 0 and 1")
+	}
+	if t.BatchTimeout < 0 || t.Timeout < 0 {
+		return fmt.Errorf("tracing.batch_timeout and tracing.timeout must not be negative")
+	}
+	if t.BatchTimeout == 0 {
+		t.BatchTimeout = 5 * time.Second
+	}
+	if t.Timeout == 0 {
+		t.Timeout = 10 * time.Second
+	}
+	return nil
+}
+
+// Ratio returns the configured sample ratio, defaulting to sampling every trace
+func (t *TracingConfig) Ratio() float64 {
+	if t.SampleRatio == nil {
+		return 1
+	}
+	return *t.SampleRatio
+}
+
+func validAuthMode(mode string) bool {
+	switch mode {
+	case AuthModeIntrospection, AuthModeJWKS, AuthModeJWKSWithFallback:
+		return true
+	}
+	return false
+}
+
+// validateUpstreams checks the route's upstream targets and load-balancing policy
+func (r *RouteConfig) validateUpstreams(i int) error {
+	if r.Upstream == "" && len(r.Upstreams) == 0 {
+		return fmt.Errorf("route[%d].upstream is required", i)
+	}
+	if r.Upstream != "" && len(r.Upstreams) > 0 {
+		return fmt.Errorf("route[%d]: upstream and upstreams are mutually exclusive", i)
+	}
+	for j := range r.Upstreams {
+		target := &r.Upstreams[j]
+		if target.URL == "" {
+			return fmt.Errorf("route[%d].upstreams[%d].url is required", i, j)
+		}
+		if target.Weight < 0 {
+			return fmt.Errorf("route[%d].upstreams[%d].weight must not be negative", i, j)
+		}
+		if target.Weight == 0 {
+			target.Weight = 1
+		}
+	}
+
+	lb := &r.LoadBalancing
+	switch lb.Policy {
+	case "":
+		lb.Policy = PolicyRoundRobin
+	case PolicyRoundRobin, PolicyWeightedRoundRobin, PolicyLeastOutstanding, PolicyRandomTwoChoices:
+	case PolicyConsistentHash:
+		if (lb.HashHeader == "") == (lb.HashCookie == "") {
+			return fmt.Errorf("route[%d].load_balancing: consistent_hash requires exactly one of hash_header or hash_cookie", i)
+		}
+	default:
+		return fmt.Errorf("route[%d].load_balancing.policy %q is not supported", i, lb.Policy)
+	}
+	return nil
+}
+
+// validateTimeoutsAndRetry checks upstream timeouts and retry settings and fills in defaults
+func (r *RouteConfig) validateTimeoutsAndRetry(i int) error {
+	t := r.Timeouts
+	if t.Connect < 0 || t.ResponseHeader < 0 || t.Request < 0 {
+		return fmt.Errorf("route[%d].timeouts must not be negative", i)
+	}
+
+	retry := r.Retry
+	if retry == nil {
+		return nil
+	}
+	if retry.Attempts <= 0 {
+		return fmt.Errorf("route[%d].retry.attempts must be positive", i)
+	}
+	for _, status := range retry.OnStatus {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("route[%d].retry.on_status: %d is not an error status", i, status)
+		}
+	}
+	if retry.BackoffBase < 0 || retry.BackoffMax < 0 {
+		return fmt.Errorf("route[%d].retry: backoff_base and backoff_max must not be negative", i)
+	}
+	if retry.BackoffBase == 0 {
+		retry.BackoffBase = 25 * time.Millisecond
+	}
+	if retry.BackoffMax == 0 {
+		retry.BackoffMax = 250 * time.Millisecond
+	}
+	if retry.BackoffMax < retry.BackoffBase {
+		return fmt.Errorf("route[%d].retry.backoff_max must not be below backoff_base", i)
+	}
+	budget := &retry.Budget
+	if budget.Ratio < 0 || budget.MinRetriesPerSecond < 0 {
+		return fmt.Errorf("route[%d].retry.budget: ratio and min_retries_per_second must not be negative", i)
+	}
+	if budget.Ratio == 0 {
+		budget.Ratio = 0.2
+	}
+	if budget.MinRetriesPerSecond == 0 {
+		budget.MinRetriesPerSecond = 10
+	}
+	return nil
+}
+
+// validate checks circuit breaker settings and fills in defaults
+func (c *CircuitBreakerConfig) validate(i int) error {
+	if c == nil {
+		return nil
+	}
+	if c.ConsecutiveFailures < 0 || c.MinRequests < 0 || c.Window < 0 || c.OpenDuration < 0 || c.HalfOpenRequests < 0 {
+		return fmt.Errorf("route[%d].circuit_breaker: thresholds and durations must not be negative", i)
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("route[%d].circuit_breaker.error_rate must be between 0 and 1", i)
+	}
+	if c.ConsecutiveFailures == 0 && c.ErrorRate == 0 {
+		return fmt.Errorf("route[%d].circuit_breaker needs consecutive_failures or error_rate", i)
+	}
+	if c.MinRequests == 0 {
+		c.MinRequests = 20
+	}
+	if c.Window == 0 {
+		c.Window = 10 * time.Second
+	}
+	if c.OpenDuration == 0 {
+		c.OpenDuration = 30 * time.Second
+	}
+	if c.HalfOpenRequests == 0 {
+		c.HalfOpenRequests = 1
+	}
+	return nil
+}
+
+// validateHealthCheck checks health-check settings and fills in defaults
+func (r *RouteConfig) validateHealthCheck(i int) error {
+	active := &r.HealthCheck.Active
+	if active.Enabled() {
+		if !strings.HasPrefix(active.Path, "/") {
+			return fmt.Errorf("route[%d].health_check.active.path must start with /", i)
+		}
+		if active.Interval < 0 || active.Timeout < 0 || active.HealthyThreshold < 0 || active.UnhealthyThreshold < 0 {
+			return fmt.Errorf("route[%d].health_check.active: interval, timeout and thresholds must not be negative", i)
+		}
+		if active.Interval == 0 {
+			active.Interval = 10 * time.Second
+		}
+		if active.Timeout == 0 {
+			active.Timeout = 2 * time.Second
+		}
+		if active.Timeout > active.Interval {
+			return fmt.Errorf("route[%d].health_check.active.timeout must not exceed interval", i)
+		}
+		if active.ExpectedStatus == 0 {
+			active.ExpectedStatus = 200
+		}
+		if active.ExpectedStatus < 100 || active.ExpectedStatus > 599 {
+			return fmt.Errorf("route[%d].health_check.active.expected_status %d is not a valid HTTP status", i, active.ExpectedStatus)
+		}
+		if active.HealthyThreshold == 0 {
+			active.HealthyThreshold = 2
+		}
+		if active.UnhealthyThreshold == 0 {
+			active.UnhealthyThreshold = 3
+		}
+	}
+
+	passive := &r.HealthCheck.Passive
+	if passive.ConsecutiveFailures < 0 || passive.EjectionDuration < 0 {
+		return fmt.Errorf("route[%d].health_check.passive: consecutive_failures and ejection_duration must not be negative", i)
+	}
+	if passive.Enabled() && passive.EjectionDuration == 0 {
+		passive.EjectionDuration = 30 * time.Second
+	}
+	return nil
+}
+
+// Targets returns the route's upstream targets, treating a single upstream
+// as one target with weight 1.
+func (r *RouteConfig) Targets() []UpstreamTarget {
+	if len(r.Upstreams) > 0 {
+		return r.Upstreams
+	}
+	return []UpstreamTarget{{URL: r.Upstream, Weight: 1}}
+}
+
 // RequiresAuth returns true if authentication is required for this rule.
 // Defaults to true if require_auth is not specified.
 func (r *RouteRule) RequiresAuth() bool {
@@ -3,8 +3,10 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func writeConfig(t *testing.T, content string) string {
@@ -225,6 +227,371 @@ func TestLoadRejectsMissingPathPattern(t *testing.T) {
 	}
 }
 
+func TestLoadCompilesPathTemplates(t *testing.T) {
+	cfg, err := Load(writeConfig(t, baseConfig(`
+  - name: "files"
+    path: "/api/v1/users/{id}/files/{path...}"
+    upstream: "http://files:8080"
+    rules:
+      - methods: ["GET"]
+`)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	route := cfg.Routes[0]
+	want := []PathSegment{{Literal: "api"}, {Literal: "v1"}, {Literal: "users"}, {Param: "id"}, {Literal: "files"}, {Param: "path", CatchAll: true}}
+	if !reflect.DeepEqual(route.CompiledPath, want) {
+		t.Fatalf("unexpected segments %+v", route.CompiledPath)
+	}
+	if route.CompiledPattern != nil {
+		t.Fatal("expected no regex for a path template")
+	}
+}
+
+func TestLoadRejectsInvalidPathTemplates(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  string
+		expect string
+	}{
+		{"both", "path: \"/users\"\n    path_pattern: \"^/users$\"", "path and path_pattern are mutually exclusive"},
+		{"relative", "path: \"users/{id}\"", "must start with /"},
+		{"partial segment", "path: \"/files/{name}.json\"", "parameters must take a whole segment"},
+		{"bad name", "path: \"/users/{user-id}\"", `invalid parameter name "user-id"`},
+		{"duplicate", "path: \"/users/{id}/friends/{id}\"", `parameter "id" is used more than once`},
+		{"catch-all not last", "path: \"/files/{path...}/meta\"", "{path...} must be the last segment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, baseConfig(`
+  - name: "users"
+    `+tt.route+`
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`)))
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadCompilesRouteMatch(t *testing.T) {
+	cfg, err := Load(writeConfig(t, baseConfig(`
+  - name: "orders-v2"
+    path: "/orders"
+    upstream: "http://orders-v2:8080"
+    match:
+      hosts: ["API.example.com", "*.Example.com"]
+      headers:
+        - name: x-api-version
+          value: "2"
+        - name: x-tenant
+          pattern: "^beta-"
+      query:
+        - name: debug
+      content_types: ["Application/JSON"]
+    rules:
+      - methods: ["GET"]
+`)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	m := cfg.Routes[0].Match
+	if !reflect.DeepEqual(m.Hosts, []string{"api.example.com", "*.example.com"}) || m.ContentTypes[0] != "application/json" {
+		t.Fatalf("expected hosts and media types in lower case, got %v %v", m.Hosts, m.ContentTypes)
+	}
+	if m.Headers[0].Name != "X-Api-Version" || m.Headers[1].Compiled == nil {
+		t.Fatalf("expected canonical header names and compiled patterns, got %+v", m.Headers)
+	}
+	if !m.Headers[1].Matches([]string{"other", "beta-acme"}) || !m.Query[0].Matches([]string{""}) || m.Query[0].Matches(nil) {
+		t.Fatal("unexpected matcher results")
+	}
+}
+
+func TestLoadRejectsInvalidRouteMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		match  string
+		expect string
+	}{
+		{"empty", "match: {}", "route[0].match: at least one condition must be configured"},
+		{"host with port", "match:\n      hosts: [\"api.example.com:443\"]", `route[0].match.hosts: "api.example.com:443" is not a host name`},
+		{"inner wildcard", "match:\n      hosts: [\"api.*.com\"]", "is not a host name or a *.domain wildcard"},
+		{"bad header", "match:\n      headers:\n        - name: \"X Version\"", `route[0].match.headers[0]: invalid header name "X Version"`},
+		{"value and pattern", "match:\n      headers:\n        - name: X-Version\n          value: \"2\"\n          pattern: \"^2\"", "value and pattern are mutually exclusive"},
+		{"bad pattern", "match:\n      query:\n        - name: v\n          pattern: \"(\"", "route[0].match.query[0].pattern invalid regex"},
+		{"unnamed query", "match:\n      query:\n        - value: \"2\"", "route[0].match.query[0]: name is required"},
+		{"bad media type", "match:\n      content_types: [\"json\"]", `route[0].match.content_types: "json" is not a media type`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, baseConfig(`
+  - name: "users"
+    path: "/users"
+    upstream: "http://users:8080"
+    `+tt.match+`
+    rules:
+      - methods: ["GET"]
+`)))
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate("/orders/$1/${2}x/{id}$$")
+	if err != nil {
+		t.Fatalf("ParseTemplate: %v", err)
+	}
+	want := []TemplatePart{{Literal: "/orders/"}, {Group: 1}, {Literal: "/"}, {Group: 2}, {Literal: "x/"}, {Param: "id"}, {Literal: "$"}}
+	if !reflect.DeepEqual(tmpl.Parts, want) {
+		t.Fatalf("unexpected parts %+v", tmpl.Parts)
+	}
+	tmpl, err = ParseTemplate("{route.name}:{client.ip}:{claims.org.id}")
+	if err != nil {
+		t.Fatalf("ParseTemplate: %v", err)
+	}
+	want = []TemplatePart{{Var: "route.name"}, {Literal: ":"}, {Var: "client.ip"}, {Literal: ":"}, {Var: "claims.org.id"}}
+	if !reflect.DeepEqual(tmpl.Parts, want) {
+		t.Fatalf("unexpected parts %+v", tmpl.Parts)
+	}
+	for _, bad := range []string{"/$x", "/$0", "/${1", "/{id", "/id}", "/{a-b}", "{claims.}", "{route.path}"} {
+		if _, err := ParseTemplate(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestLoadCompilesRewrite(t *testing.T) {
+	cfg, err := Load(writeConfig(t, baseConfig(`
+  - name: "orders"
+    path_pattern: "^/api/v1/users/(\\d+)/orders$"
+    upstream: "http://orders:8080"
+    rewrite:
+      path: "/orders?user=$1&src=gateway%20v2"
+      query:
+        region: "eu"
+      host: "orders.internal"
+    rules:
+      - methods: ["GET"]
+`)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	rw := cfg.Routes[0].Rewrite
+	if rw.CompiledPath == nil || len(rw.CompiledPath.Parts) != 1 || rw.CompiledPath.Parts[0].Literal != "/orders" {
+		t.Fatalf("expected the query to be split off the path, got %+v", rw.CompiledPath)
+	}
+	var names []string
+	for _, param := range rw.CompiledQuery {
+		names = append(names, param.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"region", "src", "user"}) || rw.CompiledQuery[1].Value.Parts[0].Literal != "gateway v2" {
+		t.Fatalf("unexpected query params %+v", rw.CompiledQuery)
+	}
+}
+
+func TestLoadRejectsInvalidRewrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  string
+		expect string
+	}{
+		{"empty", "path: \"/users/{id}\"\n    rewrite: {}", "route[0].rewrite: at least one of path, query and host must be configured"},
+		{"with strip_prefix", "path: \"/users/{id}\"\n    strip_prefix: \"/users\"\n    rewrite:\n      path: \"/u/{id}\"", "route[0].rewrite.path and strip_prefix are mutually exclusive"},
+		{"relative", "path: \"/users/{id}\"\n    rewrite:\n      path: \"u/{id}\"", "must start with /"},
+		{"unknown param", "path: \"/users/{id}\"\n    rewrite:\n      path: \"/u/{name}\"", "route[0].rewrite.path: the route captures no {name}"},
+		{"group out of range", "path_pattern: \"^/users/(\\\\d+)$\"\n    rewrite:\n      query:\n        page: \"$2\"", "route[0].rewrite.query.page: $2 is out of range; the route captures 1 values"},
+		{"bad template", "path: \"/users/{id}\"\n    rewrite:\n      path: \"/u/$x\"", "invalid capture group reference"},
+		{"bad escape", "path: \"/users/{id}\"\n    rewrite:\n      path: \"/u/%zz\"", "is not a valid URL path"},
+		{"bad host", "path: \"/users/{id}\"\n    rewrite:\n      host: \"http://legacy\"", `route[0].rewrite.host "http://legacy" is not a host name`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, baseConfig(`
+  - name: "users"
+    `+tt.route+`
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`)))
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadCompilesHeaders(t *testing.T) {
+	section := `headers:
+  response:
+    remove: ["server", "x-powered-by"]
+    set:
+      strict-transport-security: "max-age=31536000"
+`
+	cfg, err := Load(writeConfig(t, strings.Replace(baseConfig(`
+  - name: "partner"
+    path: "/partners/{id}"
+    upstream: "http://partner:8080"
+    headers:
+      request:
+        rename:
+          x-client-version: x-app-version
+        set:
+          x-api-key: "static-key"
+          x-partner: "{id} via {route.name}"
+        add:
+          x-caller: "{claims.sub}@{client.ip}"
+    rules:
+      - methods: ["GET"]
+      - methods: ["POST"]
+        headers:
+          response:
+            add:
+              cache-control: "no-store"
+`), "routes:", section+"routes:", 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	route := cfg.Routes[0]
+	if route.GatewayHeaders != cfg.Headers || !reflect.DeepEqual(cfg.Headers.Response.Remove, []string{"Server", "X-Powered-By"}) {
+		t.Fatalf("expected the top-level headers on the route with canonical names, got %+v", route.GatewayHeaders)
+	}
+	request := route.Headers.Request
+	if !reflect.DeepEqual(request.CompiledRename, []HeaderRename{{From: "X-Client-Version", To: "X-App-Version"}}) {
+		t.Fatalf("unexpected renames %+v", request.CompiledRename)
+	}
+	if len(request.CompiledSet) != 2 || request.CompiledSet[0].Name != "X-Api-Key" || request.CompiledSet[1].Value.Parts[0].Param != "id" {
+		t.Fatalf("expected set headers sorted by canonical name, got %+v", request.CompiledSet)
+	}
+	if route.Rules[1].Headers.Response.CompiledAdd[0].Name != "Cache-Control" {
+		t.Fatalf("expected rule headers to be compiled, got %+v", route.Rules[1].Headers.Response)
+	}
+}
+
+func TestLoadRejectsInvalidHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		section string
+		route   string
+		expect  string
+	}{
+		{"empty block", "", "headers: {}", "route[0].headers: request or response must be configured"},
+		{"empty transform", "", "headers:\n      request: {}", "route[0].headers.request: at least one of remove, rename, set and add must be configured"},
+		{"bad name", "", "headers:\n      response:\n        remove: [\"bad header\"]", `route[0].headers.response.remove: invalid header name "bad header"`},
+		{"rename to itself", "", "headers:\n      request:\n        rename:\n          x-a: X-A", `route[0].headers.request.rename: "x-a" is renamed to itself`},
+		{"host", "", "headers:\n      request:\n        set:\n          host: legacy", "the Host header cannot be transformed; use rewrite.host"},
+		{"unknown param", "", "headers:\n      request:\n        set:\n          x-user: \"{user}\"", "route[0].headers.request.set.x-user: the route captures no {user}"},
+		{"captures at top level", "headers:\n  request:\n    set:\n      x-id: \"$1\"\n", "", "headers.request.set.x-id: path captures are only available in route templates"},
+		{"vars in rewrite", "", "rewrite:\n      query:\n        user: \"{claims.sub}\"", "route[0].rewrite.query.user: {claims.sub} is only available in header templates"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path: "/users/{id}"
+    upstream: "http://users:8080"
+    `+tt.route+`
+    rules:
+      - methods: ["GET"]
+`), "routes:", tt.section+"routes:", 1)))
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadCompilesCORS(t *testing.T) {
+	section := `cors:
+  allowed_origins: ["https://App.example.com/", "https://*.preview.example.com:8443"]
+  allowed_origin_patterns: ["^https://[a-z]+\\.example\\.org$"]
+  allowed_methods: ["get", "post"]
+  allowed_headers: ["authorization", "*"]
+  exposed_headers: ["x-request-id"]
+  allow_credentials: true
+  max_age: 10m
+`
+	cfg, err := Load(writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path: "/users"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+  - name: "widgets"
+    path: "/widgets"
+    upstream: "http://widgets:8080"
+    cors:
+      allowed_origins: ["*"]
+    rules:
+      - methods: ["GET"]
+`), "routes:", section+"routes:", 1)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	cors := cfg.Routes[0].CORS
+	if cors != cfg.CORS {
+		t.Fatal("expected a route without a cors block to use the top-level one")
+	}
+	if !reflect.DeepEqual(cors.AllowedMethods, []string{"GET", "POST"}) || !reflect.DeepEqual(cors.AllowedHeaders, []string{"Authorization", "*"}) ||
+		cors.ExposedHeaders[0] != "X-Request-Id" {
+		t.Fatalf("expected methods and header names to be normalized, got %+v", cors)
+	}
+	for origin, want := range map[string]bool{
+		"https://app.example.com":              true,
+		"https://a.b.preview.example.com:8443": true,
+		"https://preview.example.com:8443":     false,
+		"https://x.preview.example.com":        false,
+		"https://evilpreview.example.com:8443": false,
+		"https://docs.example.org":             true,
+		"http://app.example.com":               false,
+		"":                                     false,
+	} {
+		if got := cors.AllowsOrigin(origin); got != want {
+			t.Errorf("AllowsOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+	if widgets := cfg.Routes[1].CORS; widgets == cfg.CORS || !widgets.AllowsAnyOrigin() {
+		t.Fatalf("expected the route's cors block to replace the top-level one, got %+v", widgets)
+	}
+}
+
+func TestLoadRejectsInvalidCORS(t *testing.T) {
+	tests := []struct {
+		name   string
+		cors   string
+		expect string
+	}{
+		{"no origins", "cors:\n      max_age: 1m", "route[0].cors: allowed_origins or allowed_origin_patterns must be configured"},
+		{"not an origin", "cors:\n      allowed_origins: [\"app.example.com\"]", `route[0].cors.allowed_origins: "app.example.com" is not an origin`},
+		{"origin with path", "cors:\n      allowed_origins: [\"https://app.example.com/x\"]", "is not an origin"},
+		{"inner wildcard", "cors:\n      allowed_origins: [\"https://app.*.example.com\"]", "is not an origin"},
+		{"credentials with any origin", "cors:\n      allowed_origins: [\"*\"]\n      allow_credentials: true", "allow_credentials cannot be combined with the * origin"},
+		{"bad pattern", "cors:\n      allowed_origin_patterns: [\"(\"]", "route[0].cors.allowed_origin_patterns[0] invalid regex"},
+		{"bad header", "cors:\n      allowed_origins: [\"*\"]\n      allowed_headers: [\"bad header\"]", `route[0].cors.allowed_headers: invalid header name "bad header"`},
+		{"negative max_age", "cors:\n      allowed_origins: [\"*\"]\n      max_age: -1s", "route[0].cors.max_age must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, baseConfig(`
+  - name: "users"
+    path: "/users"
+    upstream: "http://users:8080"
+    `+tt.cors+`
+    rules:
+      - methods: ["GET"]
+`)))
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
 func TestLoadRejectsInvalidRegex(t *testing.T) {
 	cfgPath := writeConfig(t, baseConfig(`
   - name: "users"
@@ -369,3 +736,895 @@ func TestLoadAcceptsPatternWithExistingCaseInsensitiveFlag(t *testing.T) {
 		t.Fatal("expected compiled pattern")
 	}
 }
+
+func TestLoadParsesReloadSettings(t *testing.T) {
+	cfgPath := writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`), "routes:", "reload:\n  watch: true\n  interval: 2s\nroutes:", 1))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !cfg.Reload.Watch || cfg.Reload.Interval != 2*time.Second {
+		t.Fatalf("unexpected reload settings: %+v", cfg.Reload)
+	}
+}
+
+func TestLoadRejectsNegativeReloadInterval(t *testing.T) {
+	cfgPath := writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`), "routes:", "reload:\n  interval: -1s\nroutes:", 1))
+
+	_, err := Load(cfgPath)
+	if err == nil || !strings.Contains(err.Error(), "reload.interval") {
+		t.Fatalf("expected reload interval validation error, got: %v", err)
+	}
+}
+
+func TestLoadAcceptsMultipleUpstreamsWithDefaults(t *testing.T) {
+	cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstreams:
+      - url: "http://users-1:8080"
+        weight: 3
+      - url: "http://users-2:8080"
+    rules:
+      - methods: ["GET"]
+`))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	route := cfg.Routes[0]
+	if route.LoadBalancing.Policy != PolicyRoundRobin {
+		t.Fatalf("expected default round_robin policy, got %q", route.LoadBalancing.Policy)
+	}
+	targets := route.Targets()
+	if len(targets) != 2 || targets[0].Weight != 3 || targets[1].Weight != 1 {
+		t.Fatalf("unexpected targets: %+v", targets)
+	}
+}
+
+func TestRouteTargetsWrapsSingleUpstream(t *testing.T) {
+	route := RouteConfig{Upstream: "http://users:8080"}
+	targets := route.Targets()
+	if len(targets) != 1 || targets[0].URL != "http://users:8080" || targets[0].Weight != 1 {
+		t.Fatalf("unexpected targets: %+v", targets)
+	}
+}
+
+func TestLoadRejectsInvalidUpstreamTargets(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  string
+		expect string
+	}{
+		{
+			name: "both upstream and upstreams",
+			route: `
+    upstream: "http://users:8080"
+    upstreams:
+      - url: "http://users-1:8080"`,
+			expect: "mutually exclusive",
+		},
+		{
+			name: "missing target url",
+			route: `
+    upstreams:
+      - weight: 2`,
+			expect: "upstreams[0].url is required",
+		},
+		{
+			name: "negative weight",
+			route: `
+    upstreams:
+      - url: "http://users-1:8080"
+        weight: -1`,
+			expect: "weight must not be negative",
+		},
+		{
+			name: "unknown policy",
+			route: `
+    upstream: "http://users:8080"
+    load_balancing:
+      policy: "fastest"`,
+			expect: "is not supported",
+		},
+		{
+			name: "consistent hash without key",
+			route: `
+    upstream: "http://users:8080"
+    load_balancing:
+      policy: "consistent_hash"`,
+			expect: "exactly one of hash_header or hash_cookie",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"`+tt.route+`
+    rules:
+      - methods: ["GET"]
+`))
+			_, err := Load(cfgPath)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadAppliesHealthCheckDefaults(t *testing.T) {
+	cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    health_check:
+      active:
+        path: "/healthz"
+      passive:
+        consecutive_failures: 5
+    rules:
+      - methods: ["GET"]
+`))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	hc := cfg.Routes[0].HealthCheck
+	if hc.Active.Interval != 10*time.Second || hc.Active.Timeout != 2*time.Second || hc.Active.ExpectedStatus != 200 ||
+		hc.Active.HealthyThreshold != 2 || hc.Active.UnhealthyThreshold != 3 {
+		t.Fatalf("unexpected active health check defaults: %+v", hc.Active)
+	}
+	if hc.Passive.EjectionDuration != 30*time.Second {
+		t.Fatalf("unexpected passive ejection default: %v", hc.Passive.EjectionDuration)
+	}
+}
+
+func TestLoadRejectsInvalidHealthCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		hc     string
+		expect string
+	}{
+		{"relative path", "active:\n        path: \"healthz\"", "must start with /"},
+		{"timeout above interval", "active:\n        path: \"/healthz\"\n        interval: 1s\n        timeout: 2s", "must not exceed interval"},
+		{"bad status", "active:\n        path: \"/healthz\"\n        expected_status: 42", "not a valid HTTP status"},
+		{"negative failures", "passive:\n        consecutive_failures: -1", "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    health_check:
+      `+tt.hc+`
+    rules:
+      - methods: ["GET"]
+`))
+			_, err := Load(cfgPath)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsAdminPortEqualToServerPort(t *testing.T) {
+	cfgPath := writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`), "port: 4010", "port: 4010\n  admin_port: 4010", 1))
+
+	_, err := Load(cfgPath)
+	if err == nil || !strings.Contains(err.Error(), "admin_port") {
+		t.Fatalf("expected admin_port validation error, got: %v", err)
+	}
+}
+
+func TestLoadResolvesRouteAuthModes(t *testing.T) {
+	cfgPath := writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+  - name: "orders"
+    path_pattern: "^/api/orders(/.*)?$"
+    upstream: "http://orders:8080"
+    auth_mode: "jwks_with_fallback"
+    rules:
+      - methods: ["GET"]
+`), "  timeout: 5s", "  timeout: 5s\n  mode: jwks\n  jwks:\n    url: \"http://keycloak/certs\"\n    issuer: \"http://keycloak/realms/test\"", 1))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Routes[0].AuthMode != AuthModeJWKS {
+		t.Fatalf("expected route to inherit authz.mode, got %q", cfg.Routes[0].AuthMode)
+	}
+	if cfg.Routes[1].AuthMode != AuthModeJWKSWithFallback {
+		t.Fatalf("expected route override, got %q", cfg.Routes[1].AuthMode)
+	}
+	if cfg.Authz.JWKS.RefreshInterval != 10*time.Minute || cfg.Authz.JWKS.MinRefreshInterval != 30*time.Second {
+		t.Fatalf("unexpected JWKS defaults: %+v", cfg.Authz.JWKS)
+	}
+}
+
+func TestLoadAllowsJWKSOnlyWithoutIntrospectionSettings(t *testing.T) {
+	cfgPath := writeConfig(t, `
+server:
+  port: 4010
+authz:
+  mode: jwks
+  jwks:
+    url: "http://keycloak/certs"
+    issuer: "http://keycloak/realms/test"
+routes:
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`)
+
+	if _, err := Load(cfgPath); err != nil {
+		t.Fatalf("expected jwks-only config to load, got: %v", err)
+	}
+}
+
+func TestLoadRejectsInvalidAuthModes(t *testing.T) {
+	tests := []struct {
+		name   string
+		authz  string
+		route  string
+		expect string
+	}{
+		{"unknown default mode", "\n  mode: magic", "", "authz.mode"},
+		{"unknown route mode", "", "\n    auth_mode: magic", "route[0].auth_mode"},
+		{"jwks without url", "", "\n    auth_mode: jwks", "authz.jwks.url is required"},
+		{"jwks without issuer", "\n  jwks:\n    url: \"http://keycloak/certs\"", "\n    auth_mode: jwks", "authz.jwks.issuer is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"`+tt.route+`
+    rules:
+      - methods: ["GET"]
+`), "  timeout: 5s", "  timeout: 5s"+tt.authz, 1))
+			_, err := Load(cfgPath)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadAppliesCacheDefaults(t *testing.T) {
+	cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Cache.MaxEntries != DefaultCacheMaxEntries || cfg.Cache.SweepInterval != DefaultCacheSweepInterval {
+		t.Fatalf("unexpected cache defaults: %+v", cfg.Cache)
+	}
+}
+
+func TestLoadKeepsByteBudgetWithoutEntryLimit(t *testing.T) {
+	cfgPath := writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`), "ttl: 60s", "ttl: 60s\n  max_bytes: 1048576", 1))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Cache.MaxEntries != 0 || cfg.Cache.MaxBytes != 1048576 {
+		t.Fatalf("expected only a byte budget, got %+v", cfg.Cache)
+	}
+}
+
+func TestLoadRejectsNegativeCacheLimits(t *testing.T) {
+	cfgPath := writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`), "ttl: 60s", "ttl: 60s\n  max_entries: -1", 1))
+
+	_, err := Load(cfgPath)
+	if err == nil || !strings.Contains(err.Error(), "cache.") {
+		t.Fatalf("expected cache validation error, got: %v", err)
+	}
+}
+
+func TestLoadParsesNegativeAndStaleCacheSettings(t *testing.T) {
+	cfgPath := writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`), "ttl: 60s", "ttl: 60s\n  negative_ttl: 5s\n  stale_grace: 2m", 1))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Cache.NegativeTTL != 5*time.Second || cfg.Cache.StaleGrace != 2*time.Minute {
+		t.Fatalf("unexpected cache settings: %+v", cfg.Cache)
+	}
+
+	cfgPath = writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`), "ttl: 60s", "ttl: 60s\n  stale_grace: -1s", 1))
+	if _, err := Load(cfgPath); err == nil || !strings.Contains(err.Error(), "cache.stale_grace") {
+		t.Fatalf("expected stale_grace validation error, got: %v", err)
+	}
+}
+
+func TestLoadAppliesTracingDefaults(t *testing.T) {
+	cfgPath := writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`), "routes:", "tracing:\n  enabled: true\n  endpoint: http://collector:4318\nroutes:", 1))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	tr := cfg.Tracing
+	if tr.Exporter != TracingExporterOTLPHTTP || tr.ServiceName != "cloud-api-gateway" || tr.Ratio() != 1 ||
+		tr.BatchTimeout != 5*time.Second || tr.Timeout != 10*time.Second {
+		t.Fatalf("unexpected tracing defaults: %+v", tr)
+	}
+}
+
+func TestLoadRejectsInvalidTracing(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracing string
+		expect  string
+	}{
+		{"missing endpoint", "tracing:\n  enabled: true\n", "tracing.endpoint"},
+		{"unknown exporter", "tracing:\n  enabled: true\n  exporter: zipkin\n", "tracing.exporter"},
+		{"ratio out of range", "tracing:\n  enabled: true\n  exporter: stdout\n  sample_ratio: 1.5\n", "tracing.sample_ratio"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`), "routes:", tt.tracing+"routes:", 1))
+			_, err := Load(cfgPath)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadAppliesRateLimitDefaults(t *testing.T) {
+	cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rate_limit:
+      requests: 100
+      window: 1m
+    rules:
+      - methods: ["POST"]
+        rate_limit:
+          algorithm: sliding_window
+          requests: 10
+          window: 1s
+          key: username
+`))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	route := cfg.Routes[0].RateLimit
+	if route.Algorithm != RateLimitTokenBucket || route.Burst != 100 || route.Key != RateLimitKeyIP {
+		t.Fatalf("unexpected route rate limit defaults: %+v", route)
+	}
+	rule := cfg.Routes[0].Rules[0].RateLimit
+	if rule.Algorithm != RateLimitSlidingWindow || rule.Burst != 0 || rule.Key != RateLimitKeyUsername {
+		t.Fatalf("unexpected rule rate limit: %+v", rule)
+	}
+}
+
+func TestLoadRejectsInvalidRateLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		rateLimit string
+		expect    string
+	}{
+		{"missing requests", "window: 1s", "route[0].rate_limit: requests and window must be positive"},
+		{"missing window", "requests: 5", "route[0].rate_limit: requests and window must be positive"},
+		{"unknown algorithm", "requests: 5\n      window: 1s\n      algorithm: leaky", "unsupported algorithm"},
+		{"burst with sliding window", "requests: 5\n      window: 1s\n      algorithm: sliding_window\n      burst: 10", "burst is only supported"},
+		{"unknown key", "requests: 5\n      window: 1s\n      key: cookie", "unsupported key"},
+		{"header key without header", "requests: 5\n      window: 1s\n      key: header", "header is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rate_limit:
+      `+tt.rateLimit+`
+    rules:
+      - methods: ["GET"]
+`))
+			_, err := Load(cfgPath)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadParsesIdentityHeaders(t *testing.T) {
+	cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    identity_headers:
+      username: "x-username"
+      roles: "X-User-Roles"
+      claims:
+        email: "X-User-Email"
+    rules:
+      - methods: ["GET"]
+`))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	names := cfg.Routes[0].IdentityHeaders.HeaderNames()
+	if strings.Join(names, ",") != "X-User-Email,X-User-Roles,X-Username" {
+		t.Fatalf("unexpected identity header names: %v", names)
+	}
+}
+
+func TestLoadRejectsInvalidIdentityHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers string
+		expect  string
+	}{
+		{"empty block", "{}", "at least one header"},
+		{"invalid name", "{username: \"X User\"}", "invalid header name"},
+		{"duplicate header", "{username: X-User, claims: {sub: x-user}}", "configured more than once"},
+		{"empty claim header", "{claims: {email: \"\"}}", "must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    identity_headers: `+tt.headers+`
+    rules:
+      - methods: ["GET"]
+`))
+			_, err := Load(cfgPath)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadResolvesUpstreamTokenPerRoute(t *testing.T) {
+	cfgPath := writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+  - name: "legacy"
+    path_pattern: "^/legacy(/.*)?$"
+    upstream: "http://legacy:8080"
+    upstream_token: false
+    rules:
+      - methods: ["GET"]
+`), "routes:", "upstream_token:\n  enabled: true\n  keys:\n    - file: /keys/current.pem\nroutes:", 1))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !cfg.Routes[0].MintsUpstreamToken() || cfg.Routes[1].MintsUpstreamToken() {
+		t.Fatalf("unexpected per-route minting: users=%v legacy=%v",
+			cfg.Routes[0].MintsUpstreamToken(), cfg.Routes[1].MintsUpstreamToken())
+	}
+	ut := cfg.UpstreamToken
+	if ut.TTL != time.Minute || ut.Issuer != "cloud-api-gateway" || ut.JWKSPath != "/.well-known/jwks.json" {
+		t.Fatalf("unexpected upstream token defaults: %+v", ut)
+	}
+}
+
+func TestLoadRejectsInvalidUpstreamToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		section string
+		route   string
+		expect  string
+	}{
+		{"enabled without keys", "upstream_token:\n  enabled: true\n", "", "upstream_token.keys is required"},
+		{"route opt-in without keys", "", "    upstream_token: true\n", "upstream_token.keys is required"},
+		{"key without file", "upstream_token:\n  keys:\n    - id: k1\n", "", "upstream_token.keys[0].file is required"},
+		{"duplicate id", "upstream_token:\n  keys:\n    - {id: k1, file: a.pem}\n    - {id: k1, file: b.pem}\n", "", "used more than once"},
+		{"relative jwks path", "upstream_token:\n  jwks_path: jwks\n  keys:\n    - file: a.pem\n", "", "jwks_path must start with /"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := writeConfig(t, strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+`+tt.route+`    rules:
+      - methods: ["GET"]
+`), "routes:", tt.section+"routes:", 1))
+			_, err := Load(cfgPath)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadAppliesRetryDefaults(t *testing.T) {
+	cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    timeouts:
+      connect: 2s
+      response_header: 5s
+      request: 30s
+    retry:
+      attempts: 2
+      on_status: [502, 503]
+    rules:
+      - methods: ["GET"]
+`))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	route := cfg.Routes[0]
+	if route.Timeouts != (TimeoutsConfig{Connect: 2 * time.Second, ResponseHeader: 5 * time.Second, Request: 30 * time.Second}) {
+		t.Fatalf("unexpected timeouts: %+v", route.Timeouts)
+	}
+	retry := route.Retry
+	if retry.BackoffBase != 25*time.Millisecond || retry.BackoffMax != 250*time.Millisecond ||
+		retry.Budget.Ratio != 0.2 || retry.Budget.MinRetriesPerSecond != 10 {
+		t.Fatalf("unexpected retry defaults: %+v", retry)
+	}
+}
+
+func TestLoadRejectsInvalidTimeoutsAndRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		block  string
+		expect string
+	}{
+		{"negative timeout", "timeouts:\n      request: -1s", "route[0].timeouts must not be negative"},
+		{"no attempts", "retry:\n      on_status: [503]", "route[0].retry.attempts must be positive"},
+		{"success status", "retry:\n      attempts: 1\n      on_status: [200]", "is not an error status"},
+		{"max below base", "retry:\n      attempts: 1\n      backoff_base: 1s\n      backoff_max: 10ms", "backoff_max must not be below backoff_base"},
+		{"negative budget", "retry:\n      attempts: 1\n      budget:\n        ratio: -1", "route[0].retry.budget"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    `+tt.block+`
+    rules:
+      - methods: ["GET"]
+`))
+			_, err := Load(cfgPath)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadAppliesCircuitBreakerDefaults(t *testing.T) {
+	cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    circuit_breaker:
+      error_rate: 0.5
+    rules:
+      - methods: ["GET"]
+`))
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	expected := CircuitBreakerConfig{ErrorRate: 0.5, MinRequests: 20, Window: 10 * time.Second, OpenDuration: 30 * time.Second, HalfOpenRequests: 1}
+	if got := cfg.Routes[0].CircuitBreaker; got == nil || *got != expected {
+		t.Fatalf("unexpected circuit breaker defaults: %+v", got)
+	}
+}
+
+func TestLoadRejectsInvalidCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name   string
+		block  string
+		expect string
+	}{
+		{"no trigger", "circuit_breaker:\n      open_duration: 10s", "route[0].circuit_breaker needs consecutive_failures or error_rate"},
+		{"rate above one", "circuit_breaker:\n      error_rate: 1.5", "error_rate must be between 0 and 1"},
+		{"negative duration", "circuit_breaker:\n      consecutive_failures: 5\n      open_duration: -1s", "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    `+tt.block+`
+    rules:
+      - methods: ["GET"]
+`))
+			_, err := Load(cfgPath)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsInvalidWebSocketConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		block  string
+		expect string
+	}{
+		{"no token source", "websocket: {}", "route[0].websocket needs token_query_param or token_subprotocol_prefix"},
+		{"invalid prefix", "websocket:\n      token_subprotocol_prefix: \"bearer \"", "is not a valid subprotocol token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := writeConfig(t, baseConfig(`
+  - name: "chat"
+    path_pattern: "^/ws(/.*)?$"
+    upstream: "http://chat:8080"
+    `+tt.block+`
+    rules:
+      - methods: ["GET"]
+`))
+			_, err := Load(cfgPath)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestLoadAppliesAuditDefaults(t *testing.T) {
+	cfg, err := Load(writeConfig(t, baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`)))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Audit.MaxBodyBytes != DefaultAuditMaxBodyBytes || len(cfg.Audit.SkipContentTypes) != len(DefaultAuditSkipContentTypes) {
+		t.Fatalf("unexpected audit defaults: %+v", cfg.Audit)
+	}
+	sink := cfg.Audit.Sink
+	if sink.Type != AuditSinkStdout || sink.QueueSize != 10000 || sink.Overflow != AuditOverflowBlock ||
+		sink.BatchSize != 100 || sink.FlushInterval != time.Second {
+		t.Fatalf("unexpected audit sink defaults: %+v", sink)
+	}
+	if cfg.Audit.Chain != nil {
+		t.Fatal("expected the audit log to be unchained by default")
+	}
+}
+
+func TestLoadAppliesAuditChainDefaults(t *testing.T) {
+	content := strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`), "routes:", "audit:\n  chain:\n    signing_key:\n      file: /etc/gateway/audit-key.pem\nroutes:", 1)
+	cfg, err := Load(writeConfig(t, content))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	chain := cfg.Audit.Chain
+	if chain == nil || chain.CheckpointEntries != 1000 || chain.CheckpointInterval != time.Minute {
+		t.Fatalf("unexpected audit chain defaults: %+v", chain)
+	}
+}
+
+func TestLoadCompilesAuditPolicies(t *testing.T) {
+	content := strings.Replace(baseConfig(`
+  - name: "payments"
+    path_pattern: "^/api/payments(/.*)?$"
+    upstream: "http://payments:8080"
+    audit:
+      skip_methods: ["head"]
+      skip_paths: ["^/api/payments/status$"]
+      redact_fields: ["$.card.cvv"]
+      redact_values:
+        - name: card_number
+      capture_response_body: true
+    rules:
+      - methods: ["GET"]
+`), "routes:", `audit:
+  skip_paths: ["^/internal/"]
+  redact_headers: ["X-Tenant-Secret"]
+  redact_values:
+    - name: email
+    - name: iban
+      pattern: "[A-Z]{2}\\d{2}[A-Z0-9]{11,30}"
+  capture_query: false
+routes:`, 1)
+	cfg, err := Load(writeConfig(t, content))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	audit := cfg.Audit
+	if len(audit.CompiledSkipPaths) != 1 || !audit.CompiledSkipPaths[0].MatchString("/internal/x") {
+		t.Fatalf("expected skip_paths to replace the defaults, got %v", audit.SkipPaths)
+	}
+	if len(audit.SkipMethods) != 0 {
+		t.Fatalf("expected no methods to be skipped by default, got %v", audit.SkipMethods)
+	}
+	if *audit.CaptureQuery || !*audit.CaptureRequestBody || *audit.CaptureResponseBody {
+		t.Fatal("unexpected capture toggles")
+	}
+	if !audit.RedactValues[0].Compiled.MatchString("ann@example.com") || !audit.RedactValues[1].Compiled.MatchString("DE89370400440532013000") {
+		t.Fatal("expected the built-in and custom value patterns to be compiled")
+	}
+
+	route := cfg.Routes[0].Audit
+	if route == nil || route.SkipMethods[0] != "HEAD" || !route.CompiledSkipPaths[0].MatchString("/api/payments/status") {
+		t.Fatalf("expected the route audit block to be compiled, got %+v", route)
+	}
+	if route.CaptureQuery != nil || !*route.CaptureResponseBody || route.RedactValues[0].Compiled == nil {
+		t.Fatalf("expected unset route toggles to stay nil, got %+v", route)
+	}
+}
+
+func TestLoadAppliesAuditSinkDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		section string
+		check   func(AuditSinkConfig) bool
+	}{
+		{"file", "audit:\n  sink:\n    type: file\n    file:\n      path: /var/log/audit.log\n", func(s AuditSinkConfig) bool {
+			return s.File.MaxSizeMB == 100 && s.File.MaxBackups == 0
+		}},
+		{"syslog", "audit:\n  sink:\n    type: syslog\n    syslog:\n      address: \"collector:514\"\n", func(s AuditSinkConfig) bool {
+			return s.Syslog.Network == "udp" && s.Syslog.Tag == "cloud-api-gateway" && s.Syslog.Facility == "local0"
+		}},
+		{"webhook", "audit:\n  sink:\n    type: webhook\n    overflow: drop\n    webhook:\n      url: \"https://collector/audit\"\n", func(s AuditSinkConfig) bool {
+			return s.Webhook.Timeout == 5*time.Second && s.Overflow == AuditOverflowDrop
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`), "routes:", tt.section+"routes:", 1)
+			cfg, err := Load(writeConfig(t, content))
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if !tt.check(cfg.Audit.Sink) {
+				t.Fatalf("unexpected sink defaults: %+v", cfg.Audit.Sink)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsInvalidAuditConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		section string
+		expect  string
+	}{
+		{"negative body limit", "audit:\n  max_body_bytes: -1\n", "audit.max_body_bytes must not be negative"},
+		{"bad media type", "audit:\n  skip_content_types: [\"json\"]\n", `audit.skip_content_types: "json" is not a media type`},
+		{"bad skip path", "audit:\n  skip_paths: [\"(\"]\n", "audit.skip_paths: invalid regex"},
+		{"bad redact header", "audit:\n  redact_headers: [\"X Secret\"]\n", `audit.redact_headers: "X Secret" is not a header name`},
+		{"bad redact field", "audit:\n  redact_fields: [\"card..cvv\"]\n", `audit.redact_fields: "card..cvv" is not a JSON path`},
+		{"unknown preset", "audit:\n  redact_values:\n    - name: phone\n", "audit.redact_values[0]: pattern is required"},
+		{"bad value regex", "audit:\n  redact_values:\n    - pattern: \"[\"\n", "audit.redact_values[0]: invalid regex"},
+		{"unknown sink", "audit:\n  sink:\n    type: kafka\n", `audit.sink.type "kafka" is not supported`},
+		{"unknown overflow", "audit:\n  sink:\n    overflow: spill\n", `audit.sink.overflow "spill" is not supported`},
+		{"negative queue", "audit:\n  sink:\n    queue_size: -1\n", "must not be negative"},
+		{"file without path", "audit:\n  sink:\n    type: file\n", "audit.sink.file.path is required"},
+		{"syslog without address", "audit:\n  sink:\n    type: syslog\n", "audit.sink.syslog.address is required"},
+		{"syslog network", "audit:\n  sink:\n    type: syslog\n    syslog:\n      network: unix\n      address: /dev/log\n", "audit.sink.syslog.network must be udp or tcp"},
+		{"syslog facility", "audit:\n  sink:\n    type: syslog\n    syslog:\n      address: \"collector:514\"\n      facility: local9\n", `audit.sink.syslog.facility "local9"`},
+		{"webhook without url", "audit:\n  sink:\n    type: webhook\n", "audit.sink.webhook.url is required"},
+		{"chain without key", "audit:\n  chain:\n    checkpoint_entries: 10\n", "audit.chain.signing_key.file is required"},
+		{"negative checkpoint interval", "audit:\n  chain:\n    checkpoint_interval: -1s\n", "audit.chain: checkpoint_entries and checkpoint_interval must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := strings.Replace(baseConfig(`
+  - name: "users"
+    path_pattern: "^/api/users(/.*)?$"
+    upstream: "http://users:8080"
+    rules:
+      - methods: ["GET"]
+`), "routes:", tt.section+"routes:", 1)
+			_, err := Load(writeConfig(t, content))
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Fatalf("expected error containing %q, got: %v", tt.expect, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+)
+
+// AdminHandler serves operator endpoints on the admin port:
+//
+//	GET /upstreams  health of every route's upstream targets
+//	GET /cache      token cache size and hit/miss/eviction counters
+//	GET /metrics    Prometheus metrics
+func (g *Gateway) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Default.Handler())
+	mux.HandleFunc("GET /upstreams", g.serveUpstreams)
+	mux.HandleFunc("GET /cache", g.serveCache)
+	return mux
+}
+
+// serveUpstreams reports which targets are healthy, unhealthy or ejected
+func (g *Gateway) serveUpstreams(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g.Current().Registry.Status())
+}
+
+// serveCache reports token cache statistics
+func (g *Gateway) serveCache(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g.Current().KeycloakClient.CacheStats())
+}
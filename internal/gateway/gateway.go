@@ -0,0 +1,327 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/auth"
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/middleware"
+	"github.com/aveiga/cloud-api-gateway/internal/proxy"
+	"github.com/aveiga/cloud-api-gateway/internal/ratelimit"
+	"github.com/aveiga/cloud-api-gateway/internal/router"
+	"github.com/aveiga/cloud-api-gateway/internal/tracing"
+)
+
+// Snapshot is an immutable view of everything built from one configuration:
+// router, prebuilt proxies and auth settings. Requests are served entirely
+// against the snapshot that was current when they arrived.
+type Snapshot struct {
+	Config         *config.Config
+	Router         *router.Router
+	Registry       *proxy.Registry
+	KeycloakClient *auth.Client
+	JWKSValidator  *auth.JWKSValidator
+	Tracer         *tracing.Tracer                       // nil when tracing is disabled
+	RateLimits     ratelimit.Store                       // shared by all routes, kept across reloads
+	TokenSigner    *auth.TokenSigner                     // nil unless a route mints upstream tokens
+	authMWs        map[string]*middleware.AuthMiddleware // by auth mode
+}
+
+// NewSnapshot builds the router, proxies and auth middleware for a configuration.
+// Transports are drawn from pool so upstream connections survive reloads.
+func NewSnapshot(cfg *config.Config, pool *proxy.TransportPool) (*Snapshot, error) {
+	registry, err := proxy.NewRegistryWithPool(cfg.Routes, pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build route proxies: %w", err)
+	}
+
+	var signer *auth.TokenSigner
+	if mintsUpstreamTokens(cfg.Routes) {
+		signer, err = auth.NewTokenSigner(&cfg.UpstreamToken)
+		if err != nil {
+			registry.Close()
+			return nil, fmt.Errorf("failed to load upstream token keys: %w", err)
+		}
+	}
+
+	var tracer *tracing.Tracer
+	if cfg.Tracing.Enabled {
+		tracer, err = tracing.NewTracer(cfg.Tracing)
+		if err != nil {
+			registry.Close()
+			return nil, fmt.Errorf("failed to set up tracing: %w", err)
+		}
+	}
+
+	snapshot := &Snapshot{
+		Config:         cfg,
+		Router:         router.NewRouter(cfg.Routes, registry),
+		Registry:       registry,
+		KeycloakClient: auth.NewClientWithCache(&cfg.Authz, cfg.Cache),
+		JWKSValidator:  auth.NewJWKSValidator(&cfg.Authz.JWKS, cfg.Authz.Timeout),
+		Tracer:         tracer,
+		RateLimits:     ratelimit.NewMemoryStore(),
+		TokenSigner:    signer,
+	}
+	snapshot.buildAuthMiddlewares()
+
+	return snapshot, nil
+}
+
+// buildAuthMiddlewares creates one auth middleware per auth mode
+func (s *Snapshot) buildAuthMiddlewares() {
+	s.authMWs = map[string]*middleware.AuthMiddleware{
+		config.AuthModeIntrospection: middleware.NewAuthMiddleware(s.KeycloakClient),
+		config.AuthModeJWKS:          middleware.NewAuthMiddleware(s.JWKSValidator),
+		config.AuthModeJWKSWithFallback: middleware.NewAuthMiddleware(&auth.FallbackValidator{
+			Primary:  s.JWKSValidator,
+			Fallback: s.KeycloakClient,
+		}),
+	}
+}
+
+// authMiddleware returns the auth middleware for a route's auth mode
+func (s *Snapshot) authMiddleware(route *router.Route) *middleware.AuthMiddleware {
+	if authMW, ok := s.authMWs[route.AuthMode]; ok {
+		return authMW
+	}
+	return s.authMWs[config.AuthModeIntrospection]
+}
+
+// ServeHTTP matches the request to a route and runs it through auth, RBAC and the route proxy
+func (s *Snapshot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := s.Tracer.StartServer(r, r.Method)
+	defer span.End()
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("url.path", r.URL.Path)
+
+	status := instrument(w, r.WithContext(ctx), s.serve)
+	span.SetAttribute("http.response.status_code", status)
+	if status >= 500 {
+		span.SetError(fmt.Errorf("request failed with status %d", status))
+	}
+}
+
+// serve handles the request and returns the name of the matched route
+func (s *Snapshot) serve(w http.ResponseWriter, r *http.Request) string {
+	if s.TokenSigner != nil && r.Method == http.MethodGet && r.URL.Path == s.Config.UpstreamToken.JWKSPath {
+		s.serveJWKS(w, r)
+		return jwksRoute
+	}
+
+	// Match route
+	_, matchSpan := tracing.Start(r.Context(), "router.match", tracing.SpanKindInternal)
+	matchedRoute, matchingRules, params := s.matchRoute(r)
+	matchSpan.SetAttribute("gateway.route.matched", matchedRoute != nil)
+	matchSpan.End()
+	if matchedRoute == nil {
+		http.Error(w, "Route not found", http.StatusNotFound)
+		return unmatchedRoute
+	}
+
+	middleware.SetAuditPolicy(r, matchedRoute.Audit)
+	middleware.SetAuditPathParams(r, params.Map())
+	if params != nil {
+		r = r.WithContext(router.WithParams(r.Context(), params))
+	}
+
+	serverSpan := tracing.SpanFromContext(r.Context())
+	serverSpan.SetName(r.Method + " " + matchedRoute.Name)
+	serverSpan.SetAttribute("gateway.route", matchedRoute.Name)
+	if matchedRoute.Path != "" {
+		serverSpan.SetAttribute("http.route", matchedRoute.Path)
+	}
+
+	// Use the proxy prebuilt for this route
+	routeProxy := matchedRoute.Handler
+	if routeProxy == nil {
+		log.Printf("No proxy registered for route %s", matchedRoute.Name)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return matchedRoute.Name
+	}
+
+	// Compose middleware chain from matched rules.
+	// Any matching public rule bypasses auth; otherwise use auth + RBAC.
+	// WebSocket handshakes first move their token into the Authorization header.
+	// Rate limits run after auth so they can be keyed by identity.
+	// The upstream token is minted last, once the caller is known to be allowed.
+	// CORS runs first so preflights are answered before auth.
+	var signer *auth.TokenSigner
+	if matchedRoute.MintsUpstreamToken() {
+		signer = s.TokenSigner
+	}
+	upstream := middleware.NewUpstreamTokenMiddleware(matchedRoute.Name, signer).Handler(routeProxy)
+	rateLimitMW := middleware.NewRateLimitMiddleware(matchedRoute.Name, s.RateLimits, matchedRoute.RateLimit, matchingRules)
+	chain := rateLimitMW.Handler(upstream)
+
+	publicRules, protectedRules := splitRulesByAuth(matchingRules)
+	if len(publicRules) == 0 {
+		rbacMW := middleware.NewRBACMiddleware(matchedRoute.Name, protectedRules)
+		chain = s.authMiddleware(matchedRoute).Handler(rateLimitMW.Handler(rbacMW.Handler(upstream)))
+	}
+	chain = middleware.NewWebSocketTokenMiddleware(matchedRoute.WebSocket).Handler(chain)
+	chain = middleware.NewCORSMiddleware(matchedRoute.CORS).Handler(chain)
+
+	chain.ServeHTTP(w, r)
+	return matchedRoute.Name
+}
+
+// matchRoute matches a CORS preflight by the method it asks about, so it is answered
+// by the route the actual request will reach. Preflights for routes without CORS and
+// all other requests are matched by their own method.
+func (s *Snapshot) matchRoute(r *http.Request) (*router.Route, []config.RouteRule, router.Params) {
+	if middleware.IsCORSPreflight(r) {
+		actual := *r
+		actual.Method = r.Header.Get("Access-Control-Request-Method")
+		if route, rules, params := s.Router.MatchRoute(&actual); route != nil && route.CORS != nil {
+			return route, rules, params
+		}
+	}
+	return s.Router.MatchRoute(r)
+}
+
+// serveJWKS publishes the keys upstreams use to verify gateway-minted tokens
+func (s *Snapshot) serveJWKS(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(s.TokenSigner.JWKS())
+}
+
+// Gateway serves requests from the current snapshot and swaps it atomically on reload
+type Gateway struct {
+	configPath string
+	pool       *proxy.TransportPool
+	current    atomic.Pointer[Snapshot]
+	reloadMu   sync.Mutex
+}
+
+// New creates a gateway from an already loaded configuration.
+// configPath is re-read on every Reload.
+func New(configPath string, cfg *config.Config) (*Gateway, error) {
+	g := &Gateway{
+		configPath: configPath,
+		pool:       proxy.NewTransportPool(),
+	}
+
+	snapshot, err := NewSnapshot(cfg, g.pool)
+	if err != nil {
+		return nil, err
+	}
+	g.current.Store(snapshot)
+
+	return g, nil
+}
+
+// Current returns the snapshot currently serving new requests
+func (g *Gateway) Current() *Snapshot {
+	return g.current.Load()
+}
+
+// ServeHTTP serves the request against the snapshot current at arrival time
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.current.Load().ServeHTTP(w, r)
+}
+
+// Reload re-reads and validates the configuration file and swaps in a new snapshot.
+// If the new configuration is invalid the current snapshot keeps serving and the error is returned.
+func (g *Gateway) Reload() error {
+	g.reloadMu.Lock()
+	defer g.reloadMu.Unlock()
+
+	cfg, err := config.Load(g.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	previous := g.current.Load()
+	if previous.Config.Server != cfg.Server {
+		log.Printf("Server settings changed in %s; they take effect only after a restart", g.configPath)
+	}
+	if !reflect.DeepEqual(previous.Config.Audit, cfg.Audit) {
+		log.Printf("Audit settings changed in %s; they take effect only after a restart", g.configPath)
+	}
+
+	snapshot, err := NewSnapshot(cfg, g.pool)
+	if err != nil {
+		return err
+	}
+
+	// Keep the token cache and JWKS keys warm when auth settings did not change
+	reuseAuth := reflect.DeepEqual(previous.Config.Authz, cfg.Authz) && previous.Config.Cache == cfg.Cache
+	if reuseAuth {
+		snapshot.KeycloakClient.Close()
+		snapshot.KeycloakClient = previous.KeycloakClient
+		snapshot.JWKSValidator = previous.JWKSValidator
+		snapshot.buildAuthMiddlewares()
+	}
+
+	// Rate limit counters survive reloads
+	snapshot.RateLimits = previous.RateLimits
+
+	// Keep the tracer (and its export queue) when tracing settings did not change
+	reuseTracer := reflect.DeepEqual(previous.Config.Tracing, cfg.Tracing)
+	if reuseTracer {
+		shutdownTracer(snapshot.Tracer)
+		snapshot.Tracer = previous.Tracer
+	}
+
+	g.current.Store(snapshot)
+	snapshot.Registry.RetainTransports()
+	previous.Registry.Close()
+	if !reuseAuth {
+		// Stops the cache sweeper and takes its entries out of the metrics; in-flight
+		// requests can still use the old client
+		previous.KeycloakClient.Close()
+	}
+	if !reuseTracer {
+		shutdownTracer(previous.Tracer)
+	}
+
+	log.Printf("Configuration reloaded from %s (%d routes)", g.configPath, len(cfg.Routes))
+	return nil
+}
+
+// Close stops background work of the current snapshot
+func (g *Gateway) Close() {
+	snapshot := g.current.Load()
+	snapshot.Registry.Close()
+	snapshot.KeycloakClient.Close()
+	shutdownTracer(snapshot.Tracer)
+}
+
+// shutdownTracer flushes buffered spans, giving the collector a bounded amount of time
+func shutdownTracer(tracer *tracing.Tracer) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
+}
+
+// mintsUpstreamTokens reports whether any route needs the upstream token signer
+func mintsUpstreamTokens(routes []config.RouteConfig) bool {
+	for i := range routes {
+		if routes[i].MintsUpstreamToken() {
+			return true
+		}
+	}
+	return false
+}
+
+func splitRulesByAuth(rules []config.RouteRule) (publicRules []config.RouteRule, protectedRules []config.RouteRule) {
+	for _, rule := range rules {
+		if rule.RequiresAuth() {
+			protectedRules = append(protectedRules, rule)
+			continue
+		}
+		publicRules = append(publicRules, rule)
+	}
+	return publicRules, protectedRules
+}
@@ -0,0 +1,733 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/auth"
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/middleware"
+	"github.com/aveiga/cloud-api-gateway/internal/proxy"
+	"github.com/aveiga/cloud-api-gateway/internal/tracing/tracingtest"
+)
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+func gatewayConfig(upstream string, extraRoutes string) string {
+	return `
+server:
+  port: 4010
+authz:
+  introspection_url: "http://keycloak/introspect"
+  client_id: "gateway"
+  client_secret: "secret"
+  timeout: 5s
+cache:
+  enabled: true
+  ttl: 60s
+routes:
+  - name: "public"
+    path_pattern: "^/public(/.*)?$"
+    upstream: "` + upstream + `"
+    rules:
+      - methods: ["GET"]
+        require_auth: false
+  - name: "private"
+    path_pattern: "^/private(/.*)?$"
+    upstream: "` + upstream + `"
+    rules:
+      - methods: ["GET"]
+` + extraRoutes
+}
+
+func writeConfigFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+}
+
+func newBackend(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func newTestGateway(t *testing.T, content string) (*Gateway, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, content)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	gw, err := New(path, cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(gw.Close)
+	return gw, path
+}
+
+func get(gw http.Handler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	return rec
+}
+
+func TestSplitRulesByAuth(t *testing.T) {
+	rules := []config.RouteRule{
+		{Methods: []string{"GET"}, RequireAuth: boolPtr(false)},
+		{Methods: []string{"POST"}},
+		{Methods: []string{"DELETE"}, RequireAuth: boolPtr(true)},
+	}
+
+	publicRules, protectedRules := splitRulesByAuth(rules)
+	if len(publicRules) != 1 {
+		t.Fatalf("expected 1 public rule, got %d", len(publicRules))
+	}
+	if len(protectedRules) != 2 {
+		t.Fatalf("expected 2 protected rules, got %d", len(protectedRules))
+	}
+}
+
+func TestGatewayServesPublicAndProtectedRoutes(t *testing.T) {
+	backend := newBackend(t, "v1")
+	gw, _ := newTestGateway(t, gatewayConfig(backend.URL, ""))
+
+	if rec := get(gw, "/public"); rec.Code != http.StatusOK || rec.Body.String() != "v1" {
+		t.Fatalf("expected public route to proxy, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := get(gw, "/private"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 on protected route without token, got %d", rec.Code)
+	}
+	if rec := get(gw, "/missing"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown route, got %d", rec.Code)
+	}
+}
+
+func TestGatewayReloadSwapsRoutes(t *testing.T) {
+	v1 := newBackend(t, "v1")
+	v2 := newBackend(t, "v2")
+	gw, path := newTestGateway(t, gatewayConfig(v1.URL, ""))
+	before := gw.Current()
+
+	writeConfigFile(t, path, gatewayConfig(v2.URL, `
+  - name: "extra"
+    path_pattern: "^/extra$"
+    upstream: "`+v2.URL+`"
+    rules:
+      - methods: ["GET"]
+        require_auth: false
+`))
+	if err := gw.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if gw.Current() == before {
+		t.Fatal("expected a new snapshot after reload")
+	}
+	if rec := get(gw, "/public"); rec.Body.String() != "v2" {
+		t.Fatalf("expected reloaded upstream, got %q", rec.Body.String())
+	}
+	if rec := get(gw, "/extra"); rec.Code != http.StatusOK {
+		t.Fatalf("expected new route to be served, got %d", rec.Code)
+	}
+	if gw.Current().KeycloakClient != before.KeycloakClient {
+		t.Fatal("expected auth client (and its cache) to survive a reload with unchanged auth settings")
+	}
+}
+
+func TestGatewayReloadDropsTransportsWithChangedOptions(t *testing.T) {
+	backend := newBackend(t, "ok")
+	gw, path := newTestGateway(t, gatewayConfig(backend.URL, ""))
+	if got := gw.pool.Len(); got != 1 {
+		t.Fatalf("expected one transport for the shared upstream, got %d", got)
+	}
+
+	withTimeouts := strings.ReplaceAll(gatewayConfig(backend.URL, ""), `upstream: "`+backend.URL+`"`,
+		`upstream: "`+backend.URL+`"
+    timeouts:
+      response_header: 5s`)
+	writeConfigFile(t, path, withTimeouts)
+	if err := gw.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := gw.pool.Len(); got != 1 {
+		t.Fatalf("expected the transport with the old timeouts to be dropped, got %d transports", got)
+	}
+	if rec := get(gw, "/public"); rec.Body.String() != "ok" {
+		t.Fatalf("expected the route to be served after reload, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGatewayReloadRejectsInvalidConfig(t *testing.T) {
+	backend := newBackend(t, "v1")
+	gw, path := newTestGateway(t, gatewayConfig(backend.URL, ""))
+	before := gw.Current()
+
+	writeConfigFile(t, path, strings.Replace(gatewayConfig(backend.URL, ""), `"^/public(/.*)?$"`, `"^/public(["`, 1))
+	if err := gw.Reload(); err == nil {
+		t.Fatal("expected reload of invalid config to fail")
+	}
+
+	if gw.Current() != before {
+		t.Fatal("expected last good snapshot to keep serving")
+	}
+	if rec := get(gw, "/public"); rec.Code != http.StatusOK || rec.Body.String() != "v1" {
+		t.Fatalf("expected last good config to keep serving, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGatewayReloadReplacesAuthClientWhenAuthSettingsChange(t *testing.T) {
+	backend := newBackend(t, "v1")
+	gw, path := newTestGateway(t, gatewayConfig(backend.URL, ""))
+	before := gw.Current()
+
+	writeConfigFile(t, path, strings.Replace(gatewayConfig(backend.URL, ""), "ttl: 60s", "ttl: 30s", 1))
+	if err := gw.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if gw.Current().KeycloakClient == before.KeycloakClient {
+		t.Fatal("expected a new auth client after cache settings changed")
+	}
+}
+
+func TestGatewayInFlightRequestFinishesOnOldSnapshot(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("old"))
+	}))
+	defer slow.Close()
+	fresh := newBackend(t, "new")
+
+	gw, path := newTestGateway(t, gatewayConfig(slow.URL, ""))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- get(gw, "/public") }()
+	<-started
+
+	writeConfigFile(t, path, gatewayConfig(fresh.URL, ""))
+	if err := gw.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	close(release)
+
+	if rec := <-done; rec.Code != http.StatusOK || rec.Body.String() != "old" {
+		t.Fatalf("expected in-flight request to complete against old snapshot, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := get(gw, "/public"); rec.Body.String() != "new" {
+		t.Fatalf("expected new requests to use reloaded config, got %q", rec.Body.String())
+	}
+}
+
+func TestSnapshotSelectsAuthMiddlewarePerRouteMode(t *testing.T) {
+	backend := newBackend(t, "v1")
+	gw, _ := newTestGateway(t, strings.Replace(gatewayConfig(backend.URL, `
+  - name: "jwks"
+    path_pattern: "^/jwks$"
+    upstream: "`+backend.URL+`"
+    auth_mode: "jwks"
+    rules:
+      - methods: ["GET"]
+`), "  timeout: 5s", "  timeout: 5s\n  jwks:\n    url: \"http://127.0.0.1:1/certs\"\n    issuer: \"http://keycloak/realms/test\"", 1))
+	snapshot := gw.Current()
+
+	jwksRoute, _, _ := snapshot.Router.MatchRoute(httptest.NewRequest("GET", "/jwks", nil))
+	privateRoute, _, _ := snapshot.Router.MatchRoute(httptest.NewRequest("GET", "/private", nil))
+	if snapshot.authMiddleware(jwksRoute) != snapshot.authMWs[config.AuthModeJWKS] {
+		t.Fatal("expected jwks route to use the JWKS auth middleware")
+	}
+	if snapshot.authMiddleware(privateRoute) != snapshot.authMWs[config.AuthModeIntrospection] {
+		t.Fatal("expected default route to use the introspection auth middleware")
+	}
+
+	req := httptest.NewRequest("GET", "/jwks", nil)
+	req.Header.Set("Authorization", "Bearer opaque-token")
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected opaque token to be rejected in jwks mode, got %d", rec.Code)
+	}
+}
+
+func TestAdminHandlerReportsUpstreamHealth(t *testing.T) {
+	backend := newBackend(t, "v1")
+	gw, _ := newTestGateway(t, gatewayConfig(backend.URL, ""))
+
+	rec := httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/upstreams", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var statuses []proxy.RouteStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(statuses) != 2 || statuses[0].Route != "public" || len(statuses[0].Targets) != 1 || !statuses[0].Targets[0].Healthy {
+		t.Fatalf("unexpected upstream status: %+v", statuses)
+	}
+}
+
+func TestAdminHandlerReportsCacheStats(t *testing.T) {
+	backend := newBackend(t, "v1")
+	gw, _ := newTestGateway(t, gatewayConfig(backend.URL, ""))
+
+	rec := httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/cache", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var stats auth.CacheStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if stats.Entries != 0 || stats.Hits != 0 {
+		t.Fatalf("expected an empty cache, got %+v", stats)
+	}
+}
+
+func TestAdminHandlerServesPrometheusMetrics(t *testing.T) {
+	backend := newBackend(t, "v1")
+	gw, _ := newTestGateway(t, gatewayConfig(backend.URL, `
+  - name: "metered"
+    path_pattern: "^/metered$"
+    upstream: "`+backend.URL+`"
+    rules:
+      - methods: ["GET"]
+        require_auth: false
+`))
+	get(gw, "/metered")
+	get(gw, "/metered")
+	get(gw, "/nowhere")
+
+	rec := httptest.NewRecorder()
+	gw.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`gateway_requests_total{route="metered",method="GET",status="2xx"} 2`,
+		`gateway_request_duration_seconds_count{route="metered",method="GET",status="2xx"} 2`,
+		`gateway_requests_total{route="unmatched",method="GET",status="4xx"}`,
+		"# TYPE gateway_requests_in_flight gauge",
+		"# TYPE gateway_introspection_duration_seconds histogram",
+		"# TYPE gateway_token_cache_requests_total counter",
+		"# TYPE gateway_rbac_denials_total counter",
+		"# TYPE gateway_upstream_errors_total counter",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestGatewayTracesRequestsIntoUpstream(t *testing.T) {
+	collector := tracingtest.NewCollector()
+	defer collector.Close()
+	var upstreamTraceparent atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent.Store(r.Header.Get("traceparent"))
+	}))
+	defer backend.Close()
+
+	cfg := strings.Replace(gatewayConfig(backend.URL, ""), "routes:",
+		"tracing:\n  enabled: true\n  endpoint: \""+collector.URL+"\"\nroutes:", 1)
+	gw, _ := newTestGateway(t, cfg)
+
+	req := httptest.NewRequest("GET", "/public", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	gw.ServeHTTP(httptest.NewRecorder(), req)
+	gw.Close() // flushes spans
+
+	server, ok := collector.Span("GET public")
+	if !ok {
+		t.Fatalf("expected server span, got %+v", collector.Spans())
+	}
+	if server.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID != "00f067aa0ba902b7" {
+		t.Fatalf("expected server span to continue the caller's trace, got %+v", server)
+	}
+	for _, name := range []string{"router.match", "proxy.upstream"} {
+		span, ok := collector.Span(name)
+		if !ok || span.TraceID != server.TraceID || span.ParentSpanID != server.SpanID {
+			t.Fatalf("expected %s span under the server span, got %+v", name, span)
+		}
+	}
+
+	upstream, _ := collector.Span("proxy.upstream")
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + upstream.SpanID + "-01"
+	if got := upstreamTraceparent.Load(); got != want {
+		t.Fatalf("expected upstream traceparent %s, got %v", want, got)
+	}
+}
+
+func TestGatewayRateLimitSurvivesReload(t *testing.T) {
+	backend := newBackend(t, "v1")
+	limited := gatewayConfig(backend.URL, `
+  - name: "limited"
+    path_pattern: "^/limited$"
+    upstream: "`+backend.URL+`"
+    rate_limit:
+      requests: 1
+      window: 1m
+    rules:
+      - methods: ["GET"]
+        require_auth: false
+`)
+	gw, path := newTestGateway(t, limited)
+
+	if rec := get(gw, "/limited"); rec.Code != http.StatusOK {
+		t.Fatalf("expected first request to pass, got %d", rec.Code)
+	}
+	if rec := get(gw, "/limited"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d %v", rec.Code, rec.Header())
+	}
+
+	writeConfigFile(t, path, limited+`  - name: "extra"
+    path_pattern: "^/extra$"
+    upstream: "`+backend.URL+`"
+    rules:
+      - methods: ["GET"]
+        require_auth: false
+`)
+	if err := gw.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if rec := get(gw, "/limited"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected rate limit state to survive reload, got %d", rec.Code)
+	}
+}
+
+func writeSigningKey(t *testing.T, path string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+}
+
+func TestGatewayServesJWKSAndRotatesKeysOnReload(t *testing.T) {
+	var authorization atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization.Store(r.Header.Get("Authorization"))
+	}))
+	t.Cleanup(backend.Close)
+
+	keyPath := filepath.Join(t.TempDir(), "signing.pem")
+	writeSigningKey(t, keyPath)
+	content := strings.Replace(gatewayConfig(backend.URL, ""), "routes:",
+		"upstream_token:\n  enabled: true\n  keys:\n    - file: "+keyPath+"\nroutes:", 1)
+	gw, path := newTestGateway(t, content)
+
+	jwks := func() string {
+		rec := get(gw, "/.well-known/jwks.json")
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("expected JWKS, got %d %q", rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+	before := jwks()
+	if !strings.Contains(before, `"kty":"EC"`) {
+		t.Fatalf("unexpected JWKS: %s", before)
+	}
+
+	// Public rules have no validated caller, so the client's Authorization is dropped
+	req := httptest.NewRequest("GET", "/public", nil)
+	req.Header.Set("Authorization", "Bearer forged")
+	gw.ServeHTTP(httptest.NewRecorder(), req)
+	if got := authorization.Load(); got != "" {
+		t.Fatalf("expected Authorization to be stripped upstream, got %q", got)
+	}
+
+	// Replacing the key file and reloading publishes the new key
+	writeSigningKey(t, keyPath)
+	writeConfigFile(t, path, content)
+	if err := gw.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if after := jwks(); after == before {
+		t.Fatal("expected reload to pick up the rotated key")
+	}
+}
+
+func TestWatchFileFiresOnContentChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, "a: 1\n")
+
+	changed := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go WatchFile(ctx, path, 10*time.Millisecond, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	// Touching the file without changing it must not fire
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	select {
+	case <-changed:
+		t.Fatal("expected no reload when contents are unchanged")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	writeConfigFile(t, path, "a: 2\n")
+	later := future.Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected change callback after file contents changed")
+	}
+}
+
+// newEchoBackend accepts protocol upgrades and echoes everything the client sends
+func newEchoBackend(t *testing.T, handshake func(r *http.Request)) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handshake(r)
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("backend hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		brw.Flush()
+		io.Copy(conn, brw)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestGatewayProxiesWebSocketWithQueryToken(t *testing.T) {
+	introspection := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		json.NewEncoder(w).Encode(map[string]interface{}{"active": r.PostForm.Get("token") == "good-token", "username": "alice"})
+	}))
+	defer introspection.Close()
+
+	var handshake atomic.Value
+	backend := newEchoBackend(t, func(r *http.Request) {
+		handshake.Store(r.Header.Get("Authorization") + " " + r.URL.RawQuery)
+	})
+	cfg := gatewayConfig(backend.URL, `
+  - name: "ws"
+    path_pattern: "^/ws(/.*)?$"
+    upstream: "`+backend.URL+`"
+    websocket:
+      token_query_param: "access_token"
+    rules:
+      - methods: ["GET"]
+`)
+	cfg = strings.Replace(cfg, "http://keycloak/introspect", introspection.URL, 1)
+	gw, _ := newTestGateway(t, cfg)
+	server := httptest.NewServer(gw)
+	defer server.Close()
+
+	handshakeTo := func(query string) (net.Conn, *bufio.Reader, *http.Response) {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		fmt.Fprintf(conn, "GET /ws/chat?%s HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n", query)
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("read handshake response: %v", err)
+		}
+		return conn, br, resp
+	}
+
+	conn, _, resp := handshakeTo("room=1&access_token=bad-token")
+	conn.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected an invalid token to be rejected at handshake, got %d", resp.StatusCode)
+	}
+
+	conn, br, resp := handshakeTo("room=1&access_token=good-token")
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if got := handshake.Load(); got != "Bearer good-token room=1" {
+		t.Fatalf("expected the token to move from the query into Authorization, got %q", got)
+	}
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("ping"))
+	echo := make([]byte, 4)
+	if _, err := io.ReadFull(br, echo); err != nil || string(echo) != "ping" {
+		t.Fatalf("expected the upgraded connection to echo, got %q (%v)", echo, err)
+	}
+}
+
+func TestGatewayAppliesRouteAuditPolicy(t *testing.T) {
+	backend := newBackend(t, "ok")
+	gw, _ := newTestGateway(t, gatewayConfig(backend.URL, `  - name: "quiet"
+    path_pattern: "^/quiet(/.*)?$"
+    upstream: "`+backend.URL+`"
+    audit:
+      skip_methods: ["GET"]
+    rules:
+      - methods: ["GET"]
+        require_auth: false
+`))
+	var out bytes.Buffer
+	handler := middleware.NewAuditMiddleware().WithOutput(&out).Handler(gw)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/quiet/x", nil))
+	if out.Len() != 0 {
+		t.Fatalf("expected the route's audit block to skip GET, got %s", out.String())
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/public/x", nil))
+	if !strings.Contains(out.String(), `"path":"/public/x"`) {
+		t.Fatalf("expected other routes to be logged, got %q", out.String())
+	}
+}
+
+func TestGatewayMatchesPathTemplatesAndLogsParams(t *testing.T) {
+	backend := newBackend(t, "ok")
+	gw, _ := newTestGateway(t, gatewayConfig(backend.URL, `  - name: "orders"
+    path: "/shop/{shop}/orders/{id}"
+    upstream: "`+backend.URL+`"
+    rules:
+      - methods: ["GET"]
+        require_auth: false
+`))
+	var out bytes.Buffer
+	handler := middleware.NewAuditMiddleware().WithOutput(&out).Handler(gw)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/shop/north/orders/42", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the template route to serve the request, got %d", rec.Code)
+	}
+	if !strings.Contains(out.String(), `"pathParams":{"id":"42","shop":"north"}`) {
+		t.Fatalf("expected the path parameters in the audit log, got %s", out.String())
+	}
+}
+
+func TestGatewayRoutesSamePathToUpstreamsByHost(t *testing.T) {
+	eu := newBackend(t, "eu")
+	us := newBackend(t, "us")
+	gw, _ := newTestGateway(t, gatewayConfig(us.URL, `  - name: "catalog-eu"
+    path: "/catalog"
+    upstream: "`+eu.URL+`"
+    match:
+      hosts: ["*.eu.example.com"]
+    rules:
+      - methods: ["GET"]
+        require_auth: false
+  - name: "catalog"
+    path: "/catalog"
+    upstream: "`+us.URL+`"
+    rules:
+      - methods: ["GET"]
+        require_auth: false
+`))
+
+	for host, want := range map[string]string{"shop.eu.example.com": "eu", "shop.example.com": "us"} {
+		req := httptest.NewRequest("GET", "/catalog", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, req)
+		if rec.Body.String() != want {
+			t.Errorf("%s: expected the %s upstream, got %d %q", host, want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestGatewayAnswersCORSPreflightBeforeAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(backend.Close)
+	cors := `cors:
+  allowed_origins: ["https://*.example.com"]
+  allow_credentials: true
+  exposed_headers: ["X-Request-Id"]
+  max_age: 10m
+`
+	gw, _ := newTestGateway(t, strings.Replace(gatewayConfig(backend.URL, ""), "routes:", cors+"routes:", 1))
+
+	req := httptest.NewRequest("OPTIONS", "/private/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "authorization")
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	h := rec.Header()
+	if rec.Code != http.StatusNoContent || h.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		h.Get("Access-Control-Allow-Methods") != "GET" || h.Get("Access-Control-Allow-Headers") != "Authorization" ||
+		h.Get("Access-Control-Allow-Credentials") != "true" || h.Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("expected the gateway to answer the preflight of a protected route, got %d %v", rec.Code, h)
+	}
+
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected a preflight for a method no rule allows to fall through to routing, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/private/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Fatalf("expected auth errors to carry CORS headers, got %d %v", rec.Code, rec.Header())
+	}
+
+	req = httptest.NewRequest("GET", "/public/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	if got := rec.Header().Values("Access-Control-Allow-Origin"); len(got) != 1 || got[0] != "https://app.example.com" {
+		t.Fatalf("expected the upstream's CORS headers to be replaced, got %v", got)
+	}
+	if rec.Header().Get("Access-Control-Expose-Headers") != "X-Request-Id" || rec.Body.String() != "ok" {
+		t.Fatalf("unexpected response %v %q", rec.Header(), rec.Body.String())
+	}
+}
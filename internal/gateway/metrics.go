@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+)
+
+// Route labels for requests not served by a configured route
+const (
+	unmatchedRoute = "unmatched"    // matched no route
+	jwksRoute      = "gateway_jwks" // the gateway's own upstream token JWKS
+)
+
+// statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.status = http.StatusOK
+		r.wroteHeader = true
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses working through the recorder
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack records the protocol switch and hands the connection to the upgrade. The
+// server's read and write deadlines bound requests, not upgraded connections, so
+// they are cleared.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	r.status = http.StatusSwitchingProtocols
+	r.wroteHeader = true
+	conn.SetDeadline(time.Time{})
+	return conn, brw, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument records request count, latency and in-flight requests around serve,
+// which returns the name of the route that handled the request. It returns the
+// response status.
+func instrument(w http.ResponseWriter, r *http.Request, serve func(http.ResponseWriter, *http.Request) string) int {
+	inFlight := metrics.InFlightRequests.WithLabelValues()
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	route := serve(rec, r)
+
+	labels := []string{route, metrics.Method(r.Method), metrics.StatusClass(rec.status)}
+	metrics.Requests.WithLabelValues(labels...).Inc()
+	metrics.RequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	return rec.status
+}
@@ -0,0 +1,62 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// DefaultWatchInterval is used when reload.interval is not configured
+const DefaultWatchInterval = 5 * time.Second
+
+// WatchFile polls path every interval and calls onChange whenever its contents change.
+// Polling (rather than inotify) keeps this stdlib-only and copes with editors and
+// Kubernetes ConfigMaps that replace the file instead of writing it in place.
+// It blocks until ctx is cancelled.
+func WatchFile(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	lastStat, lastSum := fileState(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stat, _ := os.Stat(path)
+			if stat == nil || (lastStat != nil && stat.ModTime().Equal(lastStat.ModTime()) && stat.Size() == lastStat.Size()) {
+				continue
+			}
+
+			// Metadata changed; only fire when the contents really differ
+			_, sum := fileState(path)
+			lastStat = stat
+			if sum == nil || bytes.Equal(sum, lastSum) {
+				continue
+			}
+			lastSum = sum
+			onChange()
+		}
+	}
+}
+
+// fileState returns the file's metadata and a checksum of its contents
+func fileState(path string) (os.FileInfo, []byte) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return stat, nil
+	}
+	sum := sha256.Sum256(data)
+	return stat, sum[:]
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Gateway metrics, registered in Default
+var (
+	Requests = NewCounterVec("gateway_requests_total",
+		"Requests handled by the gateway.", "route", "method", "status")
+	RequestDuration = NewHistogramVec("gateway_request_duration_seconds",
+		"Time from receiving a request to finishing its response.", DefBuckets, "route", "method", "status")
+	InFlightRequests = NewGaugeVec("gateway_requests_in_flight",
+		"Requests currently being served.")
+
+	UpstreamErrors = NewCounterVec("gateway_upstream_errors_total",
+		"Requests that could not be proxied to an upstream.", "route", "reason")
+	UpstreamRetries = NewCounterVec("gateway_upstream_retries_total",
+		"Upstream attempts retried by reason (connection_error, status).", "route", "reason")
+	RetryBudgetExhausted = NewCounterVec("gateway_retry_budget_exhausted_total",
+		"Retries skipped because the route's retry budget was spent.", "route")
+	CircuitBreakerState = NewGaugeVec("gateway_circuit_breaker_state",
+		"Circuit breaker state per upstream target (0 closed, 1 half-open, 2 open).", "route", "upstream")
+	CircuitBreakerTransitions = NewCounterVec("gateway_circuit_breaker_transitions_total",
+		"Circuit breaker state changes by the state entered (closed, half_open, open).", "route", "upstream", "state")
+
+	IntrospectionDuration = NewHistogramVec("gateway_introspection_duration_seconds",
+		"Latency of token introspection calls to Keycloak by outcome (active, inactive, error).", DefBuckets, "outcome")
+
+	TokenCacheRequests = NewCounterVec("gateway_token_cache_requests_total",
+		"Token cache lookups by result (hit, negative_hit, stale_hit, miss).", "result")
+	TokenCacheRemovals = NewCounterVec("gateway_token_cache_removals_total",
+		"Entries removed from the token cache by reason (evicted, expired).", "reason")
+	TokenCacheEntries = NewGaugeVec("gateway_token_cache_entries",
+		"Entries currently held in token caches.")
+
+	RBACDenials = NewCounterVec("gateway_rbac_denials_total",
+		"Requests rejected because the caller lacked the required roles.", "route")
+	RateLimited = NewCounterVec("gateway_rate_limited_total",
+		"Requests rejected with 429 by a rate limit.", "route")
+
+	AuditEntriesDropped = NewCounterVec("gateway_audit_entries_dropped_total",
+		"Audit log entries lost by reason (queue_full, sink_error, shutdown).", "reason")
+)
+
+func init() {
+	Default.MustRegister(
+		Requests, RequestDuration, InFlightRequests,
+		UpstreamErrors, UpstreamRetries, RetryBudgetExhausted,
+		CircuitBreakerState, CircuitBreakerTransitions,
+		IntrospectionDuration,
+		TokenCacheRequests, TokenCacheRemovals, TokenCacheEntries,
+		RBACDenials, RateLimited,
+		AuditEntriesDropped,
+	)
+}
+
+// StatusClass maps a status code to its Prometheus-friendly class label, e.g. "2xx"
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// Method returns the method label for a request, collapsing non-standard
+// methods so clients cannot inflate label cardinality
+func Method(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(r *Registry) string {
+	var b strings.Builder
+	r.WriteText(&b)
+	return b.String()
+}
+
+func TestCounterVecWritesTextFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := NewCounterVec("test_requests_total", "Requests.", "route", "status")
+	r.MustRegister(requests)
+
+	requests.WithLabelValues("users", "2xx").Inc()
+	requests.WithLabelValues("users", "2xx").Add(2)
+	requests.WithLabelValues("orders", "5xx").Inc()
+
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="orders",status="5xx"} 1
+test_requests_total{route="users",status="2xx"} 3
+`
+	if got := render(r); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGaugeWithoutLabels(t *testing.T) {
+	r := NewRegistry()
+	inFlight := NewGaugeVec("test_in_flight", "In flight.")
+	r.MustRegister(inFlight)
+
+	inFlight.WithLabelValues().Inc()
+	inFlight.WithLabelValues().Inc()
+	inFlight.WithLabelValues().Dec()
+
+	if got := render(r); !strings.Contains(got, "# TYPE test_in_flight gauge\ntest_in_flight 1\n") {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
+
+func TestHistogramWritesCumulativeBuckets(t *testing.T) {
+	r := NewRegistry()
+	latency := NewHistogramVec("test_seconds", "Latency.", []float64{0.1, 1}, "route")
+	r.MustRegister(latency)
+
+	h := latency.WithLabelValues("users")
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	want := `# HELP test_seconds Latency.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="users",le="0.1"} 2
+test_seconds_bucket{route="users",le="1"} 3
+test_seconds_bucket{route="users",le="+Inf"} 4
+test_seconds_sum{route="users"} 3.65
+test_seconds_count{route="users"} 4
+`
+	if got := render(r); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelValuesAreEscaped(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounterVec("test_total", "Help with \\ and\nnewline.", "route")
+	r.MustRegister(c)
+	c.WithLabelValues("a\"b\\c\nd").Inc()
+
+	got := render(r)
+	if !strings.Contains(got, `# HELP test_total Help with \\ and\nnewline.`) {
+		t.Fatalf("expected escaped help, got:\n%s", got)
+	}
+	if !strings.Contains(got, `test_total{route="a\"b\\c\nd"} 1`) {
+		t.Fatalf("expected escaped label value, got:\n%s", got)
+	}
+}
+
+func TestRegisterRejectsDuplicateNames(t *testing.T) {
+	r := NewRegistry()
+	r.MustRegister(NewCounterVec("dup_total", "first"))
+	if err := r.Register(NewGaugeVec("dup_total", "second")); err == nil {
+		t.Fatal("expected duplicate registration to fail")
+	}
+}
+
+func TestHandlerServesTextFormat(t *testing.T) {
+	r := NewRegistry()
+	r.MustRegister(NewCounterVec("test_total", "Test."))
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+}
+
+func TestStatusClassAndMethodLabels(t *testing.T) {
+	if StatusClass(204) != "2xx" || StatusClass(503) != "5xx" || StatusClass(42) != "unknown" {
+		t.Fatal("unexpected status classes")
+	}
+	if Method("GET") != "GET" || Method("BREW") != "OTHER" {
+		t.Fatal("unexpected method labels")
+	}
+}
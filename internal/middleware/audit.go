@@ -1,39 +1,41 @@
 package middleware
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"os"
 	"strings"
+	"sync/atomic"
 	"time"
-)
-
-// Skip logging for certain paths (health checks, static files, etc.)
-var skipPaths = []string{
-	"/health",
-	"/ping",
-	"/favicon.ico",
-	"/audit-logs", // Prevent infinite logging loops
-}
 
-// Skip logging for certain methods
-var skipMethods = []string{"OPTIONS"}
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
 
-// responseWriter wraps http.ResponseWriter to capture response data
+// responseWriter wraps http.ResponseWriter to capture response data. It streams the
+// response through, keeps only a prefix of the body and preserves http.Flusher,
+// http.Hijacker and io.ReaderFrom of the underlying writer.
 type responseWriter struct {
 	http.ResponseWriter
-	statusCode int
-	body        *bytes.Buffer
-	headerWritten bool
+	statusCode       int
+	body             *bodyCapture
+	headerWritten    bool
+	skipContentTypes []string
+
+	// set once a protocol upgrade takes over the connection
+	upgraded   *countingConn
+	hijackedAt time.Time
 }
 
-func newResponseWriter(w http.ResponseWriter) *responseWriter {
+func newResponseWriter(w http.ResponseWriter, cfg config.AuditConfig) *responseWriter {
 	return &responseWriter{
-		ResponseWriter: w,
-		statusCode:     http.StatusOK, // Default status code
-		body:           &bytes.Buffer{},
+		ResponseWriter:   w,
+		statusCode:       http.StatusOK, // Default status code
+		body:             newBodyCapture(cfg.MaxBodyBytes, false),
+		skipContentTypes: cfg.SkipContentTypes,
 	}
 }
 
@@ -45,6 +47,7 @@ func (rw *responseWriter) WriteHeader(code int) {
 	if !rw.headerWritten {
 		rw.statusCode = code
 		rw.headerWritten = true
+		rw.body.skip = matchesContentType(rw.Header().Get("Content-Type"), rw.skipContentTypes)
 		rw.ResponseWriter.WriteHeader(code)
 	}
 }
@@ -53,40 +56,145 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	if !rw.headerWritten {
 		rw.WriteHeader(http.StatusOK)
 	}
-	rw.body.Write(b)
-	return rw.ResponseWriter.Write(b)
+	n, err := rw.ResponseWriter.Write(b)
+	rw.body.Write(b[:n])
+	return n, err
+}
+
+// Flush sends buffered data to the client so streamed responses (SSE, chunked) stay live
+func (rw *responseWriter) Flush() {
+	if !rw.headerWritten {
+		rw.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// ReadFrom captures the body prefix, then hands the rest to the underlying writer's
+// ReaderFrom so large copies keep their fast path
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !rw.headerWritten {
+		rw.WriteHeader(http.StatusOK)
+	}
+	var written int64
+	if room := rw.body.room(); room > 0 {
+		n, err := io.CopyN(writerOnly{rw}, src, room)
+		written += n
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return written, err
+		}
+	}
+	n, err := readFrom(rw.ResponseWriter, src)
+	rw.body.count(n)
+	return written + n, err
+}
+
+// Hijack hands the connection to a protocol upgrade. Traffic on the upgraded
+// connection is counted instead of captured.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	rw.statusCode = http.StatusSwitchingProtocols
+	rw.headerWritten = true
+	rw.upgraded = &countingConn{Conn: conn}
+	rw.hijackedAt = time.Now()
+	return rw.upgraded, brw, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// countingConn counts the bytes read from and written to an upgraded connection
+type countingConn struct {
+	net.Conn
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// CloseWrite half-closes the connection when the underlying connection supports it,
+// so the proxy can pass on the upstream closing its side
+func (c *countingConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// UpgradeAuditInfo describes a connection that switched protocols, e.g. to WebSocket
+type UpgradeAuditInfo struct {
+	Protocol        string `json:"protocol"`
+	Duration        int64  `json:"duration"`        // milliseconds the upgraded connection was open
+	BytesFromClient int64  `json:"bytesFromClient"` // after the handshake
+	BytesToClient   int64  `json:"bytesToClient"`   // after the handshake
 }
 
 // AuditLogEntry represents the audit log structure
 type AuditLogEntry struct {
-	Type           string                 `json:"type"`
-	Timestamp      string                 `json:"timestamp"`
-	Method         string                 `json:"method"`
-	URL            string                 `json:"url"`
-	Path           string                 `json:"path"`
-	Query          map[string][]string    `json:"query"`
-	Headers        map[string]string      `json:"headers"`
-	Body           interface{}            `json:"body"`
-	UserAgent      string                 `json:"userAgent"`
-	IPAddress      string                 `json:"ipAddress"`
-	UserID         *string                `json:"userId"`
-	OrganizationID *string                `json:"organizationId"`
-	UserName       *string                `json:"userName"`
-	Roles          []string               `json:"roles"`
-	UserEmail      *string                `json:"userEmail"`
-	ResponseStatus int                    `json:"responseStatus"`
-	ResponseTime   int64                  `json:"responseTime"`
-	RequestSize    int64                  `json:"requestSize"`
-	ResponseSize   int64                  `json:"responseSize"`
-	Error          *string                `json:"error"`
+	Seq            uint64              `json:"seq,omitempty"`      // set by the audit chain when enabled
+	PrevHash       string              `json:"prevHash,omitempty"` // set by the audit chain when enabled
+	Type           string              `json:"type"`
+	Timestamp      string              `json:"timestamp"`
+	Method         string              `json:"method"`
+	URL            string              `json:"url"`
+	Path           string              `json:"path"`
+	PathParams     map[string]string   `json:"pathParams,omitempty"` // captured by the matched route
+	Query          map[string][]string `json:"query"`
+	Headers        map[string]string   `json:"headers"`
+	Body           interface{}         `json:"body"`
+	ResponseBody   interface{}         `json:"responseBody,omitempty"` // only with capture_response_body
+	UserAgent      string              `json:"userAgent"`
+	IPAddress      string              `json:"ipAddress"`
+	UserID         *string             `json:"userId"`
+	OrganizationID *string             `json:"organizationId"`
+	UserName       *string             `json:"userName"`
+	Roles          []string            `json:"roles"`
+	UserEmail      *string             `json:"userEmail"`
+	ResponseStatus int                 `json:"responseStatus"`
+	ResponseTime   int64               `json:"responseTime"`
+	RequestSize    int64               `json:"requestSize"`
+	ResponseSize   int64               `json:"responseSize"`
+	Error          *string             `json:"error"`
+	Upgrade        *UpgradeAuditInfo   `json:"upgrade,omitempty"`
 }
 
 // AuditMiddleware handles audit logging for all requests
-type AuditMiddleware struct{}
+type AuditMiddleware struct {
+	config config.AuditConfig
+	out    io.Writer
+}
 
-// NewAuditMiddleware creates a new audit logging middleware
+// NewAuditMiddleware creates a new audit logging middleware with default settings
 func NewAuditMiddleware() *AuditMiddleware {
-	return &AuditMiddleware{}
+	return NewAuditMiddlewareWithConfig(config.DefaultAuditConfig())
+}
+
+// NewAuditMiddlewareWithConfig creates an audit logging middleware from a validated audit section
+func NewAuditMiddlewareWithConfig(cfg config.AuditConfig) *AuditMiddleware {
+	return &AuditMiddleware{config: cfg, out: os.Stdout}
+}
+
+// WithOutput sends entries to out instead of stdout, one JSON document per Write
+func (m *AuditMiddleware) WithOutput(out io.Writer) *AuditMiddleware {
+	m.out = out
+	return m
 }
 
 // Handler returns an HTTP handler that logs all requests and responses
@@ -95,48 +203,32 @@ func (m *AuditMiddleware) Handler(next http.Handler) http.Handler {
 		startTime := time.Now()
 
 		// Skip logging for certain paths and methods
-		if shouldSkipLogging(r) {
+		policy := auditPolicy{deployment: &m.config.AuditPolicy}
+		if policy.skip(r) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Capture request body
-		var requestBody interface{}
-		var requestSize int64
-		if r.Body != nil {
-			bodyBytes, err := io.ReadAll(r.Body)
-			if err == nil {
-				requestSize = int64(len(bodyBytes))
-				// Restore body for downstream handlers
-				r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
-				// Try to parse as JSON, otherwise keep as string
-				var jsonBody interface{}
-				if err := json.Unmarshal(bodyBytes, &jsonBody); err == nil {
-					requestBody = sanitizeBody(jsonBody)
-				} else if len(bodyBytes) > 0 {
-					// If not JSON, store as string (truncated if too long)
-					bodyStr := string(bodyBytes)
-					if len(bodyStr) > 1000 {
-						bodyStr = bodyStr[:1000] + "..."
-					}
-					requestBody = bodyStr
-				}
-			}
+		// Capture a prefix of the request body as downstream handlers read it; an
+		// upgrade request's body would be the upgraded stream
+		var requestCapture *bodyCapture
+		if r.Body != nil && r.Body != http.NoBody && !isUpgrade(r) {
+			skip := matchesContentType(r.Header.Get("Content-Type"), m.config.SkipContentTypes)
+			requestCapture = newBodyCapture(m.config.MaxBodyBytes, skip)
+			r.Body = &captureReader{ReadCloser: r.Body, capture: requestCapture}
 		}
 
+		// The request is logged as it arrived; handlers may change headers in place
+		requestURL := *r.URL
+		requestHeaders := r.Header.Clone()
+
 		// Extract request data
 		requestData := AuditLogEntry{
-			Timestamp:   startTime.UTC().Format(time.RFC3339),
-			Method:      r.Method,
-			URL:         r.URL.String(),
-			Path:        r.URL.Path,
-			Query:       r.URL.Query(),
-			Headers:     sanitizeHeaders(r.Header),
-			Body:        requestBody,
-			UserAgent:   r.UserAgent(),
-			IPAddress:   getClientIP(r),
-			RequestSize: requestSize,
+			Timestamp: startTime.UTC().Format(time.RFC3339),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			UserAgent: r.UserAgent(),
+			IPAddress: ClientIP(r),
 		}
 
 		// Extract user information from token claims if available
@@ -153,17 +245,41 @@ func (m *AuditMiddleware) Handler(next http.Handler) http.Handler {
 		}
 
 		// Wrap response writer to capture response
-		rw := newResponseWriter(w)
+		rw := newResponseWriter(w, m.config)
 
-		// Call next handler
+		// Call next handler; the gateway reports the matched route's audit policy
+		r, scope := withAuditScope(r)
 		next.ServeHTTP(rw, r)
+		policy.route = scope.route
+		if scope.route != nil && policy.skip(r) {
+			return
+		}
+
+		// Sanitize what was captured according to the route's policy
+		requestData.URL = policy.sanitizeURL(&requestURL)
+		if policy.captureQuery() {
+			requestData.Query = policy.sanitizeQuery(requestURL.Query())
+		}
+		requestData.Headers = policy.sanitizeHeaders(requestHeaders)
+		requestData.PathParams = policy.sanitizePathParams(scope.params)
 
 		// Calculate response time
 		endTime := time.Now()
 		responseTime := endTime.Sub(startTime).Milliseconds()
 
+		// The request body is complete once the handler returned, unless it was never read
+		if requestCapture != nil {
+			if policy.captureRequestBody() {
+				requestData.Body = requestCapture.logged(policy)
+			}
+			requestData.RequestSize = max(requestCapture.size(), r.ContentLength)
+		}
+		if policy.captureResponseBody() && rw.upgraded == nil {
+			requestData.ResponseBody = rw.body.logged(policy)
+		}
+
 		// Capture response data
-		responseSize := int64(rw.body.Len())
+		responseSize := rw.body.size()
 		if contentLength := rw.Header().Get("Content-Length"); contentLength != "" {
 			if size, err := parseInt64(contentLength); err == nil {
 				responseSize = size
@@ -176,6 +292,14 @@ func (m *AuditMiddleware) Handler(next http.Handler) http.Handler {
 		auditData.ResponseStatus = rw.statusCode
 		auditData.ResponseTime = responseTime
 		auditData.ResponseSize = responseSize
+		if rw.upgraded != nil {
+			auditData.Upgrade = &UpgradeAuditInfo{
+				Protocol:        r.Header.Get("Upgrade"),
+				Duration:        endTime.Sub(rw.hijackedAt).Milliseconds(),
+				BytesFromClient: rw.upgraded.read.Load(),
+				BytesToClient:   rw.upgraded.written.Load(),
+			}
+		}
 
 		// Set error if status code indicates error
 		if rw.statusCode >= 400 {
@@ -204,96 +328,16 @@ func (m *AuditMiddleware) Handler(next http.Handler) http.Handler {
 		logJSON, err := json.Marshal(auditData)
 		if err != nil {
 			// Fallback: log error message if JSON marshaling fails
-			fmt.Printf(`{"type":"audit_log_error","error":"failed to marshal audit log: %s"}`+"\n", err.Error())
+			fmt.Fprintf(m.out, `{"type":"audit_log_error","error":"failed to marshal audit log: %s"}`+"\n", err.Error())
 		} else {
-			fmt.Println(string(logJSON))
+			fmt.Fprintln(m.out, string(logJSON))
 		}
 	})
 }
 
-// shouldSkipLogging checks if the request should be skipped
-func shouldSkipLogging(r *http.Request) bool {
-	path := r.URL.Path
-	method := r.Method
-
-	// Check skip paths
-	for _, skipPath := range skipPaths {
-		if strings.HasPrefix(path, skipPath) {
-			return true
-		}
-	}
-
-	// Check skip methods
-	for _, skipMethod := range skipMethods {
-		if method == skipMethod {
-			return true
-		}
-	}
-
-	return false
-}
-
-// sanitizeHeaders removes sensitive headers
-func sanitizeHeaders(headers http.Header) map[string]string {
-	sanitized := make(map[string]string)
-	sensitiveHeaders := map[string]bool{
-		"authorization": true,
-		"cookie":        true,
-		"x-api-key":     true,
-	}
-
-	for key, values := range headers {
-		lowerKey := strings.ToLower(key)
-		if !sensitiveHeaders[lowerKey] {
-			// Join multiple values with comma
-			sanitized[key] = strings.Join(values, ", ")
-		}
-	}
-
-	return sanitized
-}
-
-// sanitizeBody redacts sensitive fields from request/response body
-func sanitizeBody(body interface{}) interface{} {
-	if body == nil {
-		return nil
-	}
-
-	bodyMap, ok := body.(map[string]interface{})
-	if !ok {
-		return body
-	}
-
-	sanitized := make(map[string]interface{})
-	sensitiveFields := []string{"password", "token", "secret", "key", "auth"}
-
-	for key, value := range bodyMap {
-		lowerKey := strings.ToLower(key)
-		isSensitive := false
-		for _, sensitiveField := range sensitiveFields {
-			if strings.Contains(lowerKey, sensitiveField) {
-				isSensitive = true
-				break
-			}
-		}
-
-		if isSensitive {
-			sanitized[key] = "[REDACTED]"
-		} else {
-			// Recursively sanitize nested objects
-			if nestedMap, ok := value.(map[string]interface{}); ok {
-				sanitized[key] = sanitizeBody(nestedMap)
-			} else {
-				sanitized[key] = value
-			}
-		}
-	}
-
-	return sanitized
-}
-
-// getClientIP extracts the client IP address from the request
-func getClientIP(r *http.Request) string {
+// ClientIP extracts the client IP address from the request, as the audit log sees it.
+// It trusts X-Forwarded-For, so rate limits use RemoteIP instead.
+func ClientIP(r *http.Request) string {
 	// Check X-Forwarded-For header first
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
 		// X-Forwarded-For can contain multiple IPs, take the first one
@@ -1,13 +1,21 @@
 package middleware
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aveiga/cloud-api-gateway/internal/auth"
+	"github.com/aveiga/cloud-api-gateway/internal/config"
 )
 
 func TestAuditMiddlewareSkipsHealthPath(t *testing.T) {
@@ -28,21 +36,15 @@ func TestAuditMiddlewareSkipsHealthPath(t *testing.T) {
 	}
 }
 
-func TestAuditMiddlewareSkipsOPTIONSMethod(t *testing.T) {
-	mw := NewAuditMiddleware()
-
-	rec := httptest.NewRecorder()
-	nextCalled := false
-	handler := mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-		nextCalled = true
-		w.WriteHeader(http.StatusOK)
-	}))
+func TestAuditPolicyLogsOPTIONSByDefault(t *testing.T) {
+	defaults := config.DefaultAuditConfig()
+	policy := auditPolicy{deployment: &defaults.AuditPolicy}
 
 	req := httptest.NewRequest("OPTIONS", "/api/users", nil)
-	handler.ServeHTTP(rec, req)
-
-	if !nextCalled || rec.Code != http.StatusOK {
-		t.Fatalf("expected OPTIONS to pass through, status=%d nextCalled=%v", rec.Code, nextCalled)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	if policy.skip(req) {
+		t.Fatal("expected CORS preflights to be logged unless skip_methods lists OPTIONS")
 	}
 }
 
@@ -64,7 +66,9 @@ func TestAuditMiddlewareLogsNormalRequest(t *testing.T) {
 	}
 }
 
-func TestShouldSkipLoggingMatchesPathPrefix(t *testing.T) {
+func TestAuditPolicySkipsDefaultPathPrefixes(t *testing.T) {
+	defaults := config.DefaultAuditConfig()
+	policy := auditPolicy{deployment: &defaults.AuditPolicy}
 	tests := []struct {
 		path   string
 		expect bool
@@ -79,9 +83,9 @@ func TestShouldSkipLoggingMatchesPathPrefix(t *testing.T) {
 	}
 	for _, tt := range tests {
 		req := httptest.NewRequest("GET", tt.path, nil)
-		got := shouldSkipLogging(req)
+		got := policy.skip(req)
 		if got != tt.expect {
-			t.Errorf("shouldSkipLogging(%q) = %v, want %v", tt.path, got, tt.expect)
+			t.Errorf("skip(%q) = %v, want %v", tt.path, got, tt.expect)
 		}
 	}
 }
@@ -90,7 +94,7 @@ func TestSanitizeHeadersRemovesAuthorization(t *testing.T) {
 	h := http.Header{}
 	h.Set("Authorization", "Bearer secret")
 	h.Set("Content-Type", "application/json")
-	sanitized := sanitizeHeaders(h)
+	sanitized := auditPolicy{}.sanitizeHeaders(h)
 	if _, ok := sanitized["Authorization"]; ok {
 		t.Error("expected Authorization to be redacted")
 	}
@@ -104,7 +108,7 @@ func TestSanitizeBodyRedactsPassword(t *testing.T) {
 		"username": "user",
 		"password": "secret123",
 	}
-	sanitized := sanitizeBody(body).(map[string]interface{})
+	sanitized := auditPolicy{}.sanitizeBody(body).(map[string]interface{})
 	if sanitized["password"] != "[REDACTED]" {
 		t.Errorf("expected password redacted, got %v", sanitized["password"])
 	}
@@ -117,7 +121,7 @@ func TestGetClientIPUsesXForwardedFor(t *testing.T) {
 	req := httptest.NewRequest("GET", "/", nil)
 	req.Header.Set("X-Forwarded-For", "10.0.0.1, 10.0.0.2")
 	req.RemoteAddr = "192.168.1.1:12345"
-	got := getClientIP(req)
+	got := ClientIP(req)
 	if got != "10.0.0.1" {
 		t.Errorf("expected first X-Forwarded-For IP, got %s", got)
 	}
@@ -127,7 +131,7 @@ func TestGetClientIPUsesXRealIP(t *testing.T) {
 	req := httptest.NewRequest("GET", "/", nil)
 	req.Header.Set("X-Real-IP", "203.0.113.1")
 	req.RemoteAddr = "192.168.1.1:12345"
-	got := getClientIP(req)
+	got := ClientIP(req)
 	if got != "203.0.113.1" {
 		t.Errorf("expected X-Real-IP, got %s", got)
 	}
@@ -136,7 +140,7 @@ func TestGetClientIPUsesXRealIP(t *testing.T) {
 func TestGetClientIPFallsBackToRemoteAddr(t *testing.T) {
 	req := httptest.NewRequest("GET", "/", nil)
 	req.RemoteAddr = "192.168.1.1:12345"
-	got := getClientIP(req)
+	got := ClientIP(req)
 	if !strings.HasPrefix(got, "192.168.1.1") {
 		t.Errorf("expected RemoteAddr IP, got %s", got)
 	}
@@ -178,7 +182,7 @@ func TestSanitizeBodyHandlesNestedSensitiveFields(t *testing.T) {
 			"password": "secret",
 		},
 	}
-	sanitized := sanitizeBody(body).(map[string]interface{})
+	sanitized := auditPolicy{}.sanitizeBody(body).(map[string]interface{})
 	nested := sanitized["user"].(map[string]interface{})
 	if nested["password"] != "[REDACTED]" {
 		t.Errorf("expected nested password redacted, got %v", nested["password"])
@@ -188,7 +192,7 @@ func TestSanitizeBodyHandlesNestedSensitiveFields(t *testing.T) {
 func TestGetClientIPReturnsUnknownWhenEmpty(t *testing.T) {
 	req := httptest.NewRequest("GET", "/", nil)
 	req.RemoteAddr = ""
-	got := getClientIP(req)
+	got := ClientIP(req)
 	if got != "unknown" {
 		t.Errorf("expected unknown for empty RemoteAddr, got %s", got)
 	}
@@ -242,3 +246,183 @@ func TestAuditMiddlewareLogsRequestWithTokenClaims(t *testing.T) {
 		t.Errorf("expected 200, got %d", rec.Code)
 	}
 }
+
+// logWriter hands each audit log line to the test
+type logWriter chan []byte
+
+func (w logWriter) Write(b []byte) (int, error) {
+	w <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func TestAuditMiddlewareRecordsUpgradedConnections(t *testing.T) {
+	logs := make(logWriter, 1)
+	mw := &AuditMiddleware{out: logs}
+	server := httptest.NewServer(mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack through the audit writer: %v", err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		brw.Flush()
+		buf := make([]byte, 5)
+		io.ReadFull(conn, buf)
+		conn.Write([]byte("pong!!"))
+	})))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ws?access_token=secret HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	if resp, err := http.ReadResponse(br, nil); err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %v %v", resp, err)
+	}
+	conn.Write([]byte("ping!"))
+	io.ReadFull(br, make([]byte, 6))
+
+	var entry AuditLogEntry
+	select {
+	case line := <-logs:
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("decode audit log: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no audit log written")
+	}
+	if entry.ResponseStatus != http.StatusSwitchingProtocols || entry.Upgrade == nil {
+		t.Fatalf("expected an upgrade entry, got %+v", entry)
+	}
+	if entry.Upgrade.Protocol != "websocket" || entry.Upgrade.BytesFromClient != 5 || entry.Upgrade.BytesToClient != 6 {
+		t.Fatalf("unexpected upgrade info: %+v", entry.Upgrade)
+	}
+	if strings.Contains(entry.URL, "secret") || entry.Query["access_token"][0] != "[REDACTED]" {
+		t.Fatalf("expected the query token to be redacted, got %q %v", entry.URL, entry.Query)
+	}
+}
+
+// decodeAuditLog parses the single audit log line written to out
+func decodeAuditLog(t *testing.T, out *bytes.Buffer) AuditLogEntry {
+	t.Helper()
+	var entry AuditLogEntry
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("decode audit log %q: %v", out.String(), err)
+	}
+	return entry
+}
+
+func TestAuditMiddlewareStreamsBodiesAndCapturesPrefix(t *testing.T) {
+	var out bytes.Buffer
+	mw := NewAuditMiddlewareWithConfig(config.AuditConfig{MaxBodyBytes: 16})
+	mw.out = &out
+
+	requestBody := `{"password":"` + strings.Repeat("x", 1000) + `"}`
+	var received int
+	handler := mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = len(body)
+		w.Write([]byte(strings.Repeat("y", 100000)))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/upload", strings.NewReader(requestBody)))
+
+	if received != len(requestBody) || rec.Body.Len() != 100000 {
+		t.Fatalf("expected bodies to pass through whole, got request %d response %d", received, rec.Body.Len())
+	}
+	entry := decodeAuditLog(t, &out)
+	if entry.RequestSize != int64(len(requestBody)) || entry.ResponseSize != 100000 {
+		t.Fatalf("expected full sizes to be logged, got request %d response %d", entry.RequestSize, entry.ResponseSize)
+	}
+	if entry.Body != fmt.Sprintf("[truncated JSON body of %d bytes]", len(requestBody)) {
+		t.Fatalf("expected a truncated JSON body to be withheld, got %v", entry.Body)
+	}
+}
+
+func TestAuditMiddlewareLogsTruncatedTextPrefix(t *testing.T) {
+	var out bytes.Buffer
+	mw := NewAuditMiddlewareWithConfig(config.AuditConfig{MaxBodyBytes: 5})
+	mw.out = &out
+
+	handler := mw.Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/notes", strings.NewReader("hello world")))
+
+	if entry := decodeAuditLog(t, &out); entry.Body != "hello..." || entry.RequestSize != 11 {
+		t.Fatalf("expected the captured prefix, got %v (%d bytes)", entry.Body, entry.RequestSize)
+	}
+}
+
+func TestAuditMiddlewarePreservesStreamingInterfaces(t *testing.T) {
+	var out bytes.Buffer
+	mw := NewAuditMiddleware()
+	mw.out = &out
+
+	handler := mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if _, ok := w.(http.Hijacker); !ok {
+			t.Error("expected the audit writer to implement http.Hijacker")
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("expected the audit writer to implement http.Flusher")
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: one\n\n"))
+		flusher.Flush()
+		if _, ok := w.(io.ReaderFrom); !ok {
+			t.Fatal("expected the audit writer to implement io.ReaderFrom")
+		}
+		io.Copy(w, strings.NewReader("data: two\n\n"))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/events", nil))
+
+	if !rec.Flushed || rec.Body.String() != "data: one\n\ndata: two\n\n" {
+		t.Fatalf("expected a flushed event stream, got flushed=%v body=%q", rec.Flushed, rec.Body.String())
+	}
+	if entry := decodeAuditLog(t, &out); entry.ResponseSize != 22 {
+		t.Fatalf("expected both writes to be counted, got %d", entry.ResponseSize)
+	}
+}
+
+func TestAuditMiddlewareSkipsCaptureForConfiguredContentTypes(t *testing.T) {
+	var out bytes.Buffer
+	mw := NewAuditMiddlewareWithConfig(config.AuditConfig{MaxBodyBytes: 1024, SkipContentTypes: []string{"image/*"}})
+	mw.out = &out
+
+	handler := mw.Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	req := httptest.NewRequest("PUT", "/api/avatar", strings.NewReader("\x89PNG binary"))
+	req.Header.Set("Content-Type", "image/png")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if entry := decodeAuditLog(t, &out); entry.Body != nil || entry.RequestSize != 11 {
+		t.Fatalf("expected the body to be counted but not captured, got %v (%d bytes)", entry.Body, entry.RequestSize)
+	}
+}
+
+func TestMatchesContentType(t *testing.T) {
+	patterns := []string{"text/event-stream", "image/*"}
+	tests := []struct {
+		contentType string
+		expect      bool
+	}{
+		{"text/event-stream", true},
+		{"Text/Event-Stream; charset=utf-8", true},
+		{"image/png", true},
+		{"application/json", false},
+		{"imagex/png", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := matchesContentType(tt.contentType, patterns); got != tt.expect {
+			t.Errorf("matchesContentType(%q) = %v, want %v", tt.contentType, got, tt.expect)
+		}
+	}
+}
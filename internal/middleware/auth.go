@@ -17,13 +17,15 @@ const (
 
 // AuthMiddleware handles JWT token extraction and validation
 type AuthMiddleware struct {
-	keycloakClient *auth.Client
+	validator auth.TokenValidator
 }
 
-// NewAuthMiddleware creates a new authentication middleware
-func NewAuthMiddleware(keycloakClient *auth.Client) *AuthMiddleware {
+// NewAuthMiddleware creates a new authentication middleware.
+// The validator is typically an *auth.Client (introspection), an *auth.JWKSValidator
+// or an *auth.FallbackValidator combining both.
+func NewAuthMiddleware(validator auth.TokenValidator) *AuthMiddleware {
 	return &AuthMiddleware{
-		keycloakClient: keycloakClient,
+		validator: validator,
 	}
 }
 
@@ -37,8 +39,8 @@ func (m *AuthMiddleware) Handler(next http.Handler) http.Handler {
 			return
 		}
 
-		// Introspect token via Keycloak
-		introspectionResult, err := m.keycloakClient.IntrospectToken(r.Context(), token)
+		// Validate token via introspection or local JWKS verification
+		introspectionResult, err := m.validator.ValidateToken(r.Context(), token)
 		if err != nil {
 			http.Error(w, "Token validation failed: "+err.Error(), http.StatusUnauthorized)
 			return
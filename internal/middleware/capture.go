@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// bodyCapture keeps the first limit bytes of a body for the audit log and counts the
+// rest. Bodies stream through untouched; only the prefix is held in memory.
+type bodyCapture struct {
+	limit int
+	skip  bool // the content type is excluded from capture; bytes are still counted
+
+	mu    sync.Mutex // the transport may still read a request body after the handler returned
+	buf   bytes.Buffer
+	total int64
+}
+
+func newBodyCapture(limit int, skip bool) *bodyCapture {
+	return &bodyCapture{limit: limit, skip: skip}
+}
+
+// Write records b; it never fails so it can sit behind a tee
+func (c *bodyCapture) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += int64(len(b))
+	if room := c.limit - c.buf.Len(); !c.skip && room > 0 {
+		c.buf.Write(b[:min(room, len(b))])
+	}
+	return len(b), nil
+}
+
+// count records n bytes that bypassed capture
+func (c *bodyCapture) count(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += n
+}
+
+// room returns how many more bytes would be captured
+func (c *bodyCapture) room() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.skip {
+		return 0
+	}
+	return int64(max(0, c.limit-c.buf.Len()))
+}
+
+// size returns the number of body bytes seen
+func (c *bodyCapture) size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// logged renders the captured body for the audit log: JSON sanitized by policy when
+// the whole body was captured, otherwise text truncated to 1000 characters
+func (c *bodyCapture) logged(policy auditPolicy) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.skip || c.buf.Len() == 0 {
+		return nil
+	}
+	data := c.buf.Bytes()
+	truncated := int64(len(data)) < c.total
+
+	if !truncated {
+		var jsonBody interface{}
+		if err := json.Unmarshal(data, &jsonBody); err == nil {
+			return policy.sanitizeBody(jsonBody)
+		}
+	} else if looksLikeJSON(data) {
+		// A JSON prefix cannot be parsed, so its sensitive fields cannot be redacted
+		return fmt.Sprintf("[truncated JSON body of %d bytes]", c.total)
+	}
+
+	bodyStr := string(data)
+	if len(bodyStr) > 1000 {
+		bodyStr = bodyStr[:1000] + "..."
+	} else if truncated {
+		bodyStr += "..."
+	}
+	return policy.redactValue(bodyStr)
+}
+
+// looksLikeJSON reports whether data starts like a JSON object or array
+func looksLikeJSON(data []byte) bool {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')
+}
+
+// captureReader streams a request body to the handler while capturing its prefix
+type captureReader struct {
+	io.ReadCloser
+	capture *bodyCapture
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.capture.Write(p[:n])
+	return n, err
+}
+
+// matchesContentType reports whether the media type of contentType is listed in
+// patterns; a pattern such as "image/*" matches every subtype
+func matchesContentType(contentType string, patterns []string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == mediaType || (strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, pattern[:len(pattern)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// writerOnly hides every method but Write, so io.Copy cannot recurse into ReadFrom
+type writerOnly struct {
+	io.Writer
+}
+
+// readFrom copies src to w, using w's ReaderFrom (e.g. sendfile) when it has one
+func readFrom(w io.Writer, src io.Reader) (int64, error) {
+	if rf, ok := w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(writerOnly{w}, src)
+}
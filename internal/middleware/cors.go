@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// IsCORSPreflight reports whether r is a browser's CORS preflight request
+func IsCORSPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// CORSMiddleware answers CORS preflight requests and adds CORS headers to the
+// responses of a route
+type CORSMiddleware struct {
+	config *config.CORSConfig
+}
+
+// NewCORSMiddleware creates a CORS middleware; a nil config leaves requests untouched
+func NewCORSMiddleware(cfg *config.CORSConfig) *CORSMiddleware {
+	return &CORSMiddleware{config: cfg}
+}
+
+// Handler answers preflights without calling next, so they never reach auth or the
+// upstream, and sets the CORS response headers before next runs for other requests
+func (m *CORSMiddleware) Handler(next http.Handler) http.Handler {
+	if m.config == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsCORSPreflight(r) {
+			m.servePreflight(w, r)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			m.allowOrigin(w.Header(), origin)
+			if len(m.config.ExposedHeaders) > 0 && m.config.AllowsOrigin(origin) {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(m.config.ExposedHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// servePreflight replies 204 with the methods and headers the actual request may use,
+// or 403 when the origin, method or a requested header is not allowed
+func (m *CORSMiddleware) servePreflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	requested := requestedHeaders(r)
+	if !m.config.AllowsOrigin(origin) || !m.allowsMethod(method) || !m.allowsHeaders(requested) {
+		http.Error(w, "CORS request not allowed", http.StatusForbidden)
+		return
+	}
+
+	m.allowOrigin(h, origin)
+	if len(m.config.AllowedMethods) > 0 {
+		h.Set("Access-Control-Allow-Methods", strings.Join(m.config.AllowedMethods, ", "))
+	} else {
+		h.Set("Access-Control-Allow-Methods", method)
+	}
+	if len(requested) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if m.config.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(m.config.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowOrigin sets Access-Control-Allow-Origin when origin is allowed. Unless every
+// origin gets the same answer, the response varies by Origin.
+func (m *CORSMiddleware) allowOrigin(h http.Header, origin string) {
+	anyOrigin := m.config.AllowsAnyOrigin() && !m.config.AllowCredentials
+	if !anyOrigin && !slices.Contains(h.Values("Vary"), "Origin") {
+		h.Add("Vary", "Origin")
+	}
+	if !m.config.AllowsOrigin(origin) {
+		return
+	}
+	if anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if m.config.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// allowsMethod reports whether the actual request may use method. Without
+// allowed_methods the route's rules decide, which the router already checked.
+func (m *CORSMiddleware) allowsMethod(method string) bool {
+	return len(m.config.AllowedMethods) == 0 || slices.Contains(m.config.AllowedMethods, method)
+}
+
+// allowsHeaders reports whether the actual request may send every requested header
+func (m *CORSMiddleware) allowsHeaders(requested []string) bool {
+	allowed := m.config.AllowedHeaders
+	if len(allowed) == 0 || slices.Contains(allowed, "*") {
+		return true
+	}
+	for _, name := range requested {
+		if !slices.Contains(allowed, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestedHeaders returns the canonical names listed in Access-Control-Request-Headers
+func requestedHeaders(r *http.Request) []string {
+	var names []string
+	for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+func serveCORS(cfg *config.CORSConfig, req *http.Request) (*httptest.ResponseRecorder, bool) {
+	nextCalled := false
+	handler := NewCORSMiddleware(cfg).Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		nextCalled = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec, nextCalled
+}
+
+func preflight(origin, method, headers string) *http.Request {
+	req := httptest.NewRequest("OPTIONS", "/api/users", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return req
+}
+
+func TestCORSMiddlewareAnswersPreflight(t *testing.T) {
+	cfg := &config.CORSConfig{
+		AllowedOrigins:         []string{"https://app.example.com"},
+		CompiledOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^https://[a-z0-9]+\.preview\.example\.com$`)},
+		AllowedMethods:         []string{"GET", "POST"},
+		AllowedHeaders:         []string{"Authorization", "Content-Type"},
+	}
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"allowed", preflight("https://app.example.com", "POST", "content-type, Authorization"), http.StatusNoContent},
+		{"origin pattern", preflight("https://pr7.preview.example.com", "GET", ""), http.StatusNoContent},
+		{"other origin", preflight("https://evil.example.net", "GET", ""), http.StatusForbidden},
+		{"method", preflight("https://app.example.com", "DELETE", ""), http.StatusForbidden},
+		{"header", preflight("https://app.example.com", "GET", "X-Debug"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, nextCalled := serveCORS(cfg, tt.req)
+			if nextCalled || rec.Code != tt.status {
+				t.Fatalf("expected %d without calling next, got %d nextCalled=%v", tt.status, rec.Code, nextCalled)
+			}
+			allowed := rec.Header().Get("Access-Control-Allow-Origin")
+			if (tt.status == http.StatusNoContent) != (allowed == tt.req.Header.Get("Origin")) {
+				t.Fatalf("unexpected Access-Control-Allow-Origin %q", allowed)
+			}
+		})
+	}
+
+	rec, _ := serveCORS(cfg, preflight("https://app.example.com", "POST", "content-type, Authorization"))
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+		t.Fatalf("expected the configured methods, got %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type, Authorization" {
+		t.Fatalf("expected the requested headers, got %q", got)
+	}
+}
+
+func TestCORSMiddlewareDecoratesResponses(t *testing.T) {
+	cfg := &config.CORSConfig{AllowedOrigins: []string{"*"}, ExposedHeaders: []string{"X-Request-Id"}}
+
+	req := httptest.NewRequest("GET", "/api/users", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	rec, nextCalled := serveCORS(cfg, req)
+	if !nextCalled || rec.Header().Get("Access-Control-Allow-Origin") != "*" || rec.Header().Get("Access-Control-Expose-Headers") != "X-Request-Id" {
+		t.Fatalf("expected the wildcard origin and exposed headers, got %v", rec.Header())
+	}
+	if rec.Header().Get("Vary") != "" {
+		t.Fatalf("expected no Vary for a wildcard origin, got %v", rec.Header()["Vary"])
+	}
+
+	rec, nextCalled = serveCORS(cfg, httptest.NewRequest("OPTIONS", "/api/users", nil))
+	if !nextCalled || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("expected a plain OPTIONS request to pass through, got %v", rec.Header())
+	}
+}
+
+func TestCORSMiddlewareVariesByOriginWithCredentials(t *testing.T) {
+	cfg := &config.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true}
+
+	req := httptest.NewRequest("GET", "/api/users", nil)
+	req.Header.Set("Origin", "https://other.example.com")
+	rec, nextCalled := serveCORS(cfg, req)
+	if !nextCalled || rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Vary") != "Origin" {
+		t.Fatalf("expected a disallowed origin to get no CORS headers but Vary, got %v", rec.Header())
+	}
+
+	req.Header.Set("Origin", "https://app.example.com")
+	rec, _ = serveCORS(cfg, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatalf("expected the origin to be echoed with credentials, got %v", rec.Header())
+	}
+}
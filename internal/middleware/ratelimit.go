@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+	"github.com/aveiga/cloud-api-gateway/internal/ratelimit"
+)
+
+// RateLimitMiddleware enforces the rate limits of a route and of its matching rules
+type RateLimitMiddleware struct {
+	routeName string
+	store     ratelimit.Store
+	limits    []scopedLimit
+}
+
+// scopedLimit is a configured limit together with where it was declared
+type scopedLimit struct {
+	scope  string // "route" or "rule"
+	config *config.RateLimitConfig
+}
+
+// NewRateLimitMiddleware creates a rate limit middleware for a route. The route-level
+// limit (if any) and the limits of every matching rule all apply.
+func NewRateLimitMiddleware(routeName string, store ratelimit.Store, routeLimit *config.RateLimitConfig, rules []config.RouteRule) *RateLimitMiddleware {
+	m := &RateLimitMiddleware{routeName: routeName, store: store}
+	if routeLimit != nil {
+		m.limits = append(m.limits, scopedLimit{scope: "route", config: routeLimit})
+	}
+	for _, rule := range rules {
+		if rule.RateLimit != nil {
+			m.limits = append(m.limits, scopedLimit{scope: "rule", config: rule.RateLimit})
+		}
+	}
+	return m
+}
+
+// Enabled reports whether any limit applies
+func (m *RateLimitMiddleware) Enabled() bool {
+	return len(m.limits) > 0
+}
+
+// Handler returns an HTTP handler that rejects requests over a limit with 429
+func (m *RateLimitMiddleware) Handler(next http.Handler) http.Handler {
+	if !m.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reported *ratelimit.Decision // most restrictive decision, reported in headers
+		var reportedLimit *config.RateLimitConfig
+		var denied bool
+		var retryAfter time.Duration
+
+		for _, limit := range m.limits {
+			decision, err := m.store.Allow(r.Context(), m.key(r, limit), ratelimit.LimitFromConfig(limit.config))
+			if err != nil {
+				// Fail open: a broken limiter store must not take the route down
+				log.Printf("Rate limit store error for route %s: %v", m.routeName, err)
+				continue
+			}
+			if !decision.Allowed {
+				denied = true
+				retryAfter = max(retryAfter, decision.RetryAfter)
+			}
+			if reported == nil || (!decision.Allowed && reported.Allowed) || decision.Remaining < reported.Remaining {
+				reported, reportedLimit = &decision, limit.config
+			}
+		}
+
+		if reported != nil {
+			setRateLimitHeaders(w.Header(), reported, reportedLimit)
+		}
+		if denied {
+			metrics.RateLimited.WithLabelValues(m.routeName).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// key identifies the client a limit counts against. Identity-based keys fall back
+// to the connection's IP when the request carries no such identity.
+func (m *RateLimitMiddleware) key(r *http.Request, limit scopedLimit) string {
+	cfg := limit.config
+	kind, value := cfg.Key, ""
+	switch cfg.Key {
+	case config.RateLimitKeyUsername:
+		if claims := GetTokenClaims(r); claims != nil {
+			value = claims.Username
+		}
+	case config.RateLimitKeyClientID:
+		if claims := GetTokenClaims(r); claims != nil {
+			value = claims.ClientID
+		}
+	case config.RateLimitKeyHeader:
+		value = r.Header.Get(cfg.Header)
+	}
+	if value == "" {
+		kind, value = config.RateLimitKeyIP, RemoteIP(r)
+	}
+
+	// Limits with identical settings in the same scope share state, which also keeps
+	// counters across reloads that do not change the limit
+	return fmt.Sprintf("%s|%s|%s:%d/%s:%d|%s:%s|%s", m.routeName, limit.scope,
+		cfg.Algorithm, cfg.Requests, cfg.Window, cfg.Burst, kind, cfg.Header, value)
+}
+
+// setRateLimitHeaders writes the RateLimit header fields (draft-ietf-httpapi-ratelimit-headers)
+func setRateLimitHeaders(h http.Header, d *ratelimit.Decision, cfg *config.RateLimitConfig) {
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", cfg.Requests, ceilSeconds(cfg.Window)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// RemoteIP returns the IP of the connection the request arrived on. Unlike ClientIP it
+// ignores X-Forwarded-For and X-Real-IP, which the client can set to anything.
+func RemoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/auth"
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+	"github.com/aveiga/cloud-api-gateway/internal/ratelimit"
+)
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func serveFrom(handler http.Handler, remoteAddr string, mutate func(*http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/users", nil)
+	req.RemoteAddr = remoteAddr
+	if mutate != nil {
+		mutate(req)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitRejectsOverLimitWithHeaders(t *testing.T) {
+	limit := &config.RateLimitConfig{Algorithm: config.RateLimitTokenBucket, Requests: 2, Window: time.Minute, Burst: 2, Key: config.RateLimitKeyIP}
+	mw := NewRateLimitMiddleware("limited", ratelimit.NewMemoryStore(), limit, nil)
+	handler := mw.Handler(okHandler())
+	before := metrics.RateLimited.WithLabelValues("limited").Value()
+
+	first := serveFrom(handler, "10.0.0.1:1234", nil)
+	if first.Code != http.StatusNoContent {
+		t.Fatalf("expected first request to pass, got %d", first.Code)
+	}
+	if first.Header().Get("RateLimit-Limit") != "2" || first.Header().Get("RateLimit-Remaining") != "1" ||
+		first.Header().Get("RateLimit-Policy") != "2;w=60" {
+		t.Fatalf("unexpected rate limit headers: %v", first.Header())
+	}
+
+	serveFrom(handler, "10.0.0.1:1234", nil)
+	denied := serveFrom(handler, "10.0.0.1:1234", nil)
+	if denied.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", denied.Code)
+	}
+	if denied.Header().Get("Retry-After") != "30" || denied.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("unexpected headers on denial: %v", denied.Header())
+	}
+	if got := metrics.RateLimited.WithLabelValues("limited").Value() - before; got != 1 {
+		t.Fatalf("expected one rate limited request to be counted, got %v", got)
+	}
+
+	// Other clients have their own budget
+	if rec := serveFrom(handler, "10.0.0.2:1234", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected another client to pass, got %d", rec.Code)
+	}
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	limit := &config.RateLimitConfig{Algorithm: config.RateLimitTokenBucket, Requests: 1, Window: time.Minute, Burst: 1, Key: config.RateLimitKeyIP}
+	store := ratelimit.NewMemoryStore()
+	handler := NewRateLimitMiddleware("spoofed", store, limit, nil).Handler(okHandler())
+
+	first := serveFrom(handler, "10.0.0.1:1234", func(r *http.Request) { r.Header.Set("X-Forwarded-For", "198.51.100.1") })
+	if first.Code != http.StatusNoContent {
+		t.Fatalf("expected first request to pass, got %d", first.Code)
+	}
+	second := serveFrom(handler, "10.0.0.1:1234", func(r *http.Request) {
+		r.Header.Set("X-Forwarded-For", "198.51.100.2")
+		r.Header.Set("X-Real-IP", "198.51.100.3")
+	})
+	if second.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected a new X-Forwarded-For value to share the connection's bucket, got %d", second.Code)
+	}
+}
+
+func TestRateLimitAppliesRouteAndRuleLimits(t *testing.T) {
+	routeLimit := &config.RateLimitConfig{Algorithm: config.RateLimitTokenBucket, Requests: 10, Window: time.Minute, Burst: 10, Key: config.RateLimitKeyIP}
+	rules := []config.RouteRule{
+		{Methods: []string{"GET"}, RateLimit: &config.RateLimitConfig{Algorithm: config.RateLimitSlidingWindow, Requests: 1, Window: time.Minute, Key: config.RateLimitKeyIP}},
+		{Methods: []string{"GET"}},
+	}
+	mw := NewRateLimitMiddleware("users", ratelimit.NewMemoryStore(), routeLimit, rules)
+	handler := mw.Handler(okHandler())
+
+	first := serveFrom(handler, "10.0.0.1:1234", nil)
+	if first.Code != http.StatusNoContent || first.Header().Get("RateLimit-Limit") != "1" {
+		t.Fatalf("expected the most restrictive limit to be reported, got %d %v", first.Code, first.Header())
+	}
+	if rec := serveFrom(handler, "10.0.0.1:1234", nil); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the rule limit to reject, got %d", rec.Code)
+	}
+}
+
+func TestRateLimitKeysByIdentity(t *testing.T) {
+	limit := &config.RateLimitConfig{Algorithm: config.RateLimitTokenBucket, Requests: 1, Window: time.Minute, Burst: 1, Key: config.RateLimitKeyUsername}
+	handler := NewRateLimitMiddleware("users", ratelimit.NewMemoryStore(), limit, nil).Handler(okHandler())
+	as := func(username string) func(*http.Request) {
+		return func(r *http.Request) {
+			claims := &auth.IntrospectionResponse{Active: true, Username: username}
+			*r = *r.WithContext(context.WithValue(r.Context(), TokenClaimsKey, claims))
+		}
+	}
+
+	// The same user is limited across addresses, different users are not
+	if rec := serveFrom(handler, "10.0.0.1:1234", as("alice")); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected alice to pass, got %d", rec.Code)
+	}
+	if rec := serveFrom(handler, "10.0.0.2:1234", as("alice")); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected alice to be limited from another address, got %d", rec.Code)
+	}
+	if rec := serveFrom(handler, "10.0.0.1:1234", as("bob")); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected bob to pass, got %d", rec.Code)
+	}
+
+	// Without an identity the client IP is used
+	if rec := serveFrom(handler, "10.0.0.3:1234", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected anonymous client to pass, got %d", rec.Code)
+	}
+	if rec := serveFrom(handler, "10.0.0.3:1234", nil); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected anonymous client to be limited by IP, got %d", rec.Code)
+	}
+}
+
+func TestRateLimitKeysByHeader(t *testing.T) {
+	limit := &config.RateLimitConfig{Algorithm: config.RateLimitTokenBucket, Requests: 1, Window: time.Minute, Burst: 1, Key: config.RateLimitKeyHeader, Header: "X-API-Key"}
+	handler := NewRateLimitMiddleware("users", ratelimit.NewMemoryStore(), limit, nil).Handler(okHandler())
+	withKey := func(key string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("X-API-Key", key) }
+	}
+
+	serveFrom(handler, "10.0.0.1:1234", withKey("a"))
+	if rec := serveFrom(handler, "10.0.0.1:1234", withKey("b")); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected a different key to pass, got %d", rec.Code)
+	}
+	if rec := serveFrom(handler, "10.0.0.2:1234", withKey("a")); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected key a to be limited, got %d", rec.Code)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Allow(context.Context, string, ratelimit.Limit) (ratelimit.Decision, error) {
+	return ratelimit.Decision{}, errors.New("store unavailable")
+}
+
+func TestRateLimitFailsOpenOnStoreError(t *testing.T) {
+	limit := &config.RateLimitConfig{Algorithm: config.RateLimitTokenBucket, Requests: 1, Window: time.Minute, Burst: 1, Key: config.RateLimitKeyIP}
+	handler := NewRateLimitMiddleware("users", failingStore{}, limit, nil).Handler(okHandler())
+
+	for i := 0; i < 3; i++ {
+		if rec := serveFrom(handler, "10.0.0.1:1234", nil); rec.Code != http.StatusNoContent {
+			t.Fatalf("expected request to pass when the store fails, got %d", rec.Code)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+	"github.com/aveiga/cloud-api-gateway/internal/tracing"
 )
 
 // RBACMiddleware checks if the authenticated user has the required roles
@@ -35,14 +37,21 @@ func (m *RBACMiddleware) Handler(next http.Handler) http.Handler {
 		userRoles := claims.GetAllRoles()
 
 		// OR semantics across rules: user is authorized when at least one rule passes.
+		_, span := tracing.Start(r.Context(), "rbac.evaluate", tracing.SpanKindInternal)
+		span.SetAttribute("gateway.route", m.routeName)
 		for _, rule := range m.rules {
 			if m.checkRoles(userRoles, rule.RequiredRoles, rule.RequireAllRoles) {
+				span.SetAttribute("rbac.allowed", true)
+				span.End()
 				next.ServeHTTP(w, r)
 				return
 			}
 		}
+		span.SetAttribute("rbac.allowed", false)
+		span.End()
 
 		log.Printf("Insufficient permissions for route %s", m.routeName)
+		metrics.RBACDenials.WithLabelValues(m.routeName).Inc()
 		http.Error(w, "Insufficient permissions", http.StatusForbidden)
 	})
 }
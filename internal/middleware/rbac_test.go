@@ -8,6 +8,7 @@ import (
 
 	"github.com/aveiga/cloud-api-gateway/internal/auth"
 	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
 )
 
 func requestWithRoles(roles []string) *http.Request {
@@ -58,6 +59,21 @@ func TestRBACDeniesWhenNoRuleMatches(t *testing.T) {
 	}
 }
 
+func TestRBACCountsDenialsPerRoute(t *testing.T) {
+	mw := NewRBACMiddleware("rbac-metrics", []config.RouteRule{
+		{Methods: []string{"GET"}, RequiredRoles: []string{"admin"}},
+	})
+	handler := mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	denials := metrics.RBACDenials.WithLabelValues("rbac-metrics")
+
+	handler.ServeHTTP(httptest.NewRecorder(), requestWithRoles([]string{"viewer"}))
+	handler.ServeHTTP(httptest.NewRecorder(), requestWithRoles([]string{"admin"}))
+
+	if denials.Value() != 1 {
+		t.Fatalf("expected 1 denial, got %v", denials.Value())
+	}
+}
+
 func TestRBACRequiresAuthenticatedClaims(t *testing.T) {
 	mw := NewRBACMiddleware("users", []config.RouteRule{
 		{Methods: []string{"GET"}, RequiredRoles: []string{"admin"}, RequireAllRoles: true},
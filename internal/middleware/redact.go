@@ -0,0 +1,297 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// redacted replaces every value the audit log must not record
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are never logged, whatever the configuration says
+var sensitiveHeaders = []string{
+	"authorization",
+	"cookie",
+	"x-api-key",
+	"sec-websocket-protocol", // may carry a WebSocket access token
+}
+
+// sensitiveFields are redacted from bodies and query strings when a field name contains one
+var sensitiveFields = []string{"password", "token", "secret", "key", "auth"}
+
+// auditScopeKey is the context key of a request's *auditScope
+type auditScopeKey struct{}
+
+// auditScope lets the gateway hand the matched route's audit policy back to the audit
+// middleware, which wraps the gateway and never sees the route itself
+type auditScope struct {
+	route  *config.AuditPolicy
+	params map[string]string
+}
+
+// SetAuditPolicy applies a route's audit block to the audit entry of r; a nil policy
+// leaves the deployment's policy in force
+func SetAuditPolicy(r *http.Request, policy *config.AuditPolicy) {
+	if scope, ok := r.Context().Value(auditScopeKey{}).(*auditScope); ok {
+		scope.route = policy
+	}
+}
+
+// SetAuditPathParams records the path parameters captured by the matched route in the
+// audit entry of r
+func SetAuditPathParams(r *http.Request, params map[string]string) {
+	if scope, ok := r.Context().Value(auditScopeKey{}).(*auditScope); ok {
+		scope.params = params
+	}
+}
+
+// withAuditScope returns r with an empty audit scope the gateway can fill in
+func withAuditScope(r *http.Request) (*http.Request, *auditScope) {
+	scope := &auditScope{}
+	return r.WithContext(context.WithValue(r.Context(), auditScopeKey{}, scope)), scope
+}
+
+// auditPolicy is the policy in force for one request: the deployment's, extended by
+// the matched route's. Either may be nil; the zero value applies only the built-in
+// redaction of credentials.
+type auditPolicy struct {
+	deployment *config.AuditPolicy
+	route      *config.AuditPolicy
+}
+
+// each calls fn for the deployment and route policies that are set
+func (p auditPolicy) each(fn func(*config.AuditPolicy) bool) bool {
+	for _, policy := range []*config.AuditPolicy{p.deployment, p.route} {
+		if policy != nil && fn(policy) {
+			return true
+		}
+	}
+	return false
+}
+
+// toggle returns the route's setting, else the deployment's, else def
+func (p auditPolicy) toggle(setting func(*config.AuditPolicy) *bool, def bool) bool {
+	for _, policy := range []*config.AuditPolicy{p.route, p.deployment} {
+		if policy != nil && setting(policy) != nil {
+			return *setting(policy)
+		}
+	}
+	return def
+}
+
+func (p auditPolicy) captureQuery() bool {
+	return p.toggle(func(c *config.AuditPolicy) *bool { return c.CaptureQuery }, true)
+}
+
+func (p auditPolicy) captureRequestBody() bool {
+	return p.toggle(func(c *config.AuditPolicy) *bool { return c.CaptureRequestBody }, true)
+}
+
+func (p auditPolicy) captureResponseBody() bool {
+	return p.toggle(func(c *config.AuditPolicy) *bool { return c.CaptureResponseBody }, false)
+}
+
+// skip reports whether the request is not logged at all
+func (p auditPolicy) skip(r *http.Request) bool {
+	return p.each(func(c *config.AuditPolicy) bool {
+		for _, pattern := range c.CompiledSkipPaths {
+			if pattern.MatchString(r.URL.Path) {
+				return true
+			}
+		}
+		return slices.Contains(c.SkipMethods, r.Method)
+	})
+}
+
+// sanitizeHeaders drops credential and configured headers and masks sensitive values
+func (p auditPolicy) sanitizeHeaders(headers http.Header) map[string]string {
+	sanitized := make(map[string]string)
+	for key, values := range headers {
+		if p.redactHeader(key) {
+			continue
+		}
+		// Join multiple values with comma
+		sanitized[key] = p.redactValue(strings.Join(values, ", "))
+	}
+	return sanitized
+}
+
+func (p auditPolicy) redactHeader(name string) bool {
+	lowerName := strings.ToLower(name)
+	if slices.Contains(sensitiveHeaders, lowerName) {
+		return true
+	}
+	return p.each(func(c *config.AuditPolicy) bool {
+		return slices.ContainsFunc(c.RedactHeaders, func(header string) bool { return strings.EqualFold(header, name) })
+	})
+}
+
+// sanitizeBody redacts sensitive fields from a decoded JSON body. Arrays are walked
+// element by element and do not add a level to the field's path.
+func (p auditPolicy) sanitizeBody(body interface{}) interface{} {
+	return p.sanitizeJSON(body, nil)
+}
+
+func (p auditPolicy) sanitizeJSON(value interface{}, path []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		sanitized := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			fieldPath := append(path[:len(path):len(path)], key)
+			if isSensitiveField(key) || p.redactField(fieldPath) {
+				sanitized[key] = redacted
+			} else {
+				sanitized[key] = p.sanitizeJSON(nested, fieldPath)
+			}
+		}
+		return sanitized
+	case []interface{}:
+		sanitized := make([]interface{}, len(v))
+		for i, item := range v {
+			sanitized[i] = p.sanitizeJSON(item, path)
+		}
+		return sanitized
+	case string:
+		return p.redactValue(v)
+	}
+	return value
+}
+
+// redactField reports whether a configured JSON path selects the field at path
+func (p auditPolicy) redactField(path []string) bool {
+	return p.each(func(c *config.AuditPolicy) bool {
+		for _, field := range c.RedactFields {
+			if matchJSONPath(strings.Split(strings.TrimPrefix(field, "$."), "."), path) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// matchJSONPath matches a field path against a pattern whose "*" segments match any
+// one key and "**" segments any number of keys
+func matchJSONPath(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchJSONPath(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 || (pattern[0] != "*" && pattern[0] != path[0]) {
+		return false
+	}
+	return matchJSONPath(pattern[1:], path[1:])
+}
+
+// redactValue masks every substring matched by a configured value pattern
+func (p auditPolicy) redactValue(value string) string {
+	p.each(func(c *config.AuditPolicy) bool {
+		for _, pattern := range c.RedactValues {
+			if pattern.Compiled == nil {
+				continue
+			}
+			cardNumbers := pattern.Name == config.AuditRedactCardNumber
+			value = pattern.Compiled.ReplaceAllStringFunc(value, func(match string) string {
+				if cardNumbers && !luhnValid(match) {
+					return match // an order number or timestamp, not a card
+				}
+				return redacted
+			})
+		}
+		return false
+	})
+	return value
+}
+
+// luhnValid reports whether the digits of s pass the Luhn checksum of card numbers
+func luhnValid(s string) bool {
+	sum, digits := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits > 0 && sum%10 == 0
+}
+
+// isSensitiveField reports whether a body field or query parameter must be redacted
+func isSensitiveField(name string) bool {
+	lowerName := strings.ToLower(name)
+	for _, sensitiveField := range sensitiveFields {
+		if strings.Contains(lowerName, sensitiveField) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeQuery redacts sensitive query parameters, such as a WebSocket access token,
+// and masks sensitive values
+func (p auditPolicy) sanitizeQuery(query url.Values) url.Values {
+	for key, values := range query {
+		for i := range values {
+			if isSensitiveField(key) {
+				values[i] = redacted
+			} else {
+				values[i] = p.redactValue(values[i])
+			}
+		}
+	}
+	return query
+}
+
+// sanitizePathParams masks sensitive values among the matched route's path parameters
+func (p auditPolicy) sanitizePathParams(params map[string]string) map[string]string {
+	if len(params) == 0 {
+		return nil
+	}
+	sanitized := make(map[string]string, len(params))
+	for name, value := range params {
+		if isSensitiveField(name) {
+			sanitized[name] = redacted
+		} else {
+			sanitized[name] = p.redactValue(value)
+		}
+	}
+	return sanitized
+}
+
+// sanitizeURL returns the URL with its query sanitized, or without a query when
+// query strings are not captured
+func (p auditPolicy) sanitizeURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	sanitized := *u
+	if !p.captureQuery() {
+		sanitized.RawQuery = ""
+		sanitized.ForceQuery = false
+		return sanitized.String()
+	}
+	query := u.Query()
+	clean := query.Encode()
+	if redactedQuery := p.sanitizeQuery(query).Encode(); redactedQuery != clean {
+		sanitized.RawQuery = redactedQuery
+	}
+	return sanitized.String()
+}
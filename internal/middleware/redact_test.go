@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// valuePattern compiles a redact_values entry the way config validation does
+func valuePattern(name, pattern string) config.AuditValuePattern {
+	if pattern == "" {
+		pattern = config.AuditRedactPresets[name]
+	}
+	return config.AuditValuePattern{Name: name, Pattern: pattern, Compiled: regexp.MustCompile(pattern)}
+}
+
+func TestSanitizeBodyRecursesIntoArrays(t *testing.T) {
+	body := map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"name": "ann", "password": "one"},
+			[]interface{}{map[string]interface{}{"apiKey": "two"}},
+			"plain",
+		},
+	}
+	sanitized := auditPolicy{}.sanitizeBody(body).(map[string]interface{})
+	users := sanitized["users"].([]interface{})
+	if first := users[0].(map[string]interface{}); first["password"] != redacted || first["name"] != "ann" {
+		t.Fatalf("expected fields of array elements to be redacted, got %v", first)
+	}
+	if nested := users[1].([]interface{})[0].(map[string]interface{}); nested["apiKey"] != redacted {
+		t.Fatalf("expected nested arrays to be walked, got %v", nested)
+	}
+	if users[2] != "plain" {
+		t.Fatalf("expected plain values to be kept, got %v", users[2])
+	}
+}
+
+func TestSanitizeBodyRedactsConfiguredJSONPaths(t *testing.T) {
+	policy := auditPolicy{deployment: &config.AuditPolicy{
+		RedactFields: []string{"$.card.number", "items.*.iban", "**.ssn"},
+	}}
+	body := map[string]interface{}{
+		"card":   map[string]interface{}{"number": "4111", "holder": "ann"},
+		"number": "kept at the root",
+		"items": []interface{}{
+			map[string]interface{}{"bank": map[string]interface{}{"iban": "DE89"}},
+			map[string]interface{}{"iban": "kept, one level too shallow"},
+		},
+		"person": map[string]interface{}{"details": map[string]interface{}{"ssn": "123-45-6789"}},
+		"ssn":    "000-00-0000",
+	}
+	sanitized := policy.sanitizeBody(body).(map[string]interface{})
+
+	if card := sanitized["card"].(map[string]interface{}); card["number"] != redacted || card["holder"] != "ann" {
+		t.Fatalf("expected card.number to be redacted, got %v", card)
+	}
+	if sanitized["number"] != "kept at the root" {
+		t.Fatalf("expected a path to match only its own field, got %v", sanitized["number"])
+	}
+	items := sanitized["items"].([]interface{})
+	if bank := items[0].(map[string]interface{})["bank"].(map[string]interface{}); bank["iban"] != redacted {
+		t.Fatalf("expected items.*.iban to match through the array, got %v", bank)
+	}
+	if items[1].(map[string]interface{})["iban"] == redacted {
+		t.Fatal("expected * to match exactly one key")
+	}
+	details := sanitized["person"].(map[string]interface{})["details"].(map[string]interface{})
+	if details["ssn"] != redacted || sanitized["ssn"] != redacted {
+		t.Fatalf("expected **.ssn to match at any depth, got %v and %v", details["ssn"], sanitized["ssn"])
+	}
+}
+
+func TestRedactValueMasksCardNumbersAndEmails(t *testing.T) {
+	policy := auditPolicy{deployment: &config.AuditPolicy{RedactValues: []config.AuditValuePattern{
+		valuePattern(config.AuditRedactCardNumber, ""),
+		valuePattern(config.AuditRedactEmail, ""),
+	}}}
+	tests := []struct {
+		in, want string
+	}{
+		{"paid with 4111 1111 1111 1111 today", "paid with [REDACTED] today"},
+		{"card 4111-1111-1111-1111", "card [REDACTED]"},
+		{"order 1700000000123", "order 1700000000123"}, // fails the Luhn check
+		{"contact ann.lee+work@example.co.uk now", "contact [REDACTED] now"},
+	}
+	for _, tt := range tests {
+		if got := policy.redactValue(tt.in); got != tt.want {
+			t.Errorf("redactValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAuditPolicyMergesRouteSettings(t *testing.T) {
+	deployment := &config.AuditPolicy{
+		SkipMethods:        []string{"OPTIONS"},
+		RedactHeaders:      []string{"X-Tenant-Secret"},
+		CaptureQuery:       boolPtr(true),
+		CaptureRequestBody: boolPtr(true),
+	}
+	route := &config.AuditPolicy{
+		SkipMethods:        []string{"HEAD"},
+		RedactHeaders:      []string{"x-session"},
+		CaptureRequestBody: boolPtr(false),
+	}
+	policy := auditPolicy{deployment: deployment, route: route}
+
+	for _, method := range []string{"OPTIONS", "HEAD"} {
+		if !policy.skip(httptest.NewRequest(method, "/api", nil)) {
+			t.Errorf("expected %s to be skipped", method)
+		}
+	}
+	headers := policy.sanitizeHeaders(http.Header{"X-Tenant-Secret": {"a"}, "X-Session": {"b"}, "Accept": {"*/*"}})
+	if len(headers) != 1 || headers["Accept"] != "*/*" {
+		t.Fatalf("expected deployment and route headers to be dropped, got %v", headers)
+	}
+	if !policy.captureQuery() || policy.captureRequestBody() || policy.captureResponseBody() {
+		t.Fatal("expected route toggles to override the deployment's and unset ones to fall back")
+	}
+}
+
+func TestAuditMiddlewareAppliesRoutePolicy(t *testing.T) {
+	cfg := config.DefaultAuditConfig()
+	cfg.RedactValues = []config.AuditValuePattern{valuePattern(config.AuditRedactEmail, "")}
+	var out bytes.Buffer
+	mw := NewAuditMiddlewareWithConfig(cfg).WithOutput(&out)
+
+	route := &config.AuditPolicy{
+		RedactFields:        []string{"profile.phone"},
+		CaptureQuery:        boolPtr(false),
+		CaptureResponseBody: boolPtr(true),
+	}
+	handler := mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetAuditPolicy(r, route)
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":7,"email":"ann@example.com"}`))
+	}))
+	req := httptest.NewRequest("POST", "/api/users?invite=bob@example.com", strings.NewReader(`{"profile":{"phone":"555-0100","name":"ann"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	entry := decodeAuditLog(t, &out)
+	if entry.URL != "/api/users" || entry.Query != nil {
+		t.Fatalf("expected the query string to be omitted, got %q %v", entry.URL, entry.Query)
+	}
+	profile := entry.Body.(map[string]interface{})["profile"].(map[string]interface{})
+	if profile["phone"] != redacted || profile["name"] != "ann" {
+		t.Fatalf("expected the route's JSON path to be redacted, got %v", profile)
+	}
+	response, ok := entry.ResponseBody.(map[string]interface{})
+	if !ok || response["email"] != redacted || response["id"] != float64(7) {
+		t.Fatalf("expected the response body with the deployment's value patterns applied, got %v", entry.ResponseBody)
+	}
+}
+
+func TestAuditMiddlewareSkipsByRoutePolicy(t *testing.T) {
+	var out bytes.Buffer
+	mw := NewAuditMiddleware().WithOutput(&out)
+	route := &config.AuditPolicy{CompiledSkipPaths: []*regexp.Regexp{regexp.MustCompile("^/api/metrics")}}
+	handler := mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetAuditPolicy(r, route)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/metrics/cpu", nil))
+	if out.Len() != 0 {
+		t.Fatalf("expected no audit entry, got %s", out.String())
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/users", nil))
+	if out.Len() == 0 {
+		t.Fatal("expected other paths of the route to be logged")
+	}
+}
+
+func TestMatchJSONPath(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		expect        bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.b", "a.b.c", false},
+		{"a.*", "a.x", true},
+		{"a.*", "a", false},
+		{"**.c", "c", true},
+		{"**.c", "a.b.c", true},
+		{"a.**.c", "a.c", true},
+		{"a.**", "b", false},
+	}
+	for _, tt := range tests {
+		if got := matchJSONPath(strings.Split(tt.pattern, "."), strings.Split(tt.path, ".")); got != tt.expect {
+			t.Errorf("matchJSONPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.expect)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/aveiga/cloud-api-gateway/internal/auth"
+)
+
+// UpstreamTokenMiddleware replaces the caller's Authorization header with a
+// gateway-signed JWT describing the authenticated caller
+type UpstreamTokenMiddleware struct {
+	routeName string
+	signer    *auth.TokenSigner
+}
+
+// NewUpstreamTokenMiddleware creates the middleware for a route. A nil signer
+// leaves requests untouched.
+func NewUpstreamTokenMiddleware(routeName string, signer *auth.TokenSigner) *UpstreamTokenMiddleware {
+	return &UpstreamTokenMiddleware{
+		routeName: routeName,
+		signer:    signer,
+	}
+}
+
+// Handler returns an HTTP handler that sets the upstream Authorization header.
+// Requests without validated claims (public rules) have Authorization removed so
+// upstreams only ever see tokens the gateway issued.
+func (m *UpstreamTokenMiddleware) Handler(next http.Handler) http.Handler {
+	if m.signer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := GetTokenClaims(r)
+		if claims == nil {
+			r.Header.Del("Authorization")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, err := m.signer.Sign(claims, m.routeName)
+		if err != nil {
+			log.Printf("Failed to mint upstream token for route %s: %v", m.routeName, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/auth"
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+func newTestSigner(t *testing.T) *auth.TokenSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	signer, err := auth.NewTokenSigner(&config.UpstreamTokenConfig{
+		Issuer: "gateway",
+		TTL:    time.Minute,
+		Keys:   []config.SigningKeyConfig{{ID: "k1", File: path}},
+	})
+	if err != nil {
+		t.Fatalf("NewTokenSigner: %v", err)
+	}
+	return signer
+}
+
+func captureAuthorization(captured *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*captured = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func TestUpstreamTokenReplacesAuthorization(t *testing.T) {
+	var captured string
+	handler := NewUpstreamTokenMiddleware("users", newTestSigner(t)).Handler(captureAuthorization(&captured))
+
+	req := httptest.NewRequest("GET", "/api/users", nil)
+	req.Header.Set("Authorization", "Bearer caller-token")
+	claims := &auth.IntrospectionResponse{Active: true, Subject: "user-1"}
+	req = req.WithContext(context.WithValue(req.Context(), TokenClaimsKey, claims))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	token, ok := strings.CutPrefix(captured, "Bearer ")
+	if !ok || token == "caller-token" || strings.Count(token, ".") != 2 {
+		t.Fatalf("expected a minted JWT upstream, got %q", captured)
+	}
+}
+
+func TestUpstreamTokenStripsAuthorizationWithoutClaims(t *testing.T) {
+	var captured string
+	handler := NewUpstreamTokenMiddleware("public", newTestSigner(t)).Handler(captureAuthorization(&captured))
+
+	req := httptest.NewRequest("GET", "/public", nil)
+	req.Header.Set("Authorization", "Bearer forged")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if captured != "" {
+		t.Fatalf("expected Authorization to be removed, got %q", captured)
+	}
+}
+
+func TestUpstreamTokenWithoutSignerPassesThrough(t *testing.T) {
+	var captured string
+	handler := NewUpstreamTokenMiddleware("users", nil).Handler(captureAuthorization(&captured))
+
+	req := httptest.NewRequest("GET", "/api/users", nil)
+	req.Header.Set("Authorization", "Bearer caller-token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if captured != "Bearer caller-token" {
+		t.Fatalf("expected Authorization to be forwarded unchanged, got %q", captured)
+	}
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// WebSocketTokenMiddleware moves the access token of a WebSocket handshake from the
+// route's configured query parameter or subprotocol into the Authorization header, so
+// the auth middleware validates it like any other bearer token. The token is removed
+// from where it was found and never reaches the upstream there.
+type WebSocketTokenMiddleware struct {
+	config *config.WebSocketConfig
+}
+
+// NewWebSocketTokenMiddleware creates the middleware; a nil config disables it
+func NewWebSocketTokenMiddleware(cfg *config.WebSocketConfig) *WebSocketTokenMiddleware {
+	return &WebSocketTokenMiddleware{config: cfg}
+}
+
+// Handler returns an HTTP handler that rewrites handshakes without an Authorization header
+func (m *WebSocketTokenMiddleware) Handler(next http.Handler) http.Handler {
+	if m.config == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWebSocketUpgrade(r) && r.Header.Get("Authorization") == "" {
+			r = r.Clone(r.Context())
+			if token := m.takeToken(r); token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// takeToken removes the token from the query or the subprotocol list and returns it
+func (m *WebSocketTokenMiddleware) takeToken(r *http.Request) string {
+	if name := m.config.TokenQueryParam; name != "" {
+		query := r.URL.Query()
+		if token := query.Get(name); token != "" {
+			query.Del(name)
+			r.URL.RawQuery = query.Encode()
+			return token
+		}
+	}
+
+	prefix := m.config.TokenSubprotocolPrefix
+	if prefix == "" {
+		return ""
+	}
+	var token string
+	var kept []string
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(value, ",") {
+			protocol = strings.TrimSpace(protocol)
+			switch {
+			case protocol == "":
+			case strings.HasPrefix(protocol, prefix):
+				// Never forward token-bearing subprotocols, even a second one
+				if token == "" {
+					token = strings.TrimPrefix(protocol, prefix)
+				}
+			default:
+				kept = append(kept, protocol)
+			}
+		}
+	}
+	if token == "" {
+		return ""
+	}
+	r.Header.Del("Sec-WebSocket-Protocol")
+	if len(kept) > 0 {
+		r.Header.Set("Sec-WebSocket-Protocol", strings.Join(kept, ", "))
+	}
+	return token
+}
+
+// isUpgrade reports whether the request asks to switch protocols
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, option := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(option), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isWebSocketUpgrade reports whether the request is a WebSocket handshake
+func isWebSocketUpgrade(r *http.Request) bool {
+	return isUpgrade(r) && strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// handshake builds a WebSocket upgrade request
+func handshake(target string) *http.Request {
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	return req
+}
+
+// forwarded runs req through the middleware and returns the request the next handler saw
+func forwarded(t *testing.T, cfg *config.WebSocketConfig, req *http.Request) *http.Request {
+	t.Helper()
+	var seen *http.Request
+	NewWebSocketTokenMiddleware(cfg).Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		seen = r
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	return seen
+}
+
+func TestWebSocketTokenMiddlewareTakesTokenFromQuery(t *testing.T) {
+	req := forwarded(t, &config.WebSocketConfig{TokenQueryParam: "access_token"}, handshake("/ws?room=1&access_token=abc"))
+
+	if got := req.Header.Get("Authorization"); got != "Bearer abc" {
+		t.Fatalf("expected the query token in Authorization, got %q", got)
+	}
+	if req.URL.RawQuery != "room=1" {
+		t.Fatalf("expected the token to be removed from the query, got %q", req.URL.RawQuery)
+	}
+}
+
+func TestWebSocketTokenMiddlewareTakesTokenFromSubprotocol(t *testing.T) {
+	req := handshake("/ws")
+	req.Header.Set("Sec-WebSocket-Protocol", "chat, bearer.abc.def")
+	req = forwarded(t, &config.WebSocketConfig{TokenSubprotocolPrefix: "bearer."}, req)
+
+	if got := req.Header.Get("Authorization"); got != "Bearer abc.def" {
+		t.Fatalf("expected the subprotocol token in Authorization, got %q", got)
+	}
+	if got := req.Header.Get("Sec-WebSocket-Protocol"); got != "chat" {
+		t.Fatalf("expected only the real subprotocols to be forwarded, got %q", got)
+	}
+}
+
+func TestWebSocketTokenMiddlewareLeavesOtherRequestsAlone(t *testing.T) {
+	cfg := &config.WebSocketConfig{TokenQueryParam: "access_token"}
+
+	plain := forwarded(t, cfg, httptest.NewRequest("GET", "/ws?access_token=abc", nil))
+	if plain.Header.Get("Authorization") != "" || plain.URL.RawQuery != "access_token=abc" {
+		t.Fatalf("expected a non-upgrade request to pass unchanged, got %q %q", plain.Header.Get("Authorization"), plain.URL.RawQuery)
+	}
+
+	req := handshake("/ws?access_token=abc")
+	req.Header.Set("Authorization", "Bearer from-header")
+	withHeader := forwarded(t, cfg, req)
+	if withHeader.Header.Get("Authorization") != "Bearer from-header" {
+		t.Fatalf("expected the Authorization header to take precedence, got %q", withHeader.Header.Get("Authorization"))
+	}
+}
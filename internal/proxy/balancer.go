@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// Target is one upstream replica of a route
+type Target struct {
+	URL    *url.URL
+	Weight int
+
+	outstanding atomic.Int64
+	health      targetHealth
+	breaker     *circuitBreaker // nil when the route has no circuit breaker
+}
+
+// newTarget parses a configured upstream target
+func newTarget(cfg config.UpstreamTarget) (*Target, error) {
+	upstreamURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	weight := cfg.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	target := &Target{URL: upstreamURL, Weight: weight}
+	target.health.healthy.Store(true)
+	return target, nil
+}
+
+// Outstanding returns the number of requests currently in flight to the target
+func (t *Target) Outstanding() int64 {
+	return t.outstanding.Load()
+}
+
+// load returns outstanding requests scaled by weight, so heavier targets absorb more
+func (t *Target) load() float64 {
+	return float64(t.outstanding.Load()) / float64(t.Weight)
+}
+
+// Balancer picks one target per request from the given candidates.
+// Candidates are never empty and may be a subset of the route's targets.
+type Balancer interface {
+	Pick(r *http.Request, candidates []*Target) *Target
+}
+
+// NewBalancer creates the balancer for a route's load-balancing policy
+func NewBalancer(cfg config.LoadBalancingConfig, targets []*Target) (Balancer, error) {
+	switch cfg.Policy {
+	case "", config.PolicyRoundRobin:
+		return &roundRobin{}, nil
+	case config.PolicyWeightedRoundRobin:
+		return &weightedRoundRobin{current: make(map[*Target]int)}, nil
+	case config.PolicyLeastOutstanding:
+		return leastOutstanding{}, nil
+	case config.PolicyRandomTwoChoices:
+		return randomTwoChoices{}, nil
+	case config.PolicyConsistentHash:
+		return newConsistentHash(cfg, targets), nil
+	default:
+		return nil, fmt.Errorf("unsupported load-balancing policy %q", cfg.Policy)
+	}
+}
+
+// roundRobin cycles through candidates ignoring weights
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (b *roundRobin) Pick(_ *http.Request, candidates []*Target) *Target {
+	n := b.next.Add(1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// weightedRoundRobin is the smooth weighted round-robin used by nginx:
+// every pick raises each candidate by its weight and lowers the winner by the total,
+// which interleaves targets instead of sending bursts to the heaviest one.
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[*Target]int
+}
+
+func (b *weightedRoundRobin) Pick(_ *http.Request, candidates []*Target) *Target {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best *Target
+	total := 0
+	for _, t := range candidates {
+		b.current[t] += t.Weight
+		total += t.Weight
+		if best == nil || b.current[t] > b.current[best] {
+			best = t
+		}
+	}
+	b.current[best] -= total
+	return best
+}
+
+// leastOutstanding picks the candidate with the fewest in-flight requests per unit of weight
+type leastOutstanding struct{}
+
+func (leastOutstanding) Pick(_ *http.Request, candidates []*Target) *Target {
+	best := candidates[0]
+	for _, t := range candidates[1:] {
+		if t.load() < best.load() {
+			best = t
+		}
+	}
+	return best
+}
+
+// randomTwoChoices samples two distinct candidates and keeps the less loaded one
+type randomTwoChoices struct{}
+
+func (randomTwoChoices) Pick(_ *http.Request, candidates []*Target) *Target {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := rand.IntN(len(candidates))
+	j := rand.IntN(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := candidates[i], candidates[j]
+	if b.load() < a.load() {
+		return b
+	}
+	return a
+}
+
+// replicasPerWeight is the number of points each unit of weight gets on the hash ring
+const replicasPerWeight = 100
+
+type ringPoint struct {
+	hash   uint32
+	target *Target
+}
+
+// consistentHash maps a header or cookie value onto a ring of targets.
+// When a target is not a candidate (for example ejected), its keys move to the
+// next target on the ring while all other keys stay where they were.
+type consistentHash struct {
+	header   string
+	cookie   string
+	ring     []ringPoint
+	fallback roundRobin
+}
+
+func newConsistentHash(cfg config.LoadBalancingConfig, targets []*Target) *consistentHash {
+	b := &consistentHash{header: cfg.HashHeader, cookie: cfg.HashCookie}
+	for _, t := range targets {
+		for i := 0; i < t.Weight*replicasPerWeight; i++ {
+			b.ring = append(b.ring, ringPoint{hash: hashKey(t.URL.String() + "#" + strconv.Itoa(i)), target: t})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+	return b
+}
+
+func (b *consistentHash) Pick(r *http.Request, candidates []*Target) *Target {
+	key := b.key(r)
+	if key == "" || len(b.ring) == 0 {
+		return b.fallback.Pick(r, candidates)
+	}
+
+	h := hashKey(key)
+	start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+	for i := 0; i < len(b.ring); i++ {
+		point := b.ring[(start+i)%len(b.ring)]
+		for _, t := range candidates {
+			if t == point.target {
+				return t
+			}
+		}
+	}
+	return b.fallback.Pick(r, candidates)
+}
+
+// key extracts the configured hash key from the request
+func (b *consistentHash) key(r *http.Request) string {
+	if b.header != "" {
+		return r.Header.Get(b.header)
+	}
+	if cookie, err := r.Cookie(b.cookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+func testTargets(t *testing.T, weights ...int) []*Target {
+	t.Helper()
+	targets := make([]*Target, len(weights))
+	for i, w := range weights {
+		target, err := newTarget(config.UpstreamTarget{URL: fmt.Sprintf("http://backend-%d:8080", i), Weight: w})
+		if err != nil {
+			t.Fatalf("newTarget: %v", err)
+		}
+		targets[i] = target
+	}
+	return targets
+}
+
+func newTestBalancer(t *testing.T, cfg config.LoadBalancingConfig, targets []*Target) Balancer {
+	t.Helper()
+	b, err := NewBalancer(cfg, targets)
+	if err != nil {
+		t.Fatalf("NewBalancer: %v", err)
+	}
+	return b
+}
+
+func TestRoundRobinCyclesThroughTargets(t *testing.T) {
+	targets := testTargets(t, 1, 1, 1)
+	b := newTestBalancer(t, config.LoadBalancingConfig{Policy: config.PolicyRoundRobin}, targets)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	for i := 0; i < 6; i++ {
+		if got := b.Pick(req, targets); got != targets[i%3] {
+			t.Fatalf("pick %d: expected target %d", i, i%3)
+		}
+	}
+}
+
+func TestWeightedRoundRobinHonoursWeightsAndInterleaves(t *testing.T) {
+	targets := testTargets(t, 3, 1)
+	b := newTestBalancer(t, config.LoadBalancingConfig{Policy: config.PolicyWeightedRoundRobin}, targets)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	counts := map[*Target]int{}
+	var sequence []*Target
+	for i := 0; i < 8; i++ {
+		picked := b.Pick(req, targets)
+		counts[picked]++
+		sequence = append(sequence, picked)
+	}
+	if counts[targets[0]] != 6 || counts[targets[1]] != 2 {
+		t.Fatalf("expected 6:2 split, got %d:%d", counts[targets[0]], counts[targets[1]])
+	}
+	// Smooth WRR never sends more than 3 in a row to the heavy target with weights 3:1
+	run := 0
+	for _, picked := range sequence {
+		if picked == targets[0] {
+			run++
+		} else {
+			run = 0
+		}
+		if run > 3 {
+			t.Fatalf("expected interleaved picks, got a run of %d", run)
+		}
+	}
+}
+
+func TestLeastOutstandingPicksLeastLoadedTarget(t *testing.T) {
+	targets := testTargets(t, 1, 1, 2)
+	targets[0].outstanding.Store(3)
+	targets[1].outstanding.Store(1)
+	targets[2].outstanding.Store(4) // weight 2 -> load 2
+	b := newTestBalancer(t, config.LoadBalancingConfig{Policy: config.PolicyLeastOutstanding}, targets)
+
+	if got := b.Pick(httptest.NewRequest("GET", "/", nil), targets); got != targets[1] {
+		t.Fatalf("expected least loaded target, got %s", got.URL)
+	}
+}
+
+func TestRandomTwoChoicesAvoidsBusiestTarget(t *testing.T) {
+	targets := testTargets(t, 1, 1)
+	targets[0].outstanding.Store(10)
+	b := newTestBalancer(t, config.LoadBalancingConfig{Policy: config.PolicyRandomTwoChoices}, targets)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	for i := 0; i < 20; i++ {
+		if got := b.Pick(req, targets); got != targets[1] {
+			t.Fatal("expected the idle target to win every two-choice comparison")
+		}
+	}
+	if got := b.Pick(req, targets[:1]); got != targets[0] {
+		t.Fatal("expected single candidate to be returned")
+	}
+}
+
+func TestConsistentHashIsStablePerKey(t *testing.T) {
+	targets := testTargets(t, 1, 1, 1)
+	b := newTestBalancer(t, config.LoadBalancingConfig{Policy: config.PolicyConsistentHash, HashHeader: "X-User"}, targets)
+
+	seen := map[*Target]bool{}
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", fmt.Sprintf("user-%d", i))
+		first := b.Pick(req, targets)
+		if again := b.Pick(req, targets); again != first {
+			t.Fatalf("expected key user-%d to stick to one target", i)
+		}
+		seen[first] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected keys to spread over all targets, got %d", len(seen))
+	}
+}
+
+func TestConsistentHashOnlyMovesKeysOfMissingTarget(t *testing.T) {
+	targets := testTargets(t, 1, 1, 1)
+	b := newTestBalancer(t, config.LoadBalancingConfig{Policy: config.PolicyConsistentHash, HashCookie: "session"}, targets)
+	remaining := []*Target{targets[0], targets[2]}
+
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: fmt.Sprintf("s-%d", i)})
+		before := b.Pick(req, targets)
+		after := b.Pick(req, remaining)
+		if after == targets[1] {
+			t.Fatal("expected missing target never to be picked")
+		}
+		if before != targets[1] && after != before {
+			t.Fatalf("expected key s-%d to stay on its target", i)
+		}
+	}
+}
+
+func TestConsistentHashFallsBackWithoutKey(t *testing.T) {
+	targets := testTargets(t, 1, 1)
+	b := newTestBalancer(t, config.LoadBalancingConfig{Policy: config.PolicyConsistentHash, HashHeader: "X-User"}, targets)
+
+	if got := b.Pick(httptest.NewRequest("GET", "/", nil), targets); got == nil {
+		t.Fatal("expected a target when the hash key is missing")
+	}
+}
+
+func TestNewBalancerRejectsUnknownPolicy(t *testing.T) {
+	if _, err := NewBalancer(config.LoadBalancingConfig{Policy: "fastest"}, nil); err == nil {
+		t.Fatal("expected error for unknown policy")
+	}
+}
+
+func TestProxySpreadsRequestsAcrossUpstreams(t *testing.T) {
+	hits := map[string]int{}
+	newBackend := func(name string) *httptest.Server {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[name]++
+			if r.URL.Path != "/base/users" {
+				t.Errorf("expected target base path to be joined, got %s", r.URL.Path)
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(backend.Close)
+		return backend
+	}
+	a := newBackend("a")
+	b := newBackend("b")
+
+	route := &config.RouteConfig{
+		Name: "users",
+		Upstreams: []config.UpstreamTarget{
+			{URL: a.URL + "/base", Weight: 1},
+			{URL: b.URL + "/base", Weight: 1},
+		},
+		LoadBalancing: config.LoadBalancingConfig{Policy: config.PolicyRoundRobin},
+	}
+	p, err := NewProxyWithTransport(route, NewTransportPool())
+	if err != nil {
+		t.Fatalf("NewProxyWithTransport: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "http://gateway/users", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rec.Code)
+		}
+	}
+	if hits["a"] != 2 || hits["b"] != 2 {
+		t.Fatalf("expected requests split evenly, got %v", hits)
+	}
+	for _, target := range p.Targets() {
+		if target.Outstanding() != 0 {
+			t.Fatalf("expected no outstanding requests after completion, got %d", target.Outstanding())
+		}
+	}
+}
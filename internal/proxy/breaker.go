@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+)
+
+// circuitState is the state of a target's circuit breaker; the values double as the
+// gateway_circuit_breaker_state gauge value
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitHalfOpen:
+		return "half_open"
+	case circuitOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// Error-rate window, tracked in buckets of window/breakerBuckets
+const breakerBuckets = 10
+
+// circuitBreaker stops traffic to a failing target. Closed, it counts results and opens
+// on consecutive failures or a high error rate. Open, it rejects requests until
+// OpenDuration has passed and then turns half-open, admitting HalfOpenRequests probes:
+// one failed probe opens it again, all succeeding close it. A nil breaker admits everything.
+type circuitBreaker struct {
+	cfg      *config.CircuitBreakerConfig
+	route    string
+	upstream string
+	now      func() time.Time
+
+	mu          sync.Mutex
+	state       circuitState
+	generation  uint64    // bumped on every state change so late results are ignored
+	changedAt   time.Time // when the current state was entered
+	consecutive int       // consecutive failures while closed
+	buckets     [breakerBuckets]breakerBucket
+	probes      int // probes admitted while half-open
+	successes   int // successful probes while half-open
+}
+
+type breakerBucket struct {
+	index    int64
+	requests int
+	failures int
+}
+
+// newCircuitBreaker returns nil when the route has no circuit breaker
+func newCircuitBreaker(cfg *config.CircuitBreakerConfig, routeName string, upstream *url.URL) *circuitBreaker {
+	if cfg == nil {
+		return nil
+	}
+	b := &circuitBreaker{
+		cfg:      cfg,
+		route:    routeName,
+		upstream: upstream.String(),
+		now:      time.Now,
+	}
+	metrics.CircuitBreakerState.WithLabelValues(b.route, b.upstream).Set(float64(circuitClosed))
+	return b
+}
+
+// allow reports whether a request may be sent to the target and returns the
+// generation its result must be recorded with
+func (b *circuitBreaker) allow() (uint64, bool) {
+	if b == nil {
+		return 0, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case circuitOpen:
+		if now.Sub(b.changedAt) < b.cfg.OpenDuration {
+			return 0, false
+		}
+		b.transition(circuitHalfOpen, now, "open duration elapsed")
+	case circuitHalfOpen:
+		if b.probes >= b.cfg.HalfOpenRequests {
+			if now.Sub(b.changedAt) < b.cfg.OpenDuration {
+				return 0, false
+			}
+			// Probes whose results never arrived (the client went away) must not keep
+			// the circuit half-open forever: start a fresh round of probes
+			b.generation++
+			b.changedAt = now
+			b.probes, b.successes = 0, 0
+		}
+	}
+	if b.state == circuitHalfOpen {
+		b.probes++
+	}
+	return b.generation, true
+}
+
+// record counts the result of a request admitted under generation
+func (b *circuitBreaker) record(generation uint64, failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		// Admitted before the last state change; it says nothing about the current state
+		return
+	}
+	now := b.now()
+	switch b.state {
+	case circuitHalfOpen:
+		if failed {
+			b.transition(circuitOpen, now, "probe failed")
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenRequests {
+			b.transition(circuitClosed, now, fmt.Sprintf("%d probes succeeded", b.successes))
+		}
+	case circuitClosed:
+		bucket := b.bucket(now)
+		bucket.requests++
+		if !failed {
+			b.consecutive = 0
+			return
+		}
+		bucket.failures++
+		b.consecutive++
+		if b.cfg.ConsecutiveFailures > 0 && b.consecutive >= b.cfg.ConsecutiveFailures {
+			b.transition(circuitOpen, now, fmt.Sprintf("%d consecutive failures", b.consecutive))
+			return
+		}
+		if b.cfg.ErrorRate > 0 {
+			requests, failures := b.windowCounts(now)
+			if requests >= b.cfg.MinRequests && float64(failures) >= b.cfg.ErrorRate*float64(requests) {
+				b.transition(circuitOpen, now, fmt.Sprintf("%d of %d requests failed within %s", failures, requests, b.cfg.Window))
+			}
+		}
+	}
+}
+
+// transition enters state, resetting all counters; must be called with b.mu held
+func (b *circuitBreaker) transition(state circuitState, now time.Time, reason string) {
+	b.state = state
+	b.generation++
+	b.changedAt = now
+	b.consecutive, b.probes, b.successes = 0, 0, 0
+	b.buckets = [breakerBuckets]breakerBucket{}
+
+	log.Printf("Circuit breaker for upstream %s on route %s is now %s: %s", b.upstream, b.route, state, reason)
+	metrics.CircuitBreakerState.WithLabelValues(b.route, b.upstream).Set(float64(state))
+	metrics.CircuitBreakerTransitions.WithLabelValues(b.route, b.upstream, state.String()).Inc()
+}
+
+// bucketWidth is the span of one error-rate bucket
+func (b *circuitBreaker) bucketWidth() int64 {
+	return max(int64(b.cfg.Window)/breakerBuckets, 1)
+}
+
+// bucket returns the current bucket, resetting it if it holds an old span;
+// must be called with b.mu held
+func (b *circuitBreaker) bucket(now time.Time) *breakerBucket {
+	index := now.UnixNano() / b.bucketWidth()
+	bucket := &b.buckets[index%breakerBuckets]
+	if bucket.index != index {
+		*bucket = breakerBucket{index: index}
+	}
+	return bucket
+}
+
+// windowCounts sums the buckets within the window; must be called with b.mu held
+func (b *circuitBreaker) windowCounts(now time.Time) (requests, failures int) {
+	current := now.UnixNano() / b.bucketWidth()
+	for _, bucket := range b.buckets {
+		if current-bucket.index < breakerBuckets {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+	return requests, failures
+}
+
+// State returns the breaker state as reported by the admin API
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state.String()
+}
+
+// retryAfter estimates when the breaker admits requests again
+func (b *circuitBreaker) retryAfter() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitClosed {
+		return 0
+	}
+	// A saturated half-open circuit frees up once its probes finish, at the latest
+	// after another OpenDuration
+	return max(0, b.cfg.OpenDuration-b.now().Sub(b.changedAt))
+}
+
+// admit picks a target among candidates whose circuit lets the request through. It
+// reports false when every candidate's circuit rejects it.
+func (p *Proxy) admit(r *http.Request, candidates []*Target) (*Target, uint64, bool) {
+	for len(candidates) > 0 {
+		target := p.balancer.Pick(r, candidates)
+		if generation, ok := target.breaker.allow(); ok {
+			return target, generation, true
+		}
+		remaining := make([]*Target, 0, len(candidates)-1)
+		for _, candidate := range candidates {
+			if candidate != target {
+				remaining = append(remaining, candidate)
+			}
+		}
+		candidates = remaining
+	}
+	return nil, 0, false
+}
+
+// circuitOpenResponse is the body sent when every target's circuit is open
+type circuitOpenResponse struct {
+	Error      string `json:"error"`
+	Message    string `json:"message"`
+	Route      string `json:"route"`
+	RetryAfter int    `json:"retryAfter"` // seconds
+}
+
+// rejectOpenCircuit fails fast with 503 when no candidate's circuit admits the request
+func (p *Proxy) rejectOpenCircuit(w http.ResponseWriter, candidates []*Target) {
+	metrics.UpstreamErrors.WithLabelValues(p.route.Name, "circuit_open").Inc()
+
+	wait := time.Duration(math.MaxInt64)
+	for _, target := range candidates {
+		wait = min(wait, target.breaker.retryAfter())
+	}
+	seconds := max(1, int(math.Ceil(wait.Seconds())))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(circuitOpenResponse{
+		Error:      "circuit_open",
+		Message:    "Upstream is failing; requests are rejected until it recovers",
+		Route:      p.route.Name,
+		RetryAfter: seconds,
+	})
+}
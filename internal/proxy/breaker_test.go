@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+)
+
+// newTestBreaker returns a breaker with a controllable clock
+func newTestBreaker(t *testing.T, cfg config.CircuitBreakerConfig) (*circuitBreaker, *time.Time) {
+	t.Helper()
+	upstream, _ := url.Parse("http://breaker.test:8080")
+	b := newCircuitBreaker(&cfg, "breaker-"+t.Name(), upstream)
+	now := time.Unix(1_700_000_000, 0)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+// fail records n failed requests, each admitted separately
+func fail(t *testing.T, b *circuitBreaker, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		generation, ok := b.allow()
+		if !ok {
+			t.Fatalf("request %d was rejected", i+1)
+		}
+		b.record(generation, true)
+	}
+}
+
+func TestCircuitBreakerOpensOnConsecutiveFailures(t *testing.T) {
+	b, _ := newTestBreaker(t, config.CircuitBreakerConfig{ConsecutiveFailures: 3, OpenDuration: time.Second, HalfOpenRequests: 1})
+	opened := metrics.CircuitBreakerTransitions.WithLabelValues(b.route, b.upstream, "open")
+
+	fail(t, b, 2)
+	generation, _ := b.allow()
+	b.record(generation, false) // a success resets the streak
+	fail(t, b, 2)
+	if b.State() != "closed" {
+		t.Fatalf("expected the circuit to stay closed, got %s", b.State())
+	}
+
+	fail(t, b, 1)
+	if b.State() != "open" {
+		t.Fatalf("expected the circuit to open after 3 consecutive failures, got %s", b.State())
+	}
+	if _, ok := b.allow(); ok {
+		t.Fatal("expected an open circuit to reject requests")
+	}
+	if opened.Value() != 1 {
+		t.Fatalf("expected one transition to open, got %v", opened.Value())
+	}
+	if got := metrics.CircuitBreakerState.WithLabelValues(b.route, b.upstream).Value(); got != float64(circuitOpen) {
+		t.Fatalf("expected the state gauge to report open, got %v", got)
+	}
+}
+
+func TestCircuitBreakerOpensOnErrorRate(t *testing.T) {
+	b, now := newTestBreaker(t, config.CircuitBreakerConfig{
+		ErrorRate: 0.5, MinRequests: 10, Window: 10 * time.Second, OpenDuration: time.Second, HalfOpenRequests: 1,
+	})
+	record := func(failed bool) {
+		generation, ok := b.allow()
+		if !ok {
+			t.Fatal("request was rejected")
+		}
+		b.record(generation, failed)
+	}
+
+	// Failures that age out of the window do not count
+	for i := 0; i < 4; i++ {
+		record(true)
+	}
+	*now = now.Add(11 * time.Second)
+
+	for i := 0; i < 5; i++ {
+		record(false)
+		record(true)
+		if i < 4 && b.State() != "closed" {
+			t.Fatalf("expected the circuit to stay closed below min_requests, got %s", b.State())
+		}
+	}
+	if b.State() != "open" {
+		t.Fatalf("expected the circuit to open at a 50%% error rate over 10 requests, got %s", b.State())
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	b, now := newTestBreaker(t, config.CircuitBreakerConfig{ConsecutiveFailures: 1, OpenDuration: 5 * time.Second, HalfOpenRequests: 2})
+	fail(t, b, 1)
+
+	*now = now.Add(4 * time.Second)
+	if _, ok := b.allow(); ok {
+		t.Fatal("expected the circuit to stay open until open_duration has passed")
+	}
+	if got := b.retryAfter(); got != time.Second {
+		t.Fatalf("expected retryAfter 1s, got %s", got)
+	}
+
+	// Half-open admits exactly half_open_requests probes; a failed one reopens the circuit
+	*now = now.Add(time.Second)
+	first, ok := b.allow()
+	if !ok || b.State() != "half_open" {
+		t.Fatalf("expected a probe in half-open, got ok=%v state=%s", ok, b.State())
+	}
+	if _, ok := b.allow(); !ok {
+		t.Fatal("expected a second probe to be admitted")
+	}
+	if _, ok := b.allow(); ok {
+		t.Fatal("expected a third probe to be rejected")
+	}
+	b.record(first, true)
+	if b.State() != "open" {
+		t.Fatalf("expected a failed probe to reopen the circuit, got %s", b.State())
+	}
+
+	// All probes succeeding closes it
+	*now = now.Add(5 * time.Second)
+	first, _ = b.allow()
+	second, _ := b.allow()
+	b.record(first, false)
+	if b.State() != "half_open" {
+		t.Fatalf("expected the circuit to wait for every probe, got %s", b.State())
+	}
+	b.record(second, false)
+	if b.State() != "closed" {
+		t.Fatalf("expected the circuit to close after successful probes, got %s", b.State())
+	}
+}
+
+func TestCircuitBreakerIgnoresResultsFromEarlierStates(t *testing.T) {
+	b, now := newTestBreaker(t, config.CircuitBreakerConfig{ConsecutiveFailures: 1, OpenDuration: time.Second, HalfOpenRequests: 1})
+	slow, _ := b.allow() // admitted while closed, finishes after the circuit opened
+	fail(t, b, 1)
+
+	*now = now.Add(time.Second)
+	probe, _ := b.allow()
+	b.record(slow, true)
+	if b.State() != "half_open" {
+		t.Fatalf("expected a stale failure to be ignored, got %s", b.State())
+	}
+	b.record(probe, false)
+	if b.State() != "closed" {
+		t.Fatalf("expected the probe to close the circuit, got %s", b.State())
+	}
+}
+
+func TestCircuitBreakerRecoversFromLostProbes(t *testing.T) {
+	b, now := newTestBreaker(t, config.CircuitBreakerConfig{ConsecutiveFailures: 1, OpenDuration: time.Second, HalfOpenRequests: 1})
+	fail(t, b, 1)
+	*now = now.Add(time.Second)
+	b.allow() // probe whose result never arrives
+
+	if _, ok := b.allow(); ok {
+		t.Fatal("expected half-open to reject requests beyond the probes")
+	}
+	*now = now.Add(time.Second)
+	probe, ok := b.allow()
+	if !ok {
+		t.Fatal("expected a new probe once the lost one timed out")
+	}
+	b.record(probe, false)
+	if b.State() != "closed" {
+		t.Fatalf("expected the circuit to close, got %s", b.State())
+	}
+}
+
+func TestProxyFailsFastWhenCircuitIsOpen(t *testing.T) {
+	backend, calls := flakyBackend(t, 100, http.StatusInternalServerError)
+	route := &config.RouteConfig{
+		Name:           "breaker-route",
+		Upstream:       backend.URL,
+		CircuitBreaker: &config.CircuitBreakerConfig{ConsecutiveFailures: 2, OpenDuration: time.Minute, HalfOpenRequests: 1},
+	}
+	p, err := NewProxy(route)
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+	before := metrics.UpstreamErrors.WithLabelValues("breaker-route", "circuit_open").Value()
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "http://gateway/", nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("expected the upstream 500, got %d", rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "http://gateway/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while the circuit is open, got %d", rec.Code)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected the open circuit to keep the request from the upstream, got %d calls", calls.Load())
+	}
+	if rec.Header().Get("Content-Type") != "application/json" || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("unexpected headers: %v", rec.Header())
+	}
+	var body circuitOpenResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected a JSON body: %v", err)
+	}
+	if body.Error != "circuit_open" || body.Route != "breaker-route" || body.RetryAfter != 60 {
+		t.Fatalf("unexpected body: %+v", body)
+	}
+	if got := metrics.UpstreamErrors.WithLabelValues("breaker-route", "circuit_open").Value() - before; got != 1 {
+		t.Fatalf("expected one circuit_open error, got %v", got)
+	}
+	if status := p.Targets()[0].Status(); status.Circuit != "open" {
+		t.Fatalf("expected the target status to report the open circuit, got %q", status.Circuit)
+	}
+}
+
+func TestProxyRoutesAroundOpenCircuit(t *testing.T) {
+	broken, brokenCalls := flakyBackend(t, 100, http.StatusBadGateway)
+	healthy, healthyCalls := flakyBackend(t, 0, 0)
+	route := &config.RouteConfig{
+		Name:           "breaker-failover",
+		Upstreams:      []config.UpstreamTarget{{URL: broken.URL}, {URL: healthy.URL}},
+		CircuitBreaker: &config.CircuitBreakerConfig{ConsecutiveFailures: 1, OpenDuration: time.Minute, HalfOpenRequests: 1},
+	}
+	p, err := NewProxy(route)
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+
+	for i := 0; i < 6; i++ {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://gateway/", nil))
+	}
+	if brokenCalls.Load() != 1 || healthyCalls.Load() != 5 {
+		t.Fatalf("expected traffic to move off the broken target, got broken=%d healthy=%d",
+			brokenCalls.Load(), healthyCalls.Load())
+	}
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/middleware"
+)
+
+// headerBlocks returns the header blocks that apply to a request with the given
+// method: the top-level block, the route's and those of every rule matching the method
+func (p *Proxy) headerBlocks(method string) []*config.HeadersConfig {
+	var blocks []*config.HeadersConfig
+	if p.route.GatewayHeaders != nil {
+		blocks = append(blocks, p.route.GatewayHeaders)
+	}
+	if p.route.Headers != nil {
+		blocks = append(blocks, p.route.Headers)
+	}
+	for i := range p.route.Rules {
+		rule := &p.route.Rules[i]
+		if rule.Headers != nil && ruleMatchesMethod(rule, method) {
+			blocks = append(blocks, rule.Headers)
+		}
+	}
+	return blocks
+}
+
+// ruleMatchesMethod reports whether a rule applies to method, as the router decides it
+func ruleMatchesMethod(rule *config.RouteRule, method string) bool {
+	if len(rule.Methods) == 0 {
+		return true
+	}
+	for _, m := range rule.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// transformRequestHeaders applies the request transforms of every header block to
+// the request about to be sent upstream
+func (p *Proxy) transformRequestHeaders(req *http.Request) {
+	var value func(config.TemplatePart) string
+	for _, block := range p.headerBlocks(req.Method) {
+		if block.Request == nil {
+			continue
+		}
+		if value == nil {
+			value = p.templateValue(req)
+		}
+		applyHeaderTransform(req.Header, block.Request, value)
+	}
+}
+
+// transformResponseHeaders applies the response transforms of every header block to
+// the upstream's response
+func (p *Proxy) transformResponseHeaders(resp *http.Response) {
+	var value func(config.TemplatePart) string
+	for _, block := range p.headerBlocks(resp.Request.Method) {
+		if block.Response == nil {
+			continue
+		}
+		if value == nil {
+			value = p.templateValue(resp.Request)
+		}
+		applyHeaderTransform(resp.Header, block.Response, value)
+	}
+}
+
+// templateValue resolves header template placeholders for req: the route's captures,
+// the route name, the address of the client's connection and the caller's token
+// claims. The address never comes from X-Forwarded-For, which the client controls.
+func (p *Proxy) templateValue(req *http.Request) func(config.TemplatePart) string {
+	captures := captureValue(p.captures(req), false)
+	claims := middleware.GetTokenClaims(req)
+	return func(part config.TemplatePart) string {
+		switch {
+		case part.Var == config.TemplateVarRouteName:
+			return p.route.Name
+		case part.Var == config.TemplateVarClientIP:
+			return middleware.RemoteIP(req)
+		case strings.HasPrefix(part.Var, config.TemplateVarClaimPrefix):
+			if claims == nil {
+				return ""
+			}
+			claim, ok := claims.Claim(strings.TrimPrefix(part.Var, config.TemplateVarClaimPrefix))
+			if !ok {
+				return ""
+			}
+			s, _ := claimHeaderValue(claim)
+			return s
+		}
+		return captures(part)
+	}
+}
+
+// applyHeaderTransform removes, renames, sets and adds headers in that order. Values
+// that expand to an empty string are skipped, as are values no header may carry.
+func applyHeaderTransform(h http.Header, t *config.HeaderTransform, value func(config.TemplatePart) string) {
+	for _, name := range t.Remove {
+		h.Del(name)
+	}
+	for _, rename := range t.CompiledRename {
+		if values, ok := h[rename.From]; ok {
+			delete(h, rename.From)
+			h[rename.To] = values
+		}
+	}
+	for _, header := range t.CompiledSet {
+		if s, ok := expandHeaderValue(header, value); ok {
+			h.Set(header.Name, s)
+		}
+	}
+	for _, header := range t.CompiledAdd {
+		if s, ok := expandHeaderValue(header, value); ok {
+			h.Add(header.Name, s)
+		}
+	}
+}
+
+// expandHeaderValue fills in a header's template and reports whether the result can be sent
+func expandHeaderValue(header config.HeaderValue, value func(config.TemplatePart) string) (string, bool) {
+	s := expand(header.Value, value)
+	if s == "" {
+		return "", false
+	}
+	if !validHeaderValue(s) {
+		log.Printf("Not setting header %s: value contains control characters", header.Name)
+		return "", false
+	}
+	return s, true
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/aveiga/cloud-api-gateway/internal/auth"
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/middleware"
+	"github.com/aveiga/cloud-api-gateway/internal/router"
+)
+
+func TestProxyTransformsRequestHeaders(t *testing.T) {
+	backend, captured := captureHeaders(t)
+	route := &config.RouteConfig{
+		Name:            "partner",
+		CompiledPattern: regexp.MustCompile(`(?i)^/partners/(?P<id>\d+)$`),
+		Upstream:        backend.URL + "/v2",
+		GatewayHeaders: &config.HeadersConfig{Request: &config.HeaderTransform{
+			CompiledSet: []config.HeaderValue{{Name: "X-Gateway", Value: mustTemplate(t, "edge")}},
+		}},
+		Headers: &config.HeadersConfig{Request: &config.HeaderTransform{
+			Remove:         []string{"X-Debug"},
+			CompiledRename: []config.HeaderRename{{From: "X-Client-Version", To: "X-App-Version"}},
+			CompiledSet: []config.HeaderValue{
+				{Name: "X-Api-Key", Value: mustTemplate(t, "static-key")},
+				{Name: "X-Gateway", Value: mustTemplate(t, "edge/{route.name}")},
+				{Name: "X-Partner", Value: mustTemplate(t, "{id}")},
+			},
+			CompiledAdd: []config.HeaderValue{
+				{Name: "X-Caller", Value: mustTemplate(t, "{claims.sub}@{client.ip}")},
+				{Name: "X-Tenant", Value: mustTemplate(t, "{claims.org.id}")},
+			},
+		}},
+		Rules: []config.RouteRule{
+			{Methods: []string{"GET"}, Headers: &config.HeadersConfig{Request: &config.HeaderTransform{
+				CompiledSet: []config.HeaderValue{{Name: "X-Read", Value: mustTemplate(t, "1")}},
+			}}},
+			{Methods: []string{"POST"}, Headers: &config.HeadersConfig{Request: &config.HeaderTransform{
+				CompiledSet: []config.HeaderValue{{Name: "X-Write", Value: mustTemplate(t, "1")}},
+			}}},
+		},
+	}
+	p, err := NewProxy(route)
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+	defer p.Close()
+
+	var claims auth.IntrospectionResponse
+	if err := json.Unmarshal([]byte(`{"active":true,"sub":"u-1"}`), &claims); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	req := httptest.NewRequest("GET", "http://gateway/partners/42", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	req.Header.Set("X-Debug", "1")
+	req.Header.Set("X-Client-Version", "3.1")
+	req.Header.Set("X-Caller", "spoofed")
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.TokenClaimsKey, &claims))
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := map[string][]string{
+		"X-Debug":          nil,
+		"X-Client-Version": nil,
+		"X-App-Version":    {"3.1"},
+		"X-Api-Key":        {"static-key"},
+		"X-Gateway":        {"edge/partner"},
+		"X-Partner":        {"42"},
+		"X-Caller":         {"spoofed", "u-1@203.0.113.7"}, // the connection address, not X-Forwarded-For
+		"X-Tenant":         nil,                            // the claim is missing, so the value is empty
+		"X-Read":           {"1"},
+		"X-Write":          nil,
+	}
+	for name, values := range want {
+		if got := (*captured)[name]; len(got) != len(values) || (len(values) > 0 && got[len(got)-1] != values[len(values)-1]) {
+			t.Errorf("%s: expected %q, got %q", name, values, got)
+		}
+	}
+}
+
+func TestProxyTransformsResponseHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.25")
+		w.Header().Set("X-Powered-By", "PHP/8.3")
+		w.Header().Set("X-Upstream-Id", "abc")
+	}))
+	defer backend.Close()
+
+	segments, _ := config.CompilePathTemplate("/users/{id}")
+	route := &config.RouteConfig{
+		Name:         "users",
+		CompiledPath: segments,
+		Upstream:     backend.URL,
+		GatewayHeaders: &config.HeadersConfig{Response: &config.HeaderTransform{
+			Remove:      []string{"Server", "X-Powered-By"},
+			CompiledSet: []config.HeaderValue{{Name: "X-Content-Type-Options", Value: mustTemplate(t, "nosniff")}},
+		}},
+		Headers: &config.HeadersConfig{Response: &config.HeaderTransform{
+			CompiledRename: []config.HeaderRename{{From: "X-Upstream-Id", To: "X-Request-Source"}},
+			CompiledAdd:    []config.HeaderValue{{Name: "X-Route", Value: mustTemplate(t, "{route.name}/{id}")}},
+		}},
+		Rules: []config.RouteRule{{Methods: []string{"GET"}}},
+	}
+	p, err := NewProxy(route)
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+	defer p.Close()
+
+	req := httptest.NewRequest("GET", "http://gateway/users/7", nil)
+	_, _, params := router.NewRouter([]config.RouteConfig{*route}, nil).MatchRoute(req)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req.WithContext(router.WithParams(req.Context(), params)))
+
+	h := rec.Header()
+	if h.Get("Server") != "" || h.Get("X-Powered-By") != "" {
+		t.Fatalf("expected Server and X-Powered-By to be stripped, got %v", h)
+	}
+	if h.Get("X-Content-Type-Options") != "nosniff" || h.Get("X-Request-Source") != "abc" || h.Get("X-Upstream-Id") != "" {
+		t.Fatalf("expected headers to be set and renamed, got %v", h)
+	}
+	if h.Get("X-Route") != "users/7" {
+		t.Fatalf("expected the template to use the route name and path params, got %q", h.Get("X-Route"))
+	}
+}
+
+func TestApplyHeaderTransformSkipsUnsafeValues(t *testing.T) {
+	h := http.Header{}
+	transform := &config.HeaderTransform{
+		CompiledSet: []config.HeaderValue{{Name: "X-Id", Value: mustTemplate(t, "{id}")}},
+	}
+	applyHeaderTransform(h, transform, func(config.TemplatePart) string { return "a\r\nX-Injected: 1" })
+	if len(h) != 0 {
+		t.Fatalf("expected a value with line breaks to be dropped, got %v", h)
+	}
+}
@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// targetHealth combines the active check verdict with passive outlier ejection
+type targetHealth struct {
+	healthy      atomic.Bool  // verdict of active checks; true until proven otherwise
+	ejectedUntil atomic.Int64 // unix nanoseconds; zero when not ejected
+	failures     atomic.Int64 // consecutive passive failures
+}
+
+// Healthy reports the verdict of active health checks
+func (t *Target) Healthy() bool {
+	return t.health.healthy.Load()
+}
+
+// EjectedUntil returns when passive ejection ends, or the zero time if the target is not ejected
+func (t *Target) EjectedUntil() time.Time {
+	until := t.health.ejectedUntil.Load()
+	if until == 0 || time.Now().UnixNano() >= until {
+		return time.Time{}
+	}
+	return time.Unix(0, until)
+}
+
+// Available reports whether the target may receive traffic
+func (t *Target) Available() bool {
+	return t.Healthy() && t.EjectedUntil().IsZero()
+}
+
+// recordPassiveResult counts consecutive failures and ejects the target once the threshold is hit
+func (t *Target) recordPassiveResult(routeName string, cfg config.PassiveHealthCheckConfig, failed bool) {
+	if !cfg.Enabled() {
+		return
+	}
+	if !failed {
+		t.health.failures.Store(0)
+		return
+	}
+	if t.health.failures.Add(1) < int64(cfg.ConsecutiveFailures) {
+		return
+	}
+	t.health.failures.Store(0)
+	t.health.ejectedUntil.Store(time.Now().Add(cfg.EjectionDuration).UnixNano())
+	log.Printf("Upstream %s for route %s ejected for %s after %d consecutive failures",
+		t.URL, routeName, cfg.EjectionDuration, cfg.ConsecutiveFailures)
+}
+
+// TargetStatus is the operator-facing health snapshot of one target
+type TargetStatus struct {
+	URL          string     `json:"url"`
+	Weight       int        `json:"weight"`
+	Healthy      bool       `json:"healthy"`
+	Ejected      bool       `json:"ejected"`
+	EjectedUntil *time.Time `json:"ejectedUntil,omitempty"`
+	Outstanding  int64      `json:"outstanding"`
+	Circuit      string     `json:"circuit,omitempty"` // closed, half_open or open; empty without a circuit breaker
+}
+
+// Status returns the current health snapshot of the target
+func (t *Target) Status() TargetStatus {
+	status := TargetStatus{
+		URL:         t.URL.String(),
+		Weight:      t.Weight,
+		Healthy:     t.Healthy(),
+		Outstanding: t.Outstanding(),
+	}
+	if until := t.EjectedUntil(); !until.IsZero() {
+		status.Ejected = true
+		status.EjectedUntil = &until
+	}
+	if t.breaker != nil {
+		status.Circuit = t.breaker.State()
+	}
+	return status
+}
+
+// healthChecker actively probes a route's targets until stopped
+type healthChecker struct {
+	routeName string
+	cfg       config.ActiveHealthCheckConfig
+	client    *http.Client
+	targets   []*Target
+
+	// consecutive results per target; only touched by the checker goroutine
+	successes map[*Target]int
+	failures  map[*Target]int
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// newHealthChecker creates a checker that probes targets through transport
+func newHealthChecker(routeName string, cfg config.ActiveHealthCheckConfig, targets []*Target, transport http.RoundTripper) *healthChecker {
+	return &healthChecker{
+		routeName: routeName,
+		cfg:       cfg,
+		client:    &http.Client{Transport: transport},
+		targets:   targets,
+		successes: make(map[*Target]int),
+		failures:  make(map[*Target]int),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// start probes all targets immediately and then on every interval
+func (c *healthChecker) start() {
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			c.checkAll()
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// close stops the checker and waits for the in-progress round to finish
+func (c *healthChecker) close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	<-c.done
+}
+
+func (c *healthChecker) checkAll() {
+	var wg sync.WaitGroup
+	results := make([]bool, len(c.targets))
+	for i, target := range c.targets {
+		wg.Add(1)
+		go func(i int, target *Target) {
+			defer wg.Done()
+			results[i] = c.probe(target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	for i, target := range c.targets {
+		c.record(target, results[i])
+	}
+}
+
+// probe sends one health-check request and reports whether the target passed
+func (c *healthChecker) probe(target *Target) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+	defer cancel()
+
+	checkURL := *target.URL
+	checkURL.Path = c.cfg.Path
+	checkURL.RawPath = ""
+	checkURL.RawQuery = ""
+
+	req, err := http.NewRequestWithContext(ctx, "GET", checkURL.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode == c.cfg.ExpectedStatus
+}
+
+// record applies healthy/unhealthy thresholds to a probe result
+func (c *healthChecker) record(target *Target, passed bool) {
+	if passed {
+		c.failures[target] = 0
+		c.successes[target]++
+		if !target.Healthy() && c.successes[target] >= c.cfg.HealthyThreshold {
+			target.health.healthy.Store(true)
+			log.Printf("Upstream %s for route %s is healthy again", target.URL, c.routeName)
+		}
+		return
+	}
+
+	c.successes[target] = 0
+	c.failures[target]++
+	if target.Healthy() && c.failures[target] >= c.cfg.UnhealthyThreshold {
+		target.health.healthy.Store(false)
+		log.Printf("Upstream %s for route %s marked unhealthy after %d failed checks", target.URL, c.routeName, c.failures[target])
+	}
+}
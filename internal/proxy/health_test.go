@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+func TestHealthCheckerAppliesThresholds(t *testing.T) {
+	var status atomic.Int64
+	status.Store(http.StatusOK)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			t.Errorf("expected health check path /healthz, got %s", r.URL.Path)
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer backend.Close()
+
+	targets := []*Target{mustTarget(t, backend.URL+"/base")}
+	checker := newHealthChecker("users", config.ActiveHealthCheckConfig{
+		Path:               "/healthz",
+		Interval:           time.Hour,
+		Timeout:            time.Second,
+		ExpectedStatus:     http.StatusOK,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 2,
+	}, targets, NewTransportPool())
+
+	status.Store(http.StatusServiceUnavailable)
+	checker.checkAll()
+	if !targets[0].Healthy() {
+		t.Fatal("expected target to stay healthy below the unhealthy threshold")
+	}
+	checker.checkAll()
+	if targets[0].Healthy() {
+		t.Fatal("expected target to be unhealthy after 2 failed checks")
+	}
+
+	status.Store(http.StatusOK)
+	checker.checkAll()
+	if targets[0].Healthy() {
+		t.Fatal("expected target to stay unhealthy below the healthy threshold")
+	}
+	checker.checkAll()
+	if !targets[0].Healthy() {
+		t.Fatal("expected target to recover after 2 passing checks")
+	}
+}
+
+func TestHealthCheckerStartAndClose(t *testing.T) {
+	var checks atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		checks.Add(1)
+	}))
+	defer backend.Close()
+
+	route := &config.RouteConfig{
+		Name:     "users",
+		Upstream: backend.URL,
+		HealthCheck: config.HealthCheckConfig{Active: config.ActiveHealthCheckConfig{
+			Path: "/healthz", Interval: 10 * time.Millisecond, Timeout: 10 * time.Millisecond,
+			ExpectedStatus: 200, HealthyThreshold: 1, UnhealthyThreshold: 1,
+		}},
+	}
+	p, err := NewProxyWithTransport(route, NewTransportPool())
+	if err != nil {
+		t.Fatalf("NewProxyWithTransport: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for checks.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	p.Close()
+	if checks.Load() < 2 {
+		t.Fatalf("expected periodic health checks, got %d", checks.Load())
+	}
+}
+
+func TestPassiveHealthEjectsTargetAfterConsecutive5xx(t *testing.T) {
+	var badHits, goodHits atomic.Int64
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		badHits.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		goodHits.Add(1)
+	}))
+	defer good.Close()
+
+	route := &config.RouteConfig{
+		Name:      "users",
+		Upstreams: []config.UpstreamTarget{{URL: bad.URL}, {URL: good.URL}},
+		HealthCheck: config.HealthCheckConfig{Passive: config.PassiveHealthCheckConfig{
+			ConsecutiveFailures: 2, EjectionDuration: time.Minute,
+		}},
+	}
+	p, err := NewProxyWithTransport(route, NewTransportPool())
+	if err != nil {
+		t.Fatalf("NewProxyWithTransport: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://gateway/", nil))
+	}
+
+	if badHits.Load() != 2 {
+		t.Fatalf("expected failing target to be ejected after 2 errors, got %d hits", badHits.Load())
+	}
+	status := p.Targets()[0].Status()
+	if !status.Ejected || status.EjectedUntil == nil {
+		t.Fatalf("expected ejected status, got %+v", status)
+	}
+	if p.Targets()[1].Status().Ejected {
+		t.Fatal("expected healthy target not to be ejected")
+	}
+}
+
+func TestPassiveHealthCountsConnectionErrors(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	deadURL := dead.URL
+	dead.Close()
+
+	route := &config.RouteConfig{
+		Name:     "users",
+		Upstream: deadURL,
+		HealthCheck: config.HealthCheckConfig{Passive: config.PassiveHealthCheckConfig{
+			ConsecutiveFailures: 1, EjectionDuration: time.Minute,
+		}},
+	}
+	p, err := NewProxyWithTransport(route, NewTransportPool())
+	if err != nil {
+		t.Fatalf("NewProxyWithTransport: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "http://gateway/", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 on connection error, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "http://gateway/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 once the only target is ejected, got %d", rec.Code)
+	}
+}
+
+func TestPassiveHealthSuccessResetsFailureCount(t *testing.T) {
+	target := mustTarget(t, "http://users:8080")
+	cfg := config.PassiveHealthCheckConfig{ConsecutiveFailures: 2, EjectionDuration: time.Minute}
+
+	target.recordPassiveResult("users", cfg, true)
+	target.recordPassiveResult("users", cfg, false)
+	target.recordPassiveResult("users", cfg, true)
+	if !target.Available() {
+		t.Fatal("expected non-consecutive failures not to eject the target")
+	}
+}
+
+func mustTarget(t *testing.T, rawURL string) *Target {
+	t.Helper()
+	target, err := newTarget(config.UpstreamTarget{URL: rawURL})
+	if err != nil {
+		t.Fatalf("newTarget: %v", err)
+	}
+	return target
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/middleware"
+)
+
+// identityHeaders forwards the authenticated caller's identity to the upstream
+type identityHeaders struct {
+	config *config.IdentityHeadersConfig
+	names  []string // every configured header, removed from incoming requests
+}
+
+// newIdentityHeaders returns nil when the route forwards no identity
+func newIdentityHeaders(cfg *config.IdentityHeadersConfig) *identityHeaders {
+	if cfg == nil {
+		return nil
+	}
+	return &identityHeaders{config: cfg, names: cfg.HeaderNames()}
+}
+
+// apply strips client-supplied identity headers so they cannot be spoofed, then sets
+// them from the token claims the auth middleware stored in the request context
+func (ih *identityHeaders) apply(req *http.Request) {
+	if ih == nil {
+		return
+	}
+	for _, name := range ih.names {
+		req.Header.Del(name)
+	}
+
+	claims := middleware.GetTokenClaims(req)
+	if claims == nil {
+		return
+	}
+	setHeader(req.Header, ih.config.Username, claims.Username)
+	setHeader(req.Header, ih.config.ClientID, claims.ClientID)
+	if ih.config.Roles != "" {
+		roles := claims.GetAllRoles()
+		sort.Strings(roles)
+		setHeader(req.Header, ih.config.Roles, strings.Join(roles, ","))
+	}
+	for claim, name := range ih.config.Claims {
+		value, ok := claims.Claim(claim)
+		if !ok {
+			continue
+		}
+		if s, ok := claimHeaderValue(value); ok {
+			setHeader(req.Header, name, s)
+		} else {
+			log.Printf("Not forwarding claim %q: value cannot be sent in header %s", claim, name)
+		}
+	}
+}
+
+// setHeader sets a configured header, skipping unconfigured headers and empty values
+func setHeader(h http.Header, name, value string) {
+	if name != "" && value != "" {
+		h.Set(name, value)
+	}
+}
+
+// claimHeaderValue renders a claim as a header value. Scalars are written as-is,
+// arrays of scalars comma separated and anything else as JSON.
+func claimHeaderValue(value interface{}) (string, bool) {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		s = v
+	case bool:
+		s = strconv.FormatBool(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case string, bool, float64:
+				rendered, _ := claimHeaderValue(item)
+				items = append(items, rendered)
+			default:
+				return claimJSON(v)
+			}
+		}
+		s = strings.Join(items, ",")
+	default:
+		return claimJSON(v)
+	}
+	return s, validHeaderValue(s)
+}
+
+func claimJSON(value interface{}) (string, bool) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(data), validHeaderValue(string(data))
+}
+
+// validHeaderValue rejects control characters, which would let a claim inject headers
+func validHeaderValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
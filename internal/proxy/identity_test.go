@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aveiga/cloud-api-gateway/internal/auth"
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/middleware"
+)
+
+func identityRoute(upstream string) *config.RouteConfig {
+	return &config.RouteConfig{
+		Name:     "users",
+		Upstream: upstream,
+		IdentityHeaders: &config.IdentityHeadersConfig{
+			Username: "X-Username",
+			ClientID: "X-Client-ID",
+			Roles:    "X-User-Roles",
+			Claims: map[string]string{
+				"email":           "X-User-Email",
+				"address.country": "X-User-Country",
+				"groups":          "X-User-Groups",
+				"address":         "X-User-Address",
+			},
+		},
+	}
+}
+
+func captureHeaders(t *testing.T) (*httptest.Server, *http.Header) {
+	t.Helper()
+	captured := new(http.Header)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*captured = r.Header.Clone()
+	}))
+	t.Cleanup(backend.Close)
+	return backend, captured
+}
+
+func TestProxyForwardsIdentityHeaders(t *testing.T) {
+	backend, captured := captureHeaders(t)
+	p, err := NewProxy(identityRoute(backend.URL))
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+	defer p.Close()
+
+	var claims auth.IntrospectionResponse
+	if err := json.Unmarshal([]byte(`{"active":true,"username":"alice","client_id":"web",
+		"realm_access":{"roles":["viewer","admin"]},"resource_access":{"app":{"roles":["editor"]}},
+		"email":"alice@example.com","groups":["a","b"],"address":{"country":"PT"}}`), &claims); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	req := httptest.NewRequest("GET", "http://gateway/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.TokenClaimsKey, &claims))
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := map[string]string{
+		"X-Username":     "alice",
+		"X-Client-Id":    "web",
+		"X-User-Roles":   "admin,editor,viewer",
+		"X-User-Email":   "alice@example.com",
+		"X-User-Country": "PT",
+		"X-User-Groups":  "a,b",
+		"X-User-Address": `{"country":"PT"}`,
+	}
+	for name, value := range want {
+		if got := captured.Get(name); got != value {
+			t.Errorf("expected %s=%q, got %q", name, value, got)
+		}
+	}
+}
+
+func TestProxyStripsClientSuppliedIdentityHeaders(t *testing.T) {
+	backend, captured := captureHeaders(t)
+	p, err := NewProxy(identityRoute(backend.URL))
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+	defer p.Close()
+
+	// Unauthenticated request: spoofed headers are removed and nothing is set
+	req := httptest.NewRequest("GET", "http://gateway/", nil)
+	req.Header.Set("X-Username", "admin")
+	req.Header.Add("x-user-roles", "admin")
+	req.Header.Set("X-User-Email", "admin@example.com")
+	req.Header.Set("X-Other", "kept")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, name := range []string{"X-Username", "X-User-Roles", "X-User-Email"} {
+		if values := captured.Values(name); len(values) != 0 {
+			t.Errorf("expected %s to be stripped, got %v", name, values)
+		}
+	}
+	if captured.Get("X-Other") != "kept" {
+		t.Error("expected unrelated headers to be forwarded")
+	}
+
+	// Authenticated request without the claim: the spoofed value must not survive either
+	req = httptest.NewRequest("GET", "http://gateway/", nil)
+	req.Header.Set("X-User-Email", "admin@example.com")
+	claims := &auth.IntrospectionResponse{Active: true, Username: "bob"}
+	req = req.WithContext(context.WithValue(req.Context(), middleware.TokenClaimsKey, claims))
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if captured.Get("X-Username") != "bob" || captured.Get("X-User-Email") != "" {
+		t.Errorf("unexpected identity headers: %v", *captured)
+	}
+}
+
+func TestClaimHeaderValueRejectsControlCharacters(t *testing.T) {
+	if _, ok := claimHeaderValue("alice\r\nX-Admin: true"); ok {
+		t.Fatal("expected a claim with CRLF to be rejected")
+	}
+	if got, ok := claimHeaderValue(float64(42)); !ok || got != "42" {
+		t.Fatalf("expected numbers without exponent, got %q", got)
+	}
+}
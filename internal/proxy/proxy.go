@@ -1,69 +1,257 @@
 package proxy
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+	"github.com/aveiga/cloud-api-gateway/internal/tracing"
 )
 
 // Proxy handles reverse proxying to upstream services
 type Proxy struct {
-	proxy *httputil.ReverseProxy
-	route *config.RouteConfig
+	proxy    *httputil.ReverseProxy
+	route    *config.RouteConfig
+	targets  []*Target
+	balancer Balancer
+	checker  *healthChecker
+	identity *identityHeaders
+	budget   *retryBudget // nil when the route does not retry
 }
 
-// NewProxy creates a new reverse proxy for the given route
+// upstreamStateKey carries the request's upstreamState into the director and transport
+type upstreamStateKey struct{}
+
+// NewProxy creates a new reverse proxy for the given route with a dedicated transport.
+// Prefer a Registry when serving traffic so transports are shared between routes.
 func NewProxy(route *config.RouteConfig) (*Proxy, error) {
-	upstreamURL, err := url.Parse(route.Upstream)
+	return NewProxyWithTransport(route, newTransportWithOptions(TransportOptionsFromConfig(route.Timeouts)))
+}
+
+// NewProxyWithTransport creates a new reverse proxy for the given route that
+// sends upstream requests through the given transport.
+func NewProxyWithTransport(route *config.RouteConfig, transport http.RoundTripper) (*Proxy, error) {
+	targetConfigs := route.Targets()
+	targets := make([]*Target, 0, len(targetConfigs))
+	for _, targetConfig := range targetConfigs {
+		target, err := newTarget(targetConfig)
+		if err != nil {
+			return nil, err
+		}
+		target.breaker = newCircuitBreaker(route.CircuitBreaker, route.Name, target.URL)
+		targets = append(targets, target)
+	}
+
+	balancer, err := NewBalancer(route.LoadBalancing, targets)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create reverse proxy
-	reverseProxy := httputil.NewSingleHostReverseProxy(upstreamURL)
+	p := &Proxy{
+		route:    route,
+		targets:  targets,
+		balancer: balancer,
+		identity: newIdentityHeaders(route.IdentityHeaders),
+		budget:   newRetryBudget(route.Retry),
+	}
+	p.proxy = &httputil.ReverseProxy{
+		Director:       p.director,
+		Transport:      &upstreamTransport{proxy: p, next: transport},
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.errorHandler,
+	}
+
+	if route.HealthCheck.Active.Enabled() {
+		p.checker = newHealthChecker(route.Name, route.HealthCheck.Active, targets, transport)
+		p.checker.start()
+	}
 
-	// Configure transport with connection pooling
-	reverseProxy.Transport = &http.Transport{
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 10,
-		IdleConnTimeout:     90 * time.Second,
+	return p, nil
+}
+
+// Close stops background health checks for the route
+func (p *Proxy) Close() {
+	if p.checker != nil {
+		p.checker.close()
 	}
+}
 
-	// Customize director for path rewriting and header forwarding
-	originalDirector := reverseProxy.Director
-	reverseProxy.Director = func(req *http.Request) {
-		originalDirector(req)
+// Targets returns the upstream targets of the route
+func (p *Proxy) Targets() []*Target {
+	return p.targets
+}
 
-		// Path rewriting: strip prefix if configured
-		if route.StripPrefix != "" {
-			originalPath := req.URL.Path
-			if strings.HasPrefix(originalPath, route.StripPrefix) {
-				newPath := strings.TrimPrefix(originalPath, route.StripPrefix)
-				if newPath == "" {
-					newPath = "/"
-				}
-				req.URL.Path = newPath
+// availableTargets returns the targets that are neither unhealthy nor ejected
+func (p *Proxy) availableTargets() []*Target {
+	for i, target := range p.targets {
+		if target.Available() {
+			continue
+		}
+		// Slow path: copy the available targets only when some are out
+		available := make([]*Target, 0, len(p.targets)-1)
+		available = append(available, p.targets[:i]...)
+		for _, rest := range p.targets[i+1:] {
+			if rest.Available() {
+				available = append(available, rest)
 			}
 		}
+		return available
+	}
+	return p.targets
+}
+
+// ServeHTTP picks an upstream target and proxies the request to it
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	candidates := p.availableTargets()
+	if len(candidates) == 0 {
+		metrics.UpstreamErrors.WithLabelValues(p.route.Name, "no_healthy_upstream").Inc()
+		http.Error(w, "No healthy upstream available", http.StatusServiceUnavailable)
+		return
+	}
+	target, generation, ok := p.admit(r, candidates)
+	if !ok {
+		p.rejectOpenCircuit(w, candidates)
+		return
+	}
 
-		// Forward relevant headers
-		forwardHeaders(req)
+	state := &upstreamState{target: target, generation: generation, incoming: *r.URL}
+	state.charge(target)
+	defer state.release()
+
+	ctx := r.Context()
+	if timeout := p.route.Timeouts.Request; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	return &Proxy{
-		proxy: reverseProxy,
-		route: route,
-	}, nil
+	ctx, span := tracing.Start(ctx, "proxy.upstream", tracing.SpanKindClient)
+	defer span.End()
+	span.SetAttribute("gateway.route", p.route.Name)
+	span.SetAttribute("server.address", target.URL.Host)
+
+	ctx = context.WithValue(ctx, upstreamStateKey{}, state)
+	p.proxy.ServeHTTP(w, r.WithContext(ctx))
 }
 
-// ServeHTTP handles the proxy request
-func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	p.proxy.ServeHTTP(w, r)
+// modifyResponse records the upstream status on the proxy span, feeds 5xx responses
+// into passive outlier detection and the circuit breaker and transforms the headers.
+// On routes with CORS the gateway's CORS headers replace the upstream's.
+func (p *Proxy) modifyResponse(resp *http.Response) error {
+	tracing.SpanFromContext(resp.Request.Context()).SetAttribute("http.response.status_code", resp.StatusCode)
+	if state, ok := stateFromContext(resp.Request.Context()); ok {
+		state.record(p.route, resp.StatusCode >= 500)
+	}
+	if p.route.CORS != nil {
+		for name := range resp.Header {
+			if strings.HasPrefix(name, "Access-Control-") {
+				delete(resp.Header, name)
+			}
+		}
+	}
+	p.transformResponseHeaders(resp)
+	return nil
+}
+
+// errorHandler replies 504 when the upstream timed out and 502 otherwise. Connection
+// errors were already counted towards passive outlier detection and the circuit
+// breaker by the transport.
+func (p *Proxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("http: proxy error: %v", err)
+	tracing.SpanFromContext(r.Context()).SetError(err)
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		metrics.UpstreamErrors.WithLabelValues(p.route.Name, "timeout").Inc()
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+	metrics.UpstreamErrors.WithLabelValues(p.route.Name, "proxy_error").Inc()
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// director points the outgoing request at the picked target, rewrites the path, forwards
+// headers and applies the configured header transforms
+func (p *Proxy) director(req *http.Request) {
+	target := p.targets[0]
+	if state, ok := stateFromContext(req.Context()); ok {
+		target = state.target
+	}
+	p.rewriteURL(req, target)
+
+	// Forward relevant headers
+	forwardHeaders(req)
+	p.identity.apply(req)
+	p.transformRequestHeaders(req)
+	if rw := p.route.Rewrite; rw != nil && rw.Host != "" {
+		req.Host = rw.Host
+	}
+
+	// Continue the trace from the proxy span; without tracing an incoming traceparent passes through
+	tracing.Inject(req.Context(), req.Header)
+}
+
+// rewriteURL applies the route's path rewriting and points the request URL at target
+func (p *Proxy) rewriteURL(req *http.Request, target *Target) {
+	p.rewritePath(req)
+	rewriteRequestURL(req, target.URL)
+}
+
+// rewriteRequestURL mirrors httputil.NewSingleHostReverseProxy: it sets the target's
+// scheme and host, joins the target's base path and merges query strings.
+func rewriteRequestURL(req *http.Request, target *url.URL) {
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path, req.URL.RawPath = joinURLPath(target, req.URL)
+	if target.RawQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = target.RawQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// explicitly disable User-Agent so it's not set to default value
+		req.Header.Set("User-Agent", "")
+	}
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+	// Same as singleJoiningSlash, but uses EscapedPath to determine
+	// whether a slash should be added
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+
+	aslash := strings.HasSuffix(apath, "/")
+	bslash := strings.HasPrefix(bpath, "/")
+
+	switch {
+	case aslash && bslash:
+		return a.Path + b.Path[1:], apath + bpath[1:]
+	case !aslash && !bslash:
+		return a.Path + "/" + b.Path, apath + "/" + bpath
+	}
+	return a.Path + b.Path, apath + bpath
 }
 
 // forwardHeaders forwards relevant headers from the original request
@@ -91,10 +279,4 @@ func forwardHeaders(req *http.Request) {
 	if req.Header.Get("X-Forwarded-Host") == "" {
 		req.Header.Set("X-Forwarded-Host", req.Host)
 	}
-
-	// Forward user information if available (from auth middleware)
-	// This can be useful for upstream services
-	if username := req.Header.Get("X-Username"); username == "" {
-		// Could extract from token claims if needed
-	}
 }
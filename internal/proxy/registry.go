@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// Registry holds one prebuilt Proxy per configured route.
+// Proxies are built once at startup and share transports per upstream origin.
+type Registry struct {
+	transports *TransportPool
+	proxies    map[*config.RouteConfig]*Proxy
+	routes     []*config.RouteConfig
+	upstreams  []*url.URL
+	keys       []transportKey // pooled transports the routes use
+}
+
+// NewRegistry builds a proxy for every route using a fresh transport pool
+func NewRegistry(routes []config.RouteConfig) (*Registry, error) {
+	return NewRegistryWithPool(routes, NewTransportPool())
+}
+
+// NewRegistryWithPool builds a proxy for every route, drawing transports from the given pool.
+// Routes are keyed by their address in the slice, so callers must look them up
+// through pointers into the same slice (as router.NewRouter does).
+func NewRegistryWithPool(routes []config.RouteConfig, pool *TransportPool) (*Registry, error) {
+	registry := &Registry{
+		transports: pool,
+		proxies:    make(map[*config.RouteConfig]*Proxy, len(routes)),
+	}
+
+	for i := range routes {
+		route := &routes[i]
+		options := TransportOptionsFromConfig(route.Timeouts)
+		transport := newPooledTransport()
+		routeProxy, err := NewProxyWithTransport(route, transport)
+		if err != nil {
+			registry.Close()
+			return nil, fmt.Errorf("route %s: invalid upstream: %w", route.Name, err)
+		}
+		registry.proxies[route] = routeProxy
+		registry.routes = append(registry.routes, route)
+
+		// Resolve transports eagerly so the pool reflects every configured origin and
+		// serving a request never creates one
+		for _, target := range routeProxy.Targets() {
+			transport.add(pool, target.URL, options)
+			registry.upstreams = append(registry.upstreams, target.URL)
+			registry.keys = append(registry.keys, transportKey{origin: originKey(target.URL), options: options})
+		}
+	}
+
+	return registry, nil
+}
+
+// Get returns the prebuilt proxy for a route, or nil if the route is unknown
+func (r *Registry) Get(route *config.RouteConfig) *Proxy {
+	return r.proxies[route]
+}
+
+// Handler returns the prebuilt proxy for a route as an http.Handler
+func (r *Registry) Handler(route *config.RouteConfig) http.Handler {
+	if p := r.Get(route); p != nil {
+		return p
+	}
+	return nil
+}
+
+// Upstreams returns the upstream target URLs of every route in the registry
+func (r *Registry) Upstreams() []*url.URL {
+	return r.upstreams
+}
+
+// RetainTransports drops every pooled transport this registry's routes do not use,
+// such as those of removed origins or of timeouts that changed on reload
+func (r *Registry) RetainTransports() {
+	keep := make(map[transportKey]bool, len(r.keys))
+	for _, key := range r.keys {
+		keep[key] = true
+	}
+	r.transports.retain(keep)
+}
+
+// Transports returns the transport pool backing this registry
+func (r *Registry) Transports() *TransportPool {
+	return r.transports
+}
+
+// RouteStatus is the health snapshot of one route's targets
+type RouteStatus struct {
+	Route   string         `json:"route"`
+	Targets []TargetStatus `json:"targets"`
+}
+
+// Status returns the health of every route's targets in configuration order
+func (r *Registry) Status() []RouteStatus {
+	statuses := make([]RouteStatus, 0, len(r.routes))
+	for _, route := range r.routes {
+		status := RouteStatus{Route: route.Name}
+		for _, target := range r.proxies[route].Targets() {
+			status.Targets = append(status.Targets, target.Status())
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Close stops background work (such as health checks) of every proxy in the registry
+func (r *Registry) Close() {
+	for _, p := range r.proxies {
+		p.Close()
+	}
+}
@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// newCountingBackend starts a backend that counts newly accepted connections
+func newCountingBackend(tb testing.TB) (*httptest.Server, *atomic.Int64) {
+	tb.Helper()
+	var conns atomic.Int64
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	backend.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	backend.Start()
+	tb.Cleanup(backend.Close)
+	return backend, &conns
+}
+
+func TestRegistryBuildsOneProxyPerRoute(t *testing.T) {
+	routes := []config.RouteConfig{
+		{Name: "users", Upstream: "http://users:8080"},
+		{Name: "orders", Upstream: "http://orders:8080"},
+	}
+	registry, err := NewRegistry(routes)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+
+	first := registry.Get(&routes[0])
+	if first == nil {
+		t.Fatal("expected proxy for users route")
+	}
+	if registry.Get(&routes[0]) != first {
+		t.Fatal("expected the same prebuilt proxy on every lookup")
+	}
+	if registry.Get(&routes[1]) == first {
+		t.Fatal("expected a distinct proxy per route")
+	}
+	if registry.Handler(&config.RouteConfig{Name: "unknown"}) != nil {
+		t.Fatal("expected nil handler for unknown route")
+	}
+}
+
+func TestRegistrySharesTransportForSameOrigin(t *testing.T) {
+	routes := []config.RouteConfig{
+		{Name: "users", Upstream: "http://backend:8080/users"},
+		{Name: "admin", Upstream: "HTTP://Backend:8080/admin"},
+		{Name: "orders", Upstream: "http://orders:8080"},
+	}
+	registry, err := NewRegistry(routes)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+
+	if got := registry.Transports().Len(); got != 2 {
+		t.Fatalf("expected 2 transports for 2 distinct origins, got %d", got)
+	}
+	pool := registry.Transports()
+	users := pool.Get(registry.Get(&routes[0]).Targets()[0].URL)
+	admin := pool.Get(registry.Get(&routes[1]).Targets()[0].URL)
+	orders := pool.Get(registry.Get(&routes[2]).Targets()[0].URL)
+	if users != admin {
+		t.Fatal("expected routes on the same origin to share a transport")
+	}
+	if users == orders {
+		t.Fatal("expected routes on different origins to use different transports")
+	}
+}
+
+func TestRegistryFailsWithInvalidUpstreamURL(t *testing.T) {
+	_, err := NewRegistry([]config.RouteConfig{{Name: "bad", Upstream: "://invalid"}})
+	if err == nil {
+		t.Fatal("expected error for invalid upstream URL")
+	}
+}
+
+func TestTransportPoolReusesTransport(t *testing.T) {
+	pool := NewTransportPool()
+	a, _ := url.Parse("http://backend:8080/a")
+	b, _ := url.Parse("http://backend:8080/b")
+	if pool.Get(a) != pool.Get(b) {
+		t.Fatal("expected same transport for same origin")
+	}
+	pool.CloseIdleConnections()
+}
+
+func TestTransportPoolSeparatesTransportOptions(t *testing.T) {
+	pool := NewTransportPool()
+	u, _ := url.Parse("http://backend:8080")
+	slow := TransportOptions{ResponseHeaderTimeout: time.Second}
+
+	if pool.GetWithOptions(u, slow) == pool.Get(u) {
+		t.Fatal("expected routes with different timeouts to use different transports")
+	}
+	if pool.GetWithOptions(u, slow) != pool.GetWithOptions(u, slow) {
+		t.Fatal("expected routes with the same timeouts to share a transport")
+	}
+	if got := pool.GetWithOptions(u, slow).ResponseHeaderTimeout; got != time.Second {
+		t.Fatalf("expected response header timeout to be applied, got %v", got)
+	}
+
+	pool.retain(nil)
+	if pool.Len() != 0 {
+		t.Fatalf("expected retain to drop every transport of the origin, %d left", pool.Len())
+	}
+}
+
+func TestTransportPoolRetainDropsUnusedOrigins(t *testing.T) {
+	pool := NewTransportPool()
+	keep, _ := url.Parse("http://keep:8080")
+	drop, _ := url.Parse("http://drop:8080")
+	kept := pool.Get(keep)
+	pool.Get(drop)
+
+	pool.retain(map[transportKey]bool{{origin: originKey(keep)}: true})
+
+	if got := pool.Len(); got != 1 {
+		t.Fatalf("expected 1 transport after retain, got %d", got)
+	}
+	if pool.Get(keep) != kept {
+		t.Fatal("expected retained origin to keep its transport")
+	}
+}
+
+func TestRegistryRetainTransportsDropsChangedOptions(t *testing.T) {
+	pool := NewTransportPool()
+	before := []config.RouteConfig{{Name: "users", Upstream: "http://users:8080"}}
+	if _, err := NewRegistryWithPool(before, pool); err != nil {
+		t.Fatalf("NewRegistryWithPool: %v", err)
+	}
+	after := []config.RouteConfig{{Name: "users", Upstream: "http://users:8080", Timeouts: config.TimeoutsConfig{ResponseHeader: 5 * time.Second}}}
+	registry, err := NewRegistryWithPool(after, pool)
+	if err != nil {
+		t.Fatalf("NewRegistryWithPool: %v", err)
+	}
+
+	registry.RetainTransports()
+	if got := pool.Len(); got != 1 {
+		t.Fatalf("expected only the transport with the new timeouts to be kept, got %d", got)
+	}
+	u, _ := url.Parse("http://users:8080")
+	if got := pool.GetWithOptions(u, TransportOptionsFromConfig(after[0].Timeouts)).ResponseHeaderTimeout; got != 5*time.Second {
+		t.Fatalf("expected the kept transport to use the new timeout, got %v", got)
+	}
+}
+
+func TestRegistryDoesNotReaddDroppedTransports(t *testing.T) {
+	backend, _ := newCountingBackend(t)
+	pool := NewTransportPool()
+	before := []config.RouteConfig{{Name: "users", Upstream: backend.URL}}
+	old, err := NewRegistryWithPool(before, pool)
+	if err != nil {
+		t.Fatalf("NewRegistryWithPool: %v", err)
+	}
+	after := []config.RouteConfig{{Name: "users", Upstream: backend.URL, Timeouts: config.TimeoutsConfig{ResponseHeader: 5 * time.Second}}}
+	registry, err := NewRegistryWithPool(after, pool)
+	if err != nil {
+		t.Fatalf("NewRegistryWithPool: %v", err)
+	}
+	defer pool.CloseIdleConnections()
+	registry.RetainTransports()
+
+	// A request still served by the old snapshot uses its dropped transport
+	rec := httptest.NewRecorder()
+	old.Handler(&before[0]).ServeHTTP(rec, httptest.NewRequest("GET", "http://gateway/users", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	old.Get(&before[0]).proxy.Transport.(*upstreamTransport).CloseIdleConnections()
+	if got := pool.Len(); got != 1 {
+		t.Fatalf("expected the dropped transport to stay out of the pool, got %d transports", got)
+	}
+}
+
+func TestRegistryProxyReusesUpstreamConnections(t *testing.T) {
+	backend, conns := newCountingBackend(t)
+	routes := []config.RouteConfig{{Name: "users", Upstream: backend.URL}}
+	registry, err := NewRegistry(routes)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	defer registry.Transports().CloseIdleConnections()
+
+	handler := registry.Handler(&routes[0])
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest("GET", "http://gateway/users", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d", rec.Code)
+		}
+	}
+
+	if got := conns.Load(); got != 1 {
+		t.Fatalf("expected sequential requests to reuse 1 connection, got %d", got)
+	}
+}
+
+// BenchmarkProxyPerRequest reproduces the old behaviour of building a proxy per request
+func BenchmarkProxyPerRequest(b *testing.B) {
+	backend, conns := newCountingBackend(b)
+	route := &config.RouteConfig{Name: "users", Upstream: backend.URL}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p, err := NewProxy(route)
+		if err != nil {
+			b.Fatalf("NewProxy: %v", err)
+		}
+		req := httptest.NewRequest("GET", "http://gateway/users", nil)
+		p.ServeHTTP(httptest.NewRecorder(), req)
+		p.proxy.Transport.(*upstreamTransport).CloseIdleConnections()
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+}
+
+// BenchmarkProxyFromRegistry serves every request through the prebuilt registry proxy
+func BenchmarkProxyFromRegistry(b *testing.B) {
+	backend, conns := newCountingBackend(b)
+	routes := []config.RouteConfig{{Name: "users", Upstream: backend.URL}}
+	registry, err := NewRegistry(routes)
+	if err != nil {
+		b.Fatalf("NewRegistry: %v", err)
+	}
+	defer registry.Transports().CloseIdleConnections()
+	handler := registry.Handler(&routes[0])
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest("GET", "http://gateway/users", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+}
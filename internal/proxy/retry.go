@@ -0,0 +1,308 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+)
+
+// maxRetryBodyBytes is the largest request body buffered so it can be replayed on retry.
+// Requests with larger or unknown-length bodies are sent once.
+const maxRetryBodyBytes = 1 << 20
+
+// upstreamState tracks where a request is being sent; retries move it to another target
+type upstreamState struct {
+	target     *Target
+	generation uint64    // circuit breaker generation the target admitted the request under
+	incoming   url.URL   // request URL before rewriting, used to re-target retries
+	charged    []*Target // targets whose outstanding count this request holds
+}
+
+// stateFromContext returns the upstream state ServeHTTP stored in the request context
+func stateFromContext(ctx context.Context) (*upstreamState, bool) {
+	state, ok := ctx.Value(upstreamStateKey{}).(*upstreamState)
+	return state, ok
+}
+
+// charge counts the request as outstanding on target
+func (s *upstreamState) charge(target *Target) {
+	target.outstanding.Add(1)
+	s.charged = append(s.charged, target)
+}
+
+// record feeds the result of the current attempt into passive outlier detection and
+// the target's circuit breaker
+func (s *upstreamState) record(route *config.RouteConfig, failed bool) {
+	s.target.recordPassiveResult(route.Name, route.HealthCheck.Passive, failed)
+	s.target.breaker.record(s.generation, failed)
+}
+
+// release drops the request from the outstanding count of every target it used
+func (s *upstreamState) release() {
+	for _, target := range s.charged {
+		target.outstanding.Add(-1)
+	}
+}
+
+// upstreamTransport sends a route's requests upstream. It records connection errors for
+// passive health checking and the circuit breaker, and retries idempotent requests according to the route's
+// retry policy, moving to another target when one is available.
+type upstreamTransport struct {
+	proxy *Proxy
+	next  http.RoundTripper
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := t.proxy
+	policy := p.route.Retry
+	attempts := 1
+	if policy != nil && isIdempotent(req.Method) && makeReplayable(req) {
+		attempts += policy.Attempts
+	}
+	p.budget.recordRequest()
+
+	for attempt := 1; ; attempt++ {
+		state, _ := stateFromContext(req.Context())
+		resp, err := t.next.RoundTrip(req)
+
+		var reason string
+		switch {
+		case err != nil:
+			if req.Context().Err() != nil {
+				// The client went away or the route timeout expired; neither is the target's fault
+				return nil, err
+			}
+			if state != nil {
+				state.record(p.route, true)
+			}
+			reason = "connection_error"
+		case policy != nil && slices.Contains(policy.OnStatus, resp.StatusCode):
+			reason = "status"
+		}
+		if reason == "" || attempt >= attempts {
+			return resp, err
+		}
+		next, generation, ok := p.nextTarget(req, state)
+		if !ok {
+			// Every target's circuit is open, so there is nowhere to retry
+			return resp, err
+		}
+		if !p.budget.tryRetry() {
+			metrics.RetryBudgetExhausted.WithLabelValues(p.route.Name).Inc()
+			return resp, err
+		}
+
+		if resp != nil {
+			// The response is discarded, so modifyResponse never sees it
+			if state != nil {
+				state.record(p.route, resp.StatusCode >= 500)
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		metrics.UpstreamRetries.WithLabelValues(p.route.Name, reason).Inc()
+
+		if err := sleepContext(req.Context(), backoff(policy, attempt)); err != nil {
+			return nil, err
+		}
+		if req, err = p.retryRequest(req, state, next, generation); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped transport, if it keeps any
+func (t *upstreamTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// nextTarget picks the target of the next attempt, preferring one the request has not
+// tried yet. It reports false when no target's circuit admits the retry.
+func (p *Proxy) nextTarget(req *http.Request, state *upstreamState) (*Target, uint64, bool) {
+	if state == nil {
+		return nil, 0, true
+	}
+	candidates := p.availableTargets()
+	untried := make([]*Target, 0, len(candidates))
+	for _, target := range candidates {
+		if !slices.Contains(state.charged, target) {
+			untried = append(untried, target)
+		}
+	}
+	if len(untried) > 0 {
+		candidates = untried
+	}
+	if len(candidates) == 0 {
+		candidates = []*Target{state.target}
+	}
+	return p.admit(req, candidates)
+}
+
+// retryRequest prepares the next attempt, sent to target as picked by nextTarget
+func (p *Proxy) retryRequest(req *http.Request, state *upstreamState, target *Target, generation uint64) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	if state == nil {
+		return retry, nil
+	}
+
+	state.generation = generation
+	if target != state.target {
+		state.target = target
+		if !slices.Contains(state.charged, target) {
+			state.charge(target)
+		}
+		incoming := state.incoming
+		retry.URL = &incoming
+		p.rewriteURL(retry, target)
+	}
+	return retry, nil
+}
+
+// isIdempotent reports whether a request with method may safely be sent twice
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// makeReplayable buffers a small request body so it can be sent again. It reports
+// false when the body cannot be replayed.
+func makeReplayable(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return true
+	}
+	if req.ContentLength <= 0 || req.ContentLength > maxRetryBodyBytes {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, req.ContentLength))
+	req.Body.Close()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.Body, _ = req.GetBody()
+	// A short read fails the first attempt the same way it would have without buffering
+	return err == nil && int64(len(body)) == req.ContentLength
+}
+
+// backoff returns a random delay up to base*2^(attempt-1), capped at max ("full jitter")
+func backoff(policy *config.RetryConfig, attempt int) time.Duration {
+	ceiling := policy.BackoffMax
+	if shift := attempt - 1; shift < 32 {
+		ceiling = min(ceiling, policy.BackoffBase<<shift)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Retry budget window, tracked in one-second buckets
+const budgetWindowSeconds = 10
+
+// retryBudget allows retries up to ratio times the requests of the last 10 seconds,
+// plus minPerSecond retries per second regardless of traffic. A nil budget allows
+// every retry.
+type retryBudget struct {
+	ratio        float64
+	minPerSecond int
+	now          func() time.Time
+
+	mu      sync.Mutex
+	buckets [budgetWindowSeconds]budgetBucket
+}
+
+type budgetBucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+// newRetryBudget returns nil when the route does not retry
+func newRetryBudget(policy *config.RetryConfig) *retryBudget {
+	if policy == nil {
+		return nil
+	}
+	return &retryBudget{
+		ratio:        policy.Budget.Ratio,
+		minPerSecond: policy.Budget.MinRetriesPerSecond,
+		now:          time.Now,
+	}
+}
+
+// bucket returns the current bucket, resetting it if it holds an old second;
+// must be called with b.mu held
+func (b *retryBudget) bucket(second int64) *budgetBucket {
+	bucket := &b.buckets[second%budgetWindowSeconds]
+	if bucket.second != second {
+		*bucket = budgetBucket{second: second}
+	}
+	return bucket
+}
+
+func (b *retryBudget) recordRequest() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bucket(b.now().Unix()).requests++
+}
+
+// tryRetry reports whether the budget allows another retry and spends it if so
+func (b *retryBudget) tryRetry() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now().Unix()
+	var requests, retries int
+	for _, bucket := range b.buckets {
+		if now-bucket.second < budgetWindowSeconds {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+	allowed := float64(b.minPerSecond*budgetWindowSeconds) + b.ratio*float64(requests)
+	if float64(retries) >= allowed {
+		return false
+	}
+	b.bucket(now).retries++
+	return true
+}
@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/metrics"
+)
+
+func retryPolicy(attempts int, onStatus ...int) *config.RetryConfig {
+	return &config.RetryConfig{
+		Attempts:    attempts,
+		OnStatus:    onStatus,
+		BackoffBase: time.Millisecond,
+		BackoffMax:  2 * time.Millisecond,
+		Budget:      config.RetryBudgetConfig{Ratio: 0.2, MinRetriesPerSecond: 10},
+	}
+}
+
+// flakyBackend fails the first failures requests with status, then answers 200 with the request body
+func flakyBackend(t *testing.T, failures int64, status int) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var calls atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if calls.Add(1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(backend.Close)
+	return backend, &calls
+}
+
+func TestProxyRetriesConfiguredStatus(t *testing.T) {
+	backend, calls := flakyBackend(t, 2, http.StatusServiceUnavailable)
+	p, err := NewProxy(&config.RouteConfig{Name: "retry-status", Upstream: backend.URL, Retry: retryPolicy(2, 503)})
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+	before := metrics.UpstreamRetries.WithLabelValues("retry-status", "status").Value()
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("PUT", "http://gateway/items/1", strings.NewReader("payload")))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "payload" {
+		t.Fatalf("expected the replayed body on the third attempt, got %d %q", rec.Code, rec.Body.String())
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls.Load())
+	}
+	if got := metrics.UpstreamRetries.WithLabelValues("retry-status", "status").Value() - before; got != 2 {
+		t.Fatalf("expected 2 retries to be counted, got %v", got)
+	}
+}
+
+func TestProxyReturnsLastResponseWhenAttemptsRunOut(t *testing.T) {
+	backend, calls := flakyBackend(t, 10, http.StatusBadGateway)
+	p, err := NewProxy(&config.RouteConfig{Name: "users", Upstream: backend.URL, Retry: retryPolicy(1, 502)})
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "http://gateway/", nil))
+	if rec.Code != http.StatusBadGateway || calls.Load() != 2 {
+		t.Fatalf("expected the upstream 502 after 2 attempts, got %d after %d", rec.Code, calls.Load())
+	}
+}
+
+func TestProxyDoesNotRetryNonIdempotentMethods(t *testing.T) {
+	backend, calls := flakyBackend(t, 1, http.StatusServiceUnavailable)
+	p, err := NewProxy(&config.RouteConfig{Name: "users", Upstream: backend.URL, Retry: retryPolicy(2, 503)})
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("POST", "http://gateway/", strings.NewReader("x")))
+	if rec.Code != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Fatalf("expected a single POST attempt, got %d after %d", rec.Code, calls.Load())
+	}
+}
+
+func TestProxyRetriesConnectionErrorsOnAnotherTarget(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	deadURL := dead.URL
+	dead.Close()
+	live, calls := flakyBackend(t, 0, 0)
+
+	p, err := NewProxy(&config.RouteConfig{
+		Name:          "retry-connect",
+		Upstreams:     []config.UpstreamTarget{{URL: deadURL, Weight: 1}, {URL: live.URL, Weight: 1}},
+		LoadBalancing: config.LoadBalancingConfig{Policy: config.PolicyRoundRobin},
+		Retry:         retryPolicy(1),
+	})
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+	before := metrics.UpstreamRetries.WithLabelValues("retry-connect", "connection_error").Value()
+
+	for i := 0; i < 4; i++ {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "http://gateway/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected the retry to reach the live target, got %d", i, rec.Code)
+		}
+	}
+	if calls.Load() != 4 {
+		t.Fatalf("expected every request to reach the live target once, got %d", calls.Load())
+	}
+	if got := metrics.UpstreamRetries.WithLabelValues("retry-connect", "connection_error").Value() - before; got < 1 || got > 4 {
+		t.Fatalf("expected requests sent to the dead target to be retried, got %v retries", got)
+	}
+	for _, target := range p.Targets() {
+		if n := target.outstanding.Load(); n != 0 {
+			t.Fatalf("expected outstanding counts to be released, %s has %d", target.URL, n)
+		}
+	}
+}
+
+func TestProxyResponseHeaderTimeoutReturns504(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(backend.Close)
+	t.Cleanup(func() { close(release) })
+
+	p, err := NewProxy(&config.RouteConfig{
+		Name:     "slow",
+		Upstream: backend.URL,
+		Timeouts: config.TimeoutsConfig{ResponseHeader: 20 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+	before := metrics.UpstreamErrors.WithLabelValues("slow", "timeout").Value()
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "http://gateway/", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", rec.Code)
+	}
+	if got := metrics.UpstreamErrors.WithLabelValues("slow", "timeout").Value() - before; got != 1 {
+		t.Fatalf("expected the timeout to be counted, got %v", got)
+	}
+}
+
+func TestProxyRequestTimeoutBoundsRetries(t *testing.T) {
+	backend, calls := flakyBackend(t, 1000, http.StatusServiceUnavailable)
+	policy := retryPolicy(1000, 503)
+	policy.BackoffBase, policy.BackoffMax = 10*time.Millisecond, 10*time.Millisecond
+	p, err := NewProxy(&config.RouteConfig{
+		Name:     "users",
+		Upstream: backend.URL,
+		Timeouts: config.TimeoutsConfig{Request: 50 * time.Millisecond},
+		Retry:    policy,
+	})
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "http://gateway/", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504 once the request timeout expires, got %d", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second || calls.Load() >= 1000 {
+		t.Fatalf("expected retries to stop at the request timeout, took %v and %d attempts", elapsed, calls.Load())
+	}
+}
+
+func TestRetryBudgetLimitsRetriesToShareOfRequests(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	budget := &retryBudget{ratio: 0.5, now: func() time.Time { return now }}
+
+	for i := 0; i < 4; i++ {
+		budget.recordRequest()
+	}
+	if !budget.tryRetry() || !budget.tryRetry() {
+		t.Fatal("expected 2 retries for 4 requests at ratio 0.5")
+	}
+	if budget.tryRetry() {
+		t.Fatal("expected the budget to be spent")
+	}
+
+	// Old requests and retries leave the window
+	now = now.Add(budgetWindowSeconds * time.Second)
+	budget.recordRequest()
+	budget.recordRequest()
+	if !budget.tryRetry() {
+		t.Fatal("expected the budget to recover once the window moved on")
+	}
+
+	floor := &retryBudget{minPerSecond: 1, now: func() time.Time { return now }}
+	for i := 0; i < budgetWindowSeconds; i++ {
+		if !floor.tryRetry() {
+			t.Fatalf("expected retry %d to be allowed by the per-second floor", i)
+		}
+	}
+	if floor.tryRetry() {
+		t.Fatal("expected the floor to be exhausted without traffic")
+	}
+}
+
+func TestBackoffIsCappedAndJittered(t *testing.T) {
+	policy := &config.RetryConfig{BackoffBase: 10 * time.Millisecond, BackoffMax: 50 * time.Millisecond}
+	for attempt := 1; attempt <= 40; attempt++ {
+		ceiling := min(policy.BackoffMax, policy.BackoffBase<<min(attempt-1, 30))
+		if d := backoff(policy, attempt); d < 0 || d >= ceiling {
+			t.Fatalf("attempt %d: backoff %v outside [0, %v)", attempt, d, ceiling)
+		}
+	}
+}
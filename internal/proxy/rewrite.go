@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/router"
+)
+
+// rewritePath applies the route's strip_prefix or rewrite block to the incoming
+// request URL, before it is joined with the target's base path. Paths are rewritten
+// in escaped form, so an encoded slash stays encoded.
+func (p *Proxy) rewritePath(req *http.Request) {
+	rw := p.route.Rewrite
+	if rw == nil || rw.CompiledPath == nil {
+		if p.route.StripPrefix != "" {
+			stripPrefix(req.URL, p.route.StripPrefix)
+		}
+	}
+	if rw == nil || (rw.CompiledPath == nil && len(rw.CompiledQuery) == 0) {
+		return
+	}
+
+	captures := p.captures(req)
+	if rw.CompiledPath != nil {
+		setEscapedPath(req.URL, expand(rw.CompiledPath, captureValue(captures, true)))
+	}
+	if len(rw.CompiledQuery) > 0 {
+		added := url.Values{}
+		for _, param := range rw.CompiledQuery {
+			added.Add(param.Name, expand(param.Value, captureValue(captures, false)))
+		}
+		if req.URL.RawQuery == "" {
+			req.URL.RawQuery = added.Encode()
+		} else {
+			req.URL.RawQuery += "&" + added.Encode()
+		}
+	}
+}
+
+// captures returns the values the route's path captured from the incoming request in
+// capture order: every group of a path pattern, or the parameters of a path template
+func (p *Proxy) captures(req *http.Request) router.Params {
+	pattern := p.route.CompiledPattern
+	if pattern == nil {
+		return router.ParamsFromContext(req.Context())
+	}
+	incoming := req.URL
+	if state, ok := stateFromContext(req.Context()); ok {
+		incoming = &state.incoming // req.URL may already point upstream
+	}
+	return router.PatternCaptures(pattern, incoming)
+}
+
+// expand fills in a template, taking each placeholder's text from value
+func expand(t *config.Template, value func(config.TemplatePart) string) string {
+	var b strings.Builder
+	for _, part := range t.Parts {
+		if part.Group == 0 && part.Param == "" && part.Var == "" {
+			b.WriteString(part.Literal)
+		} else {
+			b.WriteString(value(part))
+		}
+	}
+	return b.String()
+}
+
+// captureValue resolves $N and {name} placeholders from captures; raw selects the
+// escaped form of captured values for a URL path
+func captureValue(captures router.Params, raw bool) func(config.TemplatePart) string {
+	return func(part config.TemplatePart) string {
+		var capture router.Param
+		switch {
+		case part.Group > 0:
+			if part.Group <= len(captures) {
+				capture = captures[part.Group-1]
+			}
+		case part.Param != "":
+			for _, c := range captures {
+				if c.Name == part.Param {
+					capture = c
+					break
+				}
+			}
+		}
+		if raw {
+			return capture.Raw
+		}
+		return capture.Value
+	}
+}
+
+// stripPrefix removes prefix from the decoded path and the same characters from the
+// escaped path, so what follows the prefix keeps its encoding
+func stripPrefix(u *url.URL, prefix string) {
+	if !strings.HasPrefix(u.Path, prefix) {
+		return
+	}
+	escaped := u.EscapedPath()
+	i := 0
+	for n := 0; n < len(prefix) && i < len(escaped); n++ {
+		if escaped[i] == '%' {
+			i += 3 // EscapedPath only returns valid escapes
+		} else {
+			i++
+		}
+	}
+	rest := escaped[i:]
+	if rest == "" {
+		rest = "/"
+	}
+	setEscapedPath(u, rest)
+}
+
+// setEscapedPath sets u's path from its escaped form. RawPath is kept only when it
+// differs from the default encoding of Path, e.g. for an encoded slash.
+func setEscapedPath(u *url.URL, escaped string) {
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		path = escaped
+	}
+	u.Path, u.RawPath = path, ""
+	if u.EscapedPath() != escaped {
+		u.RawPath = escaped
+	}
+}
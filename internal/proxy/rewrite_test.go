@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+	"github.com/aveiga/cloud-api-gateway/internal/router"
+)
+
+// requestURIBackend records the request URI and Host each request arrives with
+func requestURIBackend(t *testing.T) (*httptest.Server, *http.Request) {
+	t.Helper()
+	seen := &http.Request{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*seen = *r
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(backend.Close)
+	return backend, seen
+}
+
+func mustTemplate(t *testing.T, text string) *config.Template {
+	t.Helper()
+	tmpl, err := config.ParseTemplate(text)
+	if err != nil {
+		t.Fatalf("ParseTemplate(%q): %v", text, err)
+	}
+	return tmpl
+}
+
+func serveThrough(t *testing.T, route *config.RouteConfig, req *http.Request) {
+	t.Helper()
+	p, err := NewProxy(route)
+	if err != nil {
+		t.Fatalf("NewProxy: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+}
+
+func TestRewriteUsesCaptureGroups(t *testing.T) {
+	backend, seen := requestURIBackend(t)
+	route := &config.RouteConfig{
+		Name:            "orders",
+		CompiledPattern: regexp.MustCompile(`(?i)^/api/v1/users/(\d+)/orders/(?P<status>[a-z]+)$`),
+		Upstream:        backend.URL + "/legacy",
+		Rewrite: &config.RewriteConfig{
+			CompiledPath: mustTemplate(t, "/orders/{status}"),
+			CompiledQuery: []config.QueryParam{
+				{Name: "tag", Value: mustTemplate(t, "a&b")},
+				{Name: "user", Value: mustTemplate(t, "$1")},
+			},
+		},
+	}
+
+	serveThrough(t, route, httptest.NewRequest("GET", "http://gateway/api/v1/users/42/orders/open?page=2", nil))
+	if want := "/legacy/orders/open?page=2&tag=a%26b&user=42"; seen.RequestURI != want {
+		t.Fatalf("expected %s, got %s", want, seen.RequestURI)
+	}
+}
+
+func TestRewriteKeepsEncodedSlashesInParams(t *testing.T) {
+	backend, seen := requestURIBackend(t)
+	segments, _ := config.CompilePathTemplate("/buckets/{bucket}/objects/{key...}")
+	route := &config.RouteConfig{
+		Name:         "objects",
+		CompiledPath: segments,
+		Upstream:     backend.URL,
+		Rewrite:      &config.RewriteConfig{CompiledPath: mustTemplate(t, "/storage/{bucket}/{key}")},
+		Rules:        []config.RouteRule{{Methods: []string{"GET"}}},
+	}
+	r := router.NewRouter([]config.RouteConfig{*route}, nil)
+
+	req := httptest.NewRequest("GET", "http://gateway/buckets/b1/objects/dir/a%2Fb%20c", nil)
+	_, _, params := r.MatchRoute(req)
+	serveThrough(t, route, req.WithContext(router.WithParams(req.Context(), params)))
+	if want := "/storage/b1/dir/a%2Fb%20c"; seen.RequestURI != want {
+		t.Fatalf("expected %s, got %s", want, seen.RequestURI)
+	}
+}
+
+func TestRewriteKeepsEncodedSlashesInCaptureGroups(t *testing.T) {
+	backend, seen := requestURIBackend(t)
+	route := &config.RouteConfig{
+		Name:            "files",
+		CompiledPattern: regexp.MustCompile(`(?i)^/api/files/(.+)$`),
+		Upstream:        backend.URL,
+		Rewrite:         &config.RewriteConfig{CompiledPath: mustTemplate(t, "/store/$1")},
+	}
+
+	serveThrough(t, route, httptest.NewRequest("GET", "http://gateway/api/files/a%2Fb", nil))
+	if want := "/store/a%2Fb"; seen.RequestURI != want {
+		t.Fatalf("expected %s, got %s", want, seen.RequestURI)
+	}
+	serveThrough(t, route, httptest.NewRequest("GET", "http://gateway/api/files/dir/a%20b", nil))
+	if want := "/store/dir/a%20b"; seen.RequestURI != want {
+		t.Fatalf("expected %s, got %s", want, seen.RequestURI)
+	}
+}
+
+func TestStripPrefixKeepsEncodingAndBasePath(t *testing.T) {
+	backend, seen := requestURIBackend(t)
+	route := &config.RouteConfig{
+		Name:        "files",
+		Upstream:    backend.URL + "/base",
+		StripPrefix: "/api",
+	}
+
+	serveThrough(t, route, httptest.NewRequest("GET", "http://gateway/api/files/a%2Fb", nil))
+	if want := "/base/files/a%2Fb"; seen.RequestURI != want {
+		t.Fatalf("expected %s, got %s", want, seen.RequestURI)
+	}
+	serveThrough(t, route, httptest.NewRequest("GET", "http://gateway/api", nil))
+	if want := "/base/"; seen.RequestURI != want {
+		t.Fatalf("expected %s, got %s", want, seen.RequestURI)
+	}
+}
+
+func TestRewriteOverridesHost(t *testing.T) {
+	backend, seen := requestURIBackend(t)
+	route := &config.RouteConfig{
+		Name:     "legacy",
+		Upstream: backend.URL,
+		Rewrite:  &config.RewriteConfig{Host: "legacy.internal"},
+	}
+
+	serveThrough(t, route, httptest.NewRequest("GET", "http://api.example.com/users", nil))
+	if seen.Host != "legacy.internal" {
+		t.Fatalf("expected the Host header to be overridden, got %s", seen.Host)
+	}
+	if got := seen.Header.Get("X-Forwarded-Host"); got != "api.example.com" {
+		t.Fatalf("expected X-Forwarded-Host to keep the client's host, got %s", got)
+	}
+	if seen.RequestURI != "/users" {
+		t.Fatalf("expected the path to be kept, got %s", seen.RequestURI)
+	}
+}
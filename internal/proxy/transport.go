@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// TransportPool hands out one shared transport per upstream origin so that
+// routes pointing at the same backend reuse the same connection pool.
+// It is itself a RoundTripper that dispatches on the request's origin, which lets
+// a route with several upstream targets use one transport per target.
+// Routes with different timeouts get separate transports for the same origin.
+type TransportPool struct {
+	mu         sync.RWMutex
+	transports map[transportKey]*http.Transport
+}
+
+// TransportOptions are the per-route settings that need a transport of their own
+type TransportOptions struct {
+	ConnectTimeout        time.Duration // zero uses the 30s default
+	ResponseHeaderTimeout time.Duration // zero waits indefinitely
+}
+
+// TransportOptionsFromConfig extracts the transport settings of a route
+func TransportOptionsFromConfig(timeouts config.TimeoutsConfig) TransportOptions {
+	return TransportOptions{
+		ConnectTimeout:        timeouts.Connect,
+		ResponseHeaderTimeout: timeouts.ResponseHeader,
+	}
+}
+
+// transportKey identifies a pooled transport
+type transportKey struct {
+	origin  string
+	options TransportOptions
+}
+
+// NewTransportPool creates an empty transport pool
+func NewTransportPool() *TransportPool {
+	return &TransportPool{
+		transports: make(map[transportKey]*http.Transport),
+	}
+}
+
+// Get returns the default transport for the upstream's scheme and host, creating it on first use
+func (p *TransportPool) Get(upstream *url.URL) *http.Transport {
+	return p.GetWithOptions(upstream, TransportOptions{})
+}
+
+// GetWithOptions returns the transport for the upstream's scheme and host with the
+// given options, creating it on first use
+func (p *TransportPool) GetWithOptions(upstream *url.URL, options TransportOptions) *http.Transport {
+	key := transportKey{origin: originKey(upstream), options: options}
+
+	p.mu.RLock()
+	transport, ok := p.transports[key]
+	p.mu.RUnlock()
+	if ok {
+		return transport
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if transport, ok := p.transports[key]; ok {
+		return transport
+	}
+	transport = newTransportWithOptions(options)
+	p.transports[key] = transport
+	return transport
+}
+
+// RoundTrip sends the request through the default transport for its origin
+func (p *TransportPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	return p.Get(req.URL).RoundTrip(req)
+}
+
+// pooledTransport dispatches a route's requests on their origin to transports drawn
+// from a TransportPool when the route's proxy is built. Requests still in flight after
+// retain dropped one of them keep using it instead of adding it back to the pool.
+type pooledTransport struct {
+	transports map[string]*http.Transport // by origin; filled before serving, read-only after
+}
+
+func newPooledTransport() *pooledTransport {
+	return &pooledTransport{transports: make(map[string]*http.Transport)}
+}
+
+// add resolves the pool's transport for upstream with the given options
+func (t *pooledTransport) add(pool *TransportPool, upstream *url.URL, options TransportOptions) {
+	t.transports[originKey(upstream)] = pool.GetWithOptions(upstream, options)
+}
+
+func (t *pooledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, ok := t.transports[originKey(req.URL)]
+	if !ok {
+		return nil, fmt.Errorf("no transport for upstream %s", originKey(req.URL))
+	}
+	return transport.RoundTrip(req)
+}
+
+// CloseIdleConnections closes idle connections on the route's transports
+func (t *pooledTransport) CloseIdleConnections() {
+	for _, transport := range t.transports {
+		transport.CloseIdleConnections()
+	}
+}
+
+// Len returns the number of distinct transports in the pool
+func (p *TransportPool) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.transports)
+}
+
+// CloseIdleConnections closes idle connections on every pooled transport
+func (p *TransportPool) CloseIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, transport := range p.transports {
+		transport.CloseIdleConnections()
+	}
+}
+
+// retain drops every transport not in keep, closing its idle connections. A transport
+// is identified by origin and options, so one whose route changed timeouts is dropped
+// even though its origin is still in use. Requests still holding a dropped transport
+// can finish; it is simply no longer handed out.
+func (p *TransportPool) retain(keep map[transportKey]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, transport := range p.transports {
+		if !keep[key] {
+			transport.CloseIdleConnections()
+			delete(p.transports, key)
+		}
+	}
+}
+
+// originKey normalizes an upstream URL to scheme://host for pooling purposes
+func originKey(u *url.URL) string {
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+}
+
+// newTransportWithOptions creates a pooling transport with the given timeouts
+func newTransportWithOptions(options TransportOptions) *http.Transport {
+	connectTimeout := options.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = 30 * time.Second
+	}
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: options.ResponseHeaderTimeout,
+	}
+}
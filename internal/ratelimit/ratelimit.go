@@ -0,0 +1,198 @@
+// Package ratelimit decides whether a client may make another request under a
+// token-bucket or sliding-window limit. State lives behind the Store interface so
+// a shared backend can replace the in-memory default.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// Limit describes one configured rate limit
+type Limit struct {
+	Algorithm string // config.RateLimitTokenBucket or config.RateLimitSlidingWindow
+	Requests  int    // requests allowed per window
+	Window    time.Duration
+	Burst     int // token bucket capacity
+}
+
+// LimitFromConfig converts a validated rate_limit block
+func LimitFromConfig(cfg *config.RateLimitConfig) Limit {
+	return Limit{
+		Algorithm: cfg.Algorithm,
+		Requests:  cfg.Requests,
+		Window:    cfg.Window,
+		Burst:     cfg.Burst,
+	}
+}
+
+// Decision is the outcome of one request against a limit
+type Decision struct {
+	Allowed    bool
+	Limit      int           // requests allowed in a full window (or the bucket capacity)
+	Remaining  int           // requests still allowed right now
+	Reset      time.Duration // until the limit is fully replenished
+	RetryAfter time.Duration // until the next request would be allowed; zero when allowed
+}
+
+// Store keeps rate limit state. Allow consumes one request for key if the limit permits it.
+type Store interface {
+	Allow(ctx context.Context, key string, limit Limit) (Decision, error)
+}
+
+// MemoryStore keeps rate limit state in process memory
+type MemoryStore struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	states    map[string]*state
+	lastSweep time.Time
+}
+
+// sweepInterval is how often idle keys are dropped from a MemoryStore
+const sweepInterval = time.Minute
+
+// state is the per-key state of either algorithm
+type state struct {
+	// token bucket
+	tokens float64
+	last   time.Time
+
+	// sliding window: counts for the current and previous fixed windows
+	windowStart time.Time
+	current     int
+	previous    int
+
+	idleAfter time.Time // when the state carries no information any more
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		now:    time.Now,
+		states: make(map[string]*state),
+	}
+}
+
+// Allow implements Store
+func (s *MemoryStore) Allow(_ context.Context, key string, limit Limit) (Decision, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	st, ok := s.states[key]
+	if !ok {
+		st = &state{}
+		s.states[key] = st
+	}
+	if limit.Algorithm == config.RateLimitSlidingWindow {
+		return st.slidingWindow(now, limit), nil
+	}
+	return st.tokenBucket(now, limit, !ok), nil
+}
+
+// Len returns the number of keys with state
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.states)
+}
+
+// sweep drops keys whose state has fully decayed; must be called with s.mu held
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, st := range s.states {
+		if now.After(st.idleAfter) {
+			delete(s.states, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// tokenBucket refills Requests tokens per Window up to Burst and takes one
+func (st *state) tokenBucket(now time.Time, limit Limit, fresh bool) Decision {
+	capacity := float64(limit.Burst)
+	if capacity <= 0 {
+		capacity = float64(limit.Requests)
+	}
+	perToken := limit.Window / time.Duration(limit.Requests)
+
+	if fresh {
+		st.tokens = capacity
+	} else if elapsed := now.Sub(st.last); elapsed > 0 {
+		st.tokens = math.Min(capacity, st.tokens+elapsed.Seconds()/perToken.Seconds())
+	}
+	st.last = now
+
+	d := Decision{Limit: int(capacity)}
+	if st.tokens >= 1 {
+		st.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration((1 - st.tokens) * float64(perToken))
+	}
+	d.Remaining = int(st.tokens)
+	d.Reset = time.Duration((capacity - st.tokens) * float64(perToken))
+	st.idleAfter = now.Add(d.Reset)
+	return d
+}
+
+// slidingWindow approximates a sliding window by weighting the previous fixed
+// window's count by how much of it still overlaps the sliding window
+func (st *state) slidingWindow(now time.Time, limit Limit) Decision {
+	windowStart := now.Truncate(limit.Window)
+	switch {
+	case windowStart.Equal(st.windowStart):
+	case windowStart.Sub(st.windowStart) == limit.Window:
+		st.previous, st.current = st.current, 0
+	default:
+		st.previous, st.current = 0, 0
+	}
+	st.windowStart = windowStart
+
+	elapsed := now.Sub(windowStart)
+	overlap := 1 - float64(elapsed)/float64(limit.Window)
+	count := float64(st.previous)*overlap + float64(st.current)
+
+	d := Decision{Limit: limit.Requests}
+	if count+1 <= float64(limit.Requests) {
+		st.current++
+		count++
+		d.Allowed = true
+	} else {
+		d.RetryAfter = st.retryAfter(elapsed, limit)
+	}
+	d.Remaining = int(math.Max(0, float64(limit.Requests)-count))
+	// Everything counted so far has left the sliding window by the end of the next window
+	d.Reset = 2*limit.Window - elapsed
+	if st.current == 0 {
+		d.Reset = limit.Window - elapsed
+	}
+	st.idleAfter = windowStart.Add(2 * limit.Window)
+	return d
+}
+
+// retryAfter finds when the weighted count drops far enough to admit one more request
+func (st *state) retryAfter(elapsed time.Duration, limit Limit) time.Duration {
+	room := limit.Requests - 1 - st.current
+	if room >= 0 && st.previous > 0 {
+		// previous*(1 - t/window) <= room  =>  t >= window*(previous - room)/previous
+		t := limit.Window * time.Duration(st.previous-room) / time.Duration(st.previous)
+		if wait := t - elapsed; wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	// The current window alone is full: wait for it to become the previous window
+	// and decay enough, computed the same way one window later
+	t := limit.Window * time.Duration(st.current-(limit.Requests-1)) / time.Duration(st.current)
+	return limit.Window - elapsed + max(0, t)
+}
@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// newTestStore returns a store with a controllable clock
+func newTestStore() (*MemoryStore, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func allowN(t *testing.T, s *MemoryStore, key string, limit Limit, n int) Decision {
+	t.Helper()
+	var d Decision
+	for i := 0; i < n; i++ {
+		d, _ = s.Allow(context.Background(), key, limit)
+		if !d.Allowed {
+			t.Fatalf("request %d of %d unexpectedly denied: %+v", i+1, n, d)
+		}
+	}
+	return d
+}
+
+func TestTokenBucketAllowsBurstThenRefills(t *testing.T) {
+	s, now := newTestStore()
+	limit := Limit{Algorithm: config.RateLimitTokenBucket, Requests: 10, Window: 10 * time.Second, Burst: 3}
+
+	last := allowN(t, s, "k", limit, 3)
+	if last.Remaining != 0 || last.Limit != 3 {
+		t.Fatalf("unexpected decision after burst: %+v", last)
+	}
+	denied, _ := s.Allow(context.Background(), "k", limit)
+	if denied.Allowed || denied.RetryAfter != time.Second {
+		t.Fatalf("expected denial with 1s retry, got %+v", denied)
+	}
+
+	// One token per second
+	*now = now.Add(time.Second)
+	allowN(t, s, "k", limit, 1)
+	if d, _ := s.Allow(context.Background(), "k", limit); d.Allowed {
+		t.Fatal("expected only one refilled token")
+	}
+
+	*now = now.Add(time.Hour)
+	if d := allowN(t, s, "k", limit, 1); d.Remaining != 2 {
+		t.Fatalf("expected bucket to refill only up to burst, got %+v", d)
+	}
+}
+
+func TestTokenBucketKeysAreIndependent(t *testing.T) {
+	s, _ := newTestStore()
+	limit := Limit{Algorithm: config.RateLimitTokenBucket, Requests: 1, Window: time.Minute, Burst: 1}
+
+	allowN(t, s, "alice", limit, 1)
+	allowN(t, s, "bob", limit, 1)
+	if d, _ := s.Allow(context.Background(), "alice", limit); d.Allowed {
+		t.Fatal("expected alice to be limited")
+	}
+}
+
+func TestSlidingWindowWeightsPreviousWindow(t *testing.T) {
+	s, now := newTestStore()
+	*now = now.Truncate(time.Minute)
+	limit := Limit{Algorithm: config.RateLimitSlidingWindow, Requests: 10, Window: time.Minute}
+
+	allowN(t, s, "k", limit, 10)
+	denied, _ := s.Allow(context.Background(), "k", limit)
+	if denied.Allowed || denied.Remaining != 0 {
+		t.Fatalf("expected denial once the window is full, got %+v", denied)
+	}
+	if denied.RetryAfter != time.Minute+6*time.Second {
+		t.Fatalf("expected retry once the full window has decayed by one request, got %v", denied.RetryAfter)
+	}
+
+	// Halfway through the next window, half of the previous window still counts
+	*now = now.Add(90 * time.Second)
+	d := allowN(t, s, "k", limit, 5)
+	if d.Remaining != 0 {
+		t.Fatalf("expected 5 requests to fill the sliding window, got %+v", d)
+	}
+	denied, _ = s.Allow(context.Background(), "k", limit)
+	if denied.Allowed {
+		t.Fatal("expected the sliding window to be full")
+	}
+	if denied.RetryAfter != 6*time.Second {
+		t.Fatalf("expected retry once one more previous request has left, got %v", denied.RetryAfter)
+	}
+
+	// Two windows later nothing counts any more
+	*now = now.Add(2 * time.Minute)
+	allowN(t, s, "k", limit, 10)
+}
+
+func TestMemoryStoreSweepsIdleKeys(t *testing.T) {
+	s, now := newTestStore()
+	limit := Limit{Algorithm: config.RateLimitTokenBucket, Requests: 1, Window: time.Second, Burst: 1}
+	for _, key := range []string{"a", "b", "c"} {
+		s.Allow(context.Background(), key, limit)
+	}
+
+	*now = now.Add(2 * sweepInterval)
+	s.Allow(context.Background(), "d", limit)
+	if s.Len() != 1 {
+		t.Fatalf("expected idle keys to be swept, %d remain", s.Len())
+	}
+}
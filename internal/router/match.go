@@ -0,0 +1,76 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// matchesRequest reports whether the request satisfies the route's match block
+// beyond its path; a route without one matches every request
+func (route *Route) matchesRequest(req *http.Request) bool {
+	m := route.Match
+	if m == nil {
+		return true
+	}
+	if len(m.Hosts) > 0 && !matchesHost(requestHost(req), m.Hosts) {
+		return false
+	}
+	for i := range m.Headers {
+		if !m.Headers[i].Matches(req.Header.Values(m.Headers[i].Name)) {
+			return false
+		}
+	}
+	if len(m.Query) > 0 {
+		query := req.URL.Query()
+		for i := range m.Query {
+			if !m.Query[i].Matches(query[m.Query[i].Name]) {
+				return false
+			}
+		}
+	}
+	if len(m.ContentTypes) > 0 && !matchesMediaType(req.Header.Get("Content-Type"), m.ContentTypes) {
+		return false
+	}
+	return true
+}
+
+// requestHost returns the request's host in lower case, without port or trailing dot
+func requestHost(req *http.Request) string {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// matchesHost reports whether host is one of hosts; "*.example.com" matches any
+// subdomain of example.com but not example.com itself
+func matchesHost(host string, hosts []string) bool {
+	for _, pattern := range hosts {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if len(host) > len(suffix) && strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMediaType reports whether the media type of a Content-Type header is one of
+// patterns; "type/*" matches a whole type
+func matchesMediaType(contentType string, patterns []string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if pattern == mediaType || (strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, pattern[:len(pattern)-1])) {
+			return true
+		}
+	}
+	return false
+}
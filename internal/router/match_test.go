@@ -0,0 +1,103 @@
+package router
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+func withMatch(route config.RouteConfig, match *config.RouteMatchConfig) config.RouteConfig {
+	route.Match = match
+	return route
+}
+
+func TestMatchRouteByHost(t *testing.T) {
+	r := NewRouter([]config.RouteConfig{
+		withMatch(templateRoute(t, "eu", "/api/{rest...}"), &config.RouteMatchConfig{Hosts: []string{"eu.example.com"}}),
+		withMatch(templateRoute(t, "tenants", "/api/{rest...}"), &config.RouteMatchConfig{Hosts: []string{"*.example.com"}}),
+		templateRoute(t, "default", "/api/{rest...}"),
+	}, nil)
+
+	tests := []struct {
+		host, route string
+	}{
+		{"eu.example.com", "eu"},
+		{"EU.Example.com:8443", "eu"},
+		{"acme.example.com", "tenants"},
+		{"a.b.example.com.", "tenants"},
+		{"example.com", "default"},
+		{"badexample.com", "default"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/users", nil)
+		req.Host = tt.host
+		if route, _, _ := r.MatchRoute(req); route == nil || route.Name != tt.route {
+			t.Errorf("host %s: expected route %s, got %v", tt.host, tt.route, route)
+		}
+	}
+}
+
+func TestMatchRouteByHeadersQueryAndContentType(t *testing.T) {
+	r := NewRouter([]config.RouteConfig{
+		withMatch(patternRoute("v2", "^/orders$", "GET", "POST"), &config.RouteMatchConfig{
+			Headers: []config.ValueMatcher{{Name: "X-Api-Version", Value: "2"}},
+		}),
+		withMatch(patternRoute("beta", "^/orders$", "GET", "POST"), &config.RouteMatchConfig{
+			Headers: []config.ValueMatcher{{Name: "X-Tenant", Pattern: "^beta-", Compiled: regexp.MustCompile("^beta-")}},
+		}),
+		withMatch(patternRoute("debug", "^/orders$", "GET", "POST"), &config.RouteMatchConfig{
+			Headers: []config.ValueMatcher{{Name: "X-Debug"}},
+			Query:   []config.ValueMatcher{{Name: "trace"}},
+		}),
+		withMatch(patternRoute("csv", "^/orders$", "GET", "POST"), &config.RouteMatchConfig{
+			Query:        []config.ValueMatcher{{Name: "format", Value: "csv"}},
+			ContentTypes: []string{"text/*"},
+		}),
+		withMatch(patternRoute("json", "^/orders$", "GET", "POST"), &config.RouteMatchConfig{
+			ContentTypes: []string{"application/json"},
+		}),
+		patternRoute("default", "^/orders$", "GET", "POST"),
+	}, nil)
+
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string][]string
+		route   string
+	}{
+		{"exact header", "/orders", map[string][]string{"X-Api-Version": {"2"}}, "v2"},
+		{"exact header mismatch", "/orders", map[string][]string{"X-Api-Version": {"20"}}, "default"},
+		{"any of several values", "/orders", map[string][]string{"X-Api-Version": {"1", "2"}}, "v2"},
+		{"header regex", "/orders", map[string][]string{"X-Tenant": {"beta-acme"}}, "beta"},
+		{"presence needs every condition", "/orders", map[string][]string{"X-Debug": {""}}, "default"},
+		{"presence", "/orders?trace", map[string][]string{"X-Debug": {"1"}}, "debug"},
+		{"query and content type", "/orders?format=csv", map[string][]string{"Content-Type": {"text/csv"}}, "csv"},
+		{"content type with parameters", "/orders", map[string][]string{"Content-Type": {"Application/JSON; charset=utf-8"}}, "json"},
+		{"no content type", "/orders?format=csv", nil, "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.target, strings.NewReader(""))
+			for name, values := range tt.headers {
+				req.Header[name] = values
+			}
+			if route, _, _ := r.MatchRoute(req); route == nil || route.Name != tt.route {
+				t.Fatalf("expected route %s, got %v", tt.route, route)
+			}
+		})
+	}
+}
+
+func TestMatchRouteReturnsNilWhenMatchBlockFails(t *testing.T) {
+	r := NewRouter([]config.RouteConfig{
+		withMatch(templateRoute(t, "api", "/api"), &config.RouteMatchConfig{Hosts: []string{"api.example.com"}}),
+	}, nil)
+	req := httptest.NewRequest("GET", "/api", nil)
+	req.Host = "www.example.com"
+	if route, _, _ := r.MatchRoute(req); route != nil {
+		t.Fatalf("expected no match for another host, got %s", route.Name)
+	}
+}
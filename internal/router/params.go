@@ -0,0 +1,51 @@
+package router
+
+import "context"
+
+// Param is a path parameter captured by the matched route
+type Param struct {
+	Name  string
+	Value string
+	Raw   string // Value escaped for a URL path, keeping an encoded slash apart from a separator
+}
+
+// Params are the path parameters of a request in the order they appear in the path.
+// Values are decoded, so an encoded slash in a {name} segment arrives as "/"; Raw
+// keeps it as %2F.
+type Params []Param
+
+// Get returns the value of the named parameter, or "" if the route has none by that name
+func (ps Params) Get(name string) string {
+	for _, p := range ps {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// Map returns the parameters keyed by name; nil when there are none
+func (ps Params) Map() map[string]string {
+	if len(ps) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(ps))
+	for _, p := range ps {
+		m[p.Name] = p.Value
+	}
+	return m
+}
+
+// paramsKey is the context key of a request's Params
+type paramsKey struct{}
+
+// WithParams returns a copy of ctx carrying the matched route's path parameters
+func WithParams(ctx context.Context, ps Params) context.Context {
+	return context.WithValue(ctx, paramsKey{}, ps)
+}
+
+// ParamsFromContext returns the path parameters stored by WithParams, if any
+func ParamsFromContext(ctx context.Context) Params {
+	ps, _ := ctx.Value(paramsKey{}).(Params)
+	return ps
+}
@@ -2,55 +2,113 @@ package router
 
 import (
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/aveiga/cloud-api-gateway/internal/config"
 )
 
-// Router matches incoming requests against configured routes
+// HandlerSource supplies the prebuilt upstream handler for a configured route
+type HandlerSource interface {
+	Handler(route *config.RouteConfig) http.Handler
+}
+
+// Route is a configured route paired with the handler built for it at startup
+type Route struct {
+	*config.RouteConfig
+	Handler http.Handler
+
+	paramNames  []string // names of a path template's parameters, in path order
+	namedGroups bool     // the path pattern has named capture groups
+}
+
+// Router matches incoming requests against configured routes. Routes with a path
+// template live in a radix tree and are tried first, most specific template first;
+// routes with a path pattern are then tried in configuration order.
 type Router struct {
-	routes []*config.RouteConfig
+	tree   *node
+	routes []*Route // routes with a path pattern
 }
 
-// NewRouter creates a new router with the given routes
-func NewRouter(routes []config.RouteConfig) *Router {
-	// Convert slice of values to slice of pointers for efficient access
-	routePtrs := make([]*config.RouteConfig, len(routes))
+// NewRouter creates a new router with the given routes.
+// Handlers are resolved once from source; a nil source leaves Route.Handler nil.
+func NewRouter(routes []config.RouteConfig, source HandlerSource) *Router {
+	r := &Router{tree: &node{}}
 	for i := range routes {
-		routePtrs[i] = &routes[i]
-	}
-
-	return &Router{
-		routes: routePtrs,
+		route := &Route{RouteConfig: &routes[i]}
+		if source != nil {
+			route.Handler = source.Handler(&routes[i])
+		}
+		switch {
+		case len(route.CompiledPath) > 0:
+			r.tree.insert(route)
+		case route.CompiledPattern != nil:
+			route.namedGroups = slices.ContainsFunc(route.CompiledPattern.SubexpNames(), func(name string) bool { return name != "" })
+			r.routes = append(r.routes, route)
+		}
 	}
+	return r
 }
 
-// MatchRoute finds the first route that matches request path and method.
-// It returns the route and all method-matching rules for downstream auth decisions.
-func (r *Router) MatchRoute(req *http.Request) (*config.RouteConfig, []config.RouteRule) {
-	path := req.URL.Path
+// MatchRoute finds the route that matches request path, match block and method.
+// It returns the route with its prebuilt handler, all method-matching rules
+// for downstream auth decisions and the path parameters the route captured.
+func (r *Router) MatchRoute(req *http.Request) (*Route, []config.RouteRule, Params) {
 	method := strings.ToUpper(req.Method)
 
+	var (
+		matched *Route
+		rules   []config.RouteRule
+		params  Params
+	)
+	path, escaped := matchPath(req.URL)
+	r.tree.lookup(path, lowerASCII(path), nil, func(route *Route, values []string) bool {
+		if !route.matchesRequest(req) {
+			return false
+		}
+		if rules = route.matchingRules(method); rules == nil {
+			return false
+		}
+		matched, params = route, route.templateParams(values, escaped)
+		return true
+	})
+	if matched != nil {
+		return matched, rules, params
+	}
+
+	path = req.URL.Path
 	for _, route := range r.routes {
 		// Check if path matches regex pattern (case-insensitive matching via compiled pattern)
-		if !route.CompiledPattern.MatchString(path) {
+		var match []string
+		if route.namedGroups {
+			if match = route.CompiledPattern.FindStringSubmatch(path); match == nil {
+				continue
+			}
+		} else if !route.CompiledPattern.MatchString(path) {
 			continue
 		}
 
-		var matchingRules []config.RouteRule
-		for _, rule := range route.Rules {
-			if methodMatches(rule.Methods, method) {
-				matchingRules = append(matchingRules, rule)
-			}
+		if !route.matchesRequest(req) {
+			continue
 		}
-		if len(matchingRules) == 0 {
+		if rules = route.matchingRules(method); rules == nil {
 			continue
 		}
-
-		return route, matchingRules
+		return route, rules, patternParams(route.RouteConfig, match)
 	}
 
-	return nil, nil
+	return nil, nil, nil
+}
+
+// matchingRules returns the route's rules that allow method, or nil
+func (route *Route) matchingRules(method string) []config.RouteRule {
+	var matchingRules []config.RouteRule
+	for _, rule := range route.Rules {
+		if methodMatches(rule.Methods, method) {
+			matchingRules = append(matchingRules, rule)
+		}
+	}
+	return matchingRules
 }
 
 func methodMatches(allowed []string, method string) bool {
@@ -1,6 +1,7 @@
 package router
 
 import (
+	"net/http"
 	"net/http/httptest"
 	"regexp"
 	"testing"
@@ -20,10 +21,10 @@ func TestMatchRouteProtectedUsesRuleMethods(t *testing.T) {
 				{Methods: []string{"POST"}, RequiredRoles: []string{"writer"}, RequireAllRoles: true},
 			},
 		},
-	})
+	}, nil)
 
 	req := httptest.NewRequest("POST", "/api/users", nil)
-	route, rules := r.MatchRoute(req)
+	route, rules, _ := r.MatchRoute(req)
 	if route == nil {
 		t.Fatal("expected route to match")
 	}
@@ -43,10 +44,10 @@ func TestMatchRoutePublicUsesRuleMethods(t *testing.T) {
 			},
 			Upstream: "http://health:8080",
 		},
-	})
+	}, nil)
 
 	req := httptest.NewRequest("GET", "/health", nil)
-	route, rules := r.MatchRoute(req)
+	route, rules, _ := r.MatchRoute(req)
 	if route == nil {
 		t.Fatal("expected public route to match")
 	}
@@ -55,7 +56,7 @@ func TestMatchRoutePublicUsesRuleMethods(t *testing.T) {
 	}
 
 	req = httptest.NewRequest("POST", "/health", nil)
-	route, _ = r.MatchRoute(req)
+	route, _, _ = r.MatchRoute(req)
 	if route != nil {
 		t.Fatal("expected POST /health to be rejected by rule methods filter")
 	}
@@ -72,10 +73,10 @@ func TestMatchRouteReturnsNilWhenNoMatch(t *testing.T) {
 				{Methods: []string{"GET"}, RequiredRoles: []string{"reader"}, RequireAllRoles: true},
 			},
 		},
-	})
+	}, nil)
 
 	req := httptest.NewRequest("GET", "/api/other", nil)
-	route, rules := r.MatchRoute(req)
+	route, rules, _ := r.MatchRoute(req)
 	if route != nil || rules != nil {
 		t.Fatalf("expected no match for wrong path, got route=%v rules=%v", route, rules)
 	}
@@ -92,10 +93,10 @@ func TestMatchRouteReturnsNilWhenMethodNotAllowed(t *testing.T) {
 			},
 			Upstream: "http://health:8080",
 		},
-	})
+	}, nil)
 
 	req := httptest.NewRequest("PUT", "/health", nil)
-	route, rules := r.MatchRoute(req)
+	route, rules, _ := r.MatchRoute(req)
 	if route != nil || rules != nil {
 		t.Fatalf("expected no match for PUT when only GET allowed, got route=%v", route)
 	}
@@ -112,15 +113,49 @@ func TestMatchRouteCaseInsensitivePath(t *testing.T) {
 			},
 			Upstream: "http://health:8080",
 		},
-	})
+	}, nil)
 
 	req := httptest.NewRequest("GET", "/HEALTH", nil)
-	route, rules := r.MatchRoute(req)
+	route, rules, _ := r.MatchRoute(req)
 	if route == nil || len(rules) != 1 {
 		t.Fatalf("expected match for case-insensitive path, got route=%v rules=%v", route, rules)
 	}
 }
 
+type staticSource map[string]http.Handler
+
+func (s staticSource) Handler(route *config.RouteConfig) http.Handler {
+	return s[route.Name]
+}
+
+func TestMatchRouteReturnsPrebuiltHandler(t *testing.T) {
+	usersHandler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	r := NewRouter([]config.RouteConfig{
+		{
+			Name:            "users",
+			PathPattern:     "^/api/users(/.*)?$",
+			CompiledPattern: regexp.MustCompile(`(?i)^/api/users(/.*)?$`),
+			Upstream:        "http://users:8080",
+			Rules: []config.RouteRule{
+				{Methods: []string{"GET"}},
+			},
+		},
+	}, staticSource{"users": usersHandler})
+
+	req := httptest.NewRequest("GET", "/api/users/1", nil)
+	first, _, _ := r.MatchRoute(req)
+	second, _, _ := r.MatchRoute(req)
+	if first == nil || first.Handler == nil {
+		t.Fatal("expected matched route to carry a prebuilt handler")
+	}
+	if first != second {
+		t.Fatal("expected the same route entry on every match")
+	}
+	if first.Name != "users" {
+		t.Fatalf("expected embedded route config, got %q", first.Name)
+	}
+}
+
 func boolPtr(v bool) *bool {
 	return &v
 }
@@ -0,0 +1,241 @@
+package router
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// node is a node of the radix tree holding routes with a path template. Static text
+// is stored lowercased and compressed into shared prefixes; parameters hang off the
+// node that ends with the slash before them.
+type node struct {
+	prefix   string  // static text matched by this node
+	indices  string  // first byte of each static child's prefix
+	children []*node // static children, in the order of indices
+	param    *node   // child matching one non-empty segment
+	catchAll *node   // child matching the rest of the path
+	routes   []*Route
+}
+
+// insert adds a route under its compiled path template
+func (n *node) insert(route *Route) {
+	segments := route.CompiledPath
+	static := "/"
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		if segment.Param == "" {
+			static += lowerASCII(segment.Literal)
+			if !last {
+				static += "/"
+			}
+			continue
+		}
+
+		n = n.insertStatic(static)
+		static = ""
+		if segment.CatchAll {
+			if n.catchAll == nil {
+				n.catchAll = &node{}
+			}
+			n = n.catchAll
+		} else {
+			if n.param == nil {
+				n.param = &node{}
+			}
+			n = n.param
+		}
+		route.paramNames = append(route.paramNames, segment.Param)
+		if !last {
+			static = "/"
+		}
+	}
+	n = n.insertStatic(static)
+	n.routes = append(n.routes, route)
+}
+
+// insertStatic returns the node reached by matching s from n, creating and splitting
+// nodes as needed
+func (n *node) insertStatic(s string) *node {
+	for s != "" {
+		i := strings.IndexByte(n.indices, s[0])
+		if i < 0 {
+			child := &node{prefix: s}
+			n.indices += s[:1]
+			n.children = append(n.children, child)
+			return child
+		}
+
+		child := n.children[i]
+		common := commonPrefixLen(child.prefix, s)
+		if common < len(child.prefix) {
+			tail := *child
+			tail.prefix = child.prefix[common:]
+			*child = node{prefix: child.prefix[:common], indices: tail.prefix[:1], children: []*node{&tail}}
+		}
+		n, s = child, s[common:]
+	}
+	return n
+}
+
+// lookup walks the tree for the rest of a path, most specific match first: static text
+// before a parameter before a catch-all. lower is path with ASCII letters lowercased.
+// Every route whose template matches is offered to accept with the parameter values
+// captured on the way, until accept takes one.
+func (n *node) lookup(path, lower string, values []string, accept func(*Route, []string) bool) bool {
+	if path == "" {
+		for _, route := range n.routes {
+			if accept(route, values) {
+				return true
+			}
+		}
+	} else {
+		if i := strings.IndexByte(n.indices, lower[0]); i >= 0 {
+			child := n.children[i]
+			if strings.HasPrefix(lower, child.prefix) &&
+				child.lookup(path[len(child.prefix):], lower[len(child.prefix):], values, accept) {
+				return true
+			}
+		}
+		if n.param != nil {
+			end := strings.IndexByte(path, '/')
+			if end < 0 {
+				end = len(path)
+			}
+			if end > 0 && n.param.lookup(path[end:], lower[end:], append(values, path[:end]), accept) {
+				return true
+			}
+		}
+	}
+	if n.catchAll != nil {
+		values = append(values, path)
+		for _, route := range n.catchAll.routes {
+			if accept(route, values) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func commonPrefixLen(a, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// lowerASCII lowercases ASCII letters only, so the result has the same length as s and
+// offsets into it are offsets into s; s is returned as is when it has no upper case
+func lowerASCII(s string) string {
+	i := strings.IndexFunc(s, func(r rune) bool { return r >= 'A' && r <= 'Z' })
+	if i < 0 {
+		return s
+	}
+	b := []byte(s)
+	for ; i < len(b); i++ {
+		if c := b[i]; c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// segmentEscaper keeps the characters that would change segment boundaries escaped
+var segmentEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// matchPath returns the path the tree matches against: the decoded path, except that
+// encoded slashes and percent signs stay escaped so that '/' always separates
+// segments. escaped reports whether captured values still need unescaping.
+func matchPath(u *url.URL) (path string, escaped bool) {
+	if u.RawPath == "" && !strings.Contains(u.Path, "%") {
+		return u.Path, false
+	}
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, segment := range segments {
+		if decoded, err := url.PathUnescape(segment); err == nil {
+			segment = decoded
+		}
+		segments[i] = segmentEscaper.Replace(segment)
+	}
+	return strings.Join(segments, "/"), true
+}
+
+// templateParams pairs a tree route's parameter names with the captured values
+func (route *Route) templateParams(values []string, escaped bool) Params {
+	if len(values) == 0 {
+		return nil
+	}
+	params := make(Params, len(values))
+	for i, value := range values {
+		params[i] = newParam(route.paramNames[i], value, escaped)
+	}
+	return params
+}
+
+// newParam builds a parameter from a value captured from the match path
+func newParam(name, value string, escaped bool) Param {
+	raw := escapeSegments(value, escaped)
+	if escaped {
+		if decoded, err := url.PathUnescape(value); err == nil {
+			value = decoded
+		}
+	}
+	return Param{Name: name, Value: value, Raw: raw}
+}
+
+// PatternCaptures returns every capture group of a path pattern in order, matched
+// like path templates against the path with %2F and %25 kept escaped, so an encoded
+// slash in a group is told apart from a separator. When only the fully decoded path
+// matches, groups are taken from it. It returns nil when neither matches.
+func PatternCaptures(pattern *regexp.Regexp, u *url.URL) Params {
+	path, escaped := matchPath(u)
+	match := pattern.FindStringSubmatch(path)
+	if match == nil && escaped {
+		path, escaped = u.Path, false
+		match = pattern.FindStringSubmatch(path)
+	}
+	if match == nil {
+		return nil
+	}
+	names := pattern.SubexpNames()
+	captures := make(Params, 0, len(match)-1)
+	for i := 1; i < len(match); i++ {
+		captures = append(captures, newParam(names[i], match[i], escaped))
+	}
+	return captures
+}
+
+// escapeSegments escapes each segment of a value captured from the match path, where
+// escaped segments hold only %25 and %2F
+func escapeSegments(value string, escaped bool) string {
+	segments := strings.Split(value, "/")
+	for i, segment := range segments {
+		if escaped {
+			if decoded, err := url.PathUnescape(segment); err == nil {
+				segment = decoded
+			}
+		}
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// patternParams returns the named capture groups of a regex route's match
+func patternParams(route *config.RouteConfig, match []string) Params {
+	if match == nil {
+		return nil
+	}
+	var params Params
+	for i, name := range route.CompiledPattern.SubexpNames() {
+		if name != "" && i < len(match) {
+			params = append(params, Param{Name: name, Value: match[i], Raw: escapeSegments(match[i], false)})
+		}
+	}
+	return params
+}
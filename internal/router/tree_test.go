@@ -0,0 +1,201 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// templateRoute builds a route with a compiled path template
+func templateRoute(t testing.TB, name, path string, methods ...string) config.RouteConfig {
+	t.Helper()
+	segments, err := config.CompilePathTemplate(path)
+	if err != nil {
+		t.Fatalf("CompilePathTemplate(%q): %v", path, err)
+	}
+	if len(methods) == 0 {
+		methods = []string{"GET"}
+	}
+	return config.RouteConfig{
+		Name:         name,
+		Path:         path,
+		CompiledPath: segments,
+		Rules:        []config.RouteRule{{Methods: methods}},
+	}
+}
+
+// patternRoute builds a route with a compiled path pattern
+func patternRoute(name, pattern string, methods ...string) config.RouteConfig {
+	if len(methods) == 0 {
+		methods = []string{"GET"}
+	}
+	return config.RouteConfig{
+		Name:            name,
+		PathPattern:     pattern,
+		CompiledPattern: regexp.MustCompile("(?i)" + pattern),
+		Rules:           []config.RouteRule{{Methods: methods}},
+	}
+}
+
+func TestMatchRouteTemplates(t *testing.T) {
+	r := NewRouter([]config.RouteConfig{
+		templateRoute(t, "user", "/api/v1/users/{id}"),
+		templateRoute(t, "me", "/api/v1/users/me"),
+		templateRoute(t, "orders", "/api/v1/users/{userID}/orders/{orderID}"),
+		templateRoute(t, "files", "/files/{path...}"),
+		templateRoute(t, "userlist", "/api/v1/users/"),
+		templateRoute(t, "root", "/"),
+	}, nil)
+
+	tests := []struct {
+		path   string
+		route  string
+		params Params
+	}{
+		{"/api/v1/users/42", "user", Params{{"id", "42", "42"}}},
+		{"/api/v1/users/me", "me", nil},
+		{"/API/V1/Users/ME", "me", nil},
+		{"/API/v1/users/Ann", "user", Params{{"id", "Ann", "Ann"}}},
+		{"/api/v1/users/7/orders/9", "orders", Params{{"userID", "7", "7"}, {"orderID", "9", "9"}}},
+		{"/api/v1/users/", "userlist", nil},
+		{"/files/a/b/c.txt", "files", Params{{"path", "a/b/c.txt", "a/b/c.txt"}}},
+		{"/files/", "files", Params{{"path", "", ""}}},
+		{"/", "root", nil},
+		{"/api/v1/users", "", nil},
+		{"/api/v1/users/42/", "", nil},
+		{"/api/v1/users/7/orders", "", nil},
+		{"/files", "", nil},
+	}
+	for _, tt := range tests {
+		route, _, params := r.MatchRoute(httptest.NewRequest("GET", tt.path, nil))
+		if tt.route == "" {
+			if route != nil {
+				t.Errorf("%s: expected no match, got %s", tt.path, route.Name)
+			}
+			continue
+		}
+		if route == nil || route.Name != tt.route {
+			t.Errorf("%s: expected route %s, got %v", tt.path, tt.route, route)
+			continue
+		}
+		if fmt.Sprint(params) != fmt.Sprint(tt.params) {
+			t.Errorf("%s: expected params %v, got %v", tt.path, tt.params, params)
+		}
+	}
+}
+
+func TestMatchRouteBacktracksOnMethod(t *testing.T) {
+	r := NewRouter([]config.RouteConfig{
+		templateRoute(t, "me", "/users/me", "GET"),
+		templateRoute(t, "user-write", "/users/{id}", "PUT"),
+		templateRoute(t, "user-read", "/users/{id}", "GET", "PUT"),
+		templateRoute(t, "catch-all", "/{rest...}", "DELETE"),
+	}, nil)
+
+	tests := []struct {
+		method, path, route string
+	}{
+		{"GET", "/users/me", "me"},
+		{"PUT", "/users/me", "user-write"}, // the static route does not allow PUT
+		{"GET", "/users/42", "user-read"},
+		{"DELETE", "/users/42", "catch-all"},
+	}
+	for _, tt := range tests {
+		route, rules, _ := r.MatchRoute(httptest.NewRequest(tt.method, tt.path, nil))
+		if route == nil || route.Name != tt.route || len(rules) != 1 {
+			t.Errorf("%s %s: expected route %s, got %v", tt.method, tt.path, tt.route, route)
+		}
+	}
+}
+
+func TestMatchRouteFallsBackToPatterns(t *testing.T) {
+	r := NewRouter([]config.RouteConfig{
+		patternRoute("legacy", "^/api/users(/.*)?$"),
+		templateRoute(t, "user", "/api/users/{id}"),
+		patternRoute("named", `^/reports/(?P<year>\d{4})/(?P<month>\d{2})$`),
+	}, nil)
+
+	route, _, params := r.MatchRoute(httptest.NewRequest("GET", "/api/users/42", nil))
+	if route == nil || route.Name != "user" || params.Get("id") != "42" {
+		t.Fatalf("expected the template to win over an earlier pattern, got %v %v", route, params)
+	}
+	route, _, _ = r.MatchRoute(httptest.NewRequest("GET", "/api/users/42/roles", nil))
+	if route == nil || route.Name != "legacy" {
+		t.Fatalf("expected the pattern to match what no template does, got %v", route)
+	}
+	route, _, params = r.MatchRoute(httptest.NewRequest("GET", "/reports/2024/05", nil))
+	if route == nil || params.Get("year") != "2024" || params.Get("month") != "05" {
+		t.Fatalf("expected named groups as params, got %v %v", route, params)
+	}
+}
+
+func TestMatchRouteKeepsEncodedSlashesInSegments(t *testing.T) {
+	r := NewRouter([]config.RouteConfig{
+		templateRoute(t, "object", "/buckets/{bucket}/objects/{key}"),
+		templateRoute(t, "files", "/files/{path...}"),
+	}, nil)
+
+	route, _, params := r.MatchRoute(httptest.NewRequest("GET", "/buckets/b1/objects/a%2Fb%25c", nil))
+	if route == nil || route.Name != "object" || params.Get("key") != "a/b%c" || params[1].Raw != "a%2Fb%25c" {
+		t.Fatalf("expected an encoded slash to stay within its segment, got %v %v", route, params)
+	}
+	route, _, params = r.MatchRoute(httptest.NewRequest("GET", "/files/dir/a%2Fb", nil))
+	if route == nil || params.Get("path") != "dir/a/b" || params[0].Raw != "dir/a%2Fb" {
+		t.Fatalf("expected the catch-all value to be decoded, got %v %v", route, params)
+	}
+	route, _, params = r.MatchRoute(httptest.NewRequest("GET", "/buckets/b%31/objects/k", nil))
+	if route == nil || params.Get("bucket") != "b1" {
+		t.Fatalf("expected other escapes to be decoded, got %v %v", route, params)
+	}
+}
+
+func TestParamsFromContext(t *testing.T) {
+	if ps := ParamsFromContext(context.Background()); ps != nil {
+		t.Fatalf("expected no params, got %v", ps)
+	}
+	ps := Params{{"id", "42", "42"}}
+	got := ParamsFromContext(WithParams(context.Background(), ps))
+	if got.Get("id") != "42" || got.Get("missing") != "" || got.Map()["id"] != "42" {
+		t.Fatalf("unexpected params %v", got)
+	}
+}
+
+// benchmarkRoutes returns n routes of the shape /api/v1/serviceN/items/{id}, as path
+// templates or as the equivalent path patterns
+func benchmarkRoutes(b *testing.B, n int, templates bool) []config.RouteConfig {
+	routes := make([]config.RouteConfig, n)
+	for i := range routes {
+		name := fmt.Sprintf("service%d", i)
+		if templates {
+			routes[i] = templateRoute(b, name, "/api/v1/"+name+"/items/{id}")
+		} else {
+			routes[i] = patternRoute(name, "^/api/v1/"+name+"/items/[^/]+$")
+		}
+	}
+	return routes
+}
+
+// BenchmarkMatchRoute compares the radix tree with the linear scan over path patterns,
+// matching the last configured route
+func BenchmarkMatchRoute(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		for _, kind := range []string{"tree", "linear"} {
+			b.Run(fmt.Sprintf("%s/%d", kind, n), func(b *testing.B) {
+				r := NewRouter(benchmarkRoutes(b, n, kind == "tree"), nil)
+				req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/service%d/items/42", n-1), nil)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if route, _, _ := r.MatchRoute(req); route == nil {
+						b.Fatal("expected a match")
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/tracing/tracingtest"
+)
+
+const remoteTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// recordingExporter keeps exported spans in memory
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*SpanData
+}
+
+func (e *recordingExporter) ExportSpans(_ context.Context, spans []*SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(context.Context) error { return nil }
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent(remoteTraceparent)
+	if err != nil {
+		t.Fatalf("ParseTraceparent: %v", err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Fatalf("unexpected span context: %+v", sc)
+	}
+	if sc.Traceparent() != remoteTraceparent {
+		t.Fatalf("expected round trip, got %s", sc.Traceparent())
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+
+	// Future versions may append fields
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Fatalf("expected future version to parse: %v", err)
+	}
+}
+
+func TestStartServerContinuesRemoteTrace(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracerWithExporter(1, time.Hour, exporter)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(TraceparentHeader, remoteTraceparent)
+	req.Header.Set(TracestateHeader, "vendor=value")
+
+	ctx, server := tracer.StartServer(req, "GET")
+	_, child := Start(ctx, "child", SpanKindInternal)
+	child.SetAttribute("key", "value")
+	child.End()
+	server.End()
+
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(exporter.spans))
+	}
+	childData, serverData := exporter.spans[0], exporter.spans[1]
+	if serverData.Context.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || serverData.ParentID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("expected server span to continue the remote trace, got %+v", serverData)
+	}
+	if childData.Context.TraceID != serverData.Context.TraceID || childData.ParentID != serverData.Context.SpanID {
+		t.Fatalf("expected child of server span, got %+v", childData)
+	}
+	if childData.Attributes["key"] != "value" {
+		t.Fatalf("expected child attribute, got %v", childData.Attributes)
+	}
+
+	sc, ok := Extract(outgoing)
+	if !ok || sc.TraceID != serverData.Context.TraceID || sc.SpanID != serverData.Context.SpanID || !sc.Sampled {
+		t.Fatalf("expected injected traceparent for the server span, got %q", outgoing.Get(TraceparentHeader))
+	}
+	if outgoing.Get(TracestateHeader) != "vendor=value" {
+		t.Fatalf("expected tracestate to propagate, got %q", outgoing.Get(TracestateHeader))
+	}
+}
+
+func TestUnsampledTracesPropagateButAreNotExported(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracerWithExporter(0, time.Hour, exporter)
+
+	ctx, span := tracer.StartServer(httptest.NewRequest("GET", "/", nil), "GET")
+	span.End()
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	tracer.Shutdown(context.Background())
+
+	if len(exporter.spans) != 0 {
+		t.Fatalf("expected no exported spans, got %d", len(exporter.spans))
+	}
+	if sc, ok := Extract(outgoing); !ok || sc.Sampled {
+		t.Fatalf("expected unsampled traceparent, got %q", outgoing.Get(TraceparentHeader))
+	}
+}
+
+func TestNilTracerAndSpansAreNoOps(t *testing.T) {
+	var tracer *Tracer
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(TraceparentHeader, remoteTraceparent)
+
+	ctx, span := tracer.StartServer(req, "GET")
+	if span != nil || ctx != req.Context() {
+		t.Fatal("expected nil tracer to leave the request untouched")
+	}
+	_, child := Start(ctx, "child", SpanKindInternal)
+	child.SetAttribute("key", "value")
+	child.SetError(context.Canceled)
+	child.End()
+
+	outgoing := req.Header.Clone()
+	Inject(ctx, outgoing)
+	if outgoing.Get(TraceparentHeader) != remoteTraceparent {
+		t.Fatal("expected incoming traceparent to be left as is")
+	}
+}
+
+func TestOTLPExporterSendsSpansToCollector(t *testing.T) {
+	collector := tracingtest.NewCollector()
+	defer collector.Close()
+	tracer := NewTracerWithExporter(1, time.Hour, NewOTLPExporter(collector.URL, map[string]string{"X-Token": "t"}, "test-service", time.Second))
+
+	ctx, server := tracer.StartServer(httptest.NewRequest("GET", "/", nil), "GET users")
+	_, client := Start(ctx, "proxy.upstream", SpanKindClient)
+	client.SetAttribute("http.response.status_code", 502)
+	client.SetAttribute("retry", true)
+	client.SetError(context.DeadlineExceeded)
+	client.End()
+	server.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	upstream, ok := collector.Span("proxy.upstream")
+	if !ok {
+		t.Fatalf("expected proxy span, got %+v", collector.Spans())
+	}
+	root, _ := collector.Span("GET users")
+	if upstream.Service != "test-service" || upstream.Kind != int(SpanKindClient) || upstream.StatusCode != int(StatusError) {
+		t.Fatalf("unexpected span: %+v", upstream)
+	}
+	if upstream.TraceID != root.TraceID || upstream.ParentSpanID != root.SpanID || root.ParentSpanID != "" {
+		t.Fatalf("expected parent/child linkage, got root %+v child %+v", root, upstream)
+	}
+	if upstream.Attributes["http.response.status_code"] != "502" || upstream.Attributes["retry"] != true {
+		t.Fatalf("unexpected attributes: %v", upstream.Attributes)
+	}
+}
+
+func TestStdoutExporterWritesJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracerWithExporter(1, time.Hour, NewStdoutExporter(&buf, "test-service"))
+	_, span := tracer.StartServer(httptest.NewRequest("GET", "/", nil), "GET")
+	span.End()
+	tracer.Shutdown(context.Background())
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	if line["service"] != "test-service" || line["name"] != "GET" || line["kind"] != "server" {
+		t.Fatalf("unexpected span output: %v", line)
+	}
+}
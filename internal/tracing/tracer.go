@@ -0,0 +1,189 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aveiga/cloud-api-gateway/internal/config"
+)
+
+// Tracer starts server spans for incoming requests and exports finished spans
+type Tracer struct {
+	sampleRatio float64
+	processor   *batchProcessor
+}
+
+// NewTracer creates a tracer exporting through the configured exporter
+func NewTracer(cfg config.TracingConfig) (*Tracer, error) {
+	var exporter Exporter
+	switch cfg.Exporter {
+	case config.TracingExporterOTLPHTTP:
+		exporter = NewOTLPExporter(cfg.Endpoint, cfg.Headers, cfg.ServiceName, cfg.Timeout)
+	case config.TracingExporterStdout:
+		exporter = NewStdoutExporter(os.Stdout, cfg.ServiceName)
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter %q", cfg.Exporter)
+	}
+	return NewTracerWithExporter(cfg.Ratio(), cfg.BatchTimeout, exporter), nil
+}
+
+// NewTracerWithExporter creates a tracer that sends spans to exporter in batches,
+// at least every batchTimeout
+func NewTracerWithExporter(sampleRatio float64, batchTimeout time.Duration, exporter Exporter) *Tracer {
+	return &Tracer{
+		sampleRatio: sampleRatio,
+		processor:   newBatchProcessor(exporter, batchTimeout),
+	}
+}
+
+// StartServer begins the server span for an incoming request. The span continues
+// the caller's trace when the request carries a valid traceparent and honours its
+// sampling decision; otherwise a new trace is sampled at the configured ratio.
+// A nil tracer returns the request context unchanged and a nil span.
+func (t *Tracer) StartServer(r *http.Request, name string) (context.Context, *Span) {
+	if t == nil {
+		return r.Context(), nil
+	}
+
+	sc := SpanContext{SpanID: newSpanID()}
+	var parentID SpanID
+	if remote, ok := Extract(r.Header); ok {
+		sc.TraceID = remote.TraceID
+		sc.Sampled = remote.Sampled
+		sc.TraceState = remote.TraceState
+		parentID = remote.SpanID
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = t.sampleRatio >= 1 || rand.Float64() < t.sampleRatio
+	}
+
+	span := t.newSpan(name, SpanKindServer, sc, parentID)
+	return ContextWithSpan(r.Context(), span), span
+}
+
+// Shutdown exports buffered spans and stops the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.processor.shutdown(ctx)
+}
+
+func (t *Tracer) newSpan(name string, kind SpanKind, sc SpanContext, parentID SpanID) *Span {
+	return &Span{
+		tracer:  t,
+		context: sc,
+		data: SpanData{
+			Name:     name,
+			Kind:     kind,
+			Context:  sc,
+			ParentID: parentID,
+			Start:    time.Now(),
+		},
+	}
+}
+
+// Batching limits
+const (
+	maxQueueSize   = 2048
+	maxExportBatch = 512
+)
+
+// batchProcessor queues finished spans and exports them in the background so
+// requests never wait on the collector. Spans are dropped when the queue is full.
+type batchProcessor struct {
+	exporter Exporter
+	timeout  time.Duration
+	queue    chan *SpanData
+	dropped  atomic.Uint64
+
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newBatchProcessor(exporter Exporter, timeout time.Duration) *batchProcessor {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	p := &batchProcessor{
+		exporter: exporter,
+		timeout:  timeout,
+		queue:    make(chan *SpanData, maxQueueSize),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *batchProcessor) enqueue(span *SpanData) {
+	select {
+	case p.queue <- span:
+	default:
+		if p.dropped.Add(1) == 1 {
+			log.Printf("Tracing queue full, dropping spans")
+		}
+	}
+}
+
+func (p *batchProcessor) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(p.timeout)
+	defer ticker.Stop()
+
+	batch := make([]*SpanData, 0, maxExportBatch)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		if err := p.exporter.ExportSpans(ctx, batch); err != nil {
+			log.Printf("Failed to export %d spans: %v", len(batch), err)
+		}
+		cancel()
+		batch = make([]*SpanData, 0, maxExportBatch)
+	}
+
+	for {
+		select {
+		case span := <-p.queue:
+			batch = append(batch, span)
+			if len(batch) >= maxExportBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-p.stop:
+			for {
+				select {
+				case span := <-p.queue:
+					batch = append(batch, span)
+					if len(batch) >= maxExportBatch {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *batchProcessor) shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	select {
+	case <-p.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.exporter.Shutdown(ctx)
+}
@@ -0,0 +1,130 @@
+// Package tracingtest provides an in-process OTLP/HTTP collector for tests
+package tracingtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Span is a received span with its attributes flattened to Go values
+type Span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Kind         int
+	Attributes   map[string]interface{}
+	StatusCode   int
+	Service      string
+}
+
+// Collector accepts OTLP/HTTP JSON exports on /v1/traces and records the spans
+type Collector struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	spans []Span
+}
+
+// NewCollector starts a collector; call Close when done
+func NewCollector() *Collector {
+	c := &Collector{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/traces", c.receive)
+	c.Server = httptest.NewServer(mux)
+	return c
+}
+
+// Spans returns every span received so far
+func (c *Collector) Spans() []Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Span(nil), c.spans...)
+}
+
+// Span returns the first received span with the given name
+func (c *Collector) Span(name string) (Span, bool) {
+	for _, span := range c.Spans() {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return Span{}, false
+}
+
+type keyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string  `json:"stringValue"`
+		BoolValue   *bool    `json:"boolValue"`
+		IntValue    *string  `json:"intValue"`
+		DoubleValue *float64 `json:"doubleValue"`
+	} `json:"value"`
+}
+
+func flatten(attributes []keyValue) map[string]interface{} {
+	out := make(map[string]interface{}, len(attributes))
+	for _, kv := range attributes {
+		switch {
+		case kv.Value.StringValue != nil:
+			out[kv.Key] = *kv.Value.StringValue
+		case kv.Value.BoolValue != nil:
+			out[kv.Key] = *kv.Value.BoolValue
+		case kv.Value.IntValue != nil:
+			out[kv.Key] = *kv.Value.IntValue
+		case kv.Value.DoubleValue != nil:
+			out[kv.Key] = *kv.Value.DoubleValue
+		}
+	}
+	return out
+}
+
+func (c *Collector) receive(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []keyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string     `json:"traceId"`
+					SpanID       string     `json:"spanId"`
+					ParentSpanID string     `json:"parentSpanId"`
+					Name         string     `json:"name"`
+					Kind         int        `json:"kind"`
+					Attributes   []keyValue `json:"attributes"`
+					Status       struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range request.ResourceSpans {
+		service, _ := flatten(rs.Resource.Attributes)["service.name"].(string)
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				c.spans = append(c.spans, Span{
+					TraceID:      span.TraceID,
+					SpanID:       span.SpanID,
+					ParentSpanID: span.ParentSpanID,
+					Name:         span.Name,
+					Kind:         span.Kind,
+					Attributes:   flatten(span.Attributes),
+					StatusCode:   span.Status.Code,
+					Service:      service,
+				})
+			}
+		}
+	}
+	w.Write([]byte(`{}`))
+}